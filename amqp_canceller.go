@@ -6,6 +6,7 @@ import (
 
 	gocontext "context"
 
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
 	"github.com/travis-ci/worker/context"
@@ -21,14 +22,14 @@ type cancelCommand struct {
 // dispatching the commands to the right place. Currently the only valid command
 // is the 'cancel job' command.
 type AMQPCanceller struct {
-	conn                    *amqp.Connection
+	conn                    *AMQPConnection
 	ctx                     gocontext.Context
 	cancellationBroadcaster *CancellationBroadcaster
 }
 
 // NewAMQPCanceller creates a new AMQPCanceller. No network traffic
 // occurs until you call Run()
-func NewAMQPCanceller(ctx gocontext.Context, conn *amqp.Connection, cancellationBroadcaster *CancellationBroadcaster) *AMQPCanceller {
+func NewAMQPCanceller(ctx gocontext.Context, conn *AMQPConnection, cancellationBroadcaster *CancellationBroadcaster) *AMQPCanceller {
 	ctx = context.FromComponent(ctx, "canceller")
 
 	return &AMQPCanceller{
@@ -40,47 +41,69 @@ func NewAMQPCanceller(ctx gocontext.Context, conn *amqp.Connection, cancellation
 }
 
 // Run will make the AMQPCanceller listen to the worker command queue and
-// start dispatching any incoming commands.
+// start dispatching any incoming commands. Whenever the AMQP connection is
+// re-established, the command queue is declared and bound to the
+// worker.commands exchange again. Run returns once the connection is closed.
 func (d *AMQPCanceller) Run() {
-	amqpChan, err := d.conn.Channel()
 	logger := context.LoggerFromContext(d.ctx).WithFields(logrus.Fields{
 		"self": "amqp_canceller",
 		"inst": fmt.Sprintf("%p", d),
 	})
+
+	for {
+		err := d.conn.waitForConnection(d.ctx)
+		if err != nil {
+			logger.WithField("err", err).Info("stopped listening for commands")
+			return
+		}
+
+		err = d.consume(logger)
+		if err == nil {
+			logger.Info("command channel closed, waiting for the amqp connection")
+			continue
+		}
+
+		logger.WithField("err", err).Error("couldn't consume command queue, retrying")
+		err = d.conn.waitToRetry(d.ctx, amqpRetryInterval)
+		if err != nil {
+			logger.WithField("err", err).Info("stopped listening for commands")
+			return
+		}
+	}
+}
+
+// consume declares the command queue, binds it to the worker.commands
+// exchange, and processes commands until the channel is closed.
+func (d *AMQPCanceller) consume(logger *logrus.Entry) error {
+	amqpChan, err := d.conn.Channel()
 	if err != nil {
-		logger.WithField("err", err).Error("couldn't open channel")
-		return
+		return errors.Wrap(err, "couldn't open channel")
 	}
 	defer amqpChan.Close()
 
 	err = amqpChan.Qos(1, 0, false)
 	if err != nil {
-		logger.WithField("err", err).Error("couldn't set prefetch")
-		return
+		return errors.Wrap(err, "couldn't set prefetch")
 	}
 
 	err = amqpChan.ExchangeDeclare("worker.commands", "fanout", false, false, false, false, nil)
 	if err != nil {
-		logger.WithField("err", err).Error("couldn't declare exchange")
-		return
+		return errors.Wrap(err, "couldn't declare exchange")
 	}
 
 	queue, err := amqpChan.QueueDeclare("", true, false, true, false, nil)
 	if err != nil {
-		logger.WithField("err", err).Error("couldn't declare queue")
-		return
+		return errors.Wrap(err, "couldn't declare queue")
 	}
 
 	err = amqpChan.QueueBind(queue.Name, "", "worker.commands", false, nil)
 	if err != nil {
-		logger.WithField("err", err).Error("couldn't bind queue to exchange")
-		return
+		return errors.Wrap(err, "couldn't bind queue to exchange")
 	}
 
 	deliveries, err := amqpChan.Consume(queue.Name, "commands", false, true, false, false, nil)
 	if err != nil {
-		logger.WithField("err", err).Error("couldn't consume queue")
-		return
+		return errors.Wrap(err, "couldn't consume queue")
 	}
 
 	for delivery := range deliveries {
@@ -94,6 +117,8 @@ func (d *AMQPCanceller) Run() {
 			logger.WithField("err", err).WithField("delivery", delivery).Error("couldn't ack delivery")
 		}
 	}
+
+	return nil
 }
 
 func (d *AMQPCanceller) processCommand(delivery amqp.Delivery) error {
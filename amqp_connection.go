@@ -0,0 +1,517 @@
+package worker
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	gocontext "context"
+
+	"github.com/cenk/backoff"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"github.com/travis-ci/worker/context"
+	"github.com/travis-ci/worker/metrics"
+)
+
+const (
+	// amqpRetryInterval is how long consumers wait before trying again when
+	// they can't start consuming, e.g. because the connection was lost
+	// before the AMQPConnection noticed.
+	amqpRetryInterval = 5 * time.Second
+
+	// amqpPublisherMaxBuffered is how many messages an amqpPublisher buffers
+	// while the connection is down before it starts dropping them.
+	amqpPublisherMaxBuffered = 10000
+)
+
+var errAMQPConnectionClosed = errors.New("amqp connection closed")
+
+// amqpConn is the part of *amqp.Connection used by AMQPConnection.
+type amqpConn interface {
+	Channel() (*amqp.Channel, error)
+	NotifyClose(chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// AMQPConnection is an AMQP connection that is re-established, with backoff,
+// whenever it is lost. Consumers wait for it to come back and consume again,
+// and publishers buffer their messages until it is back.
+type AMQPConnection struct {
+	ctx        gocontext.Context
+	name       string
+	dial       func() (amqpConn, error)
+	newBackOff func() backoff.BackOff
+
+	mutex     sync.Mutex
+	conn      amqpConn
+	err       error
+	connected chan struct{}
+	closed    bool
+	done      chan struct{}
+	hooks     []*amqpReconnectHook
+}
+
+type amqpReconnectHook struct {
+	f func() error
+}
+
+// NewAMQPConnection dials an AMQP connection with the given function, and
+// dials it again whenever the connection is lost, until Close is called. An
+// error is returned if the first dial fails.
+func NewAMQPConnection(ctx gocontext.Context, name string, dial func() (*amqp.Connection, error)) (*AMQPConnection, error) {
+	return newAMQPConnection(ctx, name, func() (amqpConn, error) {
+		conn, err := dial()
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}, newAMQPReconnectBackOff)
+}
+
+func newAMQPConnection(ctx gocontext.Context, name string, dial func() (amqpConn, error), newBackOff func() backoff.BackOff) (*AMQPConnection, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &AMQPConnection{
+		ctx:        context.FromComponent(ctx, "amqp_connection"),
+		name:       name,
+		dial:       dial,
+		newBackOff: newBackOff,
+
+		conn:      conn,
+		connected: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	close(c.connected)
+
+	go c.watch(conn.NotifyClose(make(chan *amqp.Error, 1)))
+
+	return c, nil
+}
+
+func newAMQPReconnectBackOff() backoff.BackOff {
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxInterval = 30 * time.Second
+	bo.MaxElapsedTime = 0
+	return bo
+}
+
+// Channel opens a new channel on the connection. It returns an error if the
+// connection is down.
+func (c *AMQPConnection) Channel() (*amqp.Channel, error) {
+	c.mutex.Lock()
+	conn, err, closed := c.conn, c.err, c.closed
+	c.mutex.Unlock()
+
+	if closed {
+		return nil, errAMQPConnectionClosed
+	}
+	if conn == nil {
+		return nil, err
+	}
+	return conn.Channel()
+}
+
+// Err returns nil while the connection is up, and the reason it is down
+// otherwise.
+func (c *AMQPConnection) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.err
+}
+
+// Close closes the connection for good.
+func (c *AMQPConnection) Close() error {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.err = errAMQPConnectionClosed
+	close(c.done)
+	conn := c.conn
+	c.conn = nil
+	c.mutex.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+// onReconnect registers f to be called each time the connection has been
+// re-established, before waitForConnection returns. Hooks are called in the
+// order they were registered, and if one returns an error, the connection is
+// dropped and dialed again. The returned function unregisters f.
+func (c *AMQPConnection) onReconnect(f func() error) func() {
+	hook := &amqpReconnectHook{f: f}
+
+	c.mutex.Lock()
+	c.hooks = append(c.hooks, hook)
+	c.mutex.Unlock()
+
+	return func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		for i, h := range c.hooks {
+			if h == hook {
+				c.hooks = append(c.hooks[:i], c.hooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// waitForConnection blocks until the connection is up. It returns an error
+// if ctx is done or the connection is closed first.
+func (c *AMQPConnection) waitForConnection(ctx gocontext.Context) error {
+	c.mutex.Lock()
+	connected := c.connected
+	c.mutex.Unlock()
+
+	select {
+	case <-c.done:
+		return errAMQPConnectionClosed
+	default:
+	}
+
+	select {
+	case <-connected:
+		return nil
+	case <-c.done:
+		return errAMQPConnectionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitToRetry blocks for d. It returns an error if ctx is done or the
+// connection is closed first.
+func (c *AMQPConnection) waitToRetry(ctx gocontext.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-c.done:
+		return errAMQPConnectionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *AMQPConnection) logger() *logrus.Entry {
+	return context.LoggerFromContext(c.ctx).WithFields(logrus.Fields{
+		"self": "amqp_connection",
+		"name": c.name,
+		"inst": fmt.Sprintf("%p", c),
+	})
+}
+
+// watch waits for the connection to be lost and reconnects, until Close is
+// called. closes is the current connection's close notification channel.
+func (c *AMQPConnection) watch(closes chan *amqp.Error) {
+	logger := c.logger()
+
+	for {
+		closeErr, ok := <-closes
+
+		c.mutex.Lock()
+		if c.closed {
+			c.mutex.Unlock()
+			return
+		}
+		c.conn = nil
+		if ok && closeErr != nil {
+			c.err = errors.Wrap(closeErr, "amqp connection lost")
+		} else {
+			c.err = errors.New("amqp connection lost")
+		}
+		c.connected = make(chan struct{})
+		c.mutex.Unlock()
+
+		logger.WithField("err", closeErr).Error("amqp connection lost, reconnecting")
+		metrics.Mark("travis.worker.amqp.connection_lost")
+
+		closes = c.reconnect(logger)
+		if closes == nil {
+			return
+		}
+
+		logger.Info("amqp connection re-established")
+		metrics.Mark("travis.worker.amqp.reconnected")
+	}
+}
+
+// reconnect dials until it gets a connection on which all reconnect hooks
+// succeed, backing off between attempts, and returns the new connection's
+// close notification channel. It returns nil if Close is called first.
+func (c *AMQPConnection) reconnect(logger *logrus.Entry) chan *amqp.Error {
+	bo := c.newBackOff()
+
+	for {
+		conn, err := c.dial()
+		if err == nil {
+			closes := conn.NotifyClose(make(chan *amqp.Error, 1))
+			err = c.connect(conn)
+			if err == nil {
+				return closes
+			}
+		}
+
+		c.mutex.Lock()
+		closed := c.closed
+		c.mutex.Unlock()
+		if closed {
+			return nil
+		}
+
+		wait := bo.NextBackOff()
+		logger.WithFields(logrus.Fields{
+			"err":  err,
+			"wait": wait,
+		}).Error("couldn't reconnect to amqp")
+
+		select {
+		case <-time.After(wait):
+		case <-c.done:
+			return nil
+		}
+	}
+}
+
+// connect makes conn the current connection and runs the reconnect hooks on
+// it. If a hook fails, conn is closed and the hook's error returned.
+func (c *AMQPConnection) connect(conn amqpConn) error {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		conn.Close()
+		return errAMQPConnectionClosed
+	}
+	c.conn = conn
+	hooks := append([]*amqpReconnectHook{}, c.hooks...)
+	c.mutex.Unlock()
+
+	for _, hook := range hooks {
+		err := hook.f()
+		if err != nil {
+			c.mutex.Lock()
+			if c.conn == conn {
+				c.conn = nil
+			}
+			c.mutex.Unlock()
+
+			conn.Close()
+			return errors.Wrap(err, "couldn't set up amqp connection")
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return errAMQPConnectionClosed
+	}
+	c.err = nil
+	close(c.connected)
+	return nil
+}
+
+// amqpPublishChannel is the part of *amqp.Channel used to publish messages.
+type amqpPublishChannel interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Close() error
+}
+
+type amqpPendingPublishing struct {
+	exchange, key        string
+	mandatory, immediate bool
+	msg                  amqp.Publishing
+}
+
+// amqpPublisher publishes messages on its own channel of an AMQPConnection.
+// While the connection is down, messages are buffered, and they are published
+// in order once it is back.
+type amqpPublisher struct {
+	ctx         gocontext.Context
+	openChannel func() (amqpPublishChannel, error)
+	removeHook  func()
+	maxBuffered int
+
+	mutex    sync.Mutex
+	channel  amqpPublishChannel
+	buffered []*amqpPendingPublishing
+	closed   bool
+}
+
+func newAMQPPublisher(conn *AMQPConnection) *amqpPublisher {
+	p := &amqpPublisher{
+		ctx: conn.ctx,
+		openChannel: func() (amqpPublishChannel, error) {
+			ch, err := conn.Channel()
+			if err != nil {
+				return nil, err
+			}
+			return ch, nil
+		},
+		maxBuffered: amqpPublisherMaxBuffered,
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.removeHook = conn.onReconnect(p.reconnect)
+	p.channel, _ = p.openChannel()
+
+	return p
+}
+
+// Publish publishes a message, or buffers it if the connection is down. An
+// error is returned if the buffer is full.
+func (p *amqpPublisher) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return amqp.ErrClosed
+	}
+
+	if len(p.buffered) >= p.maxBuffered {
+		return errors.Errorf("amqp connection is down and %d messages are buffered already", len(p.buffered))
+	}
+
+	p.buffered = append(p.buffered, &amqpPendingPublishing{
+		exchange:  exchange,
+		key:       key,
+		mandatory: mandatory,
+		immediate: immediate,
+		msg:       msg,
+	})
+
+	wasBuffering := len(p.buffered) > 1
+	err := p.flush()
+	if len(p.buffered) > 0 && !wasBuffering {
+		context.LoggerFromContext(p.ctx).WithField("self", "amqp_publisher").Warn("amqp connection is down, buffering messages")
+	}
+	return err
+}
+
+// Close closes the publisher's channel. Messages that are still buffered are
+// dropped.
+func (p *amqpPublisher) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	if p.removeHook != nil {
+		p.removeHook()
+	}
+
+	if len(p.buffered) > 0 {
+		context.LoggerFromContext(p.ctx).WithFields(logrus.Fields{
+			"self":    "amqp_publisher",
+			"dropped": len(p.buffered),
+		}).Error("closing with buffered messages")
+		p.buffered = nil
+	}
+
+	if p.channel == nil {
+		return nil
+	}
+
+	err := p.channel.Close()
+	p.channel = nil
+	if err == amqp.ErrClosed {
+		return nil
+	}
+	return err
+}
+
+// reconnect opens a new channel and publishes the buffered messages. It is
+// called when the connection has been re-established.
+func (p *amqpPublisher) reconnect() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return nil
+	}
+
+	if p.channel != nil {
+		_ = p.channel.Close()
+		p.channel = nil
+	}
+
+	ch, err := p.openChannel()
+	if err != nil {
+		return err
+	}
+	p.channel = ch
+
+	buffered := len(p.buffered)
+	if buffered == 0 {
+		return nil
+	}
+
+	logger := context.LoggerFromContext(p.ctx).WithField("self", "amqp_publisher")
+	err = p.flush()
+	if err != nil {
+		logger.WithField("err", err).Error("couldn't publish buffered message")
+	}
+	logger.WithField("published", buffered-len(p.buffered)).Info("published buffered messages")
+
+	return nil
+}
+
+// flush publishes the buffered messages in order. It stops, leaving the rest
+// buffered, when the connection is down. Messages that can't be published
+// for another reason are dropped, and the last such error is returned.
+func (p *amqpPublisher) flush() error {
+	var lastErr error
+
+	for len(p.buffered) > 0 {
+		err := p.publish(p.buffered[0])
+		if err == amqp.ErrClosed {
+			return lastErr
+		}
+
+		p.buffered = p.buffered[1:]
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	p.buffered = nil
+	return lastErr
+}
+
+// publish publishes a message on the current channel, opening a new one if
+// there is none or the current one was closed. It returns amqp.ErrClosed if
+// the connection is down.
+func (p *amqpPublisher) publish(pending *amqpPendingPublishing) error {
+	if p.channel != nil {
+		err := p.channel.Publish(pending.exchange, pending.key, pending.mandatory, pending.immediate, pending.msg)
+		if err != amqp.ErrClosed {
+			return err
+		}
+		p.channel = nil
+	}
+
+	ch, err := p.openChannel()
+	if err != nil {
+		return amqp.ErrClosed
+	}
+	p.channel = ch
+
+	return ch.Publish(pending.exchange, pending.key, pending.mandatory, pending.immediate, pending.msg)
+}
@@ -0,0 +1,402 @@
+package worker
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	gocontext "context"
+
+	"github.com/cenk/backoff"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAMQPConn struct {
+	mutex  sync.Mutex
+	closes []chan *amqp.Error
+	closed bool
+}
+
+func (c *fakeAMQPConn) Channel() (*amqp.Channel, error) {
+	return nil, errors.New("fake amqp connections have no channels")
+}
+
+func (c *fakeAMQPConn) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		close(receiver)
+	} else {
+		c.closes = append(c.closes, receiver)
+	}
+	return receiver
+}
+
+func (c *fakeAMQPConn) Close() error {
+	c.shutdown(nil)
+	return nil
+}
+
+func (c *fakeAMQPConn) shutdown(err *amqp.Error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	for _, receiver := range c.closes {
+		if err != nil {
+			receiver <- err
+		}
+		close(receiver)
+	}
+}
+
+func (c *fakeAMQPConn) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.closed
+}
+
+// fakeAMQPDialer hands out the connections sent on conns, and fails when
+// sent nil.
+type fakeAMQPDialer struct {
+	conns chan *fakeAMQPConn
+}
+
+func (d *fakeAMQPDialer) dial() (amqpConn, error) {
+	conn := <-d.conns
+	if conn == nil {
+		return nil, errors.New("connection refused")
+	}
+	return conn, nil
+}
+
+func newTestAMQPConnection(t *testing.T) (*AMQPConnection, *fakeAMQPDialer, *fakeAMQPConn) {
+	dialer := &fakeAMQPDialer{conns: make(chan *fakeAMQPConn, 1)}
+	conn := &fakeAMQPConn{}
+	dialer.conns <- conn
+
+	c, err := newAMQPConnection(gocontext.TODO(), "test", dialer.dial, func() backoff.BackOff {
+		return &backoff.ZeroBackOff{}
+	})
+	require.Nil(t, err)
+
+	return c, dialer, conn
+}
+
+func waitForAMQPConnection(t *testing.T, c *AMQPConnection) {
+	ctx, cancel := gocontext.WithTimeout(gocontext.TODO(), 3*time.Second)
+	defer cancel()
+
+	require.Nil(t, c.waitForConnection(ctx))
+}
+
+// waitForAMQPConnectionLost waits until c has noticed that its connection
+// was lost.
+func waitForAMQPConnectionLost(t *testing.T, c *AMQPConnection) {
+	for i := 0; i < 300; i++ {
+		if c.Err() != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("amqp connection loss wasn't noticed")
+}
+
+func TestNewAMQPConnection_DialError(t *testing.T) {
+	_, err := newAMQPConnection(gocontext.TODO(), "test", func() (amqpConn, error) {
+		return nil, errors.New("connection refused")
+	}, newAMQPReconnectBackOff)
+	assert.EqualError(t, err, "connection refused")
+}
+
+func TestAMQPConnection_Reconnect(t *testing.T) {
+	c, dialer, conn := newTestAMQPConnection(t)
+	defer close(dialer.conns)
+	defer c.Close()
+
+	hookCalls := make(chan struct{}, 2)
+	c.onReconnect(func() error {
+		hookCalls <- struct{}{}
+		return nil
+	})
+
+	assert.Nil(t, c.Err())
+	waitForAMQPConnection(t, c)
+
+	conn.shutdown(&amqp.Error{Code: amqp.ConnectionForced, Reason: "CONNECTION_FORCED"})
+	waitForAMQPConnectionLost(t, c)
+	assert.Contains(t, c.Err().Error(), "CONNECTION_FORCED")
+
+	// the connection stays down until a dial succeeds
+	dialer.conns <- nil
+	dialer.conns <- nil
+
+	ctx, cancel := gocontext.WithTimeout(gocontext.TODO(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, gocontext.DeadlineExceeded, c.waitForConnection(ctx))
+
+	newConn := &fakeAMQPConn{}
+	dialer.conns <- newConn
+	waitForAMQPConnection(t, c)
+
+	assert.Nil(t, c.Err())
+	assert.Len(t, hookCalls, 1)
+	<-hookCalls
+
+	// the new connection is watched as well
+	newConn.shutdown(nil)
+	waitForAMQPConnectionLost(t, c)
+	dialer.conns <- &fakeAMQPConn{}
+	waitForAMQPConnection(t, c)
+
+	assert.Nil(t, c.Err())
+	assert.Len(t, hookCalls, 1)
+}
+
+func TestAMQPConnection_ReconnectHookError(t *testing.T) {
+	c, dialer, conn := newTestAMQPConnection(t)
+	defer close(dialer.conns)
+	defer c.Close()
+
+	hookErrs := make(chan error, 2)
+	hookErrs <- errors.New("queue declare failed")
+	hookErrs <- nil
+	c.onReconnect(func() error {
+		return <-hookErrs
+	})
+
+	conn.shutdown(nil)
+	waitForAMQPConnectionLost(t, c)
+
+	failedConn := &fakeAMQPConn{}
+	dialer.conns <- failedConn
+	dialer.conns <- &fakeAMQPConn{}
+	waitForAMQPConnection(t, c)
+
+	assert.True(t, failedConn.isClosed())
+	assert.Nil(t, c.Err())
+}
+
+func TestAMQPConnection_onReconnectRemove(t *testing.T) {
+	c, dialer, conn := newTestAMQPConnection(t)
+	defer close(dialer.conns)
+	defer c.Close()
+
+	removedCalls := 0
+	remove := c.onReconnect(func() error {
+		removedCalls++
+		return nil
+	})
+	keptCalls := 0
+	c.onReconnect(func() error {
+		keptCalls++
+		return nil
+	})
+	remove()
+
+	conn.shutdown(nil)
+	waitForAMQPConnectionLost(t, c)
+	dialer.conns <- &fakeAMQPConn{}
+	waitForAMQPConnection(t, c)
+
+	assert.Equal(t, 0, removedCalls)
+	assert.Equal(t, 1, keptCalls)
+}
+
+func TestAMQPConnection_Close(t *testing.T) {
+	c, _, conn := newTestAMQPConnection(t)
+
+	assert.Nil(t, c.Close())
+	assert.True(t, conn.isClosed())
+	assert.Equal(t, errAMQPConnectionClosed, c.Err())
+	assert.Equal(t, errAMQPConnectionClosed, c.waitForConnection(gocontext.TODO()))
+	assert.Equal(t, errAMQPConnectionClosed, c.waitToRetry(gocontext.TODO(), time.Hour))
+
+	_, err := c.Channel()
+	assert.Equal(t, errAMQPConnectionClosed, err)
+
+	assert.Nil(t, c.Close())
+}
+
+func TestAMQPConnection_CloseWhileReconnecting(t *testing.T) {
+	c, dialer, conn := newTestAMQPConnection(t)
+	defer close(dialer.conns)
+
+	conn.shutdown(nil)
+	waitForAMQPConnectionLost(t, c)
+	dialer.conns <- nil
+
+	assert.Nil(t, c.Close())
+	assert.Equal(t, errAMQPConnectionClosed, c.waitForConnection(gocontext.TODO()))
+}
+
+type fakeAMQPPublishChannel struct {
+	mutex     sync.Mutex
+	published []string
+	closed    bool
+}
+
+func (ch *fakeAMQPPublishChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	ch.published = append(ch.published, string(msg.Body))
+	return nil
+}
+
+func (ch *fakeAMQPPublishChannel) Close() error {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	ch.closed = true
+	return nil
+}
+
+func (ch *fakeAMQPPublishChannel) messages() []string {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+
+	return append([]string{}, ch.published...)
+}
+
+// fakeAMQPPublisherConn opens fakeAMQPPublishChannels while it is up.
+type fakeAMQPPublisherConn struct {
+	up       bool
+	channels []*fakeAMQPPublishChannel
+}
+
+func (c *fakeAMQPPublisherConn) openChannel() (amqpPublishChannel, error) {
+	if !c.up {
+		return nil, amqp.ErrClosed
+	}
+	ch := &fakeAMQPPublishChannel{}
+	c.channels = append(c.channels, ch)
+	return ch, nil
+}
+
+func (c *fakeAMQPPublisherConn) lastChannel() *fakeAMQPPublishChannel {
+	return c.channels[len(c.channels)-1]
+}
+
+func newTestAMQPPublisher(conn *fakeAMQPPublisherConn, maxBuffered int) *amqpPublisher {
+	return &amqpPublisher{
+		ctx:         gocontext.TODO(),
+		openChannel: conn.openChannel,
+		maxBuffered: maxBuffered,
+	}
+}
+
+func publishTestMessage(p *amqpPublisher, body string) error {
+	return p.Publish("reporting", "reporting.jobs.logs", false, false, amqp.Publishing{Body: []byte(body)})
+}
+
+func TestAMQPPublisher(t *testing.T) {
+	conn := &fakeAMQPPublisherConn{up: true}
+	p := newTestAMQPPublisher(conn, 10)
+
+	assert.Nil(t, publishTestMessage(p, "a"))
+	assert.Equal(t, []string{"a"}, conn.lastChannel().messages())
+
+	// a closed channel is replaced while the connection is up
+	conn.lastChannel().Close()
+	assert.Nil(t, publishTestMessage(p, "b"))
+	assert.Len(t, conn.channels, 2)
+	assert.Equal(t, []string{"b"}, conn.lastChannel().messages())
+
+	// messages are buffered while the connection is down
+	conn.lastChannel().Close()
+	conn.up = false
+	assert.Nil(t, publishTestMessage(p, "c"))
+	assert.Nil(t, publishTestMessage(p, "d"))
+	assert.Len(t, p.buffered, 2)
+
+	// and published in order once it is back
+	conn.up = true
+	assert.Nil(t, p.reconnect())
+	assert.Len(t, conn.channels, 3)
+	assert.Equal(t, []string{"c", "d"}, conn.lastChannel().messages())
+	assert.Len(t, p.buffered, 0)
+
+	assert.Nil(t, publishTestMessage(p, "e"))
+	assert.Equal(t, []string{"c", "d", "e"}, conn.lastChannel().messages())
+}
+
+func TestAMQPPublisher_BufferFull(t *testing.T) {
+	conn := &fakeAMQPPublisherConn{}
+	p := newTestAMQPPublisher(conn, 2)
+
+	assert.Nil(t, publishTestMessage(p, "a"))
+	assert.Nil(t, publishTestMessage(p, "b"))
+	assert.NotNil(t, publishTestMessage(p, "c"))
+	assert.Len(t, p.buffered, 2)
+
+	conn.up = true
+	assert.Nil(t, p.reconnect())
+	assert.Equal(t, []string{"a", "b"}, conn.lastChannel().messages())
+}
+
+func TestAMQPPublisher_reconnectError(t *testing.T) {
+	conn := &fakeAMQPPublisherConn{}
+	p := newTestAMQPPublisher(conn, 10)
+
+	assert.Nil(t, publishTestMessage(p, "a"))
+	assert.Equal(t, amqp.ErrClosed, p.reconnect())
+	assert.Len(t, p.buffered, 1)
+}
+
+func TestAMQPPublisher_Close(t *testing.T) {
+	conn := &fakeAMQPPublisherConn{up: true}
+	p := newTestAMQPPublisher(conn, 10)
+
+	assert.Nil(t, publishTestMessage(p, "a"))
+
+	conn.lastChannel().Close()
+	conn.up = false
+	assert.Nil(t, publishTestMessage(p, "b"))
+
+	assert.Nil(t, p.Close())
+	assert.Len(t, p.buffered, 0)
+	assert.Equal(t, amqp.ErrClosed, publishTestMessage(p, "c"))
+	assert.Nil(t, p.Close())
+}
+
+func TestNewAMQPPublisher(t *testing.T) {
+	c, dialer, conn := newTestAMQPConnection(t)
+	defer close(dialer.conns)
+	defer c.Close()
+
+	p := newAMQPPublisher(c)
+	assert.Nil(t, p.channel)
+	assert.Len(t, c.hooks, 1)
+
+	// fake connections have no channels, so messages stay buffered and the
+	// publisher's reconnect hook fails
+	assert.Nil(t, publishTestMessage(p, "a"))
+	assert.Len(t, p.buffered, 1)
+
+	conn.shutdown(nil)
+	waitForAMQPConnectionLost(t, c)
+	dialer.conns <- &fakeAMQPConn{}
+
+	ctx, cancel := gocontext.WithTimeout(gocontext.TODO(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, gocontext.DeadlineExceeded, c.waitForConnection(ctx))
+
+	assert.Nil(t, p.Close())
+	assert.Len(t, c.hooks, 0)
+}
@@ -14,14 +14,14 @@ import (
 	"github.com/travis-ci/worker/backend"
 	"github.com/travis-ci/worker/context"
 	"github.com/travis-ci/worker/metrics"
-	"go.opencensus.io/trace"
 )
 
 type amqpJob struct {
-	conn            *amqp.Connection
+	conn            *AMQPConnection
 	stateUpdatePool *tunny.Pool
-	logWriterChan   *amqp.Channel
+	logWriterChan   amqpPublishChannel
 	delivery        amqp.Delivery
+	inFlight        *amqpInFlightJobs
 	payload         *JobPayload
 	rawPayload      *simplejson.Json
 	startAttributes *backend.StartAttributes
@@ -50,7 +50,7 @@ func (j *amqpJob) StartAttributes() *backend.StartAttributes {
 }
 
 func (j *amqpJob) Error(ctx gocontext.Context, errMessage string) error {
-	ctx, span := trace.StartSpan(ctx, "amqpJob.Error")
+	ctx, span := startJobSpan(ctx, "amqpJob.Error")
 	defer span.End()
 
 	log, err := j.LogWriter(ctx, time.Minute)
@@ -67,7 +67,7 @@ func (j *amqpJob) Error(ctx gocontext.Context, errMessage string) error {
 }
 
 func (j *amqpJob) Requeue(ctx gocontext.Context) error {
-	ctx, span := trace.StartSpan(ctx, "amqpJob.Requeue")
+	ctx, span := startJobSpan(ctx, "amqpJob.Requeue")
 	defer span.End()
 
 	context.LoggerFromContext(ctx).WithFields(
@@ -84,11 +84,32 @@ func (j *amqpJob) Requeue(ctx gocontext.Context) error {
 		return err
 	}
 
-	return j.delivery.Ack(false)
+	if j.inFlight == nil {
+		return j.delivery.Ack(false)
+	}
+	return j.inFlight.requeue(j.payload.Job.ID)
+}
+
+// Nack hands the delivery back to the queue without processing the job, so
+// that another processor can pick it up.
+func (j *amqpJob) Nack(ctx gocontext.Context) error {
+	if j.inFlight == nil {
+		return j.delivery.Nack(false, true)
+	}
+	return j.inFlight.nack(j.payload.Job.ID)
+}
+
+// ack acks the job's delivery, which is the latest redelivery of the job if
+// it was redelivered while running.
+func (j *amqpJob) ack() error {
+	if j.inFlight == nil {
+		return j.delivery.Ack(false)
+	}
+	return j.inFlight.ack(j.payload.Job.ID)
 }
 
 func (j *amqpJob) Received(ctx gocontext.Context) error {
-	ctx, span := trace.StartSpan(ctx, "amqpJob.Received")
+	ctx, span := startJobSpan(ctx, "amqpJob.Received")
 	defer span.End()
 
 	j.received = time.Now()
@@ -101,7 +122,7 @@ func (j *amqpJob) Received(ctx gocontext.Context) error {
 }
 
 func (j *amqpJob) Started(ctx gocontext.Context) error {
-	ctx, span := trace.StartSpan(ctx, "amqpJob.Started")
+	ctx, span := startJobSpan(ctx, "amqpJob.Started")
 	defer span.End()
 
 	j.started = time.Now()
@@ -112,7 +133,7 @@ func (j *amqpJob) Started(ctx gocontext.Context) error {
 }
 
 func (j *amqpJob) Finish(ctx gocontext.Context, state FinishState) error {
-	ctx, span := trace.StartSpan(ctx, "amqpJob.Finished")
+	ctx, span := startJobSpan(ctx, "amqpJob.Finished")
 	defer span.End()
 
 	j.finished = time.Now()
@@ -141,7 +162,7 @@ func (j *amqpJob) Finish(ctx gocontext.Context, state FinishState) error {
 		return err
 	}
 
-	return j.delivery.Ack(false)
+	return j.ack()
 }
 
 func (j *amqpJob) LogWriter(ctx gocontext.Context, defaultLogTimeout time.Duration) (LogWriter, error) {
@@ -166,6 +187,8 @@ func (j *amqpJob) createStateUpdateBody(ctx gocontext.Context, state string) map
 		body["meta"].(map[string]interface{})["instance_id"] = instanceID
 	}
 
+	addJobFailureMeta(ctx, body)
+
 	if j.Payload().Job.QueuedAt != nil {
 		body["queued_at"] = j.Payload().Job.QueuedAt.UTC().Format(time.RFC3339)
 	}
@@ -187,7 +210,7 @@ func (j *amqpJob) createStateUpdateBody(ctx gocontext.Context, state string) map
 }
 
 func (j *amqpJob) sendStateUpdate(ctx gocontext.Context, event, state string) error {
-	ctx, span := trace.StartSpan(ctx, "amqpJob.sendStateUpdate")
+	ctx, span := startJobSpan(ctx, "amqpJob.sendStateUpdate")
 	defer span.End()
 
 	err := j.stateUpdatePool.Process(&amqpStateUpdatePayload{
@@ -220,7 +243,7 @@ type amqpStateUpdatePayload struct {
 }
 
 type amqpStateUpdateWorker struct {
-	stateUpdateChan *amqp.Channel
+	stateUpdateChan amqpPublishChannel
 	ctx             gocontext.Context
 	cancel          gocontext.CancelFunc
 }
@@ -247,11 +270,10 @@ func (w *amqpStateUpdateWorker) Interrupt() {
 func (w *amqpStateUpdateWorker) Terminate() {
 	err := w.stateUpdateChan.Close()
 	if err != nil {
-		time.Sleep(time.Minute)
 		logrus.WithFields(logrus.Fields{
 			"self": "amqp_state_update_worker",
 			"err":  err,
-		}).Panic("timed out waiting for shutdown after amqp connection error")
+		}).Error("couldn't close state update channel")
 	}
 }
 
@@ -3,12 +3,15 @@ package worker
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"sync"
 	"time"
 
 	gocontext "context"
 
 	"github.com/Jeffail/tunny"
 	"github.com/bitly/go-simplejson"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
 	"github.com/travis-ci/worker/backend"
@@ -18,12 +21,16 @@ import (
 
 // AMQPJobQueue is a JobQueue that uses AMQP
 type AMQPJobQueue struct {
-	conn            *amqp.Connection
+	conn            *AMQPConnection
 	queue           string
 	priority        int
 	withLogSharding bool
 
 	stateUpdatePool *tunny.Pool
+	inFlight        *amqpInFlightJobs
+
+	// consumeQueue opens a channel and starts consuming the queue on it.
+	consumeQueue func() (io.Closer, <-chan amqp.Delivery, error)
 
 	DefaultLanguage, DefaultDist, DefaultGroup, DefaultOS string
 }
@@ -31,72 +38,85 @@ type AMQPJobQueue struct {
 // NewAMQPJobQueue creates a AMQPJobQueue backed by the given AMQP connections and
 // connects to the AMQP queue with the given name. The queue will be declared
 // in AMQP when this function is called, so an error could be raised if the
-// queue already exists, but with different attributes than we expect.
-func NewAMQPJobQueue(conn *amqp.Connection, queue string, stateUpdatePoolSize int, sharded bool) (*AMQPJobQueue, error) {
-	channel, err := conn.Channel()
+// queue already exists, but with different attributes than we expect. The
+// queue is declared again whenever the connection is re-established.
+func NewAMQPJobQueue(conn *AMQPConnection, queue string, stateUpdatePoolSize int, sharded bool) (*AMQPJobQueue, error) {
+	declare := func() error {
+		return declareAMQPJobQueue(conn, queue, sharded)
+	}
+
+	err := declare()
 	if err != nil {
 		return nil, err
 	}
+	conn.onReconnect(declare)
+
+	stateUpdatePool := newStateUpdatePool(stateUpdatePoolSize, func() amqpPublishChannel {
+		return newAMQPPublisher(conn)
+	})
+
+	go reportPoolMetrics("state_update_pool", stateUpdatePool)
+
+	q := &AMQPJobQueue{
+		conn:            conn,
+		queue:           queue,
+		withLogSharding: sharded,
+
+		stateUpdatePool: stateUpdatePool,
+		inFlight:        newAMQPInFlightJobs(),
+	}
+	q.consumeQueue = q.consume
+
+	return q, nil
+}
+
+func declareAMQPJobQueue(conn *AMQPConnection, queue string, sharded bool) error {
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
 
 	_, err = channel.QueueDeclare(queue, true, false, false, false, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	err = channel.ExchangeDeclare("reporting", "topic", true, false, false, false, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	_, err = channel.QueueDeclare("reporting.jobs.builds", true, false, false, false, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if sharded {
 		// This exchange should be declared as sharded using a policy that matches its name.
 		err = channel.ExchangeDeclare("reporting.jobs.logs_sharded", "x-modulus-hash", true, false, false, false, nil)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	} else {
 		_, err = channel.QueueDeclare("reporting.jobs.logs", true, false, false, false, nil)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		err = channel.QueueBind("reporting.jobs.logs", "reporting.jobs.logs", "reporting", false, nil)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
-	err = channel.Close()
-	if err != nil {
-		return nil, err
-	}
-
-	stateUpdatePool := newStateUpdatePool(conn, stateUpdatePoolSize)
-
-	go reportPoolMetrics("state_update_pool", stateUpdatePool)
-
-	return &AMQPJobQueue{
-		conn:            conn,
-		queue:           queue,
-		withLogSharding: sharded,
-
-		stateUpdatePool: stateUpdatePool,
-	}, nil
+	return nil
 }
 
-func newStateUpdatePool(conn *amqp.Connection, poolSize int) *tunny.Pool {
+func newStateUpdatePool(poolSize int, openChannel func() amqpPublishChannel) *tunny.Pool {
 	return tunny.New(poolSize, func() tunny.Worker {
-		stateUpdateChan, err := conn.Channel()
-		if err != nil {
-			logrus.WithField("err", err).Panic("could not create state update amqp channel")
-		}
 		return &amqpStateUpdateWorker{
-			stateUpdateChan: stateUpdateChan,
+			stateUpdateChan: openChannel(),
 		}
 	})
 }
@@ -112,41 +132,23 @@ func reportPoolMetrics(poolName string, pool *tunny.Pool) {
 // Jobs creates a new consumer on the queue, and returns three channels. The
 // first channel gets sent every BuildJob that we receive from AMQP. The
 // stopChan is a channel that can be closed in order to stop the consumer.
+// When the AMQP connection is lost, the consumer waits for it to be
+// re-established and consumes again. Jobs that were running when the
+// connection was lost are redelivered by RabbitMQ then, and those
+// redeliveries are dropped instead of running the jobs again.
 func (q *AMQPJobQueue) Jobs(ctx gocontext.Context) (outChan <-chan Job, err error) {
-	jobsChannel, err := q.conn.Channel()
+	jobsChannel, deliveries, err := q.consumeQueue()
 	if err != nil {
 		return
 	}
 
-	err = jobsChannel.Qos(1, 0, false)
-	if err != nil {
-		return
-	}
-
-	deliveries, err := jobsChannel.Consume(
-		q.queue,              // queue
-		"build-job-consumer", // consumer
-
-		false, // autoAck
-		false, // exclusive
-		false, // noLocal
-		false, // noWait
-		amqp.Table{"x-priority": int64(q.priority)}) // args
-
-	if err != nil {
-		return
-	}
-
-	logWriterChannel, err := q.conn.Channel()
-	if err != nil {
-		return
-	}
+	logWriterChannel := newAMQPPublisher(q.conn)
 
 	buildJobChan := make(chan Job)
 	outChan = buildJobChan
 
 	go func() {
-		defer jobsChannel.Close()
+		defer func() { jobsChannel.Close() }()
 		defer logWriterChannel.Close()
 		defer close(buildJobChan)
 
@@ -167,8 +169,18 @@ func (q *AMQPJobQueue) Jobs(ctx gocontext.Context) (outChan <-chan Job, err erro
 				continue
 			case delivery, ok := <-deliveries:
 				if !ok {
-					logger.Info("job queue channel closed")
-					return
+					logger.Info("job queue channel closed, waiting for the amqp connection")
+					q.inFlight.markStale()
+					newJobsChannel, newDeliveries, err := q.reconsume(ctx, logger)
+					if err != nil {
+						logger.WithField("err", err).Info("stopped consuming job queue")
+						return
+					}
+					jobsChannel.Close()
+					jobsChannel, deliveries = newJobsChannel, newDeliveries
+					q.inFlight.consuming()
+					logger.Info("consuming job queue again")
+					continue
 				}
 
 				buildJob := &amqpJob{
@@ -211,14 +223,29 @@ func (q *AMQPJobQueue) Jobs(ctx gocontext.Context) (outChan <-chan Job, err erro
 					continue
 				}
 
+				added, err := q.inFlight.add(buildJob.payload.Job.ID, delivery)
+				if !added {
+					metrics.Mark("travis.worker.job_queue.amqp.duplicate")
+					logger.WithFields(logrus.Fields{
+						"job_id":      buildJob.payload.Job.ID,
+						"redelivered": delivery.Redelivered,
+					}).Info("dropped delivery of a job that is already running")
+					if err != nil {
+						logger.WithField("err", err).WithField("delivery", delivery).Error("couldn't ack+drop delivery")
+					}
+					continue
+				}
+
 				buildJob.startAttributes = startAttrs.Config
 				buildJob.startAttributes.VMType = buildJob.payload.VMType
 				buildJob.startAttributes.VMConfig = buildJob.payload.VMConfig
 				buildJob.startAttributes.Warmer = buildJob.payload.Warmer
+				buildJob.startAttributes.Queue = buildJob.payload.Queue
 				buildJob.startAttributes.SetDefaults(q.DefaultLanguage, q.DefaultDist, q.DefaultGroup, q.DefaultOS, VMTypeDefault, VMConfigDefault)
 				buildJob.conn = q.conn
 				buildJob.logWriterChan = logWriterChannel
 				buildJob.delivery = delivery
+				buildJob.inFlight = q.inFlight
 				buildJob.stateCount = buildJob.payload.Meta.StateUpdateCount
 
 				jobSendBegin := time.Now()
@@ -230,7 +257,7 @@ func (q *AMQPJobQueue) Jobs(ctx gocontext.Context) (outChan <-chan Job, err erro
 						"send_duration_ms": time.Since(jobSendBegin).Seconds() * 1e3,
 					}).Info("sent job to output channel")
 				case <-ctx.Done():
-					delivery.Nack(false, true)
+					q.inFlight.nack(buildJob.payload.Job.ID)
 					return
 				}
 			}
@@ -240,6 +267,59 @@ func (q *AMQPJobQueue) Jobs(ctx gocontext.Context) (outChan <-chan Job, err erro
 	return
 }
 
+// consume opens a channel and starts consuming the queue on it.
+func (q *AMQPJobQueue) consume() (io.Closer, <-chan amqp.Delivery, error) {
+	jobsChannel, err := q.conn.Channel()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = jobsChannel.Qos(1, 0, false)
+	if err != nil {
+		jobsChannel.Close()
+		return nil, nil, err
+	}
+
+	deliveries, err := jobsChannel.Consume(
+		q.queue,              // queue
+		"build-job-consumer", // consumer
+
+		false, // autoAck
+		false, // exclusive
+		false, // noLocal
+		false, // noWait
+		amqp.Table{"x-priority": int64(q.priority)}) // args
+
+	if err != nil {
+		jobsChannel.Close()
+		return nil, nil, err
+	}
+
+	return jobsChannel, deliveries, nil
+}
+
+// reconsume waits for the connection and consumes the queue again, retrying
+// until it succeeds, ctx is done, or the connection is closed.
+func (q *AMQPJobQueue) reconsume(ctx gocontext.Context, logger *logrus.Entry) (io.Closer, <-chan amqp.Delivery, error) {
+	for {
+		err := q.conn.waitForConnection(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		jobsChannel, deliveries, err := q.consumeQueue()
+		if err == nil {
+			return jobsChannel, deliveries, nil
+		}
+
+		logger.WithField("err", err).Error("couldn't consume job queue, retrying")
+		err = q.conn.waitToRetry(ctx, amqpRetryInterval)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
 // Name returns the name of this queue type, wow!
 func (q *AMQPJobQueue) Name() string {
 	return "amqp"
@@ -250,3 +330,164 @@ func (q *AMQPJobQueue) Cleanup() error {
 	q.stateUpdatePool.Close()
 	return q.conn.Close()
 }
+
+// amqpInFlightJobs keeps track of the deliveries of the jobs handed out by an
+// AMQPJobQueue until they're acked. When the connection is lost, RabbitMQ
+// redelivers the jobs that were running, and their deliveries can't be acked
+// anymore. A redelivery of a running job replaces its stale delivery instead
+// of being run again, and a redelivery of a job that finished while its
+// delivery was stale is acked and dropped. As RabbitMQ can redeliver to any
+// consumer, finished jobs are only kept for amqpRedeliveryWait after the
+// queue is consumed again.
+type amqpInFlightJobs struct {
+	mutex sync.Mutex
+	jobs  map[uint64]*amqpInFlightJob
+	now   func() time.Time
+}
+
+type amqpInFlightJob struct {
+	delivery amqp.Delivery
+
+	// stale is true if the channel the delivery came from is gone.
+	stale bool
+
+	// finished is true if the job is done, but its delivery couldn't be
+	// acked, so that its redelivery is expected until expires.
+	finished bool
+	expires  time.Time
+}
+
+const amqpRedeliveryWait = 5 * time.Minute
+
+func newAMQPInFlightJobs() *amqpInFlightJobs {
+	return &amqpInFlightJobs{
+		jobs: map[uint64]*amqpInFlightJob{},
+		now:  time.Now,
+	}
+}
+
+// add starts tracking the delivery of a job, and returns true if the job
+// should be run. If the job is already in flight, false is returned, and the
+// delivery either replaces the job's stale delivery, or is acked and dropped,
+// in which case an error acking it is returned.
+func (f *amqpInFlightJobs) add(jobID uint64, delivery amqp.Delivery) (bool, error) {
+	f.mutex.Lock()
+	f.expire()
+
+	job, ok := f.jobs[jobID]
+	switch {
+	case !ok:
+		f.jobs[jobID] = &amqpInFlightJob{delivery: delivery}
+		f.mutex.Unlock()
+		return true, nil
+	case job.finished:
+		delete(f.jobs, jobID)
+	case job.stale:
+		job.delivery = delivery
+		job.stale = false
+		f.mutex.Unlock()
+		return false, nil
+	}
+	f.mutex.Unlock()
+
+	return false, delivery.Ack(false)
+}
+
+// expire stops tracking the finished jobs whose redelivery didn't arrive in
+// time. It must be called with the mutex held.
+func (f *amqpInFlightJobs) expire() {
+	now := f.now()
+	for jobID, job := range f.jobs {
+		if job.finished && now.After(job.expires) {
+			delete(f.jobs, jobID)
+		}
+	}
+}
+
+// markStale marks the deliveries of all jobs in flight as stale, as the
+// channel they came from is gone.
+func (f *amqpInFlightJobs) markStale() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, job := range f.jobs {
+		job.stale = true
+	}
+}
+
+// consuming is called when the queue is consumed again after the connection
+// was lost, and starts the wait for the redeliveries of finished jobs.
+func (f *amqpInFlightJobs) consuming() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	expires := f.now().Add(amqpRedeliveryWait)
+	for _, job := range f.jobs {
+		if job.finished {
+			job.expires = expires
+		}
+	}
+}
+
+// ack acks the delivery of a job that is done. If the delivery is stale, or
+// acking it fails, the job is kept as finished until its redelivery arrives
+// or the wait for it expires.
+func (f *amqpInFlightJobs) ack(jobID uint64) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	job, ok := f.jobs[jobID]
+	if !ok {
+		return errors.Errorf("job %d isn't in flight", jobID)
+	}
+
+	var err error
+	if !job.stale {
+		err = job.delivery.Ack(false)
+		if err == nil {
+			delete(f.jobs, jobID)
+			return nil
+		}
+	}
+
+	job.finished = true
+	job.expires = f.now().Add(amqpRedeliveryWait)
+	return err
+}
+
+// requeue acks the delivery of a job that was reset to be queued again, and
+// stops tracking the job, so that it runs when it is delivered again. A
+// stale delivery can't be acked, and is redelivered by RabbitMQ.
+func (f *amqpInFlightJobs) requeue(jobID uint64) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	job, ok := f.jobs[jobID]
+	if !ok {
+		return errors.Errorf("job %d isn't in flight", jobID)
+	}
+	delete(f.jobs, jobID)
+
+	if job.stale {
+		return nil
+	}
+	return job.delivery.Ack(false)
+}
+
+// nack hands the delivery of a job that wasn't run back to the queue, and
+// stops tracking the job. A stale delivery is requeued by RabbitMQ already.
+func (f *amqpInFlightJobs) nack(jobID uint64) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	job, ok := f.jobs[jobID]
+	if !ok {
+		return errors.Errorf("job %d isn't in flight", jobID)
+	}
+	delete(f.jobs, jobID)
+
+	if job.stale {
+		return nil
+	}
+	return job.delivery.Nack(false, true)
+}
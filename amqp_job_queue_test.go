@@ -0,0 +1,238 @@
+package worker
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	gocontext "context"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+func newTestAMQPJobDelivery(t *testing.T, jobID uint64, redelivered bool) (amqp.Delivery, *fakeAMQPAcknowledger) {
+	body, err := json.Marshal(map[string]interface{}{
+		"job":    map[string]interface{}{"id": jobID},
+		"config": map[string]interface{}{},
+	})
+	require.Nil(t, err)
+
+	acker := &fakeAMQPAcknowledger{}
+	return amqp.Delivery{
+		Acknowledger: acker,
+		DeliveryTag:  jobID,
+		Redelivered:  redelivered,
+		Body:         body,
+	}, acker
+}
+
+func receiveTestJob(t *testing.T, jobs <-chan Job) Job {
+	t.Helper()
+
+	select {
+	case job, ok := <-jobs:
+		require.True(t, ok, "jobs channel closed")
+		return job
+	case <-time.After(3 * time.Second):
+		t.Fatal("no job received")
+		return nil
+	}
+}
+
+// waitForStaleAMQPDelivery waits until the job queue has noticed that the
+// delivery of the job is stale.
+func waitForStaleAMQPDelivery(t *testing.T, f *amqpInFlightJobs, jobID uint64) {
+	for i := 0; i < 300; i++ {
+		f.mutex.Lock()
+		job, ok := f.jobs[jobID]
+		stale := ok && job.stale
+		f.mutex.Unlock()
+
+		if stale {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("stale delivery wasn't noticed")
+}
+
+func TestAMQPJobQueue_RedeliveryOfRunningJob(t *testing.T) {
+	c, _, _ := newTestAMQPConnection(t)
+	defer c.Close()
+
+	consumes := make(chan chan amqp.Delivery, 2)
+	q := &AMQPJobQueue{
+		conn: c,
+		stateUpdatePool: newStateUpdatePool(1, func() amqpPublishChannel {
+			return &fakeAMQPPublishChannel{}
+		}),
+		inFlight: newAMQPInFlightJobs(),
+		consumeQueue: func() (io.Closer, <-chan amqp.Delivery, error) {
+			return nopCloser{}, <-consumes, nil
+		},
+	}
+
+	ctx, cancel := gocontext.WithCancel(gocontext.TODO())
+	defer cancel()
+
+	firstDeliveries := make(chan amqp.Delivery, 1)
+	delivery, firstAcker := newTestAMQPJobDelivery(t, 1, false)
+	firstDeliveries <- delivery
+	consumes <- firstDeliveries
+
+	jobs, err := q.Jobs(ctx)
+	require.Nil(t, err)
+
+	job := receiveTestJob(t, jobs)
+	assert.Equal(t, uint64(1), job.Payload().Job.ID)
+	require.Nil(t, job.Received(ctx))
+
+	// the connection drops while the job is running, which closes the
+	// consumer's deliveries, and RabbitMQ redelivers the job on the new
+	// channel, followed by another job
+	secondDeliveries := make(chan amqp.Delivery, 2)
+	redelivery, secondAcker := newTestAMQPJobDelivery(t, 1, true)
+	secondDeliveries <- redelivery
+	delivery, _ = newTestAMQPJobDelivery(t, 2, false)
+	secondDeliveries <- delivery
+	consumes <- secondDeliveries
+
+	close(firstDeliveries)
+
+	nextJob := receiveTestJob(t, jobs)
+	assert.Equal(t, uint64(2), nextJob.Payload().Job.ID, "redelivered job was run again")
+
+	require.Nil(t, job.Finish(ctx, FinishStatePassed))
+	assert.Equal(t, uint64(0), firstAcker.lastAckTag, "stale delivery was acked")
+	assert.Equal(t, uint64(1), secondAcker.lastAckTag, "redelivery wasn't acked")
+}
+
+func TestAMQPJobQueue_RequeueThenDeliveredAgain(t *testing.T) {
+	c, _, _ := newTestAMQPConnection(t)
+	defer c.Close()
+
+	consumes := make(chan chan amqp.Delivery, 2)
+	q := &AMQPJobQueue{
+		conn: c,
+		stateUpdatePool: newStateUpdatePool(1, func() amqpPublishChannel {
+			return &fakeAMQPPublishChannel{}
+		}),
+		inFlight: newAMQPInFlightJobs(),
+		consumeQueue: func() (io.Closer, <-chan amqp.Delivery, error) {
+			return nopCloser{}, <-consumes, nil
+		},
+	}
+
+	ctx, cancel := gocontext.WithCancel(gocontext.TODO())
+	defer cancel()
+
+	firstDeliveries := make(chan amqp.Delivery, 1)
+	delivery, _ := newTestAMQPJobDelivery(t, 1, false)
+	firstDeliveries <- delivery
+	consumes <- firstDeliveries
+
+	jobs, err := q.Jobs(ctx)
+	require.Nil(t, err)
+
+	job := receiveTestJob(t, jobs)
+	require.Nil(t, job.Received(ctx))
+
+	// the connection drops while the job is running, and the job is
+	// requeued before the queue is consumed again
+	secondDeliveries := make(chan amqp.Delivery, 1)
+	consumes <- secondDeliveries
+	close(firstDeliveries)
+	waitForStaleAMQPDelivery(t, q.inFlight, 1)
+	require.Nil(t, job.Requeue(ctx))
+
+	// the scheduler queues the job again after the reset, so it must run
+	delivery, _ = newTestAMQPJobDelivery(t, 1, false)
+	secondDeliveries <- delivery
+
+	job = receiveTestJob(t, jobs)
+	assert.Equal(t, uint64(1), job.Payload().Job.ID)
+}
+
+func TestAMQPInFlightJobs_FinishedBeforeRedelivery(t *testing.T) {
+	f := newAMQPInFlightJobs()
+
+	delivery, firstAcker := newTestAMQPJobDelivery(t, 1, false)
+	added, err := f.add(1, delivery)
+	require.Nil(t, err)
+	assert.True(t, added)
+
+	f.markStale()
+	assert.Nil(t, f.ack(1))
+	assert.Equal(t, uint64(0), firstAcker.lastAckTag)
+
+	redelivery, secondAcker := newTestAMQPJobDelivery(t, 1, true)
+	added, err = f.add(1, redelivery)
+	require.Nil(t, err)
+	assert.False(t, added)
+	assert.Equal(t, uint64(1), secondAcker.lastAckTag)
+
+	// once the redelivery is dropped, a new delivery of the job runs it again
+	delivery, _ = newTestAMQPJobDelivery(t, 1, false)
+	added, err = f.add(1, delivery)
+	require.Nil(t, err)
+	assert.True(t, added)
+}
+
+func TestAMQPInFlightJobs_Duplicate(t *testing.T) {
+	f := newAMQPInFlightJobs()
+
+	delivery, firstAcker := newTestAMQPJobDelivery(t, 1, false)
+	added, err := f.add(1, delivery)
+	require.Nil(t, err)
+	assert.True(t, added)
+
+	duplicate, secondAcker := newTestAMQPJobDelivery(t, 1, false)
+	duplicate.DeliveryTag = 2
+	added, err = f.add(1, duplicate)
+	require.Nil(t, err)
+	assert.False(t, added)
+	assert.Equal(t, uint64(2), secondAcker.lastAckTag)
+
+	assert.Nil(t, f.nack(1))
+	assert.True(t, firstAcker.lastNackReq)
+	assert.NotNil(t, f.ack(1))
+}
+
+func TestAMQPInFlightJobs_FinishedExpires(t *testing.T) {
+	now := time.Now()
+	f := newAMQPInFlightJobs()
+	f.now = func() time.Time { return now }
+
+	delivery, _ := newTestAMQPJobDelivery(t, 1, false)
+	added, err := f.add(1, delivery)
+	require.Nil(t, err)
+	assert.True(t, added)
+
+	f.markStale()
+	assert.Nil(t, f.ack(1))
+
+	// the wait for the redelivery starts once the queue is consumed again
+	now = now.Add(time.Hour)
+	f.consuming()
+	now = now.Add(amqpRedeliveryWait + time.Second)
+
+	delivery, acker := newTestAMQPJobDelivery(t, 2, false)
+	added, err = f.add(2, delivery)
+	require.Nil(t, err)
+	assert.True(t, added)
+	assert.Equal(t, uint64(0), acker.lastAckTag)
+	assert.NotContains(t, f.jobs, uint64(1))
+
+	// a delivery of the expired job runs it again
+	delivery, _ = newTestAMQPJobDelivery(t, 1, true)
+	added, err = f.add(1, delivery)
+	require.Nil(t, err)
+	assert.True(t, added)
+}
@@ -44,7 +44,8 @@ func (a *fakeAMQPAcknowledger) Reject(tag uint64, req bool) error {
 }
 
 func newTestAMQPJob(t *testing.T) *amqpJob {
-	amqpConn, logChan := setupAMQPConn(t)
+	_, logChan := setupAMQPConn(t)
+	amqpConn := setupAMQPConnection(t)
 
 	payload := &JobPayload{
 		Type: "job:test",
@@ -84,7 +85,9 @@ func newTestAMQPJob(t *testing.T) *amqpJob {
 		t.Error(err)
 	}
 
-	stateUpdatePool := newStateUpdatePool(amqpConn, 1)
+	stateUpdatePool := newStateUpdatePool(1, func() amqpPublishChannel {
+		return newAMQPPublisher(amqpConn)
+	})
 
 	return &amqpJob{
 		conn:            amqpConn,
@@ -154,6 +157,20 @@ func TestAMQPJob_Requeue(t *testing.T) {
 	}
 }
 
+func TestAMQPJob_Nack(t *testing.T) {
+	job := newTestAMQPJob(t)
+
+	err := job.Nack(gocontext.TODO())
+	if err != nil {
+		t.Error(err)
+	}
+
+	acker := job.delivery.Acknowledger.(*fakeAMQPAcknowledger)
+	if !acker.lastNackReq {
+		t.Fatalf("delivery wasn't requeued")
+	}
+}
+
 func TestAMQPJob_Received(t *testing.T) {
 	job := newTestAMQPJob(t)
 
@@ -25,39 +25,33 @@ type amqpLogPart struct {
 }
 
 type amqpLogWriter struct {
+	logWriterLimits
+
 	ctx     gocontext.Context
-	cancel  gocontext.CancelFunc
 	jobID   uint64
 	sharded bool
 
 	closeChan chan struct{}
 
-	bufferMutex      sync.Mutex
-	buffer           *bytes.Buffer
-	logPartNumber    int
-	jobStarted       bool
-	jobStartedMeta   *JobStartedMeta
-	maxLengthReached bool
-
-	bytesWritten int
-	maxLength    int
+	bufferMutex    sync.Mutex
+	buffer         *bytes.Buffer
+	logPartNumber  int
+	jobStarted     bool
+	jobStartedMeta *JobStartedMeta
 
 	amqpChanMutex sync.RWMutex
-	amqpChan      *amqp.Channel
-
-	timer   *time.Timer
-	timeout time.Duration
+	amqpChan      amqpPublishChannel
 }
 
-func newAMQPLogWriter(ctx gocontext.Context, logWriterChan *amqp.Channel, jobID uint64, timeout time.Duration, sharded bool) (*amqpLogWriter, error) {
+func newAMQPLogWriter(ctx gocontext.Context, logWriterChan amqpPublishChannel, jobID uint64, timeout time.Duration, sharded bool) (*amqpLogWriter, error) {
 	writer := &amqpLogWriter{
+		logWriterLimits: newLogWriterLimits(timeout),
+
 		ctx:       context.FromComponent(ctx, "log_writer"),
 		amqpChan:  logWriterChan,
 		jobID:     jobID,
 		closeChan: make(chan struct{}),
 		buffer:    new(bytes.Buffer),
-		timer:     time.NewTimer(time.Hour),
-		timeout:   timeout,
 		sharded:   sharded,
 	}
 
@@ -86,17 +80,7 @@ func (w *amqpLogWriter) Write(p []byte) (int, error) {
 		"bytes":  string(p),
 	}).Debug("writing bytes")
 
-	w.timer.Reset(w.timeout)
-
-	w.bytesWritten += len(p)
-	if w.bytesWritten > w.maxLength {
-		logger.Info("wrote past maximum log length - cancelling context")
-		w.maxLengthReached = true
-		if w.cancel == nil {
-			logger.Error("cancel function does not exist")
-		} else {
-			w.cancel()
-		}
+	if !w.accept(logger, p) {
 		return 0, nil
 	}
 
@@ -110,7 +94,7 @@ func (w *amqpLogWriter) Close() error {
 		return nil
 	}
 
-	w.timer.Stop()
+	w.stopTimer()
 
 	close(w.closeChan)
 	w.flush()
@@ -126,44 +110,30 @@ func (w *amqpLogWriter) Close() error {
 	return err
 }
 
-func (w *amqpLogWriter) Timeout() <-chan time.Time {
-	return w.timer.C
-}
-
-func (w *amqpLogWriter) SetMaxLogLength(bytes int) {
-	w.maxLength = bytes
-}
-
 func (w *amqpLogWriter) SetJobStarted(meta *JobStartedMeta) {
 	w.jobStarted = true
 	w.jobStartedMeta = meta
 }
 
-func (w *amqpLogWriter) SetCancelFunc(cancel gocontext.CancelFunc) {
-	w.cancel = cancel
-}
-
-func (w *amqpLogWriter) MaxLengthReached() bool {
-	return w.maxLengthReached == true
-}
-
 // WriteAndClose works like a Write followed by a Close, but ensures that no
-// other Writes are allowed in between.
+// other Writes are allowed in between. Any folds that are still open are
+// closed before p is written.
 func (w *amqpLogWriter) WriteAndClose(p []byte) (int, error) {
 	if w.closed() {
 		return 0, fmt.Errorf("log already closed")
 	}
 
-	w.timer.Stop()
+	w.stopTimer()
 
 	close(w.closeChan)
 
 	w.bufferMutex.Lock()
-	n, err := w.buffer.Write(p)
+	_, err := w.buffer.Write(w.closeOpenFolds(p))
 	w.bufferMutex.Unlock()
 	if err != nil {
-		return n, err
+		return 0, err
 	}
+	n := len(p)
 
 	w.flush()
 
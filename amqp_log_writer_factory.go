@@ -3,45 +3,61 @@ package worker
 import (
 	gocontext "context"
 	"time"
-
-	"github.com/streadway/amqp"
 )
 
 type AMQPLogWriterFactory struct {
-	conn            *amqp.Connection
+	conn            *AMQPConnection
 	withLogSharding bool
-	logWriterChan   *amqp.Channel
+	logWriterChan   amqpPublishChannel
 }
 
-func NewAMQPLogWriterFactory(conn *amqp.Connection, sharded bool) (*AMQPLogWriterFactory, error) {
-	channel, err := conn.Channel()
+// NewAMQPLogWriterFactory declares the log exchange or queue, declaring it
+// again whenever the connection is re-established, and returns a factory
+// whose log writers buffer log parts while the connection is down.
+func NewAMQPLogWriterFactory(conn *AMQPConnection, sharded bool) (*AMQPLogWriterFactory, error) {
+	declare := func() error {
+		return declareAMQPLogWriterQueue(conn, sharded)
+	}
+
+	err := declare()
 	if err != nil {
 		return nil, err
 	}
+	conn.onReconnect(declare)
+
+	return &AMQPLogWriterFactory{
+		conn:            conn,
+		withLogSharding: sharded,
+		logWriterChan:   newAMQPPublisher(conn),
+	}, nil
+}
+
+func declareAMQPLogWriterQueue(conn *AMQPConnection, sharded bool) error {
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
 
 	if sharded {
 		// This exchange should be declared as sharded using a policy that matches its name.
 		err = channel.ExchangeDeclare("reporting.jobs.logs_sharded", "x-modulus-hash", true, false, false, false, nil)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	} else {
 		_, err = channel.QueueDeclare("reporting.jobs.logs", true, false, false, false, nil)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		err = channel.QueueBind("reporting.jobs.logs", "reporting.jobs.logs", "reporting", false, nil)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
-	return &AMQPLogWriterFactory{
-		conn:            conn,
-		withLogSharding: sharded,
-		logWriterChan:   channel,
-	}, nil
+	return nil
 }
 
 func (l *AMQPLogWriterFactory) LogWriter(ctx gocontext.Context, defaultLogTimeout time.Duration, job Job) (LogWriter, error) {
@@ -4,6 +4,8 @@ import (
 	"os"
 	"testing"
 
+	gocontext "context"
+
 	"github.com/streadway/amqp"
 )
 
@@ -43,3 +45,18 @@ func setupAMQPConn(t *testing.T) (*amqp.Connection, *amqp.Channel) {
 
 	return amqpConn, logChan
 }
+
+func setupAMQPConnection(t *testing.T) *AMQPConnection {
+	if os.Getenv("AMQP_URI") == "" {
+		t.Skip("skipping amqp test since there is no AMQP_URI")
+	}
+
+	conn, err := NewAMQPConnection(gocontext.TODO(), "amqp", func() (*amqp.Connection, error) {
+		return amqp.Dial(os.Getenv("AMQP_URI"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
@@ -0,0 +1,176 @@
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	gocontext "context"
+
+	"github.com/pkg/errors"
+	"github.com/travis-ci/worker/config"
+	"github.com/travis-ci/worker/metrics"
+	"github.com/travis-ci/worker/ratelimit"
+)
+
+// apiLimiter throttles the calls a provider makes to its cloud API, as
+// configured by the standard RATE_LIMIT_* provider config keys:
+//
+//   - RATE_LIMIT_MAX_CALLS and RATE_LIMIT_DURATION limit the call rate, shared
+//     between workers via Redis if RATE_LIMIT_REDIS_URL is set, and per worker
+//     otherwise
+//   - RATE_LIMIT_MAX_IN_FLIGHT limits the number of concurrent calls
+//
+// Without any of them set, calls aren't throttled. Time spent waiting is
+// reported as travis.worker.vm.provider.{provider}.rate-limit and
+// .max-in-flight timers.
+type apiLimiter struct {
+	provider    string
+	rateLimiter ratelimit.RateLimiter
+	maxCalls    uint64
+	per         time.Duration
+	inFlight    chan struct{}
+	waiting     int64
+}
+
+// rateLimitOptions returns the RATE_LIMIT_* options read by newAPILimiter.
+// The rate is unlimited by default if defaultMaxCalls is 0.
+func rateLimitOptions(api string, defaultMaxCalls uint64, defaultPer time.Duration) []*Option {
+	maxCalls := &Option{Name: "RATE_LIMIT_MAX_CALLS", Type: OptionInt, Help: fmt.Sprintf("number of calls per duration to let through to the %s API", api)}
+	if defaultMaxCalls > 0 {
+		maxCalls.Default = strconv.FormatUint(defaultMaxCalls, 10)
+	}
+
+	return []*Option{
+		{Name: "RATE_LIMIT_PREFIX", Type: OptionString, Help: "prefix for the rate limit key in Redis"},
+		{Name: "RATE_LIMIT_REDIS_URL", Type: OptionString, Help: "URL to Redis instance to use for rate limiting, shared between workers"},
+		maxCalls,
+		{Name: "RATE_LIMIT_DURATION", Type: OptionDuration, Default: defaultPer.String(), Help: "interval in which to let max-calls through to the " + api + " API"},
+		{Name: "RATE_LIMIT_MAX_IN_FLIGHT", Type: OptionInt, Help: fmt.Sprintf("maximum number of concurrent calls to the %s API", api)},
+	}
+}
+
+// newAPILimiter builds an apiLimiter from the provider config. The rate is
+// only limited if RATE_LIMIT_REDIS_URL or RATE_LIMIT_MAX_CALLS is set.
+func newAPILimiter(provider string, cfg *config.ProviderConfig, defaultMaxCalls uint64, defaultPer time.Duration) (*apiLimiter, error) {
+	l := &apiLimiter{
+		provider:    provider,
+		rateLimiter: ratelimit.NewNullRateLimiter(),
+		maxCalls:    defaultMaxCalls,
+		per:         defaultPer,
+	}
+
+	if cfg.IsSet("RATE_LIMIT_MAX_CALLS") {
+		mc, err := strconv.ParseUint(cfg.Get("RATE_LIMIT_MAX_CALLS"), 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't parse RATE_LIMIT_MAX_CALLS")
+		}
+		l.maxCalls = mc
+	}
+
+	if cfg.IsSet("RATE_LIMIT_DURATION") {
+		per, err := time.ParseDuration(cfg.Get("RATE_LIMIT_DURATION"))
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't parse RATE_LIMIT_DURATION")
+		}
+		l.per = per
+	}
+
+	if cfg.IsSet("RATE_LIMIT_REDIS_URL") || cfg.IsSet("RATE_LIMIT_MAX_CALLS") {
+		if l.maxCalls == 0 || l.per <= 0 {
+			return nil, fmt.Errorf("RATE_LIMIT_MAX_CALLS and RATE_LIMIT_DURATION must be positive")
+		}
+
+		if cfg.IsSet("RATE_LIMIT_REDIS_URL") {
+			l.rateLimiter = ratelimit.NewRateLimiter(cfg.Get("RATE_LIMIT_REDIS_URL"), cfg.Get("RATE_LIMIT_PREFIX"))
+		} else {
+			l.rateLimiter = ratelimit.NewTokenBucketRateLimiter()
+		}
+	}
+
+	if cfg.IsSet("RATE_LIMIT_MAX_IN_FLIGHT") {
+		maxInFlight, err := strconv.ParseUint(cfg.Get("RATE_LIMIT_MAX_IN_FLIGHT"), 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't parse RATE_LIMIT_MAX_IN_FLIGHT")
+		}
+		if maxInFlight > 0 {
+			l.inFlight = make(chan struct{}, maxInFlight)
+		}
+	}
+
+	return l, nil
+}
+
+// Wait blocks until the rate limit lets a call through.
+func (l *apiLimiter) Wait(ctx gocontext.Context) error {
+	queueName := fmt.Sprintf("travis.worker.vm.provider.%s.rate-limit.queue", l.provider)
+	metrics.Gauge(queueName, atomic.AddInt64(&l.waiting, 1))
+	defer func() { metrics.Gauge(queueName, atomic.AddInt64(&l.waiting, -1)) }()
+
+	defer metrics.TimeSince(fmt.Sprintf("travis.worker.vm.provider.%s.rate-limit", l.provider), time.Now())
+
+	return l.rateLimiter.Wait(ctx, l.provider+"-api", l.maxCalls, l.per)
+}
+
+// acquire blocks until a call may be made, and returns a function to call
+// once it is done.
+func (l *apiLimiter) acquire(ctx gocontext.Context) (func(), error) {
+	release := func() {}
+
+	if l.inFlight != nil {
+		start := time.Now()
+		select {
+		case l.inFlight <- struct{}{}:
+			metrics.TimeSince(fmt.Sprintf("travis.worker.vm.provider.%s.max-in-flight", l.provider), start)
+			release = func() { <-l.inFlight }
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	err := l.Wait(ctx)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	return release, nil
+}
+
+// Do calls f once the rate limit and the max in flight allow it.
+func (l *apiLimiter) Do(ctx gocontext.Context, f func() error) error {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return f()
+}
+
+// Transport wraps an http.RoundTripper so that every request is throttled.
+// A request counts as in flight until its response headers are received.
+func (l *apiLimiter) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &apiLimiterTransport{limiter: l, next: next}
+}
+
+type apiLimiterTransport struct {
+	limiter *apiLimiter
+	next    http.RoundTripper
+}
+
+func (t *apiLimiterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.limiter.acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return t.next.RoundTrip(req)
+}
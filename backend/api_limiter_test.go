@@ -0,0 +1,115 @@
+package backend
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gocontext "context"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travis-ci/worker/config"
+	"github.com/travis-ci/worker/ratelimit"
+)
+
+func TestNewAPILimiter(t *testing.T) {
+	l, err := newAPILimiter("test", config.ProviderConfigFromMap(map[string]string{}), 0, time.Second)
+	require.Nil(t, err)
+	assert.Equal(t, ratelimit.NewNullRateLimiter(), l.rateLimiter)
+	assert.Nil(t, l.inFlight)
+
+	l, err = newAPILimiter("test", config.ProviderConfigFromMap(map[string]string{
+		"RATE_LIMIT_MAX_CALLS":     "5",
+		"RATE_LIMIT_DURATION":      "2s",
+		"RATE_LIMIT_MAX_IN_FLIGHT": "3",
+	}), 0, time.Second)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(5), l.maxCalls)
+	assert.Equal(t, 2*time.Second, l.per)
+	assert.Equal(t, 3, cap(l.inFlight))
+	assert.NotEqual(t, ratelimit.NewNullRateLimiter(), l.rateLimiter)
+}
+
+func TestNewAPILimiter_Invalid(t *testing.T) {
+	for _, cfg := range []map[string]string{
+		{"RATE_LIMIT_MAX_CALLS": "lots"},
+		{"RATE_LIMIT_MAX_CALLS": "0"},
+		{"RATE_LIMIT_MAX_CALLS": "5", "RATE_LIMIT_DURATION": "0s"},
+		{"RATE_LIMIT_DURATION": "soon"},
+		{"RATE_LIMIT_REDIS_URL": "redis://localhost:6379"},
+		{"RATE_LIMIT_MAX_IN_FLIGHT": "-1"},
+	} {
+		_, err := newAPILimiter("test", config.ProviderConfigFromMap(cfg), 0, time.Second)
+		assert.NotNil(t, err, "config %v", cfg)
+	}
+}
+
+func TestAPILimiter_MaxInFlight(t *testing.T) {
+	l, err := newAPILimiter("test", config.ProviderConfigFromMap(map[string]string{
+		"RATE_LIMIT_MAX_IN_FLIGHT": "1",
+	}), 0, time.Second)
+	require.Nil(t, err)
+
+	release, err := l.acquire(gocontext.TODO())
+	require.Nil(t, err)
+
+	ctx, cancel := gocontext.WithTimeout(gocontext.TODO(), 10*time.Millisecond)
+	defer cancel()
+	err = l.Do(ctx, func() error { return nil })
+	assert.Equal(t, gocontext.DeadlineExceeded, err)
+
+	release()
+
+	called := false
+	err = l.Do(gocontext.TODO(), func() error {
+		called = true
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.True(t, called)
+}
+
+func TestAPILimiter_Transport(t *testing.T) {
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	l, err := newAPILimiter("test", config.ProviderConfigFromMap(map[string]string{
+		"RATE_LIMIT_MAX_CALLS": "1",
+		"RATE_LIMIT_DURATION":  "1h",
+	}), 0, time.Second)
+	require.Nil(t, err)
+
+	client := &http.Client{Transport: l.Transport(nil)}
+
+	resp, err := client.Get(server.URL)
+	require.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	ctx, cancel := gocontext.WithTimeout(gocontext.TODO(), 10*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequest("GET", server.URL, nil)
+	require.Nil(t, err)
+	_, err = client.Do(req.WithContext(ctx))
+	assert.NotNil(t, err)
+
+	assert.Equal(t, int64(1), atomic.LoadInt64(&requests))
+}
+
+func TestAPILimiter_WaitQueueGauge(t *testing.T) {
+	l, err := newAPILimiter("queue-test", config.ProviderConfigFromMap(map[string]string{}), 0, time.Second)
+	require.Nil(t, err)
+
+	assert.Nil(t, l.Wait(gocontext.TODO()))
+
+	gauge := metrics.GetOrRegisterGauge("travis.worker.vm.provider.queue-test.rate-limit.queue", metrics.DefaultRegistry)
+	assert.Equal(t, int64(0), gauge.Value())
+}
@@ -16,24 +16,32 @@ var (
 
 var ErrDownloadTraceNotImplemented = errors.New("DownloadTrace not implemented")
 
-// Backend wraps up an alias, backend provider help, and a factory func for a
-// given backend provider wheee
+// Backend wraps up an alias, backend provider options and help, and a factory
+// func for a given backend provider wheee
 type Backend struct {
 	Alias             string
 	HumanReadableName string
+	Options           []*Option
 	ProviderHelp      map[string]string
 	ProviderFunc      func(*config.ProviderConfig) (Provider, error)
 }
 
-// Register adds a backend to the registry!
-func Register(alias, humanReadableName string, providerHelp map[string]string, providerFunc func(*config.ProviderConfig) (Provider, error)) {
+// Register adds a backend to the registry! The options describe the provider
+// config keys the backend understands, and are used for --help output and to
+// validate provider configs.
+func Register(alias, humanReadableName string, options []*Option, providerFunc func(*config.ProviderConfig) (Provider, error)) {
 	backendRegistryMutex.Lock()
 	defer backendRegistryMutex.Unlock()
 
+	for _, option := range options {
+		option.compile()
+	}
+
 	backendRegistry[alias] = &Backend{
 		Alias:             alias,
 		HumanReadableName: humanReadableName,
-		ProviderHelp:      providerHelp,
+		Options:           options,
+		ProviderHelp:      optionsHelp(options),
 		ProviderFunc:      providerFunc,
 	}
 }
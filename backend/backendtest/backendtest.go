@@ -0,0 +1,321 @@
+// Package backendtest implements a conformance test suite checking that a
+// backend.Provider and its instances honor the contract the worker relies
+// on, such as reporting exit codes, returning from RunScript when the context
+// is cancelled, and allowing Stop to be called twice.
+//
+// A provider's tests call Run with a Config describing how to build the
+// provider and the jobs to run on it:
+//
+//	func TestLocalConformance(t *testing.T) {
+//		backendtest.Run(t, &backendtest.Config{
+//			NewProvider: func(t *testing.T) backend.Provider { ... },
+//		})
+//	}
+package backendtest
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gocontext "context"
+
+	"github.com/pkg/errors"
+	"github.com/travis-ci/worker/backend"
+)
+
+// JobOutput is the output written by the jobs the conformance tests run.
+const JobOutput = "backendtest job output"
+
+// Job describes a job run by the conformance tests.
+type Job struct {
+	// ExitCode is the code the job script exits with after writing
+	// JobOutput.
+	ExitCode uint8
+
+	// Endless jobs write output until they are cancelled.
+	Endless bool
+}
+
+// Config describes the provider to run the conformance tests against.
+type Config struct {
+	// NewProvider builds a new provider for each test. The tests call its
+	// Setup method.
+	NewProvider func(t *testing.T) backend.Provider
+
+	// Job returns the start attributes and script for a job. ShellJob is
+	// used if it is nil.
+	Job func(job Job) (*backend.StartAttributes, []byte)
+
+	// SupportsTrace is true if the provider implements DownloadTrace.
+	// Otherwise, DownloadTrace must return
+	// backend.ErrDownloadTraceNotImplemented.
+	SupportsTrace bool
+
+	// Skip maps the names of the tests that don't apply to the provider to
+	// the reason why.
+	Skip map[string]string
+
+	// Timeout is how long any single call to the provider or instance may
+	// take, 10 seconds by default.
+	Timeout time.Duration
+}
+
+// ShellJob returns default start attributes and a bash script for job.
+func ShellJob(job Job) (*backend.StartAttributes, []byte) {
+	script := fmt.Sprintf("#!/bin/bash\necho '%s'\n", JobOutput)
+	if job.Endless {
+		script += "while true; do echo still running; sleep 0.1; done\n"
+	}
+	script += fmt.Sprintf("exit %d\n", job.ExitCode)
+
+	return &backend.StartAttributes{
+		Language: "ruby",
+		Dist:     "trusty",
+		Group:    "stable",
+		OS:       "linux",
+	}, []byte(script)
+}
+
+type conformanceTest struct {
+	name string
+	run  func(*suite)
+}
+
+var conformanceTests = []conformanceTest{
+	{"StartStop", testStartStop},
+	{"RunScript", testRunScript},
+	{"ExitCode", testExitCode},
+	{"RunScriptCancel", testRunScriptCancel},
+	{"StopTwice", testStopTwice},
+	{"DownloadTrace", testDownloadTrace},
+}
+
+// Run runs each conformance test as a subtest of t.
+func Run(t *testing.T, cfg *Config) {
+	if cfg.Job == nil {
+		cfg.Job = ShellJob
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	for _, test := range conformanceTests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			if reason, ok := cfg.Skip[test.name]; ok {
+				t.Skip(reason)
+			}
+
+			s := &suite{t: t, cfg: cfg, provider: cfg.NewProvider(t)}
+			s.call("Setup", func() error { return s.provider.Setup(gocontext.TODO()) })
+
+			test.run(s)
+		})
+	}
+}
+
+type suite struct {
+	t        *testing.T
+	cfg      *Config
+	provider backend.Provider
+}
+
+// call runs f, failing the test if it returns an error or takes longer than
+// the timeout.
+func (s *suite) call(name string, f func() error) {
+	s.t.Helper()
+
+	if err := s.callErr(name, f); err != nil {
+		s.t.Fatalf("%s returned error: %v", name, err)
+	}
+}
+
+// callErr runs f and returns its error, failing the test if it takes longer
+// than the timeout.
+func (s *suite) callErr(name string, f func() error) error {
+	s.t.Helper()
+
+	errChan := make(chan error, 1)
+	go func() { errChan <- f() }()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-time.After(s.cfg.Timeout):
+		s.t.Fatalf("%s didn't return within %v", name, s.cfg.Timeout)
+		return nil
+	}
+}
+
+// start starts an instance for job.
+func (s *suite) start(job Job) backend.Instance {
+	s.t.Helper()
+
+	startAttributes, _ := s.cfg.Job(job)
+	return s.startInstance(startAttributes)
+}
+
+// startWithScript starts an instance for job and uploads its script.
+func (s *suite) startWithScript(job Job) backend.Instance {
+	s.t.Helper()
+
+	startAttributes, script := s.cfg.Job(job)
+	instance := s.startInstance(startAttributes)
+
+	err := s.callErr("UploadScript", func() error { return instance.UploadScript(gocontext.TODO(), script) })
+	if err != nil {
+		s.stop(instance)
+		s.t.Fatalf("UploadScript returned error: %v", err)
+	}
+
+	return instance
+}
+
+func (s *suite) startInstance(startAttributes *backend.StartAttributes) backend.Instance {
+	s.t.Helper()
+
+	var instance backend.Instance
+	s.call("Start", func() (err error) {
+		instance, err = s.provider.Start(gocontext.TODO(), startAttributes)
+		return err
+	})
+	if instance == nil {
+		s.t.Fatal("Start returned a nil instance")
+	}
+	if instance.ID() == "" {
+		s.t.Error("instance has an empty ID")
+	}
+
+	return instance
+}
+
+// stop stops the instance. It doesn't end the test if that fails, as it is
+// usually deferred.
+func (s *suite) stop(instance backend.Instance) {
+	s.t.Helper()
+
+	err := s.callErr("Stop", func() error { return instance.Stop(gocontext.TODO()) })
+	if err != nil {
+		s.t.Errorf("Stop returned error: %v", err)
+	}
+}
+
+// syncBuffer is a bytes.Buffer that can be written to while it is read.
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func (s *suite) runScript(ctx gocontext.Context, instance backend.Instance) (*backend.RunResult, string, error) {
+	s.t.Helper()
+
+	output := &syncBuffer{}
+	var result *backend.RunResult
+	err := s.callErr("RunScript", func() (err error) {
+		result, err = instance.RunScript(ctx, output)
+		return err
+	})
+	if result == nil {
+		s.t.Fatalf("RunScript returned a nil result (err: %v)", err)
+	}
+
+	return result, output.String(), err
+}
+
+func testStartStop(s *suite) {
+	instance := s.start(Job{})
+	if instance.StartupDuration() < 0 {
+		s.t.Errorf("negative startup duration %v", instance.StartupDuration())
+	}
+	s.stop(instance)
+}
+
+func testRunScript(s *suite) {
+	instance := s.startWithScript(Job{})
+	defer s.stop(instance)
+
+	result, output, err := s.runScript(gocontext.TODO(), instance)
+	if err != nil {
+		s.t.Fatalf("RunScript returned error: %v", err)
+	}
+	if !result.Completed {
+		s.t.Error("RunScript result isn't completed")
+	}
+	if result.ExitCode != 0 {
+		s.t.Errorf("exit code %d, expected 0", result.ExitCode)
+	}
+	if !strings.Contains(output, JobOutput) {
+		s.t.Errorf("output %q doesn't contain %q", output, JobOutput)
+	}
+}
+
+func testExitCode(s *suite) {
+	instance := s.startWithScript(Job{ExitCode: 3})
+	defer s.stop(instance)
+
+	result, _, err := s.runScript(gocontext.TODO(), instance)
+	if err != nil {
+		s.t.Fatalf("RunScript returned error for a script exiting non-zero: %v", err)
+	}
+	if !result.Completed {
+		s.t.Error("RunScript result isn't completed")
+	}
+	if result.ExitCode != 3 {
+		s.t.Errorf("exit code %d, expected 3", result.ExitCode)
+	}
+}
+
+func testRunScriptCancel(s *suite) {
+	instance := s.startWithScript(Job{Endless: true})
+	defer s.stop(instance)
+
+	ctx, cancel := gocontext.WithCancel(gocontext.TODO())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	result, _, err := s.runScript(ctx, instance)
+	if err == nil {
+		s.t.Error("RunScript returned no error when cancelled")
+	}
+	if result.Completed {
+		s.t.Error("RunScript result is completed when cancelled")
+	}
+}
+
+func testStopTwice(s *suite) {
+	instance := s.start(Job{})
+	s.stop(instance)
+	s.stop(instance)
+}
+
+func testDownloadTrace(s *suite) {
+	if s.cfg.SupportsTrace {
+		s.t.Skip("provider supports traces")
+	}
+
+	instance := s.startWithScript(Job{})
+	defer s.stop(instance)
+
+	err := s.callErr("DownloadTrace", func() error {
+		_, err := instance.DownloadTrace(gocontext.TODO())
+		return err
+	})
+	if errors.Cause(err) != backend.ErrDownloadTraceNotImplemented {
+		s.t.Errorf("DownloadTrace returned %v, expected ErrDownloadTraceNotImplemented", err)
+	}
+}
@@ -17,9 +17,9 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/travis-ci/worker/config"
 	"github.com/travis-ci/worker/context"
+	workererrors "github.com/travis-ci/worker/errors"
 	"github.com/travis-ci/worker/image"
 	"github.com/travis-ci/worker/metrics"
-	"github.com/travis-ci/worker/ratelimit"
 	"github.com/travis-ci/worker/ssh"
 )
 
@@ -34,26 +34,25 @@ const (
 )
 
 var (
-	cbHelp = map[string]string{
-		"ENDPOINT":              "cloud-brain HTTP endpoint, including token",
-		"PROVIDER":              "cloud-brain provider name, e.g. \"gce-staging\"",
-		"BOOT_POLL_SLEEP":       fmt.Sprintf("sleep interval between polling server for instance ready status (default %v)", defaultCloudBrainBootPollSleep),
-		"BOOT_PRE_POLL_SLEEP":   fmt.Sprintf("time to sleep prior to polling server for instance ready status (default %v)", defaultCloudBrainBootPrePollSleep),
-		"IMAGE_SELECTOR_TYPE":   fmt.Sprintf("image selector type (\"env\" or \"api\", default %q)", defaultCloudBrainImageSelectorType),
-		"IMAGE_DEFAULT":         fmt.Sprintf("default image name to use when none found (default %q)", defaultCloudBrainImage),
-		"IMAGE_SELECTOR_URL":    "URL for image selector API, used only when image selector is \"api\"",
-		"IMAGE_SELECTOR_INFRA":  "Infra to pass to image selector API, e.g. \"gce\"",
-		"IMAGE_[ALIAS_]{ALIAS}": "full name for a given alias given via IMAGE_ALIASES, where the alias form in the key is uppercased and normalized by replacing non-alphanumerics with _",
-		"SSH_DIAL_TIMEOUT":      fmt.Sprintf("connection timeout for ssh connections (default %v)", defaultCloudBrainSSHDialTimeout),
-		"UPLOAD_RETRIES":        fmt.Sprintf("number of times to attempt to upload script before erroring (default %d)", defaultCloudBrainUploadRetries),
-		"UPLOAD_RETRY_SLEEP":    fmt.Sprintf("sleep interval between script upload attempts (default %v)", defaultCloudBrainUploadRetrySleep),
-	}
+	cbOptions = append(append([]*Option{
+		{Name: "ENDPOINT", Type: OptionString, Required: true, Help: "cloud-brain HTTP endpoint, including token"},
+		{Name: "PROVIDER", Type: OptionString, Required: true, Help: "cloud-brain provider name, e.g. \"gce-staging\""},
+		{Name: "BOOT_POLL_SLEEP", Type: OptionDuration, Default: defaultCloudBrainBootPollSleep.String(), Help: "sleep interval between polling server for instance ready status"},
+		{Name: "BOOT_PRE_POLL_SLEEP", Type: OptionDuration, Default: defaultCloudBrainBootPrePollSleep.String(), Help: "time to sleep prior to polling server for instance ready status"},
+		{Name: "IMAGE_DEFAULT", Type: OptionString, Default: defaultCloudBrainImage, Help: "default image name to use when none found"},
+		{Name: "IMAGE_SELECTOR_INFRA", Type: OptionString, Help: "Infra to pass to image selector API, e.g. \"gce\""},
+		{Name: "IMAGE_[ALIAS_]{ALIAS}", Type: OptionString, Help: "full name for a given alias given via IMAGE_ALIASES, where the alias form in the key is uppercased and normalized by replacing non-alphanumerics with _"},
+		{Name: "PUBLIC_IP", Type: OptionBool, Help: "connect to the public ip of the instance"},
+		{Name: "SSH_DIAL_TIMEOUT", Type: OptionDuration, Default: defaultCloudBrainSSHDialTimeout.String(), Help: "connection timeout for ssh connections"},
+		{Name: "UPLOAD_RETRIES", Type: OptionInt, Default: fmt.Sprintf("%d", defaultCloudBrainUploadRetries), Help: "number of times to attempt to upload script before erroring"},
+		{Name: "UPLOAD_RETRY_SLEEP", Type: OptionDuration, Default: defaultCloudBrainUploadRetrySleep.String(), Help: "sleep interval between script upload attempts"},
+	}, imageSelectorOptions(defaultCloudBrainImageSelectorType)...), rateLimitOptions("cloud-brain", 0, time.Second)...)
 
 	errCloudBrainMissingIPAddressError = fmt.Errorf("no IP address found")
 )
 
 func init() {
-	Register("cloudbrain", "CloudBrain", cbHelp, newCloudBrainProvider)
+	Register("cloudbrain", "CloudBrain", cbOptions, newCloudBrainProvider)
 }
 
 type cbProvider struct {
@@ -73,11 +72,6 @@ type cbProvider struct {
 	defaultImage       string
 	uploadRetries      uint64
 	uploadRetrySleep   time.Duration
-
-	rateLimiter         ratelimit.RateLimiter
-	rateLimitMaxCalls   uint64
-	rateLimitDuration   time.Duration
-	rateLimitQueueDepth uint64
 }
 
 type cbInstanceConfig struct {
@@ -137,11 +131,11 @@ func (gismw *cbInstanceStopMultistepWrapper) Run(multistep.StateBag) multistep.S
 
 func (gismw *cbInstanceStopMultistepWrapper) Cleanup(multistep.StateBag) { return }
 
-func buildCloudBrainClient(baseURL *url.URL, provider string) (*cbClient, error) {
+func buildCloudBrainClient(baseURL *url.URL, provider string, limiter *apiLimiter) (*cbClient, error) {
 	client := &cbClient{
 		baseURL:    baseURL,
 		provider:   provider,
-		httpClient: http.DefaultClient,
+		httpClient: &http.Client{Transport: limiter.Transport(nil)},
 	}
 	return client, nil
 }
@@ -167,7 +161,12 @@ func newCloudBrainProvider(cfg *config.ProviderConfig) (Provider, error) {
 
 	provider := cfg.Get("PROVIDER")
 
-	client, err := buildCloudBrainClient(baseURL, provider)
+	limiter, err := newAPILimiter("cloudbrain", cfg, 0, time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := buildCloudBrainClient(baseURL, provider, limiter)
 	if err != nil {
 		return nil, err
 	}
@@ -223,15 +222,9 @@ func newCloudBrainProvider(cfg *config.ProviderConfig) (Provider, error) {
 		imageSelectorType = cfg.Get("IMAGE_SELECTOR_TYPE")
 	}
 
-	if imageSelectorType != "env" && imageSelectorType != "api" {
-		return nil, fmt.Errorf("invalid image selector type %q", imageSelectorType)
-	}
-
-	if imageSelectorType == "env" || imageSelectorType == "api" {
-		imageSelector, err = buildCloudBrainImageSelector(imageSelectorType, cfg)
-		if err != nil {
-			return nil, err
-		}
+	imageSelector, err = buildImageSelector(imageSelectorType, cfg, nil)
+	if err != nil {
+		return nil, err
 	}
 
 	sshDialTimeout := defaultCloudBrainSSHDialTimeout
@@ -437,19 +430,7 @@ func (p *cbProvider) stepWaitForInstanceIP(c *cbStartContext) multistep.StepActi
 }
 
 func (p *cbProvider) imageSelect(ctx gocontext.Context, startAttributes *StartAttributes) (string, error) {
-	jobID, _ := context.JobIDFromContext(ctx)
-	repo, _ := context.RepositoryFromContext(ctx)
-
-	imageName, err := p.imageSelector.Select(&image.Params{
-		Infra:    p.imageSelectorInfra,
-		Language: startAttributes.Language,
-		OsxImage: startAttributes.OsxImage,
-		Dist:     startAttributes.Dist,
-		Group:    startAttributes.Group,
-		OS:       startAttributes.OS,
-		JobID:    jobID,
-		Repo:     repo,
-	})
+	imageName, err := p.imageSelector.Select(p.imageParams(ctx, startAttributes))
 
 	if err != nil {
 		return "", err
@@ -462,21 +443,30 @@ func (p *cbProvider) imageSelect(ctx gocontext.Context, startAttributes *StartAt
 	return imageName, nil
 }
 
-func buildCloudBrainImageSelector(selectorType string, cfg *config.ProviderConfig) (image.Selector, error) {
-	switch selectorType {
-	case "env":
-		return image.NewEnvSelector(cfg)
-	case "api":
-		baseURL, err := url.Parse(cfg.Get("IMAGE_SELECTOR_URL"))
-		if err != nil {
-			return nil, err
-		}
-		return image.NewAPISelector(baseURL), nil
-	default:
-		return nil, fmt.Errorf("invalid image selector type %q", selectorType)
+func (p *cbProvider) imageParams(ctx gocontext.Context, startAttributes *StartAttributes) *image.Params {
+	jobID, _ := context.JobIDFromContext(ctx)
+	repo, _ := context.RepositoryFromContext(ctx)
+
+	return &image.Params{
+		Infra:     p.imageSelectorInfra,
+		Language:  startAttributes.Language,
+		OsxImage:  startAttributes.OsxImage,
+		Dist:      startAttributes.Dist,
+		Group:     startAttributes.Group,
+		OS:        startAttributes.OS,
+		JobID:     jobID,
+		Repo:      repo,
+		Queue:     startAttributes.Queue,
+		Selection: &startAttributes.ImageSelection,
 	}
 }
 
+// ExplainImage explains which image would be selected for the job, without
+// starting anything.
+func (p *cbProvider) ExplainImage(ctx gocontext.Context, startAttributes *StartAttributes) (*image.Explanation, error) {
+	return explainImage(p.imageSelector, p.imageParams(ctx, startAttributes), "", p.defaultImage), nil
+}
+
 func (i *cbInstance) sshConnection(ctx gocontext.Context) (ssh.Connection, error) {
 	if i.cachedIPAddr == "" {
 		err := i.refreshInstance(ctx)
@@ -579,7 +569,7 @@ func (i *cbInstance) uploadScriptAttempt(ctx gocontext.Context, script []byte) e
 func (i *cbInstance) RunScript(ctx gocontext.Context, output io.Writer) (*RunResult, error) {
 	conn, err := i.sshConnection(ctx)
 	if err != nil {
-		return &RunResult{Completed: false}, errors.Wrap(err, "couldn't connect to SSH server")
+		return &RunResult{Completed: false}, errors.Wrap(workererrors.Classify(err, workererrors.ClassSSHUnreachable), "couldn't connect to SSH server")
 	}
 	defer conn.Close()
 
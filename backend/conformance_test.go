@@ -0,0 +1,56 @@
+package backend_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/travis-ci/worker/backend"
+	"github.com/travis-ci/worker/backend/backendtest"
+	"github.com/travis-ci/worker/config"
+)
+
+func newConformanceProvider(t *testing.T, name string, cfg map[string]string) backend.Provider {
+	provider, err := backend.NewBackendProvider(name, config.ProviderConfigFromMap(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestFakeConformance(t *testing.T) {
+	backendtest.Run(t, &backendtest.Config{
+		NewProvider: func(t *testing.T) backend.Provider {
+			return newConformanceProvider(t, "fake", map[string]string{
+				"LOG_OUTPUT": backendtest.JobOutput,
+			})
+		},
+		Job: func(job backendtest.Job) (*backend.StartAttributes, []byte) {
+			startAttributes, script := backendtest.ShellJob(job)
+			startAttributes.FakeFaults = map[string]interface{}{
+				"run": fmt.Sprintf("exit:%d", job.ExitCode),
+			}
+			if job.Endless {
+				startAttributes.FakeFaults["run"] = "endless"
+			}
+			return startAttributes, script
+		},
+	})
+}
+
+func TestLocalConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "travis-worker-local-conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backendtest.Run(t, &backendtest.Config{
+		NewProvider: func(t *testing.T) backend.Provider {
+			return newConformanceProvider(t, "local", map[string]string{
+				"SCRIPTS_DIR": dir,
+			})
+		},
+	})
+}
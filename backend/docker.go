@@ -7,7 +7,6 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
-	"net/url"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -27,6 +26,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/travis-ci/worker/config"
 	"github.com/travis-ci/worker/context"
+	workererrors "github.com/travis-ci/worker/errors"
 	"github.com/travis-ci/worker/image"
 	"github.com/travis-ci/worker/metrics"
 	"github.com/travis-ci/worker/ssh"
@@ -47,29 +47,30 @@ var (
 	defaultInspectInterval                     = 500 * time.Millisecond
 	defaultExecCmd                             = "bash /home/travis/build.sh"
 	defaultTmpfsMap                            = map[string]string{"/run": "rw,nosuid,nodev,exec,noatime,size=65536k"}
-	dockerHelp                                 = map[string]string{
-		"ENDPOINT / HOST":     "[REQUIRED] tcp or unix address for connecting to Docker",
-		"CERT_PATH":           "directory where ca.pem, cert.pem, and key.pem are located (default \"\")",
-		"CMD":                 "command (CMD) to run when creating containers (default \"/sbin/init\")",
-		"EXEC_CMD":            fmt.Sprintf("command to run via exec/ssh (default %q)", defaultExecCmd),
-		"INSPECT_INTERVAL":    fmt.Sprintf("time to wait between container inspections as duration (default %q)", defaultInspectInterval),
-		"TMPFS_MAP":           fmt.Sprintf("comma- or space-delimited key:value map of tmpfs mounts (default %q)", defaultTmpfsMap),
-		"MEMORY":              "memory to allocate to each container (0 disables allocation, default \"4G\")",
-		"SHM":                 "/dev/shm to allocate to each container (0 disables allocation, default \"64MiB\")",
-		"CONTAINER_LABELS":    "comma- or space-delimited key:value pairs of labels to apply to each container (default \"\")",
-		"CPUS":                "cpu count to allocate to each container (0 disables allocation, default 2)",
-		"CPU_SET_SIZE":        "size of available cpu set (default detected locally via runtime.NumCPU)",
-		"NATIVE":              "upload and run build script via docker API instead of over ssh (default false)",
-		"PRIVILEGED":          "run containers in privileged mode (default false)",
-		"SSH_DIAL_TIMEOUT":    fmt.Sprintf("connection timeout for ssh connections (default %v)", defaultDockerSSHDialTimeout),
-		"IMAGE_SELECTOR_TYPE": fmt.Sprintf("image selector type (\"tag\", \"api\", or \"env\", default %q)", defaultDockerImageSelectorType),
-		"IMAGE_SELECTOR_URL":  "URL for image selector API, used only when image selector is \"api\"",
-		"BINDS":               "Bind mount a volume (example: \"/var/run/docker.sock:/var/run/docker.sock\", default \"\")",
-	}
+	dockerOptions                              = append(append([]*Option{
+		{Name: "ENDPOINT", Aliases: []string{"HOST"}, Type: OptionString, Required: true, Help: "tcp or unix address for connecting to Docker"},
+		{Name: "API_VERSION", Type: OptionString, Help: "Docker API version to use, negotiated with the daemon if not set"},
+		{Name: "CERT_PATH", Type: OptionString, Help: "directory where ca.pem, cert.pem, and key.pem are located"},
+		{Name: "TLS_VERIFY", Type: OptionString, Help: "verify the Docker daemon's certificate when CERT_PATH is set, if not empty"},
+		{Name: "CMD", Type: OptionString, Default: "/sbin/init", Help: "command (CMD) to run when creating containers"},
+		{Name: "EXEC_CMD", Type: OptionString, Default: defaultExecCmd, Help: "command to run via exec/ssh"},
+		{Name: "INSPECT_INTERVAL", Type: OptionDuration, Default: defaultInspectInterval.String(), Help: "time to wait between container inspections as duration"},
+		{Name: "TMPFS_MAP", Type: OptionString, Help: fmt.Sprintf("comma- or space-delimited key:value map of tmpfs mounts (default %q)", defaultTmpfsMap)},
+		{Name: "MEMORY", Type: OptionString, Default: "4G", Help: "memory to allocate to each container (0 disables allocation)"},
+		{Name: "SHM", Type: OptionString, Default: "64MiB", Help: "/dev/shm to allocate to each container (0 disables allocation)"},
+		{Name: "CONTAINER_LABELS", Type: OptionString, Help: "comma- or space-delimited key:value pairs of labels to apply to each container"},
+		{Name: "CPUS", Type: OptionInt, Default: "2", Help: "cpu count to allocate to each container (0 disables allocation)"},
+		{Name: "CPU_SET_SIZE", Type: OptionInt, Help: "size of available cpu set (default detected locally via runtime.NumCPU)"},
+		{Name: "NATIVE", Type: OptionBool, Default: "false", Help: "upload and run build script via docker API instead of over ssh"},
+		{Name: "PRIVILEGED", Type: OptionBool, Default: "false", Help: "run containers in privileged mode"},
+		{Name: "SSH_DIAL_TIMEOUT", Type: OptionDuration, Default: defaultDockerSSHDialTimeout.String(), Help: "connection timeout for ssh connections"},
+		{Name: "IMAGE_[ALIAS_]{ALIAS}", Type: OptionString, Help: "full name for a given alias, used only when image selector is \"env\""},
+		{Name: "BINDS", Type: OptionString, Help: "Bind mount a volume (example: \"/var/run/docker.sock:/var/run/docker.sock\")"},
+	}, imageSelectorOptions(defaultDockerImageSelectorType, "tag")...), rateLimitOptions("Docker", 0, time.Second)...)
 )
 
 func init() {
-	Register("docker", "Docker", dockerHelp, newDockerProvider)
+	Register("docker", "Docker", dockerOptions, newDockerProvider)
 }
 
 type dockerNumCPUer interface {
@@ -99,6 +100,7 @@ type dockerProvider struct {
 	tmpFs           map[string]string
 	imageSelector   image.Selector
 	containerLabels map[string]string
+	apiLimiter      *apiLimiter
 
 	cpuSetsMutex sync.Mutex
 	cpuSets      []bool
@@ -112,6 +114,7 @@ type dockerInstance struct {
 
 	imageName string
 	runNative bool
+	removed   bool
 }
 
 type dockerTagImageSelector struct {
@@ -229,7 +232,9 @@ func newDockerProvider(cfg *config.ProviderConfig) (Provider, error) {
 		imageSelectorType = cfg.Get("IMAGE_SELECTOR_TYPE")
 	}
 
-	imageSelector, err := buildDockerImageSelector(imageSelectorType, client, cfg)
+	imageSelector, err := buildImageSelector(imageSelectorType, cfg, map[string]func() (image.Selector, error){
+		"tag": func() (image.Selector, error) { return &dockerTagImageSelector{client: client}, nil },
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "couldn't build docker image selector")
 	}
@@ -239,8 +244,14 @@ func newDockerProvider(cfg *config.ProviderConfig) (Provider, error) {
 		containerLabels = str2map(cfg.Get("CONTAINER_LABELS"))
 	}
 
+	apiLimiter, err := newAPILimiter("docker", cfg, 0, time.Second)
+	if err != nil {
+		return nil, err
+	}
+
 	return &dockerProvider{
 		client:         client,
+		apiLimiter:     apiLimiter,
 		sshDialer:      sshDialer,
 		sshDialTimeout: sshDialTimeout,
 
@@ -305,23 +316,6 @@ func buildDockerClient(cfg *config.ProviderConfig) (*docker.Client, error) {
 	return docker.NewClient(endpoint, dockerAPIVersion, httpClient, nil)
 }
 
-func buildDockerImageSelector(selectorType string, client *docker.Client, cfg *config.ProviderConfig) (image.Selector, error) {
-	switch selectorType {
-	case "tag":
-		return &dockerTagImageSelector{client: client}, nil
-	case "env":
-		return image.NewEnvSelector(cfg)
-	case "api":
-		baseURL, err := url.Parse(cfg.Get("IMAGE_SELECTOR_URL"))
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to parse image selector URL")
-		}
-		return image.NewAPISelector(baseURL), nil
-	default:
-		return nil, fmt.Errorf("invalid image selector type %q", selectorType)
-	}
-}
-
 // dockerImageNameForID returns a human-readable name for the image with the requested ID.
 // Currently, we are using the tag that includes the stack-name (e.g "travisci/ci-garnet:packer-1505167479") and reverting back to the ID if nothing is found.
 func (p *dockerProvider) dockerImageNameForID(ctx gocontext.Context, imageID string) string {
@@ -349,6 +343,26 @@ func (p *dockerProvider) StartWithProgress(ctx gocontext.Context, startAttribute
 	return p.Start(ctx, startAttributes)
 }
 
+func (p *dockerProvider) imageParams(ctx gocontext.Context, startAttributes *StartAttributes) *image.Params {
+	jobID, _ := context.JobIDFromContext(ctx)
+	repo, _ := context.RepositoryFromContext(ctx)
+
+	return &image.Params{
+		Language:  startAttributes.Language,
+		Infra:     "docker",
+		JobID:     jobID,
+		Repo:      repo,
+		Queue:     startAttributes.Queue,
+		Selection: &startAttributes.ImageSelection,
+	}
+}
+
+// ExplainImage explains which image would be selected for the job, without
+// starting anything.
+func (p *dockerProvider) ExplainImage(ctx gocontext.Context, startAttributes *StartAttributes) (*image.Explanation, error) {
+	return explainImage(p.imageSelector, p.imageParams(ctx, startAttributes), startAttributes.ImageName, ""), nil
+}
+
 func (p *dockerProvider) Start(ctx gocontext.Context, startAttributes *StartAttributes) (Instance, error) {
 	var (
 		imageID   string
@@ -360,10 +374,7 @@ func (p *dockerProvider) Start(ctx gocontext.Context, startAttributes *StartAttr
 	if startAttributes.ImageName != "" {
 		imageName = startAttributes.ImageName
 	} else {
-		selectedImageID, err := p.imageSelector.Select(&image.Params{
-			Language: startAttributes.Language,
-			Infra:    "docker",
-		})
+		selectedImageID, err := p.imageSelector.Select(p.imageParams(ctx, startAttributes))
 		if err != nil {
 			logger.WithField("err", err).Error("couldn't select image")
 			return nil, err
@@ -373,14 +384,14 @@ func (p *dockerProvider) Start(ctx gocontext.Context, startAttributes *StartAttr
 	}
 
 	containerName := hostnameFromContext(ctx)
-	existingContainer, err := p.client.ContainerInspect(ctx, containerName)
+
+	var existingContainer dockertypes.ContainerJSON
+	err := p.apiLimiter.Do(ctx, func() (err error) {
+		existingContainer, err = p.client.ContainerInspect(ctx, containerName)
+		return err
+	})
 	if err == nil {
-		err := p.client.ContainerRemove(ctx, existingContainer.ID,
-			dockertypes.ContainerRemoveOptions{
-				Force:         true,
-				RemoveLinks:   false,
-				RemoveVolumes: true,
-			})
+		err := p.removeContainer(ctx, existingContainer.ID)
 		if err != nil {
 			logger.WithField("err", err).Error("couldn't remove preexisting container before create")
 		} else {
@@ -447,8 +458,12 @@ func (p *dockerProvider) Start(ctx gocontext.Context, startAttributes *StartAttr
 		"host_config": fmt.Sprintf("%#v", dockerHostConfig),
 	}).Debug("creating container")
 
-	container, err := p.client.ContainerCreate(
-		ctx, dockerConfig, dockerHostConfig, nil, containerName)
+	var container dockercontainer.ContainerCreateCreatedBody
+	err = p.apiLimiter.Do(ctx, func() (err error) {
+		container, err = p.client.ContainerCreate(
+			ctx, dockerConfig, dockerHostConfig, nil, containerName)
+		return err
+	})
 
 	if err != nil {
 		logger.WithField("err", err).Error("couldn't create container")
@@ -456,12 +471,7 @@ func (p *dockerProvider) Start(ctx gocontext.Context, startAttributes *StartAttr
 			p.checkinCPUSets(ctx, cpuSets)
 		}
 
-		err := p.client.ContainerRemove(ctx, container.ID,
-			dockertypes.ContainerRemoveOptions{
-				Force:         true,
-				RemoveLinks:   false,
-				RemoveVolumes: true,
-			})
+		err := p.removeContainer(ctx, container.ID)
 		if err != nil {
 			logger.WithField("err", err).Error("couldn't remove container after create failure")
 		}
@@ -471,7 +481,9 @@ func (p *dockerProvider) Start(ctx gocontext.Context, startAttributes *StartAttr
 
 	startBooting := time.Now()
 
-	err = p.client.ContainerStart(ctx, container.ID, dockertypes.ContainerStartOptions{})
+	err = p.apiLimiter.Do(ctx, func() error {
+		return p.client.ContainerStart(ctx, container.ID, dockertypes.ContainerStartOptions{})
+	})
 	if err != nil {
 		logger.WithField("err", err).Error("couldn't start container")
 		if useCPUSets {
@@ -484,7 +496,11 @@ func (p *dockerProvider) Start(ctx gocontext.Context, startAttributes *StartAttr
 	errChan := make(chan error)
 	go func(id string) {
 		for {
-			container, err := p.client.ContainerInspect(ctx, id)
+			var container dockertypes.ContainerJSON
+			err := p.apiLimiter.Do(ctx, func() (err error) {
+				container, err = p.client.ContainerInspect(ctx, id)
+				return err
+			})
 			if err != nil {
 				errChan <- err
 				return
@@ -523,6 +539,25 @@ func (p *dockerProvider) Start(ctx gocontext.Context, startAttributes *StartAttr
 
 func (p *dockerProvider) Setup(ctx gocontext.Context) error { return nil }
 
+// Close closes the connections held by the Docker API client.
+func (p *dockerProvider) Close() error {
+	if closer, ok := p.client.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (p *dockerProvider) removeContainer(ctx gocontext.Context, id string) error {
+	return p.apiLimiter.Do(ctx, func() error {
+		return p.client.ContainerRemove(ctx, id,
+			dockertypes.ContainerRemoveOptions{
+				Force:         true,
+				RemoveLinks:   false,
+				RemoveVolumes: true,
+			})
+	})
+}
+
 func (p *dockerProvider) checkoutCPUSets(ctx gocontext.Context) (string, error) {
 	p.cpuSetsMutex.Lock()
 	defer p.cpuSetsMutex.Unlock()
@@ -581,8 +616,11 @@ func (p *dockerProvider) checkinCPUSets(ctx gocontext.Context, sets string) {
 }
 
 func (i *dockerInstance) sshConnection(ctx gocontext.Context) (ssh.Connection, error) {
-	var err error
-	container, err := i.client.ContainerInspect(ctx, i.container.ID)
+	var container dockertypes.ContainerJSON
+	err := i.provider.apiLimiter.Do(ctx, func() (err error) {
+		container, err = i.client.ContainerInspect(ctx, i.container.ID)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -687,21 +725,33 @@ func (i *dockerInstance) runScriptExec(ctx gocontext.Context, output io.Writer)
 	go func() {
 		buf := make([]byte, 8192)
 		didFirstByte := false
+		defer func() {
+			if !didFirstByte {
+				close(firstByte)
+			}
+		}()
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			default:
 			}
-			n, _ := tee.Read(buf)
+			n, err := tee.Read(buf)
 			if n != 0 && !didFirstByte {
-				firstByte <- struct{}{}
+				close(firstByte)
 				didFirstByte = true
 			}
+			if err != nil {
+				return
+			}
 		}
 	}()
 
-	<-firstByte
+	select {
+	case <-firstByte:
+	case <-ctx.Done():
+		return &RunResult{Completed: false}, ctx.Err()
+	}
 	for {
 		inspect, err := i.client.ContainerExecInspect(ctx, exec.ID)
 		if err != nil {
@@ -724,7 +774,7 @@ func (i *dockerInstance) runScriptExec(ctx gocontext.Context, output io.Writer)
 func (i *dockerInstance) runScriptSSH(ctx gocontext.Context, output io.Writer) (*RunResult, error) {
 	conn, err := i.sshConnection(ctx)
 	if err != nil {
-		return &RunResult{Completed: false}, errors.Wrap(err, "couldn't connect to SSH server")
+		return &RunResult{Completed: false}, errors.Wrap(workererrors.Classify(err, workererrors.ClassSSHUnreachable), "couldn't connect to SSH server")
 	}
 	defer conn.Close()
 
@@ -782,7 +832,7 @@ func (i *dockerInstance) downloadTraceNative(ctx gocontext.Context) ([]byte, err
 func (i *dockerInstance) downloadTraceSSH(ctx gocontext.Context) ([]byte, error) {
 	conn, err := i.sshConnection(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "couldn't connect to SSH server")
+		return nil, errors.Wrap(workererrors.Classify(err, workererrors.ClassSSHUnreachable), "couldn't connect to SSH server")
 	}
 	defer conn.Close()
 
@@ -795,22 +845,29 @@ func (i *dockerInstance) downloadTraceSSH(ctx gocontext.Context) ([]byte, error)
 }
 
 func (i *dockerInstance) Stop(ctx gocontext.Context) error {
+	if i.removed {
+		return nil
+	}
+
 	defer i.provider.checkinCPUSets(ctx, i.container.HostConfig.Resources.CpusetCpus)
 	logger := context.LoggerFromContext(ctx).WithField("self", "backend/docker_provider")
 
 	timeout := 30 * time.Second
-	err := i.client.ContainerStop(ctx, i.container.ID, &timeout)
+	err := i.provider.apiLimiter.Do(ctx, func() error {
+		return i.client.ContainerStop(ctx, i.container.ID, &timeout)
+	})
 	if err != nil {
 		logger.Warn("couldn't stop container")
 		return err
 	}
 
-	return i.client.ContainerRemove(ctx, i.container.ID,
-		dockertypes.ContainerRemoveOptions{
-			Force:         true,
-			RemoveLinks:   false,
-			RemoveVolumes: true,
-		})
+	err = i.provider.removeContainer(ctx, i.container.ID)
+	if err != nil {
+		return err
+	}
+
+	i.removed = true
+	return nil
 }
 
 func (i *dockerInstance) ID() string {
@@ -867,5 +924,5 @@ func findDockerImageByTag(searchTags []string, images []dockertypes.ImageSummary
 		}
 	}
 
-	return "", fmt.Errorf("failed to find matching docker image tag")
+	return "", workererrors.Classify(fmt.Errorf("failed to find matching docker image tag"), workererrors.ClassImageNotFound)
 }
@@ -0,0 +1,268 @@
+package backend_test
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/travis-ci/worker/backend"
+	"github.com/travis-ci/worker/backend/backendtest"
+)
+
+var fakeDockerPath = regexp.MustCompile(`^/v[0-9.]+/(containers|exec|images)/([^/]+)(?:/([^/]+))?$`)
+
+type fakeDockerContainer struct {
+	ID      string
+	Name    string
+	Created time.Time
+	CPUSet  string
+	Script  []byte
+}
+
+type fakeDockerExec struct {
+	container *fakeDockerContainer
+	running   bool
+	exitCode  int
+}
+
+// fakeDockerDaemon implements the parts of the Docker API used by the docker
+// provider in native mode. Its containers run the uploaded build script with
+// the local bash.
+type fakeDockerDaemon struct {
+	t *testing.T
+
+	mutex      sync.Mutex
+	containers map[string]*fakeDockerContainer
+	execs      map[string]*fakeDockerExec
+}
+
+func newFakeDockerDaemon(t *testing.T) *fakeDockerDaemon {
+	return &fakeDockerDaemon{
+		t:          t,
+		containers: map[string]*fakeDockerContainer{},
+		execs:      map[string]*fakeDockerExec{},
+	}
+}
+
+func (d *fakeDockerDaemon) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	match := fakeDockerPath.FindStringSubmatch(req.URL.Path)
+	if match == nil {
+		d.t.Errorf("unexpected Docker API request %s %s", req.Method, req.URL.Path)
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	kind, id, action := match[1], match[2], match[3]
+
+	switch {
+	case kind == "images" && id == "json":
+		d.writeJSON(w, http.StatusOK, []map[string]interface{}{
+			{"Id": "sha256:" + strings.Repeat("ab", 32), "RepoTags": []string{"travis:default"}},
+		})
+	case kind == "containers" && id == "create":
+		d.createContainer(w, req)
+	case kind == "containers":
+		d.containerAction(w, req, id, action)
+	case kind == "exec" && action == "start":
+		d.startExec(w, req, id)
+	case kind == "exec" && action == "json":
+		d.mutex.Lock()
+		e, ok := d.execs[id]
+		state := map[string]interface{}{}
+		if ok {
+			state["Running"], state["ExitCode"] = e.running, e.exitCode
+		}
+		d.mutex.Unlock()
+
+		if !ok {
+			d.writeNotFound(w, "exec", id)
+			return
+		}
+		d.writeJSON(w, http.StatusOK, state)
+	default:
+		d.t.Errorf("unexpected Docker API request %s %s", req.Method, req.URL.Path)
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (d *fakeDockerDaemon) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (d *fakeDockerDaemon) writeNotFound(w http.ResponseWriter, kind, id string) {
+	d.writeJSON(w, http.StatusNotFound, map[string]string{
+		"message": fmt.Sprintf("No such %s: %s", kind, id),
+	})
+}
+
+// lookup finds a container by ID or name. The mutex must be held.
+func (d *fakeDockerDaemon) lookup(id string) *fakeDockerContainer {
+	for _, c := range d.containers {
+		if c.ID == id || c.Name == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (d *fakeDockerDaemon) createContainer(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		HostConfig struct {
+			CpusetCpus string
+		}
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		d.t.Errorf("couldn't decode container create request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	name := req.URL.Query().Get("name")
+	c := &fakeDockerContainer{
+		ID:      fmt.Sprintf("%x", sha256.Sum256([]byte(name))),
+		Name:    name,
+		Created: time.Now(),
+		CPUSet:  body.HostConfig.CpusetCpus,
+	}
+
+	d.mutex.Lock()
+	d.containers[c.ID] = c
+	d.mutex.Unlock()
+
+	d.writeJSON(w, http.StatusCreated, map[string]interface{}{"Id": c.ID, "Warnings": nil})
+}
+
+func (d *fakeDockerDaemon) containerAction(w http.ResponseWriter, req *http.Request, id, action string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	c := d.lookup(id)
+	if c == nil {
+		d.writeNotFound(w, "container", id)
+		return
+	}
+
+	switch {
+	case action == "json":
+		d.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"Id":              c.ID,
+			"Name":            "/" + c.Name,
+			"Created":         c.Created.Format(time.RFC3339Nano),
+			"State":           map[string]interface{}{"Running": true},
+			"HostConfig":      map[string]interface{}{"CpusetCpus": c.CPUSet},
+			"NetworkSettings": map[string]interface{}{},
+		})
+	case action == "start" || action == "stop":
+		w.WriteHeader(http.StatusNoContent)
+	case action == "archive" && req.Method == "PUT":
+		tr := tar.NewReader(req.Body)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			}
+			if hdr.Name == "/home/travis/build.sh" {
+				c.Script, _ = ioutil.ReadAll(tr)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	case action == "exec":
+		execID := fmt.Sprintf("%s-exec-%d", c.ID[:12], len(d.execs))
+		d.execs[execID] = &fakeDockerExec{container: c}
+		d.writeJSON(w, http.StatusCreated, map[string]string{"Id": execID})
+	case action == "" && req.Method == "DELETE":
+		delete(d.containers, c.ID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		d.t.Errorf("unexpected Docker API request %s %s", req.Method, req.URL.Path)
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// startExec runs the container's build script, streaming its output over the
+// hijacked connection until it exits or the client hangs up.
+func (d *fakeDockerDaemon) startExec(w http.ResponseWriter, req *http.Request, id string) {
+	d.mutex.Lock()
+	e, ok := d.execs[id]
+	if ok {
+		e.running = true
+	}
+	d.mutex.Unlock()
+
+	if !ok {
+		d.writeNotFound(w, "exec", id)
+		return
+	}
+
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		d.t.Errorf("couldn't hijack connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	if _, ok := req.Header["Upgrade"]; ok {
+		fmt.Fprintf(conn, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	} else {
+		fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Type: application/vnd.docker.raw-stream\r\n\r\n")
+	}
+
+	cmd := exec.Command("bash", "-c", string(e.container.Script))
+	cmd.Stdout = conn
+	cmd.Stderr = conn
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	exitCode := -1
+	if err := cmd.Start(); err == nil {
+		go func() {
+			_, _ = io.Copy(ioutil.Discard, conn)
+			_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}()
+
+		_ = cmd.Wait()
+		if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Exited() {
+			exitCode = status.ExitStatus()
+		}
+	}
+
+	d.mutex.Lock()
+	e.running, e.exitCode = false, exitCode
+	d.mutex.Unlock()
+}
+
+func TestDockerConformance(t *testing.T) {
+	servers := []*httptest.Server{}
+	defer func() {
+		for _, server := range servers {
+			server.Close()
+		}
+	}()
+
+	backendtest.Run(t, &backendtest.Config{
+		NewProvider: func(t *testing.T) backend.Provider {
+			server := httptest.NewServer(newFakeDockerDaemon(t))
+			servers = append(servers, server)
+
+			return newConformanceProvider(t, "docker", map[string]string{
+				"ENDPOINT":         strings.Replace(server.URL, "http", "tcp", 1),
+				"API_VERSION":      "1.24",
+				"NATIVE":           "true",
+				"INSPECT_INTERVAL": "10ms",
+			})
+		},
+		SupportsTrace: true,
+	})
+}
@@ -390,6 +390,33 @@ func TestDockerProvider_Setup(t *testing.T) {
 	provider.Setup(nil)
 }
 
+func TestDockerProvider_ExplainImage_WithCanary(t *testing.T) {
+	provider, err := dockerTestSetup(t, config.ProviderConfigFromMap(map[string]string{
+		"IMAGE_SELECTOR_TYPE":           "chain",
+		"IMAGE_SELECTOR_CHAIN":          "env",
+		"IMAGE_SELECTOR_CANARY_IMAGE":   "travis-ci-canary",
+		"IMAGE_SELECTOR_CANARY_PERCENT": "100",
+		"IMAGE_SELECTOR_CANARY_MATCH":   "language=ruby",
+	}))
+	defer dockerTestTeardown()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	startAttributes := &StartAttributes{Language: "ruby"}
+
+	// the canary is picked by hashing the repository, so jobs without one
+	// in their context are never sent to it
+	explanation, err := provider.ExplainImage(gocontext.TODO(), startAttributes)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "travis-ci-canary", explanation.Image)
+
+	ctx := context.FromRepository(context.FromJobID(gocontext.TODO(), 4), "foobar/quux")
+	explanation, err = provider.ExplainImage(ctx, startAttributes)
+	assert.Nil(t, err)
+	assert.Equal(t, "travis-ci-canary", explanation.Image)
+}
+
 func TestDockerInstance_UploadScript_WithNative(t *testing.T) {
 	for _, dockerAPIVersion := range dockerAPIVersions {
 		provider, err := dockerTestSetup(t, config.ProviderConfigFromMap(map[string]string{
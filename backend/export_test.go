@@ -0,0 +1,19 @@
+package backend
+
+import (
+	"net/http"
+
+	"github.com/travis-ci/worker/config"
+)
+
+// NewGCEProviderWithTransport builds a GCE provider that sends its API
+// requests through transport, for the conformance tests.
+func NewGCEProviderWithTransport(cfg *config.ProviderConfig, transport http.RoundTripper) (Provider, error) {
+	gceCustomHTTPTransportLock.Lock()
+	defer gceCustomHTTPTransportLock.Unlock()
+
+	gceCustomHTTPTransport = transport
+	defer func() { gceCustomHTTPTransport = nil }()
+
+	return newGCEProvider(cfg)
+}
@@ -4,25 +4,36 @@ import (
 	"context"
 	"errors"
 	"io"
+	"math/rand"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/travis-ci/worker/config"
 )
 
 func init() {
-	Register("fake", "Fake", map[string]string{
-		"LOG_OUTPUT": "faked log output to write",
-		"RUN_SLEEP":  "faked runtime sleep duration",
-		"ERROR":      "error out all jobs (useful for testing requeue storms)",
-	}, newFakeProvider)
+	Register("fake", "Fake", append([]*Option{
+		{Name: "LOG_OUTPUT", Type: OptionString, Help: "faked log output to write"},
+		{Name: "LOG_BYTES", Type: OptionInt, Help: "number of bytes of generated log lines to write after the log output"},
+		{Name: "STARTUP_DURATION", Type: OptionDuration, Help: "faked instance startup duration"},
+		{Name: "RUN_SLEEP", Type: OptionDuration, Help: "faked runtime sleep duration"},
+		{Name: "ERROR", Type: OptionBool, Help: "error out all jobs (useful for testing requeue storms)"},
+	}, fakeFaultOptions()...), newFakeProvider)
 }
 
 type fakeProvider struct {
-	cfg *config.ProviderConfig
+	cfg    *config.ProviderConfig
+	random func() float64
 }
 
 func newFakeProvider(cfg *config.ProviderConfig) (Provider, error) {
-	return &fakeProvider{cfg: cfg}, nil
+	_, err := parseFakeFaults(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fakeProvider{cfg: cfg, random: rand.Float64}, nil
 }
 
 func (p *fakeProvider) SupportsProgress() bool {
@@ -33,7 +44,7 @@ func (p *fakeProvider) StartWithProgress(ctx context.Context, startAttributes *S
 	return p.Start(ctx, startAttributes)
 }
 
-func (p *fakeProvider) Start(ctx context.Context, _ *StartAttributes) (Instance, error) {
+func (p *fakeProvider) Start(ctx context.Context, startAttributes *StartAttributes) (Instance, error) {
 	var (
 		dur time.Duration
 		err error
@@ -46,13 +57,32 @@ func (p *fakeProvider) Start(ctx context.Context, _ *StartAttributes) (Instance,
 		}
 	}
 
-	return &fakeInstance{p: p, startupDuration: dur}, nil
+	var overrides map[string]interface{}
+	if startAttributes != nil {
+		overrides = startAttributes.FakeFaults
+	}
+
+	faults, err := parseFakeFaults(p.cfg, overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	fault, err := faults["START"].inject(ctx, p.random)
+	if err != nil {
+		return nil, err
+	}
+	if fault == "error" {
+		return nil, errors.New("fake provider injected a start error")
+	}
+
+	return &fakeInstance{p: p, faults: faults, startupDuration: dur}, nil
 }
 
 func (p *fakeProvider) Setup(ctx context.Context) error { return nil }
 
 type fakeInstance struct {
-	p *fakeProvider
+	p      *fakeProvider
+	faults map[string]*fakeFault
 
 	startupDuration time.Duration
 }
@@ -66,6 +96,18 @@ func (i *fakeInstance) SupportsProgress() bool {
 }
 
 func (i *fakeInstance) UploadScript(ctx context.Context, script []byte) error {
+	fault, err := i.faults["UPLOAD"].inject(ctx, i.p.random)
+	if err != nil {
+		return err
+	}
+
+	switch fault {
+	case "error":
+		return errors.New("fake provider injected an upload error")
+	case "stale_vm":
+		return ErrStaleVM
+	}
+
 	return nil
 }
 
@@ -74,6 +116,20 @@ func (i *fakeInstance) RunScript(ctx context.Context, writer io.Writer) (*RunRes
 		return &RunResult{Completed: false}, errors.New("fake provider is configured to error all jobs")
 	}
 
+	fault, err := i.faults["RUN"].inject(ctx, i.p.random)
+	if err != nil {
+		return &RunResult{Completed: false}, err
+	}
+
+	switch fault {
+	case "error":
+		return &RunResult{Completed: true}, errors.New("fake provider injected a run error")
+	case "incomplete":
+		return &RunResult{Completed: false}, errors.New("fake provider injected a lost connection")
+	case "endless":
+		return writeEndlessFakeLog(ctx, writer)
+	}
+
 	if i.p.cfg.IsSet("RUN_SLEEP") {
 		rs, err := time.ParseDuration(i.p.cfg.Get("RUN_SLEEP"))
 		if err != nil {
@@ -82,19 +138,92 @@ func (i *fakeInstance) RunScript(ctx context.Context, writer io.Writer) (*RunRes
 		time.Sleep(rs)
 	}
 
-	_, err := writer.Write([]byte(i.p.cfg.Get("LOG_OUTPUT")))
+	_, err = writer.Write([]byte(i.p.cfg.Get("LOG_OUTPUT")))
 	if err != nil {
 		return &RunResult{Completed: false}, err
 	}
 
+	if i.p.cfg.IsSet("LOG_BYTES") {
+		logBytes, err := strconv.Atoi(i.p.cfg.Get("LOG_BYTES"))
+		if err != nil {
+			return &RunResult{Completed: false}, err
+		}
+
+		err = writeFakeLogLines(writer, logBytes)
+		if err != nil {
+			return &RunResult{Completed: false}, err
+		}
+	}
+
+	if fault == "exit" {
+		return &RunResult{Completed: true, ExitCode: i.faults["RUN"].exitCode}, nil
+	}
+
 	return &RunResult{Completed: true}, nil
 }
 
+// writeEndlessFakeLog writes log lines until ctx is done.
+func writeEndlessFakeLog(ctx context.Context, writer io.Writer) (*RunResult, error) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		err := writeFakeLogLines(writer, len(fakeLogLine))
+		if err != nil {
+			return &RunResult{Completed: false}, err
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return &RunResult{Completed: false}, ctx.Err()
+		}
+	}
+}
+
+var fakeLogLine = []byte(strings.Repeat("fake log output ", 5) + "\n")
+
+// writeFakeLogLines writes n bytes of log output, one line per write like a
+// build would.
+func writeFakeLogLines(writer io.Writer, n int) error {
+	line := fakeLogLine
+
+	for n > 0 {
+		if n < len(line) {
+			line = line[len(line)-n:]
+		}
+
+		_, err := writer.Write(line)
+		if err != nil {
+			return err
+		}
+		n -= len(line)
+	}
+
+	return nil
+}
+
 func (i *fakeInstance) DownloadTrace(ctx context.Context) ([]byte, error) {
+	fault, err := i.faults["DOWNLOAD_TRACE"].inject(ctx, i.p.random)
+	if err != nil {
+		return nil, err
+	}
+	if fault == "error" {
+		return nil, errors.New("fake provider injected a download trace error")
+	}
+
 	return nil, ErrDownloadTraceNotImplemented
 }
 
 func (i *fakeInstance) Stop(ctx context.Context) error {
+	fault, err := i.faults["STOP"].inject(ctx, i.p.random)
+	if err != nil {
+		return err
+	}
+	if fault == "error" {
+		return errors.New("fake provider injected a stop error")
+	}
+
 	return nil
 }
 
@@ -0,0 +1,167 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/travis-ci/worker/config"
+)
+
+// fakeFaultKinds lists the faults that can be injected into each of the fake
+// instance methods:
+//
+//   - error: the method returns an error
+//   - hang: the method blocks until its context is done
+//   - stale_vm: UploadScript returns ErrStaleVM
+//   - incomplete: RunScript returns an incomplete result and an error, as if
+//     the connection was lost
+//   - exit:N: RunScript completes with exit code N
+//   - endless: RunScript writes output until its context is done
+var fakeFaultKinds = map[string][]string{
+	"START":          {"error", "hang"},
+	"UPLOAD":         {"error", "hang", "stale_vm"},
+	"RUN":            {"error", "hang", "incomplete", "exit", "endless"},
+	"STOP":           {"error", "hang"},
+	"DOWNLOAD_TRACE": {"error", "hang"},
+}
+
+var fakeFaultMethods = []string{"START", "UPLOAD", "RUN", "STOP", "DOWNLOAD_TRACE"}
+
+// fakeFaultOptions returns the FAULT_* options for each method.
+func fakeFaultOptions() []*Option {
+	opts := []*Option{}
+	for _, method := range fakeFaultMethods {
+		opts = append(opts,
+			&Option{Name: "FAULT_" + method, Type: OptionString, Help: fmt.Sprintf("fault to inject into %s (%s)", strings.ToLower(method), strings.Join(fakeFaultKinds[method], ", "))},
+			&Option{Name: "FAULT_" + method + "_RATE", Type: OptionString, Default: "1", Help: fmt.Sprintf("probability between 0 and 1 of injecting the %s fault", strings.ToLower(method))},
+			&Option{Name: "FAULT_" + method + "_DELAY", Type: OptionDuration, Help: fmt.Sprintf("delay before %s", strings.ToLower(method))},
+		)
+	}
+	return opts
+}
+
+// fakeFault is a fault injected into one of the fake instance methods.
+type fakeFault struct {
+	kind     string
+	exitCode uint8
+	rate     float64
+	delay    time.Duration
+}
+
+// parseFakeFaults reads the FAULT_* settings from the provider config. The
+// faults job config key can override them, keyed by the setting name without
+// the FAULT_ prefix in any case, such as {"run": "exit:1", "stop_delay":
+// "1m"}.
+func parseFakeFaults(cfg *config.ProviderConfig, overrides map[string]interface{}) (map[string]*fakeFault, error) {
+	normalizedOverrides := map[string]interface{}{}
+	for key, value := range overrides {
+		if !isFakeFaultKey(key) {
+			return nil, fmt.Errorf("unknown fake fault %q", key)
+		}
+
+		normalizedKey := "FAULT_" + strings.ToUpper(key)
+		if _, ok := normalizedOverrides[normalizedKey]; ok {
+			return nil, fmt.Errorf("fake fault %q is given more than once", key)
+		}
+		normalizedOverrides[normalizedKey] = value
+	}
+
+	get := func(key string) (string, bool) {
+		if value, ok := normalizedOverrides[key]; ok {
+			return fmt.Sprintf("%v", value), true
+		}
+		return cfg.Get(key), cfg.IsSet(key)
+	}
+
+	faults := map[string]*fakeFault{}
+	for _, method := range fakeFaultMethods {
+		fault := &fakeFault{rate: 1}
+
+		if kind, ok := get("FAULT_" + method); ok && kind != "" {
+			if strings.HasPrefix(kind, "exit:") {
+				exitCode, err := strconv.ParseUint(strings.TrimPrefix(kind, "exit:"), 10, 8)
+				if err != nil {
+					return nil, fmt.Errorf("invalid exit code in fault %q", kind)
+				}
+				kind = "exit"
+				fault.exitCode = uint8(exitCode)
+			}
+
+			if !stringInSlice(kind, fakeFaultKinds[method]) {
+				return nil, fmt.Errorf("unknown fault %q for %s", kind, strings.ToLower(method))
+			}
+			fault.kind = kind
+		}
+
+		if rate, ok := get("FAULT_" + method + "_RATE"); ok {
+			r, err := strconv.ParseFloat(rate, 64)
+			if err != nil || r < 0 || r > 1 {
+				return nil, fmt.Errorf("invalid fault rate %q for %s", rate, strings.ToLower(method))
+			}
+			fault.rate = r
+		}
+
+		if delay, ok := get("FAULT_" + method + "_DELAY"); ok {
+			d, err := time.ParseDuration(delay)
+			if err != nil {
+				return nil, fmt.Errorf("invalid fault delay %q for %s", delay, strings.ToLower(method))
+			}
+			fault.delay = d
+		}
+
+		if fault.kind != "" || fault.delay > 0 {
+			faults[method] = fault
+		}
+	}
+
+	return faults, nil
+}
+
+func isFakeFaultKey(key string) bool {
+	key = strings.ToUpper(key)
+	for _, method := range fakeFaultMethods {
+		if key == method || key == method+"_RATE" || key == method+"_DELAY" {
+			return true
+		}
+	}
+	return false
+}
+
+func stringInSlice(s string, slice []string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// inject waits for the fault's delay and returns the kind of fault to inject,
+// or "" if there is none this time. Hanging faults block until ctx is done.
+func (f *fakeFault) inject(ctx context.Context, random func() float64) (string, error) {
+	if f == nil {
+		return "", nil
+	}
+
+	if f.delay > 0 {
+		select {
+		case <-time.After(f.delay):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	if f.kind == "" || random() >= f.rate {
+		return "", nil
+	}
+
+	if f.kind == "hang" {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+
+	return f.kind, nil
+}
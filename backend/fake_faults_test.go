@@ -0,0 +1,143 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travis-ci/worker/config"
+)
+
+func newTestFakeProvider(t *testing.T, cfg map[string]string, random float64) *fakeProvider {
+	provider, err := newFakeProvider(config.ProviderConfigFromMap(cfg))
+	require.Nil(t, err)
+
+	p := provider.(*fakeProvider)
+	p.random = func() float64 { return random }
+	return p
+}
+
+func TestParseFakeFaults(t *testing.T) {
+	faults, err := parseFakeFaults(config.ProviderConfigFromMap(map[string]string{
+		"FAULT_RUN":        "exit:3",
+		"FAULT_RUN_RATE":   "0.25",
+		"FAULT_STOP_DELAY": "2s",
+	}), map[string]interface{}{
+		"upload":      "stale_vm",
+		"stop_delay":  "1s",
+		"upload_rate": 0.5,
+	})
+	require.Nil(t, err)
+
+	assert.Equal(t, &fakeFault{kind: "exit", exitCode: 3, rate: 0.25}, faults["RUN"])
+	assert.Equal(t, &fakeFault{kind: "stale_vm", rate: 0.5}, faults["UPLOAD"])
+	assert.Equal(t, &fakeFault{rate: 1, delay: time.Second}, faults["STOP"])
+	assert.NotContains(t, faults, "START")
+}
+
+func TestParseFakeFaults_UppercaseOverrides(t *testing.T) {
+	faults, err := parseFakeFaults(config.ProviderConfigFromMap(map[string]string{}), map[string]interface{}{
+		"RUN":        "exit:1",
+		"Stop_Delay": "1s",
+	})
+	require.Nil(t, err)
+
+	assert.Equal(t, &fakeFault{kind: "exit", exitCode: 1, rate: 1}, faults["RUN"])
+	assert.Equal(t, &fakeFault{rate: 1, delay: time.Second}, faults["STOP"])
+}
+
+func TestParseFakeFaults_Invalid(t *testing.T) {
+	for _, tc := range []struct {
+		cfg       map[string]string
+		overrides map[string]interface{}
+	}{
+		{cfg: map[string]string{"FAULT_START": "stale_vm"}},
+		{cfg: map[string]string{"FAULT_RUN": "exit:lots"}},
+		{cfg: map[string]string{"FAULT_RUN_RATE": "2"}},
+		{cfg: map[string]string{"FAULT_STOP_DELAY": "soon"}},
+		{overrides: map[string]interface{}{"boot": "error"}},
+		{overrides: map[string]interface{}{"run": "explode"}},
+		{overrides: map[string]interface{}{"run": "error", "RUN": "hang"}},
+	} {
+		_, err := parseFakeFaults(config.ProviderConfigFromMap(tc.cfg), tc.overrides)
+		assert.NotNil(t, err, "%#v", tc)
+	}
+
+	_, err := newFakeProvider(config.ProviderConfigFromMap(map[string]string{"FAULT_UPLOAD": "explode"}))
+	assert.NotNil(t, err)
+}
+
+func TestFakeProvider_Faults(t *testing.T) {
+	ctx := context.TODO()
+
+	p := newTestFakeProvider(t, map[string]string{"FAULT_START": "error"}, 0)
+	_, err := p.Start(ctx, &StartAttributes{})
+	assert.NotNil(t, err)
+
+	p = newTestFakeProvider(t, map[string]string{"FAULT_UPLOAD": "stale_vm"}, 0)
+	instance, err := p.Start(ctx, &StartAttributes{})
+	require.Nil(t, err)
+	assert.Equal(t, ErrStaleVM, instance.UploadScript(ctx, nil))
+
+	p = newTestFakeProvider(t, map[string]string{"FAULT_RUN": "exit:2", "LOG_OUTPUT": "hi"}, 0)
+	instance, err = p.Start(ctx, &StartAttributes{})
+	require.Nil(t, err)
+	out := &bytes.Buffer{}
+	result, err := instance.RunScript(ctx, out)
+	require.Nil(t, err)
+	assert.Equal(t, &RunResult{Completed: true, ExitCode: 2}, result)
+	assert.Equal(t, "hi", out.String())
+
+	instance, err = p.Start(ctx, &StartAttributes{FakeFaults: map[string]interface{}{"run": "incomplete"}})
+	require.Nil(t, err)
+	result, err = instance.RunScript(ctx, out)
+	assert.NotNil(t, err)
+	assert.False(t, result.Completed)
+
+	p = newTestFakeProvider(t, map[string]string{"FAULT_STOP": "error", "FAULT_DOWNLOAD_TRACE": "error"}, 0)
+	instance, err = p.Start(ctx, &StartAttributes{})
+	require.Nil(t, err)
+	assert.NotNil(t, instance.Stop(ctx))
+	_, err = instance.DownloadTrace(ctx)
+	assert.NotEqual(t, ErrDownloadTraceNotImplemented, err)
+}
+
+func TestFakeProvider_FaultRate(t *testing.T) {
+	p := newTestFakeProvider(t, map[string]string{"FAULT_UPLOAD": "error", "FAULT_UPLOAD_RATE": "0.5"}, 0.5)
+	instance, err := p.Start(context.TODO(), &StartAttributes{})
+	require.Nil(t, err)
+	assert.Nil(t, instance.UploadScript(context.TODO(), nil))
+
+	p.random = func() float64 { return 0.49 }
+	assert.NotNil(t, instance.UploadScript(context.TODO(), nil))
+}
+
+func TestFakeProvider_HangAndEndless(t *testing.T) {
+	p := newTestFakeProvider(t, map[string]string{"FAULT_START": "hang"}, 0)
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	_, err := p.Start(ctx, &StartAttributes{})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	p = newTestFakeProvider(t, map[string]string{"FAULT_RUN": "endless"}, 0)
+	instance, err := p.Start(context.TODO(), &StartAttributes{})
+	require.Nil(t, err)
+
+	ctx, cancel = context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	out := &bytes.Buffer{}
+	result, err := instance.RunScript(ctx, out)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.False(t, result.Completed)
+	assert.True(t, out.Len() > 1)
+
+	p = newTestFakeProvider(t, map[string]string{"FAULT_STOP_DELAY": "20ms"}, 0)
+	instance, err = p.Start(context.TODO(), &StartAttributes{})
+	require.Nil(t, err)
+	start := time.Now()
+	assert.Nil(t, instance.Stop(context.TODO()))
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+}
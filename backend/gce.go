@@ -18,7 +18,6 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"text/template"
 	"time"
 
@@ -32,9 +31,9 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/travis-ci/worker/config"
 	"github.com/travis-ci/worker/context"
+	workererrors "github.com/travis-ci/worker/errors"
 	"github.com/travis-ci/worker/image"
 	"github.com/travis-ci/worker/metrics"
-	"github.com/travis-ci/worker/ratelimit"
 	"github.com/travis-ci/worker/remote"
 	"github.com/travis-ci/worker/ssh"
 	"github.com/travis-ci/worker/winrm"
@@ -72,46 +71,40 @@ const (
 )
 
 var (
-	gceHelp = map[string]string{
-		"ACCOUNT_JSON":           "[REQUIRED] account JSON config",
-		"AUTO_IMPLODE":           "schedule a poweroff at HARD_TIMEOUT_MINUTES in the future (default true)",
-		"BOOT_POLL_SLEEP":        fmt.Sprintf("sleep interval between polling server for instance ready status (default %v)", defaultGCEBootPollSleep),
-		"BOOT_PRE_POLL_SLEEP":    fmt.Sprintf("time to sleep prior to polling server for instance ready status (default %v)", defaultGCEBootPrePollSleep),
-		"DEFAULT_LANGUAGE":       fmt.Sprintf("default language to use when looking up image (default %q)", defaultGCELanguage),
-		"DETERMINISTIC_HOSTNAME": "assign deterministic hostname based on repo slug and job id (default false)",
-		"DISK_SIZE":              fmt.Sprintf("disk size in GB (default %v)", defaultGCEDiskSize),
-		"GPU_COUNT":              fmt.Sprintf("number of GPUs to use (default %v)", defaultGCEGpuCount),
-		"GPU_TYPE":               fmt.Sprintf("type of GPU to use (default %q)", defaultGCEGpuType),
-		"IMAGE_ALIASES":          "comma-delimited strings used as stable names for images, used only when image selector type is \"env\"",
-		"IMAGE_DEFAULT":          fmt.Sprintf("default image name to use when none found (default %q)", defaultGCEImage),
-		"IMAGE_SELECTOR_TYPE":    fmt.Sprintf("image selector type (\"env\" or \"api\", default %q)", defaultGCEImageSelectorType),
-		"IMAGE_SELECTOR_URL":     "URL for image selector API, used only when image selector is \"api\"",
-		"IMAGE_[ALIAS_]{ALIAS}":  "full name for a given alias given via IMAGE_ALIASES, where the alias form in the key is uppercased and normalized by replacing non-alphanumerics with _",
-		"MACHINE_TYPE":           fmt.Sprintf("machine name (default %q)", defaultGCEMachineType),
-		"NETWORK":                fmt.Sprintf("network name (default %q)", defaultGCENetwork),
-		"PREEMPTIBLE":            "boot job instances with preemptible flag enabled (default false)",
-		"PREMIUM_MACHINE_TYPE":   fmt.Sprintf("premium machine type (default %q)", defaultGCEPremiumMachineType),
-		"PROJECT_ID":             "[REQUIRED] GCE project id",
-		"PUBLIC_IP":              "boot job instances with a public ip, disable this for NAT (default true)",
-		"PUBLIC_IP_CONNECT":      "connect to the public ip of the instance instead of the internal, only takes effect if PUBLIC_IP is true (default true)",
-		"IMAGE_PROJECT_ID":       "GCE project id to use for images, will use PROJECT_ID if not specified",
-		"RATE_LIMIT_PREFIX":      "prefix for the rate limit key in Redis",
-		"RATE_LIMIT_REDIS_URL":   "URL to Redis instance to use for rate limiting",
-		"RATE_LIMIT_MAX_CALLS":   fmt.Sprintf("number of calls per duration to let through to the GCE API (default %d)", defaultGCERateLimitMaxCalls),
-		"RATE_LIMIT_DURATION":    fmt.Sprintf("interval in which to let max-calls through to the GCE API (default %v)", defaultGCERateLimitDuration),
-		"REGION":                 fmt.Sprintf("only takes effect when SUBNETWORK is defined; region in which to deploy (default %v)", defaultGCERegion),
-		"SKIP_STOP_POLL":         "immediately return after issuing first instance deletion request (default false)",
-		"SSH_DIAL_TIMEOUT":       fmt.Sprintf("connection timeout for ssh connections (default %v)", defaultGCESSHDialTimeout),
-		"STOP_POLL_SLEEP":        fmt.Sprintf("sleep interval between polling server for instance stop status (default %v)", defaultGCEStopPollSleep),
-		"STOP_PRE_POLL_SLEEP":    fmt.Sprintf("time to sleep prior to polling server for instance stop status (default %v)", defaultGCEStopPrePollSleep),
-		"SUBNETWORK":             fmt.Sprintf("the subnetwork in which to launch build instances (gce internal default \"%v\")", defaultGCESubnet),
-		"UPLOAD_RETRIES":         fmt.Sprintf("number of times to attempt to upload script before erroring (default %d)", defaultGCEUploadRetries),
-		"UPLOAD_RETRY_SLEEP":     fmt.Sprintf("sleep interval between script upload attempts (default %v)", defaultGCEUploadRetrySleep),
-		"WARMER_URL":             "URL for warmer service",
-		"WARMER_TIMEOUT":         fmt.Sprintf("timeout for requests to warmer service (default %v)", defaultGCEWarmerTimeout),
-		"WARMER_SSH_PASSPHRASE":  fmt.Sprintf("The passphrase used to decipher instace SSH keys"),
-		"ZONE":                   fmt.Sprintf("zone name (default %q)", defaultGCEZone),
-	}
+	gceOptions = append(append([]*Option{
+		{Name: "ACCOUNT_JSON", Type: OptionString, Help: "account JSON config, application default credentials are used if not set"},
+		{Name: "AUTO_IMPLODE", Type: OptionBool, Default: "true", Help: "schedule a poweroff at HARD_TIMEOUT_MINUTES in the future"},
+		{Name: "BOOT_POLL_SLEEP", Type: OptionDuration, Default: defaultGCEBootPollSleep.String(), Help: "sleep interval between polling server for instance ready status"},
+		{Name: "BOOT_PRE_POLL_SLEEP", Type: OptionDuration, Default: defaultGCEBootPrePollSleep.String(), Help: "time to sleep prior to polling server for instance ready status"},
+		{Name: "DEFAULT_LANGUAGE", Type: OptionString, Default: defaultGCELanguage, Help: "default language to use when looking up image"},
+		{Name: "DETERMINISTIC_HOSTNAME", Type: OptionBool, Default: "false", Help: "assign deterministic hostname based on repo slug and job id"},
+		{Name: "DISK_SIZE", Type: OptionInt, Default: fmt.Sprintf("%d", defaultGCEDiskSize), Help: "disk size in GB"},
+		{Name: "GPU_COUNT", Type: OptionInt, Default: fmt.Sprintf("%d", defaultGCEGpuCount), Help: "number of GPUs to use"},
+		{Name: "GPU_TYPE", Type: OptionString, Default: defaultGCEGpuType, Help: "type of GPU to use"},
+		{Name: "IMAGE_ALIASES", Type: OptionString, Help: "comma-delimited strings used as stable names for images, used only when image selector type is \"env\""},
+		{Name: "IMAGE_DEFAULT", Type: OptionString, Default: defaultGCEImage, Help: "default image name to use when none found"},
+		{Name: "IMAGE_[ALIAS_]{ALIAS}", Type: OptionString, Help: "full name for a given alias given via IMAGE_ALIASES, where the alias form in the key is uppercased and normalized by replacing non-alphanumerics with _"},
+		{Name: "MACHINE_TYPE", Type: OptionString, Default: defaultGCEMachineType, Help: "machine name"},
+		{Name: "NETWORK", Type: OptionString, Default: defaultGCENetwork, Help: "network name"},
+		{Name: "PREEMPTIBLE", Type: OptionBool, Default: "false", Help: "boot job instances with preemptible flag enabled"},
+		{Name: "PREMIUM_MACHINE_TYPE", Type: OptionString, Default: defaultGCEPremiumMachineType, Help: "premium machine type"},
+		{Name: "PROJECT_ID", Type: OptionString, Help: "GCE project id, detected from instance metadata when running on GCE"},
+		{Name: "PUBLIC_IP", Type: OptionBool, Default: "true", Help: "boot job instances with a public ip, disable this for NAT"},
+		{Name: "PUBLIC_IP_CONNECT", Type: OptionBool, Default: "true", Help: "connect to the public ip of the instance instead of the internal, only takes effect if PUBLIC_IP is true"},
+		{Name: "IMAGE_PROJECT_ID", Type: OptionString, Help: "GCE project id to use for images, will use PROJECT_ID if not specified"},
+		{Name: "REGION", Type: OptionString, Default: defaultGCERegion, Help: "only takes effect when SUBNETWORK is defined; region in which to deploy"},
+		{Name: "SKIP_STOP_POLL", Type: OptionBool, Default: "false", Help: "immediately return after issuing first instance deletion request"},
+		{Name: "SSH_DIAL_TIMEOUT", Type: OptionDuration, Default: defaultGCESSHDialTimeout.String(), Help: "connection timeout for ssh connections"},
+		{Name: "STOP_POLL_SLEEP", Type: OptionDuration, Default: defaultGCEStopPollSleep.String(), Help: "sleep interval between polling server for instance stop status"},
+		{Name: "STOP_PRE_POLL_SLEEP", Type: OptionDuration, Default: defaultGCEStopPrePollSleep.String(), Help: "time to sleep prior to polling server for instance stop status"},
+		{Name: "SUBNETWORK", Type: OptionString, Help: fmt.Sprintf("the subnetwork in which to launch build instances (gce internal default %q)", defaultGCESubnet)},
+		{Name: "UPLOAD_RETRIES", Type: OptionInt, Default: fmt.Sprintf("%d", defaultGCEUploadRetries), Help: "number of times to attempt to upload script before erroring"},
+		{Name: "UPLOAD_RETRY_SLEEP", Type: OptionDuration, Default: defaultGCEUploadRetrySleep.String(), Help: "sleep interval between script upload attempts"},
+		{Name: "WARMER_URL", Type: OptionString, Help: "URL for warmer service"},
+		{Name: "WARMER_TIMEOUT", Type: OptionDuration, Default: defaultGCEWarmerTimeout.String(), Help: "timeout for requests to warmer service"},
+		{Name: "WARMER_SSH_PASSPHRASE", Type: OptionString, Help: "The passphrase used to decipher instace SSH keys"},
+		{Name: "ZONE", Type: OptionString, Default: defaultGCEZone, Help: "zone name"},
+	}, imageSelectorOptions(defaultGCEImageSelectorType)...), rateLimitOptions("GCE", defaultGCERateLimitMaxCalls, defaultGCERateLimitDuration)...)
 
 	errGCEMissingIPAddressError   = fmt.Errorf("no IP address found")
 	errGCEInstanceDeletionNotDone = fmt.Errorf("instance deletion not done")
@@ -145,7 +138,7 @@ type gceStartupScriptData struct {
 }
 
 func init() {
-	Register("gce", "Google Compute Engine", gceHelp, newGCEProvider)
+	Register("gce", "Google Compute Engine", gceOptions, newGCEProvider)
 }
 
 type gceOpError struct {
@@ -162,6 +155,17 @@ func (oe *gceOpError) Error() string {
 	return strings.Join(errStrs, ", ")
 }
 
+func (oe *gceOpError) ErrorClass() workererrors.Class {
+	for _, err := range oe.Err.Errors {
+		switch err.Code {
+		case "QUOTA_EXCEEDED", "ZONE_RESOURCE_POOL_EXHAUSTED", "ZONE_RESOURCE_POOL_EXHAUSTED_WITH_DETAILS":
+			return workererrors.ClassQuotaExceeded
+		}
+	}
+
+	return workererrors.ClassUnknown
+}
+
 type gceAccountJSON struct {
 	ClientEmail string `json:"client_email"`
 	PrivateKey  string `json:"private_key"`
@@ -188,10 +192,7 @@ type gceProvider struct {
 	sshDialer             ssh.Dialer
 	sshDialTimeout        time.Duration
 
-	rateLimiter         ratelimit.RateLimiter
-	rateLimitMaxCalls   uint64
-	rateLimitDuration   time.Duration
-	rateLimitQueueDepth uint64
+	apiLimiter *apiLimiter
 
 	warmerUrl           *url.URL
 	warmerTimeout       time.Duration
@@ -463,20 +464,14 @@ func newGCEProvider(cfg *config.ProviderConfig) (Provider, error) {
 		imageSelectorType = cfg.Get("IMAGE_SELECTOR_TYPE")
 	}
 
-	if imageSelectorType != "env" && imageSelectorType != "api" {
-		return nil, fmt.Errorf("invalid image selector type %q", imageSelectorType)
-	}
-
-	imageSelector, err := buildGCEImageSelector(imageSelectorType, cfg)
+	imageSelector, err := buildImageSelector(imageSelectorType, cfg, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	var rateLimiter ratelimit.RateLimiter
-	if cfg.IsSet("RATE_LIMIT_REDIS_URL") {
-		rateLimiter = ratelimit.NewRateLimiter(cfg.Get("RATE_LIMIT_REDIS_URL"), cfg.Get("RATE_LIMIT_PREFIX"))
-	} else {
-		rateLimiter = ratelimit.NewNullRateLimiter()
+	apiLimiter, err := newAPILimiter("gce", cfg, defaultGCERateLimitMaxCalls, defaultGCERateLimitDuration)
+	if err != nil {
+		return nil, err
 	}
 
 	var warmerUrl *url.URL
@@ -500,24 +495,6 @@ func newGCEProvider(cfg *config.ProviderConfig) (Provider, error) {
 		warmerSSHPassphrase = cfg.Get("WARMER_SSH_PASSPHRASE")
 	}
 
-	rateLimitMaxCalls := defaultGCERateLimitMaxCalls
-	if cfg.IsSet("RATE_LIMIT_MAX_CALLS") {
-		mc, err := strconv.ParseUint(cfg.Get("RATE_LIMIT_MAX_CALLS"), 10, 64)
-		if err != nil {
-			return nil, err
-		}
-		rateLimitMaxCalls = mc
-	}
-
-	rateLimitDuration := defaultGCERateLimitDuration
-	if cfg.IsSet("RATE_LIMIT_DURATION") {
-		rld, err := time.ParseDuration(cfg.Get("RATE_LIMIT_DURATION"))
-		if err != nil {
-			return nil, err
-		}
-		rateLimitDuration = rld
-	}
-
 	sshDialTimeout := defaultGCESSHDialTimeout
 	if cfg.IsSet("SSH_DIAL_TIMEOUT") {
 		sshDialTimeout, err = time.ParseDuration(cfg.Get("SSH_DIAL_TIMEOUT"))
@@ -593,9 +570,7 @@ func newGCEProvider(cfg *config.ProviderConfig) (Provider, error) {
 		uploadRetries:         uploadRetries,
 		uploadRetrySleep:      uploadRetrySleep,
 
-		rateLimiter:       rateLimiter,
-		rateLimitMaxCalls: rateLimitMaxCalls,
-		rateLimitDuration: rateLimitDuration,
+		apiLimiter: apiLimiter,
 
 		warmerUrl:           warmerUrl,
 		warmerTimeout:       warmerTimeout,
@@ -603,42 +578,38 @@ func newGCEProvider(cfg *config.ProviderConfig) (Provider, error) {
 	}, nil
 }
 
-func (p *gceProvider) apiRateLimit(ctx gocontext.Context) error {
+// apiRateLimit blocks until a call to the GCE API may be made, as limited by
+// the rate limit and RATE_LIMIT_MAX_IN_FLIGHT, and returns a function to call
+// once the call is done. If the rate limiter keeps erroring, the call is let
+// through anyway.
+func (p *gceProvider) apiRateLimit(ctx gocontext.Context) func() {
 	if trace.FromContext(ctx) != nil {
 		var span *trace.Span
 		ctx, span = trace.StartSpan(ctx, "apiRateLimit")
 		defer span.End()
 	}
 
-	metrics.Gauge("travis.worker.vm.provider.gce.rate-limit.queue", int64(p.rateLimitQueueDepth))
-	startWait := time.Now()
-	defer metrics.TimeSince("travis.worker.vm.provider.gce.rate-limit", startWait)
-
 	defer context.TimeSince(ctx, "gce_api_rate_limit", time.Now())
 
-	atomic.AddUint64(&p.rateLimitQueueDepth, 1)
-	// This decrements the counter, see the docs for atomic.AddUint64
-	defer atomic.AddUint64(&p.rateLimitQueueDepth, ^uint64(0))
-
 	errCount := 0
 
 	for {
-		ok, err := p.rateLimiter.RateLimit(ctx, "gce-api", p.rateLimitMaxCalls, p.rateLimitDuration)
-		if err != nil {
-			errCount++
-			if errCount >= 5 {
-				context.CaptureError(ctx, err)
-				context.LoggerFromContext(ctx).WithFields(logrus.Fields{
-					"err":  err,
-					"self": "backend/gce_provider",
-				}).Info("rate limiter errored 5 times")
-				return err
-			}
-		} else {
-			errCount = 0
+		release, err := p.apiLimiter.acquire(ctx)
+		if err == nil {
+			return release
 		}
-		if ok {
-			return nil
+		if ctx.Err() != nil {
+			return func() {}
+		}
+
+		errCount++
+		if errCount >= 5 {
+			context.CaptureError(ctx, err)
+			context.LoggerFromContext(ctx).WithFields(logrus.Fields{
+				"err":  err,
+				"self": "backend/gce_provider",
+			}).Info("rate limiter errored 5 times")
+			return func() {}
 		}
 
 		// Sleep for up to 1 second
@@ -649,28 +620,32 @@ func (p *gceProvider) apiRateLimit(ctx gocontext.Context) error {
 func (p *gceProvider) Setup(ctx gocontext.Context) error {
 	var err error
 
-	p.apiRateLimit(ctx)
+	release := p.apiRateLimit(ctx)
 	p.ic.Zone, err = p.client.Zones.Get(p.projectID, p.cfg.Get("ZONE")).Context(ctx).Do()
+	release()
 	if err != nil {
 		return err
 	}
 
 	p.ic.DiskType = fmt.Sprintf("zones/%s/diskTypes/pd-ssd", p.ic.Zone.Name)
 
-	p.apiRateLimit(ctx)
+	release = p.apiRateLimit(ctx)
 	p.ic.MachineType, err = p.client.MachineTypes.Get(p.projectID, p.ic.Zone.Name, p.cfg.Get("MACHINE_TYPE")).Context(ctx).Do()
+	release()
 	if err != nil {
 		return err
 	}
 
-	p.apiRateLimit(ctx)
+	release = p.apiRateLimit(ctx)
 	p.ic.PremiumMachineType, err = p.client.MachineTypes.Get(p.projectID, p.ic.Zone.Name, p.cfg.Get("PREMIUM_MACHINE_TYPE")).Context(ctx).Do()
+	release()
 	if err != nil {
 		return err
 	}
 
-	p.apiRateLimit(ctx)
+	release = p.apiRateLimit(ctx)
 	p.ic.Network, err = p.client.Networks.Get(p.projectID, p.cfg.Get("NETWORK")).Context(ctx).Do()
+	release()
 	if err != nil {
 		return err
 	}
@@ -797,8 +772,9 @@ func (p *gceProvider) StartWithProgress(ctx gocontext.Context, startAttributes *
 
 	defer func(c *gceStartContext) {
 		if c.instance != nil && abandonedStart {
-			p.apiRateLimit(c.ctx)
+			release := p.apiRateLimit(c.ctx)
 			_, _ = p.client.Instances.Delete(p.projectID, c.zoneName, c.instance.Name).Do()
+			release()
 		}
 	}(c)
 
@@ -984,8 +960,9 @@ func (p *gceProvider) stepInsertInstance(c *gceStartContext) multistep.StepActio
 		}
 	}
 
-	p.apiRateLimit(c.ctx)
+	release := p.apiRateLimit(c.ctx)
 	op, err := p.client.Instances.Insert(p.projectID, c.zoneName, inst).Context(c.ctx).Do()
+	release()
 	if err != nil {
 		c.progresser.Progress(&ProgressEntry{
 			Message: "could not insert instance",
@@ -1068,8 +1045,9 @@ func (p *gceProvider) stepWaitForInstanceIP(c *gceStartContext) multistep.StepAc
 	for {
 		metrics.Mark("worker.vm.provider.gce.boot.poll")
 
-		p.apiRateLimit(c.ctx)
+		release := p.apiRateLimit(c.ctx)
 		newOp, err := zoneOpCall.Do()
+		release()
 		if err != nil {
 			c.progresser.Progress(&ProgressEntry{
 				Message:    "could not check for instance insert",
@@ -1139,15 +1117,16 @@ func (p *gceProvider) imageByFilter(ctx gocontext.Context, filter string) (*comp
 	ctx, span := trace.StartSpan(ctx, "GCE.imageByFilter")
 	defer span.End()
 
-	p.apiRateLimit(ctx)
+	release := p.apiRateLimit(ctx)
 	// TODO: add some TTL cache in here maybe?
 	images, err := p.client.Images.List(p.imageProjectID).Filter(filter).Context(ctx).Do()
+	release()
 	if err != nil {
 		return nil, err
 	}
 
 	if len(images.Items) == 0 {
-		return nil, fmt.Errorf("no image found with filter %s", filter)
+		return nil, workererrors.Classify(fmt.Errorf("no image found with filter %s", filter), workererrors.ClassImageNotFound)
 	}
 
 	imagesByName := map[string]*compute.Image{}
@@ -1173,22 +1152,10 @@ func (p *gceProvider) imageSelect(ctx gocontext.Context, startAttributes *StartA
 		err       error
 	)
 
-	jobID, _ := context.JobIDFromContext(ctx)
-	repo, _ := context.RepositoryFromContext(ctx)
-
 	if startAttributes.ImageName != "" {
 		imageName = startAttributes.ImageName
 	} else {
-		imageName, err = p.imageSelector.Select(&image.Params{
-			Infra:    "gce",
-			Language: startAttributes.Language,
-			OsxImage: startAttributes.OsxImage,
-			Dist:     startAttributes.Dist,
-			Group:    startAttributes.Group,
-			OS:       startAttributes.OS,
-			JobID:    jobID,
-			Repo:     repo,
-		})
+		imageName, err = p.imageSelector.Select(p.imageParams(ctx, startAttributes))
 
 		if err != nil {
 			return nil, err
@@ -1202,21 +1169,30 @@ func (p *gceProvider) imageSelect(ctx gocontext.Context, startAttributes *StartA
 	return p.imageByFilter(ctx, fmt.Sprintf("name eq ^%s", imageName))
 }
 
-func buildGCEImageSelector(selectorType string, cfg *config.ProviderConfig) (image.Selector, error) {
-	switch selectorType {
-	case "env":
-		return image.NewEnvSelector(cfg)
-	case "api":
-		baseURL, err := url.Parse(cfg.Get("IMAGE_SELECTOR_URL"))
-		if err != nil {
-			return nil, err
-		}
-		return image.NewAPISelector(baseURL), nil
-	default:
-		return nil, fmt.Errorf("invalid image selector type %q", selectorType)
+func (p *gceProvider) imageParams(ctx gocontext.Context, startAttributes *StartAttributes) *image.Params {
+	jobID, _ := context.JobIDFromContext(ctx)
+	repo, _ := context.RepositoryFromContext(ctx)
+
+	return &image.Params{
+		Infra:     "gce",
+		Language:  startAttributes.Language,
+		OsxImage:  startAttributes.OsxImage,
+		Dist:      startAttributes.Dist,
+		Group:     startAttributes.Group,
+		OS:        startAttributes.OS,
+		JobID:     jobID,
+		Repo:      repo,
+		Queue:     startAttributes.Queue,
+		Selection: &startAttributes.ImageSelection,
 	}
 }
 
+// ExplainImage explains which image would be selected for the job, without
+// starting anything.
+func (p *gceProvider) ExplainImage(ctx gocontext.Context, startAttributes *StartAttributes) (*image.Explanation, error) {
+	return explainImage(p.imageSelector, p.imageParams(ctx, startAttributes), startAttributes.ImageName, p.defaultImage), nil
+}
+
 func (p *gceProvider) buildInstance(ctx gocontext.Context, startAttributes *StartAttributes, imageLink, startupScript string) (*compute.Instance, error) {
 	ctx, span := trace.StartSpan(ctx, "GCE.buildinstance")
 	defer span.End()
@@ -1247,8 +1223,9 @@ func (p *gceProvider) buildInstance(ctx gocontext.Context, startAttributes *Star
 		}
 		machineType = pic
 	} else {
-		p.apiRateLimit(ctx)
+		release := p.apiRateLimit(ctx)
 		pic, err := p.client.MachineTypes.Get(p.projectID, zone.Name, p.cfg.Get("MACHINE_TYPE")).Context(ctx).Do()
+		release()
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to look up machine type")
 		}
@@ -1522,8 +1499,9 @@ func (i *gceInstance) refreshInstance(ctx gocontext.Context) error {
 	ctx, span := trace.StartSpan(ctx, "GCE.refreshInstance")
 	defer span.End()
 
-	i.provider.apiRateLimit(ctx)
+	release := i.provider.apiRateLimit(ctx)
 	inst, err := i.client.Instances.Get(i.projectID, i.zoneName, i.instance.Name).Context(ctx).Do()
+	release()
 	if err != nil {
 		return err
 	}
@@ -1620,7 +1598,7 @@ func (i *gceInstance) uploadScriptAttempt(ctx gocontext.Context, script []byte)
 		conn, err = i.sshConnection(ctx)
 	}
 	if err != nil {
-		return errors.Wrap(err, "couldn't connect to remote server for script upload")
+		return errors.Wrap(workererrors.Classify(err, workererrors.ClassSSHUnreachable), "couldn't connect to remote server for script upload")
 	}
 	defer conn.Close()
 
@@ -1665,8 +1643,9 @@ func (i *gceInstance) isPreempted(ctx gocontext.Context) (bool, error) {
 
 	var preempted bool
 	err := backoff.Retry(func() error {
-		i.provider.apiRateLimit(ctx)
+		release := i.provider.apiRateLimit(ctx)
 		list, err := listOpCall.Do()
+		release()
 		if err != nil {
 			return err
 		}
@@ -1698,7 +1677,7 @@ func (i *gceInstance) RunScript(ctx gocontext.Context, output io.Writer) (*RunRe
 	if err != nil {
 		return &RunResult{
 			Completed: false,
-		}, errors.Wrap(err, "couldn't connect to remote server for script run")
+		}, errors.Wrap(workererrors.Classify(err, workererrors.ClassSSHUnreachable), "couldn't connect to remote server for script run")
 	}
 	defer conn.Close()
 
@@ -1737,7 +1716,7 @@ func (i *gceInstance) DownloadTrace(ctx gocontext.Context) ([]byte, error) {
 	}
 
 	if err != nil {
-		return nil, errors.Wrap(err, "couldn't connect to remote server to download trace")
+		return nil, errors.Wrap(workererrors.Classify(err, workererrors.ClassSSHUnreachable), "couldn't connect to remote server to download trace")
 	}
 	defer conn.Close()
 
@@ -1782,6 +1761,11 @@ func (i *gceInstance) Stop(ctx gocontext.Context) error {
 
 func (i *gceInstance) stepDeleteInstance(c *gceInstanceStopContext) multistep.StepAction {
 	op, err := i.client.Instances.Delete(i.projectID, i.zoneName, i.instance.Name).Do()
+	if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusNotFound {
+		// the instance is already gone, e.g. because Stop was called before
+		c.errChan <- nil
+		return multistep.ActionHalt
+	}
 	if err != nil {
 		c.errChan <- err
 		return multistep.ActionHalt
@@ -1815,8 +1799,9 @@ func (i *gceInstance) stepWaitForInstanceDeleted(c *gceInstanceStopContext) mult
 	b.MaxElapsedTime = 2 * time.Minute
 
 	err := backoff.Retry(func() error {
-		i.provider.apiRateLimit(c.ctx)
+		release := i.provider.apiRateLimit(c.ctx)
 		newOp, err := zoneOpCall.Do()
+		release()
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,164 @@
+package backend_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/travis-ci/worker/backend"
+	"github.com/travis-ci/worker/backend/backendtest"
+	"github.com/travis-ci/worker/config"
+)
+
+// fakeComputeAPI implements the parts of the Compute Engine API used to set
+// up the GCE provider and to insert and delete instances. Operations are done
+// as soon as they are created.
+type fakeComputeAPI struct {
+	t *testing.T
+
+	mutex     sync.Mutex
+	instances map[string]bool
+}
+
+func (f *fakeComputeAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// paths look like /compute/v1/projects/{project}/zones/{zone}/instances
+	parts := strings.SplitN(req.URL.Path, "/projects/", 2)
+	if len(parts) != 2 {
+		f.unexpected(w, req)
+		return
+	}
+	path := strings.Split(parts[1], "/")[1:]
+	selfLink := "https://www.googleapis.com/compute/v1/projects/" + parts[1]
+
+	switch {
+	case len(path) == 2 && path[0] == "zones":
+		f.writeJSON(w, http.StatusOK, map[string]string{
+			"name":     path[1],
+			"region":   "https://www.googleapis.com/compute/v1/projects/project_id/regions/us-central1",
+			"selfLink": selfLink,
+		})
+	case len(path) == 4 && path[2] == "machineTypes", len(path) == 3 && path[1] == "networks":
+		f.writeJSON(w, http.StatusOK, map[string]string{"name": path[len(path)-1], "selfLink": selfLink})
+	case len(path) == 2 && path[1] == "images":
+		f.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"items": []map[string]string{
+				{"name": "travis-ci-conformance", "selfLink": selfLink + "/travis-ci-conformance"},
+			},
+		})
+	case len(path) == 3 && path[2] == "instances" && req.Method == "POST":
+		var inst struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&inst); err != nil {
+			f.t.Errorf("couldn't decode instance insert request: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		f.mutex.Lock()
+		f.instances[inst.Name] = true
+		f.mutex.Unlock()
+
+		f.writeOperation(w, "insert-"+inst.Name)
+	case len(path) == 4 && path[2] == "instances" && req.Method == "DELETE":
+		f.mutex.Lock()
+		found := f.instances[path[3]]
+		delete(f.instances, path[3])
+		f.mutex.Unlock()
+
+		if !found {
+			f.writeJSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": map[string]interface{}{
+					"code":    http.StatusNotFound,
+					"message": fmt.Sprintf("The resource '%s' was not found", selfLink),
+				},
+			})
+			return
+		}
+
+		f.writeOperation(w, "delete-"+path[3])
+	case len(path) == 4 && path[2] == "operations":
+		f.writeOperation(w, path[3])
+	default:
+		f.unexpected(w, req)
+	}
+}
+
+func (f *fakeComputeAPI) writeOperation(w http.ResponseWriter, name string) {
+	f.writeJSON(w, http.StatusOK, map[string]string{"name": name, "status": "DONE"})
+}
+
+func (f *fakeComputeAPI) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeComputeAPI) unexpected(w http.ResponseWriter, req *http.Request) {
+	f.t.Errorf("unexpected Compute API request %s %s", req.Method, req.URL.Path)
+	w.WriteHeader(http.StatusNotImplemented)
+}
+
+// redirectTransport sends all requests to the server at url.
+type redirectTransport struct {
+	url *url.URL
+}
+
+func (rt *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	redirected := *req
+	redirected.URL = &url.URL{}
+	*redirected.URL = *req.URL
+	redirected.URL.Scheme = rt.url.Scheme
+	redirected.URL.Host = rt.url.Host
+	redirected.Host = rt.url.Host
+
+	return http.DefaultTransport.RoundTrip(&redirected)
+}
+
+func TestGCEConformance(t *testing.T) {
+	servers := []*httptest.Server{}
+	defer func() {
+		for _, server := range servers {
+			server.Close()
+		}
+	}()
+
+	needsSSH := "needs an SSH connection to the instance"
+
+	backendtest.Run(t, &backendtest.Config{
+		NewProvider: func(t *testing.T) backend.Provider {
+			server := httptest.NewServer(&fakeComputeAPI{t: t, instances: map[string]bool{}})
+			servers = append(servers, server)
+
+			serverURL, err := url.Parse(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			provider, err := backend.NewGCEProviderWithTransport(config.ProviderConfigFromMap(map[string]string{
+				"ACCOUNT_JSON":         "{}",
+				"PROJECT_ID":           "project_id",
+				"BOOT_PRE_POLL_SLEEP":  "0s",
+				"BOOT_POLL_SLEEP":      "10ms",
+				"STOP_PRE_POLL_SLEEP":  "0s",
+				"STOP_POLL_SLEEP":      "10ms",
+				"RATE_LIMIT_MAX_CALLS": "1000",
+			}), &redirectTransport{url: serverURL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return provider
+		},
+		Skip: map[string]string{
+			"RunScript":       needsSSH,
+			"ExitCode":        needsSSH,
+			"RunScriptCancel": needsSSH,
+			"DownloadTrace":   needsSSH,
+		},
+	})
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/travis-ci/worker/config"
 	"github.com/travis-ci/worker/context"
+	workererrors "github.com/travis-ci/worker/errors"
 	"github.com/travis-ci/worker/image"
 	"github.com/travis-ci/worker/metrics"
 	"github.com/travis-ci/worker/ssh"
@@ -34,20 +35,20 @@ const (
 
 var (
 	metricNameCleanRegexp = regexp.MustCompile(`[^A-Za-z0-9.:-_]+`)
-	jupiterBrainHelp      = map[string]string{
-		"ENDPOINT":                 "[REQUIRED] url to Jupiter Brain server, including auth",
-		"SSH_KEY_PATH":             "[REQUIRED] path to SSH key used to access job VMs",
-		"SSH_KEY_PASSPHRASE":       "[REQUIRED] passphrase for SSH key given as SSH_KEY_PATH",
-		"KEYCHAIN_PASSWORD":        "[REQUIRED] password used ... somehow",
-		"IMAGE_SELECTOR_TYPE":      fmt.Sprintf("image selector type (\"env\" or \"api\", default %q)", defaultJupiterBrainImageSelectorType),
-		"IMAGE_SELECTOR_URL":       "URL for image selector API, used only when image selector is \"api\"",
-		"IMAGE_ALIASES":            "comma-delimited strings used as stable names for images (default: \"\")",
-		"IMAGE_ALIAS_{ALIAS}":      "full name for a given alias given via IMAGE_ALIASES, where the alias form in the key is uppercased and normalized by replacing non-alphanumerics with _",
-		"BOOT_POLL_SLEEP":          "sleep interval between polling server for instance status (default 3s)",
-		"BOOT_POLL_DIAL_TIMEOUT":   "how long to wait for a TCP connection to be made when polling SSH port (default 3s)",
-		"BOOT_POLL_WAIT_FOR_ERROR": "time to wait for an error message after cancelling the boot polling (default 2s)",
-		"SSH_DIAL_TIMEOUT":         fmt.Sprintf("connection timeout for ssh connections (default %v)", defaultJupiterBrainSSHDialTimeout),
-	}
+	jupiterBrainOptions   = append(append([]*Option{
+		{Name: "ENDPOINT", Type: OptionString, Required: true, Help: "url to Jupiter Brain server, including auth"},
+		{Name: "SSH_KEY_PATH", Type: OptionString, Required: true, Help: "path to SSH key used to access job VMs"},
+		{Name: "SSH_KEY_PASSPHRASE", Type: OptionString, Required: true, Help: "passphrase for SSH key given as SSH_KEY_PATH"},
+		{Name: "KEYCHAIN_PASSWORD", Type: OptionString, Required: true, Help: "password used ... somehow"},
+		{Name: "IMAGE_ALIASES", Type: OptionString, Help: "comma-delimited strings used as stable names for images"},
+		{Name: "IMAGE_[ALIAS_]{ALIAS}", Type: OptionString, Help: "full name for a given alias given via IMAGE_ALIASES, where the alias form in the key is uppercased and normalized by replacing non-alphanumerics with _"},
+		{Name: "INSTANCE_CPUS", Type: OptionInt, Help: "number of CPUs to boot instances with"},
+		{Name: "INSTANCE_RAM", Type: OptionInt, Help: "amount of RAM to boot instances with"},
+		{Name: "BOOT_POLL_SLEEP", Type: OptionDuration, Default: "3s", Help: "sleep interval between polling server for instance status"},
+		{Name: "BOOT_POLL_DIAL_TIMEOUT", Type: OptionDuration, Default: defaultBootPollDialTimeout.String(), Help: "how long to wait for a TCP connection to be made when polling SSH port"},
+		{Name: "BOOT_POLL_WAIT_FOR_ERROR", Type: OptionDuration, Default: defaultBootPollWaitForError.String(), Help: "time to wait for an error message after cancelling the boot polling"},
+		{Name: "SSH_DIAL_TIMEOUT", Type: OptionDuration, Default: defaultJupiterBrainSSHDialTimeout.String(), Help: "connection timeout for ssh connections"},
+	}, imageSelectorOptions(defaultJupiterBrainImageSelectorType)...), rateLimitOptions("Jupiter Brain", 0, time.Second)...)
 )
 
 const (
@@ -65,7 +66,7 @@ exit $(cat ~/build.sh.exit)
 )
 
 func init() {
-	Register("jupiterbrain", "Jupiter Brain", jupiterBrainHelp, newJupiterBrainProvider)
+	Register("jupiterbrain", "Jupiter Brain", jupiterBrainOptions, newJupiterBrainProvider)
 }
 
 type jupiterBrainProvider struct {
@@ -177,7 +178,7 @@ func newJupiterBrainProvider(cfg *config.ProviderConfig) (Provider, error) {
 		imageSelectorType = cfg.Get("IMAGE_SELECTOR_TYPE")
 	}
 
-	imageSelector, err := buildJupiterBrainImageSelector(imageSelectorType, cfg)
+	imageSelector, err := buildImageSelector(imageSelectorType, cfg, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -198,6 +199,11 @@ func newJupiterBrainProvider(cfg *config.ProviderConfig) (Provider, error) {
 		}
 	}
 
+	limiter, err := newAPILimiter("jupiterbrain", cfg, 0, time.Second)
+	if err != nil {
+		return nil, err
+	}
+
 	return &jupiterBrainProvider{
 		sshDialer:            sshDialer,
 		sshDialTimeout:       sshDialTimeout,
@@ -212,27 +218,12 @@ func newJupiterBrainProvider(cfg *config.ProviderConfig) (Provider, error) {
 		defaultInstanceRAM:  defaultInstanceRAM,
 
 		apiClient: &jupiterBrainAPIClient{
-			client:  http.DefaultClient,
+			client:  &http.Client{Transport: limiter.Transport(nil)},
 			baseURL: baseURL,
 		},
 	}, nil
 }
 
-func buildJupiterBrainImageSelector(selectorType string, cfg *config.ProviderConfig) (image.Selector, error) {
-	switch selectorType {
-	case "env":
-		return image.NewEnvSelector(cfg)
-	case "api":
-		baseURL, err := url.Parse(cfg.Get("IMAGE_SELECTOR_URL"))
-		if err != nil {
-			return nil, errors.Wrap(err, "error parsing image selector URL")
-		}
-		return image.NewAPISelector(baseURL), nil
-	default:
-		return nil, fmt.Errorf("invalid image selector type %q", selectorType)
-	}
-}
-
 func (p *jupiterBrainProvider) SupportsProgress() bool {
 	return true
 }
@@ -399,7 +390,7 @@ func (i *jupiterBrainInstance) UploadScript(ctx gocontext.Context, script []byte
 
 	conn, err := i.sshConnection()
 	if err != nil {
-		return errors.Wrap(err, "couldn't connect to SSH server")
+		return errors.Wrap(workererrors.Classify(err, workererrors.ClassSSHUnreachable), "couldn't connect to SSH server")
 	}
 	defer conn.Close()
 
@@ -444,7 +435,7 @@ func (i *jupiterBrainInstance) UploadScript(ctx gocontext.Context, script []byte
 func (i *jupiterBrainInstance) RunScript(ctx gocontext.Context, output io.Writer) (*RunResult, error) {
 	conn, err := i.sshConnection()
 	if err != nil {
-		return &RunResult{Completed: false}, errors.Wrap(err, "couldn't connect to SSH server")
+		return &RunResult{Completed: false}, errors.Wrap(workererrors.Classify(err, workererrors.ClassSSHUnreachable), "couldn't connect to SSH server")
 	}
 	defer conn.Close()
 
@@ -475,7 +466,7 @@ func (i *jupiterBrainInstance) RunScript(ctx gocontext.Context, output io.Writer
 func (i *jupiterBrainInstance) DownloadTrace(ctx gocontext.Context) ([]byte, error) {
 	conn, err := i.sshConnection()
 	if err != nil {
-		return nil, errors.Wrap(err, "couldn't connect to SSH server")
+		return nil, errors.Wrap(workererrors.Classify(err, workererrors.ClassSSHUnreachable), "couldn't connect to SSH server")
 	}
 	defer conn.Close()
 
@@ -531,19 +522,31 @@ func (i *jupiterBrainInstance) sshConnection() (ssh.Connection, error) {
 func (p *jupiterBrainProvider) getImageName(ctx gocontext.Context, startAttributes *StartAttributes) (string, error) {
 	defer context.TimeSince(ctx, "image_select", time.Now())
 
+	return p.imageSelector.Select(p.imageParams(ctx, startAttributes))
+}
+
+func (p *jupiterBrainProvider) imageParams(ctx gocontext.Context, startAttributes *StartAttributes) *image.Params {
 	jobID, _ := context.JobIDFromContext(ctx)
 	repo, _ := context.RepositoryFromContext(ctx)
 
-	return p.imageSelector.Select(&image.Params{
-		Infra:    "jupiterbrain",
-		Language: startAttributes.Language,
-		OsxImage: startAttributes.OsxImage,
-		Dist:     startAttributes.Dist,
-		Group:    startAttributes.Group,
-		OS:       startAttributes.OS,
-		JobID:    jobID,
-		Repo:     repo,
-	})
+	return &image.Params{
+		Infra:     "jupiterbrain",
+		Language:  startAttributes.Language,
+		OsxImage:  startAttributes.OsxImage,
+		Dist:      startAttributes.Dist,
+		Group:     startAttributes.Group,
+		OS:        startAttributes.OS,
+		JobID:     jobID,
+		Repo:      repo,
+		Queue:     startAttributes.Queue,
+		Selection: &startAttributes.ImageSelection,
+	}
+}
+
+// ExplainImage explains which image would be selected for the job, without
+// starting anything.
+func (p *jupiterBrainProvider) ExplainImage(ctx gocontext.Context, startAttributes *StartAttributes) (*image.Explanation, error) {
+	return explainImage(p.imageSelector, p.imageParams(ctx, startAttributes), "", ""), nil
 }
 
 func (p *jupiterBrainProvider) waitForIP(ctx gocontext.Context, id string, progresser Progresser) (net.IP, *jupiterBrainInstancePayload, error) {
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	gocontext "context"
@@ -16,13 +17,13 @@ import (
 
 var (
 	errNoScriptUploaded = fmt.Errorf("no script uploaded")
-	localHelp           = map[string]string{
-		"SCRIPTS_DIR": "directory where generated scripts will be written",
+	localOptions        = []*Option{
+		{Name: "SCRIPTS_DIR", Type: OptionString, Help: "directory where generated scripts will be written"},
 	}
 )
 
 func init() {
-	Register("local", "Local", localHelp, newLocalProvider)
+	Register("local", "Local", localOptions, newLocalProvider)
 }
 
 type localProvider struct {
@@ -100,6 +101,9 @@ func (i *localInstance) RunScript(ctx gocontext.Context, writer io.Writer) (*Run
 	cmd := exec.Command("bash", i.scriptPath)
 	cmd.Stdout = writer
 	cmd.Stderr = writer
+	// Run the script in its own process group, so that it can be killed
+	// along with everything it started.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
 	err := cmd.Start()
 	if err != nil {
@@ -113,16 +117,19 @@ func (i *localInstance) RunScript(ctx gocontext.Context, writer io.Writer) (*Run
 
 	select {
 	case err := <-errChan:
-		if err != nil {
-			return &RunResult{Completed: false}, err
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Exited() {
+				return &RunResult{Completed: true, ExitCode: uint8(status.ExitStatus())}, nil
+			}
 		}
-		return &RunResult{Completed: true}, nil
-	case <-ctx.Done():
-		err = ctx.Err()
 		if err != nil {
 			return &RunResult{Completed: false}, err
 		}
 		return &RunResult{Completed: true}, nil
+	case <-ctx.Done():
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-errChan
+		return &RunResult{Completed: false}, ctx.Err()
 	}
 }
 
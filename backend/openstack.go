@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"net"
-	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -24,6 +23,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/travis-ci/worker/config"
 	"github.com/travis-ci/worker/context"
+	workererrors "github.com/travis-ci/worker/errors"
 	"github.com/travis-ci/worker/image"
 	"github.com/travis-ci/worker/metrics"
 	"github.com/travis-ci/worker/ssh"
@@ -49,36 +49,37 @@ const (
 )
 
 var (
-	openStackHelp = map[string]string{
-		"ENDPOINT":             "[REQUIRED] Keystone/Identity Service Endpoint",
-		"TENANT_NAME":          "[REQUIRED] Openstack tenant name",
-		"OS_USERNAME":          "[REQUIRED] Openstack user name",
-		"OS_PASSWORD":          "[REQUIRED] Openstack user password",
-		"OS_DOMAIN":            "[REQUIRED] Openstack domain name only in case using v3 Identity service API",
-		"SSH_KEY_PATH":         "path to SSH key used to access job VMs",
-		"INSTANCE_KEYPAIR":     "Key Pair Name to be used for instance creation",
-		"SSH_PASSWORD":         "SSH password to login into the VM",
-		"SSH_USER":             "SSH username to login into the VM",
-		"AUTO_SSH_KEY_GEN":     "If SSH key generation is to be generated automatically (default false)",
-		"IMAGE_DEFAULT":        fmt.Sprintf("default image name to use when none found (default %q)", defaultOSImage),
-		"IMAGE_SELECTOR_TYPE":  fmt.Sprintf("image selector type (\"env\" or \"api\", default %q)", defaultOSImageSelectorType),
-		"IMAGE_SELECTOR_URL":   "URL for image selector API, used only when image selector is \"api\"",
-		"IMAGE_ALIASES":        "comma-delimited strings used as stable names for images (default: \"\")",
-		"MACHINE_TYPE":         fmt.Sprintf("machine type/flavor (default %q)", defaultOSMachineType),
-		"NETWORK":              "Network to which instance is to be attached.",
-		"SECURITY_GROUP":       fmt.Sprintf("Instance Security Group Name (default %v)", defaultOSSecGroup),
-		"OS_REGION":            fmt.Sprintf("Openstack region (default %v)", defaultOSRegion),
-		"OS_ZONE":              fmt.Sprintf("Openstack zone (default %v)", defaultOSZone),
-		"INSTANCE_NAME":        fmt.Sprintf("Name of the VM to be created (default %v followed by timeStamp)", defaultOSInstancePrefix),
-		"BOOT_POLL_SLEEP":      fmt.Sprintf("sleep interval between polling server for instance ACTIVE status (default %v)", defaultOSBootPollSleep),
-		"BOOT_POLL_DIAL_SLEEP": fmt.Sprintf("sleep interval between connection dials (default %v)", defaultOSBootPollDialSleep),
-		"SSH_POLL_TIMEOUT":     fmt.Sprintf("Timeout after which VM is marked not sshable (default %v)", defaultOSSSHDialTimeout),
-		"SSH_DIAL_TIMEOUT":     fmt.Sprintf("connection timeout for ssh connections (default %v)", defaultOSSSHDialTimeout),
-	}
+	openStackOptions = append(append([]*Option{
+		{Name: "ENDPOINT", Type: OptionString, Required: true, Help: "Keystone/Identity Service Endpoint"},
+		{Name: "TENANT_NAME", Type: OptionString, Required: true, Help: "Openstack tenant name"},
+		{Name: "OS_USERNAME", Type: OptionString, Required: true, Help: "Openstack user name"},
+		{Name: "OS_PASSWORD", Type: OptionString, Required: true, Help: "Openstack user password"},
+		{Name: "OS_DOMAIN", Type: OptionString, Help: "Openstack domain name only in case using v3 Identity service API"},
+		{Name: "SSH_KEY_PATH", Type: OptionString, Help: "path to SSH key used to access job VMs"},
+		{Name: "INSTANCE_KEYPAIR", Type: OptionString, Help: "Key Pair Name to be used for instance creation"},
+		{Name: "SSH_PASSWORD", Type: OptionString, Help: "SSH password to login into the VM"},
+		{Name: "SSH_USER", Type: OptionString, Help: "SSH username to login into the VM"},
+		{Name: "AUTO_SSH_KEY_GEN", Type: OptionBool, Default: "false", Help: "If SSH key generation is to be generated automatically"},
+		{Name: "IMAGE_DEFAULT", Type: OptionString, Default: defaultOSImage, Help: "default image name to use when none found"},
+		{Name: "IMAGE_ALIASES", Type: OptionString, Help: "comma-delimited strings used as stable names for images"},
+		{Name: "IMAGE_[ALIAS_]{ALIAS}", Type: OptionString, Help: "full name for a given alias given via IMAGE_ALIASES, where the alias form in the key is uppercased and normalized by replacing non-alphanumerics with _"},
+		{Name: "MACHINE_TYPE", Type: OptionString, Default: defaultOSMachineType, Help: "machine type/flavor"},
+		{Name: "NETWORK", Type: OptionString, Help: "Network to which instance is to be attached."},
+		{Name: "REGION", Type: OptionString, Help: "Openstack region of the network service"},
+		{Name: "SECURITY_GROUP", Type: OptionString, Default: defaultOSSecGroup, Help: "Instance Security Group Name"},
+		{Name: "OS_REGION", Type: OptionString, Default: defaultOSRegion, Help: "Openstack region"},
+		{Name: "OS_ZONE", Type: OptionString, Default: defaultOSZone, Help: "Openstack zone"},
+		{Name: "INSTANCE_NAME", Type: OptionString, Help: fmt.Sprintf("Name of the VM to be created (default %v followed by timeStamp)", defaultOSInstancePrefix)},
+		{Name: "BOOT_POLL_SLEEP", Type: OptionDuration, Default: defaultOSBootPollSleep.String(), Help: "sleep interval between polling server for instance ACTIVE status"},
+		{Name: "BOOT_POLL_DIAL_SLEEP", Type: OptionDuration, Default: defaultOSBootPollDialSleep.String(), Help: "sleep interval between connection dials"},
+		{Name: "SSH_POLL_TIMEOUT", Type: OptionDuration, Default: defaultOSSSHPollTimeout.String(), Help: "Timeout after which VM is marked not sshable"},
+		{Name: "SSH_DIAL_TIMEOUT", Type: OptionDuration, Default: defaultOSSSHDialTimeout.String(), Help: "connection timeout for ssh connections"},
+		{Name: "SSH_TIMEOUT", Type: OptionDuration, Deprecated: "use SSH_DIAL_TIMEOUT instead", Help: "connection timeout for ssh connections"},
+	}, imageSelectorOptions(defaultOSImageSelectorType)...), rateLimitOptions("OpenStack", 0, time.Second)...)
 )
 
 func init() {
-	Register("openstack", "OpenStack", openStackHelp, newOSProvider)
+	Register("openstack", "OpenStack", openStackOptions, newOSProvider)
 }
 
 type osClients struct {
@@ -138,8 +139,12 @@ func newOSProvider(cfg *config.ProviderConfig) (Provider, error) {
 	}
 
 	sshDialTimeout := defaultOSSSHDialTimeout
-	if cfg.IsSet("SSH_TIMEOUT") {
-		sshDialTimeout, err = time.ParseDuration(cfg.Get("SSH_DIAL_TIMEOUT"))
+	sshDialTimeoutKey := "SSH_DIAL_TIMEOUT"
+	if !cfg.IsSet(sshDialTimeoutKey) && cfg.IsSet("SSH_TIMEOUT") {
+		sshDialTimeoutKey = "SSH_TIMEOUT"
+	}
+	if cfg.IsSet(sshDialTimeoutKey) {
+		sshDialTimeout, err = time.ParseDuration(cfg.Get(sshDialTimeoutKey))
 		if err != nil {
 			return nil, err
 		}
@@ -212,7 +217,7 @@ func newOSProvider(cfg *config.ProviderConfig) (Provider, error) {
 		imageSelectorType = cfg.Get("IMAGE_SELECTOR_TYPE")
 	}
 
-	imageSelector, err := buildOSImageSelector(imageSelectorType, cfg)
+	imageSelector, err := buildImageSelector(imageSelectorType, cfg, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -356,7 +361,18 @@ func buildOSComputeService(cfg *config.ProviderConfig) (*osClients, error) {
 		}
 	}
 
-	provider, err := openstack.AuthenticatedClient(opts)
+	limiter, err := newAPILimiter("openstack", cfg, 0, time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := openstack.NewClient(opts.IdentityEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	provider.HTTPClient.Transport = limiter.Transport(provider.HTTPClient.Transport)
+
+	err = openstack.Authenticate(provider, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -553,35 +569,8 @@ func (p *osProvider) Start(ctx gocontext.Context, startAttributes *StartAttribut
 
 }
 
-func buildOSImageSelector(selectorType string, cfg *config.ProviderConfig) (image.Selector, error) {
-	switch selectorType {
-	case "env":
-		return image.NewEnvSelector(cfg)
-	case "api":
-		baseURL, err := url.Parse(cfg.Get("IMAGE_SELECTOR_URL"))
-		if err != nil {
-			return nil, err
-		}
-		return image.NewAPISelector(baseURL), nil
-	default:
-		return nil, fmt.Errorf("invalid image selector type %q", selectorType)
-	}
-}
-
 func (p *osProvider) getImageName(ctx gocontext.Context, startAttributes *StartAttributes) (string, error) {
-	jobID, _ := context.JobIDFromContext(ctx)
-	repo, _ := context.RepositoryFromContext(ctx)
-
-	imageName, err := p.imageSelector.Select(&image.Params{
-		Infra:    "openstack",
-		Language: startAttributes.Language,
-		OsxImage: startAttributes.OsxImage,
-		Dist:     startAttributes.Dist,
-		Group:    startAttributes.Group,
-		OS:       startAttributes.OS,
-		JobID:    jobID,
-		Repo:     repo,
-	})
+	imageName, err := p.imageSelector.Select(p.imageParams(ctx, startAttributes))
 
 	if err != nil {
 		return "", err
@@ -594,6 +583,30 @@ func (p *osProvider) getImageName(ctx gocontext.Context, startAttributes *StartA
 	return imageName, nil
 }
 
+func (p *osProvider) imageParams(ctx gocontext.Context, startAttributes *StartAttributes) *image.Params {
+	jobID, _ := context.JobIDFromContext(ctx)
+	repo, _ := context.RepositoryFromContext(ctx)
+
+	return &image.Params{
+		Infra:     "openstack",
+		Language:  startAttributes.Language,
+		OsxImage:  startAttributes.OsxImage,
+		Dist:      startAttributes.Dist,
+		Group:     startAttributes.Group,
+		OS:        startAttributes.OS,
+		JobID:     jobID,
+		Repo:      repo,
+		Queue:     startAttributes.Queue,
+		Selection: &startAttributes.ImageSelection,
+	}
+}
+
+// ExplainImage explains which image would be selected for the job, without
+// starting anything.
+func (p *osProvider) ExplainImage(ctx gocontext.Context, startAttributes *StartAttributes) (*image.Explanation, error) {
+	return explainImage(p.imageSelector, p.imageParams(ctx, startAttributes), "", p.defaultImage), nil
+}
+
 func (i *osInstance) sshConnection() (ssh.Connection, error) {
 	time.Sleep(time.Second * 20)
 	return i.provider.sshDialer.Dial(fmt.Sprintf("%s:22", i.ipAddr), i.ic.AuthUser, i.provider.sshDialTimeout)
@@ -613,7 +626,7 @@ func (i *osInstance) UploadScript(ctx gocontext.Context, script []byte) error {
 	conn, err := i.sshConnection()
 	if err != nil {
 		logger.Info("could't connect to SSH server")
-		return errors.Wrap(err, "couldn't connect to SSH server")
+		return errors.Wrap(workererrors.Classify(err, workererrors.ClassSSHUnreachable), "couldn't connect to SSH server")
 	}
 	defer conn.Close()
 
@@ -632,7 +645,7 @@ func (i *osInstance) UploadScript(ctx gocontext.Context, script []byte) error {
 func (i *osInstance) RunScript(ctx gocontext.Context, output io.Writer) (*RunResult, error) {
 	conn, err := i.sshConnection()
 	if err != nil {
-		return &RunResult{Completed: false}, errors.Wrap(err, "couldn't connect to SSH server")
+		return &RunResult{Completed: false}, errors.Wrap(workererrors.Classify(err, workererrors.ClassSSHUnreachable), "couldn't connect to SSH server")
 	}
 	defer conn.Close()
 
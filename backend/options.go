@@ -0,0 +1,321 @@
+package backend
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/travis-ci/worker/config"
+)
+
+// OptionType is the type of value a provider option takes.
+type OptionType string
+
+const (
+	OptionString   OptionType = "string"
+	OptionBool     OptionType = "bool"
+	OptionInt      OptionType = "int"
+	OptionDuration OptionType = "duration"
+)
+
+// Option describes a provider config key. Names may contain placeholders in
+// braces and optional parts in brackets, e.g. "IMAGE_[ALIAS_]{ALIAS}" matches
+// both "IMAGE_FOO" and "IMAGE_ALIAS_FOO".
+type Option struct {
+	Name string
+	// Aliases are alternative names for the option, any one of which
+	// satisfies Required.
+	Aliases  []string
+	Type     OptionType
+	Required bool
+	Default  string
+	// Allowed, if not empty, lists the only values the option may take.
+	Allowed []string
+	// Deprecated, if not empty, explains what to use instead.
+	Deprecated string
+	Help       string
+
+	patterns []*regexp.Regexp
+}
+
+// builtinProviderKeys are set by the worker itself rather than by users.
+var builtinProviderKeys = map[string]bool{
+	"TRAVIS_SITE": true,
+}
+
+var optionPlaceholderRegexp = regexp.MustCompile(`\[[^\]]*\]|\{[^}]*\}`)
+
+func (o *Option) names() []string {
+	return append([]string{o.Name}, o.Aliases...)
+}
+
+func (o *Option) isPattern() bool {
+	return strings.ContainsAny(o.Name, "[{")
+}
+
+func (o *Option) compile() {
+	o.patterns = nil
+	for _, name := range o.names() {
+		o.patterns = append(o.patterns, optionNameRegexp(name))
+	}
+}
+
+func (o *Option) matches(key string) bool {
+	for _, pattern := range o.patterns {
+		if pattern.MatchString(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func optionNameRegexp(name string) *regexp.Regexp {
+	re := ""
+	last := 0
+	for _, loc := range optionPlaceholderRegexp.FindAllStringIndex(name, -1) {
+		re += regexp.QuoteMeta(name[last:loc[0]])
+		part := name[loc[0]:loc[1]]
+		if part[0] == '[' {
+			re += "(?:" + regexp.QuoteMeta(part[1:len(part)-1]) + ")?"
+		} else {
+			re += "[A-Z0-9_]+"
+		}
+		last = loc[1]
+	}
+	re += regexp.QuoteMeta(name[last:])
+
+	return regexp.MustCompile("^" + re + "$")
+}
+
+// helpText renders the option for --help output.
+func (o *Option) helpText() string {
+	help := o.Help
+	if o.Required {
+		help = "[REQUIRED] " + help
+	}
+	if o.Deprecated != "" {
+		help = "[DEPRECATED] " + help
+	}
+
+	details := []string{}
+	if len(o.Allowed) > 0 {
+		quoted := []string{}
+		for _, value := range o.Allowed {
+			quoted = append(quoted, strconv.Quote(value))
+		}
+		details = append(details, "one of "+strings.Join(quoted, ", "))
+	}
+	if o.Default != "" {
+		if o.Type == OptionString || o.Type == "" {
+			details = append(details, fmt.Sprintf("default %q", o.Default))
+		} else {
+			details = append(details, "default "+o.Default)
+		}
+	}
+	if o.Deprecated != "" {
+		details = append(details, o.Deprecated)
+	}
+
+	if len(details) > 0 {
+		help += " (" + strings.Join(details, ", ") + ")"
+	}
+
+	return help
+}
+
+func optionsHelp(options []*Option) map[string]string {
+	help := map[string]string{}
+	for _, option := range options {
+		help[strings.Join(option.names(), " / ")] = option.helpText()
+	}
+	return help
+}
+
+// ConfigProblem is an issue found when validating a provider config.
+type ConfigProblem struct {
+	Key string
+	// Fatal is true for problems that keep the provider from working, such
+	// as missing required keys or invalid values, and false for problems
+	// worth a warning, such as unknown or deprecated keys.
+	Fatal   bool
+	Message string
+}
+
+func (p *ConfigProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Key, p.Message)
+}
+
+// ValidateProviderConfig checks the given provider config against the options
+// declared by the backend with the given alias, and returns any problems
+// found sorted by key.
+func ValidateProviderConfig(alias string, cfg *config.ProviderConfig) ([]*ConfigProblem, error) {
+	backendRegistryMutex.Lock()
+	backend, ok := backendRegistry[alias]
+	backendRegistryMutex.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown backend provider: %s", alias)
+	}
+
+	return backend.Validate(cfg), nil
+}
+
+// Validate checks the given provider config against the backend's options.
+func (b *Backend) Validate(cfg *config.ProviderConfig) []*ConfigProblem {
+	problems := []*ConfigProblem{}
+
+	cfg.Each(func(key, value string) {
+		if builtinProviderKeys[key] {
+			return
+		}
+
+		option := b.option(key)
+		if option == nil {
+			message := "unknown key"
+			if suggestion := b.suggest(key); suggestion != "" {
+				message += fmt.Sprintf(", did you mean %s?", suggestion)
+			}
+			problems = append(problems, &ConfigProblem{Key: key, Message: message})
+			return
+		}
+
+		if option.Deprecated != "" {
+			problems = append(problems, &ConfigProblem{Key: key, Message: "deprecated, " + option.Deprecated})
+		}
+
+		if err := option.check(value); err != nil {
+			// providers read most bools with asBool, which takes any other
+			// value as true, so an odd bool value doesn't keep the worker
+			// from starting
+			fatal := option.Type != OptionBool
+			problems = append(problems, &ConfigProblem{Key: key, Fatal: fatal, Message: err.Error()})
+		}
+	})
+
+	for _, option := range b.Options {
+		if !option.Required || option.isPattern() {
+			continue
+		}
+
+		set := false
+		for _, name := range option.names() {
+			set = set || cfg.IsSet(name)
+		}
+		if !set {
+			problems = append(problems, &ConfigProblem{
+				Key:     strings.Join(option.names(), " / "),
+				Fatal:   true,
+				Message: "required key is missing",
+			})
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Key < problems[j].Key
+	})
+
+	return problems
+}
+
+func (b *Backend) option(key string) *Option {
+	// exact names win over patterns, so that e.g. IMAGE_DEFAULT isn't
+	// checked as an IMAGE_{ALIAS}
+	for _, option := range b.Options {
+		for _, name := range option.names() {
+			if name == key {
+				return option
+			}
+		}
+	}
+
+	for _, option := range b.Options {
+		if option.isPattern() && option.matches(key) {
+			return option
+		}
+	}
+
+	return nil
+}
+
+func (o *Option) check(value string) error {
+	var err error
+	switch o.Type {
+	case OptionBool:
+		if !isBoolString(value) {
+			err = fmt.Errorf("not a bool")
+		}
+	case OptionInt:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case OptionDuration:
+		_, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s value %q", o.Type, value)
+	}
+
+	if len(o.Allowed) == 0 {
+		return nil
+	}
+
+	for _, allowed := range o.Allowed {
+		if value == allowed {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid value %q, expected one of %s", value, strings.Join(o.Allowed, ", "))
+}
+
+// suggest returns the option name closest to the given unknown key, or an
+// empty string if none is close enough to be a likely typo.
+func (b *Backend) suggest(key string) string {
+	best := ""
+	bestDistance := len(key)/3 + 2
+
+	for _, option := range b.Options {
+		if option.isPattern() {
+			continue
+		}
+
+		for _, name := range option.names() {
+			if distance := levenshtein(key, name); distance < bestDistance {
+				best, bestDistance = name, distance
+			}
+		}
+	}
+
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/travis-ci/worker/config"
+)
+
+func newTestOptionsBackend() *Backend {
+	options := []*Option{
+		{Name: "ENDPOINT", Aliases: []string{"HOST"}, Type: OptionString, Required: true, Help: "endpoint"},
+		{Name: "BOOT_POLL_SLEEP", Type: OptionDuration, Default: "3s", Help: "sleep"},
+		{Name: "PUBLIC_IP", Type: OptionBool, Help: "public ip"},
+		{Name: "SELECTOR", Type: OptionString, Allowed: []string{"env", "api"}, Help: "selector"},
+		{Name: "IMAGE_DEFAULT", Type: OptionString, Help: "default image"},
+		{Name: "IMAGE_[ALIAS_]{ALIAS}", Type: OptionString, Help: "alias"},
+		{Name: "OLD_KEY", Type: OptionString, Deprecated: "use ENDPOINT instead", Help: "old key"},
+	}
+	for _, option := range options {
+		option.compile()
+	}
+
+	return &Backend{Alias: "test", Options: options}
+}
+
+func TestBackend_Validate(t *testing.T) {
+	b := newTestOptionsBackend()
+
+	problems := b.Validate(config.ProviderConfigFromMap(map[string]string{
+		"BOOT_POLL_SLEPE":      "3s",
+		"PUBLIC_IP":            "maybe",
+		"SELECTOR":             "tag",
+		"IMAGE_ALIAS_XENIAL":   "travis-ci-xenial",
+		"IMAGE_TRUSTY":         "travis-ci-trusty",
+		"OLD_KEY":              "foo",
+		"TRAVIS_SITE":          "org",
+		"COMPLETELY_DIFFERENT": "x",
+	}))
+
+	assert.Equal(t, []*ConfigProblem{
+		{Key: "BOOT_POLL_SLEPE", Message: "unknown key, did you mean BOOT_POLL_SLEEP?"},
+		{Key: "COMPLETELY_DIFFERENT", Message: "unknown key"},
+		{Key: "ENDPOINT / HOST", Fatal: true, Message: "required key is missing"},
+		{Key: "OLD_KEY", Message: "deprecated, use ENDPOINT instead"},
+		{Key: "PUBLIC_IP", Message: `invalid bool value "maybe"`},
+		{Key: "SELECTOR", Fatal: true, Message: `invalid value "tag", expected one of env, api`},
+	}, problems)
+}
+
+func TestBackend_Validate_Valid(t *testing.T) {
+	b := newTestOptionsBackend()
+
+	problems := b.Validate(config.ProviderConfigFromMap(map[string]string{
+		"HOST":            "tcp://localhost:4243",
+		"BOOT_POLL_SLEEP": "5s",
+		"IMAGE_DEFAULT":   "travis-ci-default",
+		"SELECTOR":        "api",
+		"PUBLIC_IP":       "yes",
+	}))
+
+	assert.Empty(t, problems)
+}
+
+func TestValidateProviderConfig(t *testing.T) {
+	problems, err := ValidateProviderConfig("fake", config.ProviderConfigFromMap(map[string]string{
+		"RUN_SLEEP": "forever",
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, []*ConfigProblem{
+		{Key: "RUN_SLEEP", Fatal: true, Message: `invalid duration value "forever"`},
+	}, problems)
+
+	_, err = ValidateProviderConfig("nope", config.ProviderConfigFromMap(map[string]string{}))
+	assert.NotNil(t, err)
+}
+
+func TestOption_HelpText(t *testing.T) {
+	assert.Equal(t, "[REQUIRED] endpoint", (&Option{Name: "ENDPOINT", Required: true, Help: "endpoint"}).helpText())
+	assert.Equal(t,
+		`image selector type (one of "env", "api", default "env")`,
+		(&Option{Type: OptionString, Default: "env", Allowed: []string{"env", "api"}, Help: "image selector type"}).helpText())
+	assert.Equal(t, "sleep (default 3s)", (&Option{Type: OptionDuration, Default: "3s", Help: "sleep"}).helpText())
+	assert.Equal(t,
+		"[DEPRECATED] old key (use NEW_KEY instead)",
+		(&Option{Deprecated: "use NEW_KEY instead", Help: "old key"}).helpText())
+}
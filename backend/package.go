@@ -38,14 +38,18 @@ import (
 	"time"
 
 	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	"github.com/travis-ci/worker/config"
 	"github.com/travis-ci/worker/context"
+	workererrors "github.com/travis-ci/worker/errors"
+	"github.com/travis-ci/worker/image"
 )
 
 var (
 	// ErrStaleVM is returned from one of the Instance methods if it detects
 	// that the VM had already been used for a repository and was not reverted
 	// afterwards.
-	ErrStaleVM = fmt.Errorf("previous build artifacts found on stale vm")
+	ErrStaleVM = workererrors.New(workererrors.ClassStaleVM, "previous build artifacts found on stale vm")
 
 	// ErrMissingEndpointConfig is returned if the provider config was missing
 	// an 'ENDPOINT' configuration, but one is required.
@@ -59,7 +63,9 @@ var (
 
 // Provider represents some kind of instance provider. It can point to an
 // external HTTP API, or some process locally, or something completely
-// different.
+// different. Providers that hold on to resources, such as API clients, also
+// implement io.Closer, which is called when the provider is replaced after a
+// config reload.
 type Provider interface {
 	// Setup performs whatever is necessary in order to be ready to start
 	// instances.
@@ -78,6 +84,12 @@ type Provider interface {
 	SupportsProgress() bool
 }
 
+// ImageExplainer is implemented by providers that can explain which image
+// they would boot for a job, without starting anything.
+type ImageExplainer interface {
+	ExplainImage(gocontext.Context, *StartAttributes) (*image.Explanation, error)
+}
+
 // An Instance is something that can run a build script.
 type Instance interface {
 	// UploadScript uploads the given script to the instance. The script is
@@ -121,6 +133,72 @@ type RunResult struct {
 	Completed bool
 }
 
+// explainImage explains the image selection of providers that boot
+// defaultImage when the selector answers "default", unless defaultImage is
+// empty. Providers honouring image_name from the job config pass it as
+// imageName.
+func explainImage(selector image.Selector, params *image.Params, imageName, defaultImage string) *image.Explanation {
+	if imageName != "" {
+		explanation := &image.Explanation{Selector: "job config", Image: imageName}
+		explanation.Notef("image_name is set in the job config")
+		return explanation
+	}
+
+	explanation := image.Explain(selector, params)
+	if explanation.Image == "default" && defaultImage != "" {
+		explanation.Notef("default resolves to the DEFAULT_IMAGE %s", defaultImage)
+		explanation.Image = defaultImage
+	}
+
+	return explanation
+}
+
+// imageSelectorOptions returns the IMAGE_SELECTOR_* options read by
+// buildImageSelector. providerTypes are the selector types a provider
+// supports in addition to the ones every provider supports.
+func imageSelectorOptions(defaultType string, providerTypes ...string) []*Option {
+	allowed := append(append([]string{}, providerTypes...), "env", "api", "file", "chain")
+
+	return []*Option{
+		{Name: "IMAGE_SELECTOR_TYPE", Type: OptionString, Default: defaultType, Allowed: allowed, Help: "image selector type"},
+		{Name: "IMAGE_SELECTOR_URL", Type: OptionString, Help: "URL for image selector API, used only when image selector is \"api\""},
+		{Name: "IMAGE_SELECTOR_FILE", Type: OptionString, Help: "path to YAML rules file for image selection, used only when image selector is \"file\""},
+		{Name: "IMAGE_SELECTOR_CHAIN", Type: OptionString, Help: "comma-delimited image selector types tried in order, used only when image selector is \"chain\""},
+		{Name: "IMAGE_SELECTOR_CANARY_IMAGE", Type: OptionString, Help: "image used for the canary percentage of jobs, used only when image selector is \"chain\""},
+		{Name: "IMAGE_SELECTOR_CANARY_PERCENT", Type: OptionInt, Default: "0", Help: "percentage of jobs sent to IMAGE_SELECTOR_CANARY_IMAGE"},
+		{Name: "IMAGE_SELECTOR_CANARY_HASH", Type: OptionString, Default: "repo", Allowed: []string{"repo", "job_id"}, Help: "job attribute hashed to pick canary jobs"},
+		{Name: "IMAGE_SELECTOR_CANARY_MATCH", Type: OptionString, Help: "comma-delimited field=pattern pairs a job must match to be considered for the canary, e.g. \"dist=xenial,os=linux\", required when IMAGE_SELECTOR_CANARY_PERCENT is set"},
+	}
+}
+
+// buildImageSelector builds an image selector of the given type. The types
+// every provider supports are built here, and providerSelectors builds the
+// provider-specific ones, if any.
+func buildImageSelector(selectorType string, cfg *config.ProviderConfig, providerSelectors map[string]func() (image.Selector, error)) (image.Selector, error) {
+	if build, ok := providerSelectors[selectorType]; ok {
+		return build()
+	}
+
+	switch selectorType {
+	case "env":
+		return image.NewEnvSelector(cfg)
+	case "file":
+		return image.NewFileSelector(cfg.Get("IMAGE_SELECTOR_FILE"))
+	case "chain":
+		return image.NewChainSelector(cfg, func(selectorType string) (image.Selector, error) {
+			return buildImageSelector(selectorType, cfg, providerSelectors)
+		})
+	case "api":
+		baseURL, err := url.Parse(cfg.Get("IMAGE_SELECTOR_URL"))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse image selector URL")
+		}
+		return image.NewAPISelector(baseURL), nil
+	default:
+		return nil, fmt.Errorf("invalid image selector type %q", selectorType)
+	}
+}
+
 func asBool(s string) bool {
 	switch strings.ToLower(s) {
 	case "0", "no", "off", "false", "":
@@ -130,6 +208,17 @@ func asBool(s string) bool {
 	}
 }
 
+// isBoolString returns true if s is one of the values asBool is meant to
+// read, rather than an arbitrary value it reads as true.
+func isBoolString(s string) bool {
+	switch strings.ToLower(s) {
+	case "0", "no", "off", "false", "f", "", "1", "yes", "on", "true", "t":
+		return true
+	default:
+		return false
+	}
+}
+
 func str2map(s string) map[string]string {
 	ret := map[string]string{}
 
@@ -9,7 +9,9 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/travis-ci/worker/config"
 	"github.com/travis-ci/worker/context"
+	"github.com/travis-ci/worker/image"
 )
 
 type recordingHTTPTransport struct {
@@ -80,3 +82,59 @@ func Test_str2Map(t *testing.T) {
 	}
 	assert.Equal(t, e, m)
 }
+
+func TestExplainImage(t *testing.T) {
+	selector, _ := image.NewEnvSelector(config.ProviderConfigFromMap(map[string]string{
+		"IMAGE_RUBY": "travis-ci-ruby",
+	}))
+
+	explanation := explainImage(selector, &image.Params{Language: "ruby"}, "", "travis-ci-default")
+	assert.Equal(t, "travis-ci-ruby", explanation.Image)
+
+	explanation = explainImage(selector, &image.Params{Language: "go"}, "", "travis-ci-default")
+	assert.Equal(t, "travis-ci-default", explanation.Image)
+	assert.Contains(t, explanation.Notes, "default resolves to the DEFAULT_IMAGE travis-ci-default")
+
+	explanation = explainImage(selector, &image.Params{Language: "go"}, "", "")
+	assert.Equal(t, "default", explanation.Image)
+
+	explanation = explainImage(selector, &image.Params{Language: "ruby"}, "travis-ci-custom", "travis-ci-default")
+	assert.Equal(t, "job config", explanation.Selector)
+	assert.Equal(t, "travis-ci-custom", explanation.Image)
+}
+
+type staticImageSelector string
+
+func (s staticImageSelector) Select(params *image.Params) (string, error) {
+	return string(s), nil
+}
+
+func TestBuildImageSelector(t *testing.T) {
+	cfg := config.ProviderConfigFromMap(map[string]string{
+		"IMAGE_SELECTOR_CHAIN": "static,env",
+		"IMAGE_RUBY":           "travis-ci-ruby",
+	})
+	providerSelectors := map[string]func() (image.Selector, error){
+		"static": func() (image.Selector, error) { return staticImageSelector("default"), nil },
+	}
+
+	selector, err := buildImageSelector("chain", cfg, providerSelectors)
+	assert.Nil(t, err)
+	imageName, err := selector.Select(&image.Params{Language: "ruby"})
+	assert.Nil(t, err)
+	assert.Equal(t, "travis-ci-ruby", imageName)
+
+	selector, err = buildImageSelector("static", cfg, providerSelectors)
+	assert.Nil(t, err)
+	assert.Equal(t, staticImageSelector("default"), selector)
+
+	_, err = buildImageSelector("static", cfg, nil)
+	assert.EqualError(t, err, `invalid image selector type "static"`)
+}
+
+func TestImageSelectorOptions(t *testing.T) {
+	options := imageSelectorOptions("tag", "tag")
+	assert.Equal(t, "IMAGE_SELECTOR_TYPE", options[0].Name)
+	assert.Equal(t, "tag", options[0].Default)
+	assert.Equal(t, []string{"tag", "env", "api", "file", "chain"}, options[0].Allowed)
+}
@@ -2,6 +2,8 @@ package backend
 
 import (
 	"time"
+
+	"github.com/travis-ci/worker/image"
 )
 
 type VmConfig struct {
@@ -20,6 +22,10 @@ type StartAttributes struct {
 	OS        string `json:"os"`
 	ImageName string `json:"image_name"`
 
+	// FakeFaults overrides the faults injected by the fake provider, and is
+	// ignored by the other providers.
+	FakeFaults map[string]interface{} `json:"fake_faults,omitempty"`
+
 	// The VMType isn't stored in the config directly, but in the top level of
 	// the job payload, see the worker.JobPayload struct.
 	VMType string `json:"-"`
@@ -32,6 +38,10 @@ type StartAttributes struct {
 	// the job payload, see the worker.JobPayload struct.
 	Warmer bool `json:"-"`
 
+	// Queue isn't stored in the config directly, but in the top level of
+	// the job payload, see the worker.JobPayload struct.
+	Queue string `json:"-"`
+
 	// HardTimeout isn't stored in the config directly, but is injected
 	// from the processor
 	HardTimeout time.Duration `json:"-"`
@@ -39,6 +49,10 @@ type StartAttributes struct {
 	// ProgressType isn't stored in the config directly, but is injected from
 	// the processor
 	ProgressType string `json:"-"`
+
+	// ImageSelection isn't stored in the config directly, but is filled in
+	// by the backend when a chained image selector picks the image
+	ImageSelection image.Selection `json:"-"`
 }
 
 // SetDefaults sets any missing required attributes to the default values provided
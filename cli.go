@@ -6,6 +6,7 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -13,7 +14,9 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -23,10 +26,8 @@ import (
 	gocontext "context"
 
 	googlecloudtrace "cloud.google.com/go/trace"
-	"contrib.go.opencensus.io/exporter/stackdriver"
 	"go.opencensus.io/trace"
 	"golang.org/x/oauth2/google"
-	"google.golang.org/api/option"
 
 	"github.com/cenk/backoff"
 	"github.com/getsentry/raven-go"
@@ -38,6 +39,7 @@ import (
 	"github.com/travis-ci/worker/backend"
 	"github.com/travis-ci/worker/config"
 	"github.com/travis-ci/worker/context"
+	"github.com/travis-ci/worker/image"
 	travismetrics "github.com/travis-ci/worker/metrics"
 	cli "gopkg.in/urfave/cli.v1"
 )
@@ -46,6 +48,10 @@ var (
 	rootContext = gocontext.TODO()
 )
 
+// jobEventsFlushTimeout is how long the worker waits on shutdown for the
+// queued job events to be sent.
+const jobEventsFlushTimeout = 30 * time.Second
+
 // CLI is the top level of execution for the whole shebang
 type CLI struct {
 	c        *cli.Context
@@ -64,9 +70,21 @@ type CLI struct {
 	CancellationBroadcaster *CancellationBroadcaster
 	JobQueue                JobQueue
 	LogWriterFactory        LogWriterFactory
+	LogTailer               *LogTailer
+	HealthChecks            *HealthChecks
+	JobEvents               *JobEventEmitter
 
 	heartbeatErrSleep time.Duration
 	heartbeatSleep    time.Duration
+
+	// providerStopSleep is how often a provider replaced by a config reload
+	// is checked for running jobs before it's stopped.
+	providerStopSleep time.Duration
+
+	traceExporters []traceExporter
+	httpJobQueue   *HTTPJobQueue
+
+	reloadMutex sync.Mutex
 }
 
 // NewCLI creates a new *CLI from a *cli.Context
@@ -77,8 +95,10 @@ func NewCLI(c *cli.Context) *CLI {
 
 		heartbeatSleep:    5 * time.Minute,
 		heartbeatErrSleep: 30 * time.Second,
+		providerStopSleep: 5 * time.Second,
 
 		CancellationBroadcaster: NewCancellationBroadcaster(),
+		HealthChecks:            NewHealthChecks(),
 	}
 }
 
@@ -97,9 +117,14 @@ func (i *CLI) Setup() (bool, error) {
 	i.cancel = cancel
 	i.logger = logger
 
-	logrus.SetFormatter(&logrus.TextFormatter{DisableColors: true})
+	logrus.SetFormatter(&redactingFormatter{Formatter: &logrus.TextFormatter{DisableColors: true}})
 
-	i.Config = config.FromCLIContext(i.c)
+	cfg, err := config.Load(i.c)
+	if err != nil {
+		logger.WithField("err", err).Error("couldn't load config")
+		return false, err
+	}
+	i.Config = cfg
 
 	if i.c.String("pprof-port") != "" && i.c.String("http-api-port") != "" {
 		return false, fmt.Errorf("only one http port is allowed. "+
@@ -107,6 +132,8 @@ func (i *CLI) Setup() (bool, error) {
 			i.c.String("pprof-port"), i.c.String("http-api-port"))
 	}
 	if i.c.String("pprof-port") != "" || i.c.String("http-api-port") != "" {
+		i.setupPrometheusMetrics()
+		i.setupHealthChecks()
 		if i.c.String("http-api-auth") != "" {
 			i.setupHTTPAPI()
 		} else {
@@ -140,7 +167,7 @@ func (i *CLI) Setup() (bool, error) {
 	i.setupSentry()
 	i.setupMetrics()
 
-	err := i.setupOpenCensus()
+	err = i.setupOpenCensus()
 	if err != nil {
 		logger.WithField("err", err).Error("failed to set up opencensus")
 		return false, err
@@ -160,13 +187,24 @@ func (i *CLI) Setup() (bool, error) {
 		i.Config.ProviderConfig.Set("TRAVIS_SITE", i.Config.TravisSite)
 	}
 
+	i.setupImageSelectorCache()
+
+	err = i.checkProviderConfig()
+	if err != nil {
+		logger.WithField("err", err).Error("invalid provider config")
+		return false, err
+	}
+
 	provider, err := backend.NewBackendProvider(i.Config.ProviderName, i.Config.ProviderConfig)
 	if err != nil {
 		logger.WithField("err", err).Error("couldn't create backend provider")
 		return false, err
 	}
 
-	err = provider.Setup(ctx)
+	healthProvider := newHealthTrackingProvider(provider)
+	i.HealthChecks.Register("provider", healthProvider.checkHealth)
+
+	err = healthProvider.Setup(ctx)
 	if err != nil {
 		logger.WithField("err", err).Error("couldn't setup backend provider")
 		return false, err
@@ -174,12 +212,24 @@ func (i *CLI) Setup() (bool, error) {
 
 	logger.WithField("provider", fmt.Sprintf("%#v", provider)).Debug("built")
 
-	i.BackendProvider = provider
+	i.BackendProvider = healthProvider
+
+	if i.Config.LogTailBufferSize > 0 {
+		i.LogTailer = NewLogTailer(i.Config.LogTailBufferSize, i.Config.LogTailHistory)
+	}
+
+	err = i.setupJobEvents()
+	if err != nil {
+		logger.WithField("err", err).Error("couldn't set up job events")
+		return false, err
+	}
 
 	ppc := &ProcessorPoolConfig{
-		Hostname: i.Config.Hostname,
-		Context:  ctx,
-		Config:   i.Config,
+		Hostname:  i.Config.Hostname,
+		Context:   ctx,
+		Config:    i.Config,
+		LogTailer: i.LogTailer,
+		JobEvents: i.JobEvents,
 	}
 
 	pool := NewProcessorPool(ppc, i.BackendProvider, i.BuildScriptGenerator, i.BuildTracePersister, i.CancellationBroadcaster)
@@ -210,6 +260,7 @@ func (i *CLI) Run() {
 
 	i.handleStartHook()
 	defer i.handleStopHook()
+	defer i.flushTraceExporters()
 
 	i.logger.Info("worker started")
 	defer i.logProcessorInfo("worker finished")
@@ -220,6 +271,16 @@ func (i *CLI) Run() {
 	i.logger.Info("starting signal handler loop")
 	go i.signalHandler()
 
+	if i.Config.SecretsRefreshInterval > 0 && len(i.Config.SecretRefs)+len(i.Config.ProviderSecretRefs) > 0 {
+		i.logger.Info("starting secrets refresher")
+		go i.refreshSecrets(i.Config.SecretsRefreshInterval)
+	}
+
+	if i.Config.ReadinessPauseIntake {
+		i.logger.Info("starting readiness intake watcher")
+		go i.HealthChecks.WatchIntake(i.ctx, i.ProcessorPool, i.Config.ReadinessInterval)
+	}
+
 	i.logger.WithFields(logrus.Fields{
 		"pool_size":         i.Config.PoolSize,
 		"queue":             i.JobQueue,
@@ -239,6 +300,92 @@ func (i *CLI) Run() {
 			i.logger.WithField("err", err).Error("couldn't clean up logs queue")
 		}
 	}
+
+	flushCtx, cancel := gocontext.WithTimeout(rootContext, jobEventsFlushTimeout)
+	defer cancel()
+
+	err = i.JobEvents.Close(flushCtx)
+	if err != nil {
+		i.logger.WithField("err", err).Error("couldn't flush job events")
+	}
+}
+
+// ValidateConfig loads the config and checks the provider config against the
+// options declared by the provider, writing any problems found to out. It
+// returns false if any of the problems would keep the worker from starting.
+func (i *CLI) ValidateConfig(out io.Writer) (bool, error) {
+	cfg, err := config.Load(i.c)
+	if err != nil {
+		return false, err
+	}
+
+	problems, err := backend.ValidateProviderConfig(cfg.ProviderName, cfg.ProviderConfig)
+	if err != nil {
+		return false, err
+	}
+
+	valid := true
+	for _, problem := range problems {
+		level := "warning"
+		if problem.Fatal {
+			level = "error"
+			valid = false
+		}
+		fmt.Fprintf(out, "%s: %s\n", level, problem)
+	}
+
+	if valid {
+		fmt.Fprintf(out, "provider config for %s is valid\n", cfg.ProviderName)
+	}
+
+	return valid, nil
+}
+
+// checkProviderConfig logs problems with the provider config and returns an
+// error if any of them would keep the provider from working.
+func (i *CLI) checkProviderConfig() error {
+	problems, err := backend.ValidateProviderConfig(i.Config.ProviderName, i.Config.ProviderConfig)
+	if err != nil {
+		// unknown providers are reported by backend.NewBackendProvider
+		return nil
+	}
+
+	fatal := []string{}
+	for _, problem := range problems {
+		logger := i.logger.WithFields(logrus.Fields{
+			"key":     problem.Key,
+			"problem": problem.Message,
+		})
+		if problem.Fatal {
+			logger.Error("invalid provider config")
+			fatal = append(fatal, problem.String())
+			continue
+		}
+		logger.Warn("suspicious provider config")
+	}
+
+	if len(fatal) > 0 {
+		return fmt.Errorf("invalid provider config: %s", strings.Join(fatal, "; "))
+	}
+
+	return nil
+}
+
+func (i *CLI) setupImageSelectorCache() {
+	if i.Config.ImageSelectorCacheTTL == 0 && !i.Config.ImageSelectorCacheStaleOnError {
+		return
+	}
+
+	image.DefaultAPICache = image.NewAPICache(
+		i.Config.ImageSelectorCacheTTL,
+		i.Config.ImageSelectorCacheStaleWhileRevalidate,
+		i.Config.ImageSelectorCacheStaleOnError)
+
+	i.logger.WithFields(logrus.Fields{
+		"ttl":                    i.Config.ImageSelectorCacheTTL,
+		"stale_while_revalidate": i.Config.ImageSelectorCacheStaleWhileRevalidate,
+		"stale_on_error":         i.Config.ImageSelectorCacheStaleOnError,
+	}).Info("caching image selector answers")
 }
 
 func (i *CLI) setupHeartbeat() {
@@ -247,18 +394,8 @@ func (i *CLI) setupHeartbeat() {
 		return
 	}
 
-	hbTok := i.c.String("heartbeat-url-auth-token")
-	if strings.HasPrefix(hbTok, "file://") {
-		hbTokBytes, err := ioutil.ReadFile(strings.Split(hbTok, "://")[1])
-		if err != nil {
-			i.logger.WithField("err", err).Error("failed to read auth token from file")
-		} else {
-			hbTok = string(hbTokBytes)
-		}
-	}
-
 	i.logger.WithField("heartbeat_url", hbURL).Info("starting heartbeat loop")
-	go i.heartbeatHandler(hbURL, strings.TrimSpace(hbTok))
+	go i.heartbeatHandler(hbURL, i.c.String("heartbeat-url-auth-token"))
 }
 
 func (i *CLI) handleStartHook() {
@@ -344,6 +481,29 @@ func (i *CLI) setupMetrics() {
 			[]float64{0.50, 0.75, 0.90, 0.95, 0.99, 0.999, 1.0}, time.Millisecond)
 	}
 
+	if i.Config.StatsdAddress != "" {
+		i.logger.WithField("address", i.Config.StatsdAddress).Info("starting statsd metrics reporter")
+
+		go func() {
+			err := travismetrics.StatsD(metrics.DefaultRegistry, i.Config.StatsdFlushInterval, travismetrics.StatsDConfig{
+				Address:   i.Config.StatsdAddress,
+				Prefix:    i.Config.StatsdPrefix,
+				DogStatsD: i.Config.StatsdFlavor != "statsd",
+				Logger:    i.logger.WithField("self", "statsd"),
+				Tags: map[string]string{
+					"site":     i.Config.TravisSite,
+					"infra":    i.Config.Infra,
+					"queue":    i.Config.QueueName,
+					"provider": i.Config.ProviderName,
+					"hostname": i.Config.Hostname,
+				},
+			})
+			if err != nil {
+				i.logger.WithField("err", err).Error("couldn't start statsd metrics reporter")
+			}
+		}()
+	}
+
 	if i.c.Bool("log-metrics") {
 		i.logger.Info("starting logger metrics reporter")
 
@@ -352,6 +512,52 @@ func (i *CLI) setupMetrics() {
 	}
 }
 
+func (i *CLI) setupPrometheusMetrics() {
+	http.Handle("/metrics", travismetrics.PrometheusHandler(metrics.DefaultRegistry, map[string]string{
+		"provider": i.Config.ProviderName,
+		"queue":    i.Config.QueueName,
+		"infra":    i.Config.Infra,
+		"site":     i.Config.TravisSite,
+	}))
+}
+
+func (i *CLI) setupHealthChecks() {
+	i.HealthChecks.Register("log_sink", checkHTTPLogPartSinksHealth)
+
+	http.HandleFunc("/healthz", i.serveHealthz)
+	http.HandleFunc("/readyz", i.serveReadyz)
+}
+
+func (i *CLI) setupJobEvents() error {
+	sinks := []JobEventSink{}
+
+	if i.Config.JobEventsWebhookURL != "" {
+		sinks = append(sinks, NewWebhookJobEventSink(i.Config.JobEventsWebhookURL, i.Config.JobEventsWebhookSecret))
+	}
+
+	if i.Config.JobEventsFile != "" {
+		sink, err := NewFileJobEventSink(i.Config.JobEventsFile)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if len(sinks) == 0 {
+		return nil
+	}
+
+	i.logger.WithField("sinks", len(sinks)).Info("emitting job events")
+	i.JobEvents = NewJobEventEmitter(i.ctx, i.Config.Hostname, i.Config.ProviderName, i.Config.TravisSite, sinks...)
+	return nil
+}
+
+func (i *CLI) flushTraceExporters() {
+	for _, exporter := range i.traceExporters {
+		exporter.Flush()
+	}
+}
+
 func loadStackdriverTraceJSON(ctx gocontext.Context, stackdriverTraceAccountJSON string) (*google.Credentials, error) {
 	if stackdriverTraceAccountJSON == "" {
 		creds, err := google.FindDefaultCredentials(gocontext.TODO(), googlecloudtrace.ScopeTraceAppend)
@@ -395,29 +601,16 @@ func (i *CLI) setupOpenCensus() error {
 		return nil
 	}
 
-	creds, err := loadStackdriverTraceJSON(gocontext.TODO(), i.Config.StackdriverTraceAccountJSON)
+	exporters, err := newTraceExporters(i.ctx, i.Config)
 	if err != nil {
 		return err
 	}
 
-	sd, err := stackdriver.NewExporter(stackdriver.Options{
-		ProjectID: i.Config.StackdriverProjectID,
-		TraceClientOptions: []option.ClientOption{
-			option.WithCredentials(creds),
-		},
-		MonitoringClientOptions: []option.ClientOption{
-			option.WithCredentials(creds),
-		},
-	})
-
-	if err != nil {
-		return err
+	// Register/enable the trace exporters
+	for _, exporter := range exporters {
+		trace.RegisterExporter(exporter)
 	}
-
-	defer sd.Flush()
-
-	// Register/enable the trace exporter
-	trace.RegisterExporter(sd)
+	i.traceExporters = exporters
 
 	traceSampleRate := i.Config.OpencensusSamplingRate
 	if traceSampleRate <= 0 {
@@ -431,13 +624,22 @@ func (i *CLI) setupOpenCensus() error {
 	return nil
 }
 
-func (i *CLI) heartbeatHandler(heartbeatURL, heartbeatAuthToken string) {
+// heartbeatHandler checks the heartbeat URL in a loop. The auth token may be
+// a secret reference, which is resolved again before each check so that a
+// rotated token is picked up without a restart.
+func (i *CLI) heartbeatHandler(heartbeatURL, heartbeatAuthTokenRef string) {
 	b := backoff.NewExponentialBackOff()
 	b.MaxInterval = 10 * time.Second
 	b.MaxElapsedTime = time.Minute
 
 	for {
-		err := backoff.Retry(func() error {
+		heartbeatAuthToken, err := config.ResolveSecret(heartbeatAuthTokenRef)
+		if err != nil {
+			i.logger.WithField("err", err).Error("failed to resolve auth token")
+		}
+		heartbeatAuthToken = strings.TrimSpace(heartbeatAuthToken)
+
+		err = backoff.Retry(func() error {
 			return i.heartbeatCheck(heartbeatURL, heartbeatAuthToken)
 		}, b)
 
@@ -506,6 +708,17 @@ func (i *CLI) httpAPI(w http.ResponseWriter, req *http.Request) {
 		fmt.Fprintf(w, strings.TrimSpace(`
 Available methods:
 
+- GET /worker/api/v1/info
+- GET /worker/api/v1/processors
+- GET /worker/api/v1/pool
+- PUT /worker/api/v1/pool {"size": n}
+- POST /worker/api/v1/intake/pause
+- POST /worker/api/v1/intake/resume
+- POST /worker/api/v1/config/reload
+- GET /worker/api/v1/image-cache
+- DELETE /worker/api/v1/image-cache
+- POST /worker/api/v1/jobs/{id}/cancel
+- GET /worker/jobs/{id}/log[?from=offset]
 - POST /worker/graceful-shutdown
 - POST /worker/graceful-shutdown-pause
 - POST /worker/info
@@ -516,21 +729,28 @@ Available methods:
 		return
 	}
 
-	if req.Method != "POST" {
-		w.WriteHeader(http.StatusNotFound)
+	if strings.HasPrefix(req.URL.Path, httpAdminAPIPrefix) {
+		if !i.httpAPIAuthorized(w, req) {
+			return
+		}
+		i.httpAdminAPI(w, req)
 		return
 	}
 
-	username, password, ok := req.BasicAuth()
-	if !ok {
-		w.Header().Set("WWW-Authenticate", "Basic realm=\"travis-ci/worker\"")
-		w.WriteHeader(http.StatusUnauthorized)
+	if req.Method == "GET" && strings.HasPrefix(req.URL.Path, "/worker/jobs/") {
+		if !i.httpAPIAuthorized(w, req) {
+			return
+		}
+		i.httpAPIJob(w, req)
 		return
 	}
 
-	authBytes := []byte(fmt.Sprintf("%s:%s", username, password))
-	if subtle.ConstantTimeCompare(authBytes, []byte(i.c.String("http-api-auth"))) != 1 {
-		w.WriteHeader(http.StatusForbidden)
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !i.httpAPIAuthorized(w, req) {
 		return
 	}
 
@@ -591,12 +811,45 @@ Available methods:
 	}
 }
 
+func (i *CLI) httpAPIAuthorized(w http.ResponseWriter, req *http.Request) bool {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Basic realm=\"travis-ci/worker\"")
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+
+	authBytes := []byte(fmt.Sprintf("%s:%s", username, password))
+	if subtle.ConstantTimeCompare(authBytes, []byte(i.c.String("http-api-auth"))) != 1 {
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+func (i *CLI) httpAPIJob(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/worker/jobs/"), "/")
+	if len(parts) != 2 || parts[1] != "log" || i.LogTailer == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	jobID, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	i.LogTailer.serveJobLog(w, req, jobID)
+}
+
 func (i *CLI) signalHandler() {
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan,
 		syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR1,
 		syscall.SIGTTIN, syscall.SIGTTOU,
-		syscall.SIGUSR2)
+		syscall.SIGUSR2, syscall.SIGHUP)
 
 	for {
 		select {
@@ -619,6 +872,12 @@ func (i *CLI) signalHandler() {
 				i.ProcessorPool.GracefulShutdown(true)
 			case syscall.SIGUSR1:
 				i.logProcessorInfo("received SIGUSR1")
+			case syscall.SIGHUP:
+				i.logger.Info("SIGHUP received, reloading config")
+				_, err := i.ReloadConfig()
+				if err != nil {
+					i.logger.WithField("err", err).Error("couldn't reload config")
+				}
 			default:
 				i.logger.WithField("signal", sig).Info("ignoring unknown signal")
 			}
@@ -676,6 +935,7 @@ func (i *CLI) setupJobQueueAndCanceller() error {
 			if err != nil {
 				return err
 			}
+			i.httpJobQueue = jobQueue
 			subQueues = append(subQueues, jobQueue)
 		default:
 			return fmt.Errorf("unknown queue type %q", queueType)
@@ -695,49 +955,13 @@ func (i *CLI) setupJobQueueAndCanceller() error {
 }
 
 func (i *CLI) buildAMQPJobQueueAndCanceller() (*AMQPJobQueue, *AMQPCanceller, error) {
-	var amqpConn *amqp.Connection
-	var err error
-
-	if i.Config.AmqpTlsCert != "" || i.Config.AmqpTlsCertPath != "" {
-		cfg := new(tls.Config)
-		cfg.RootCAs = x509.NewCertPool()
-		if i.Config.AmqpTlsCert != "" {
-			cfg.RootCAs.AppendCertsFromPEM([]byte(i.Config.AmqpTlsCert))
-		}
-		if i.Config.AmqpTlsCertPath != "" {
-			cert, err := ioutil.ReadFile(i.Config.AmqpTlsCertPath)
-			if err != nil {
-				return nil, nil, err
-			}
-			cfg.RootCAs.AppendCertsFromPEM(cert)
-		}
-		amqpConn, err = amqp.DialConfig(i.Config.AmqpURI,
-			amqp.Config{
-				Heartbeat:       i.Config.AmqpHeartbeat,
-				Locale:          "en_US",
-				TLSClientConfig: cfg,
-			})
-	} else if i.Config.AmqpInsecure {
-		amqpConn, err = amqp.DialConfig(
-			i.Config.AmqpURI,
-			amqp.Config{
-				Heartbeat:       i.Config.AmqpHeartbeat,
-				Locale:          "en_US",
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			})
-	} else {
-		amqpConn, err = amqp.DialConfig(i.Config.AmqpURI,
-			amqp.Config{
-				Heartbeat: i.Config.AmqpHeartbeat,
-				Locale:    "en_US",
-			})
-	}
+	amqpConn, err := i.dialAMQP("amqp", i.Config.AmqpURI, i.Config.AmqpTlsCert, i.Config.AmqpTlsCertPath)
 	if err != nil {
 		i.logger.WithField("err", err).Error("couldn't connect to AMQP")
 		return nil, nil, err
 	}
 
-	go i.amqpErrorWatcher(amqpConn)
+	i.HealthChecks.registerAMQPHealthCheck("amqp", amqpConn)
 
 	i.logger.Debug("connected to AMQP")
 
@@ -782,6 +1006,8 @@ func (i *CLI) buildHTTPJobQueue() (*HTTPJobQueue, error) {
 	jobQueue.DefaultGroup = i.Config.DefaultGroup
 	jobQueue.DefaultOS = i.Config.DefaultOS
 
+	i.HealthChecks.Register("job_board", jobQueue.checkHealth)
+
 	return jobQueue, nil
 }
 
@@ -814,49 +1040,13 @@ func (i *CLI) setupLogWriterFactory() error {
 }
 
 func (i *CLI) buildAMQPLogWriterFactory() (*AMQPLogWriterFactory, error) {
-	var amqpConn *amqp.Connection
-	var err error
-
-	if i.Config.LogsAmqpTlsCert != "" || i.Config.LogsAmqpTlsCertPath != "" {
-		cfg := new(tls.Config)
-		cfg.RootCAs = x509.NewCertPool()
-		if i.Config.LogsAmqpTlsCert != "" {
-			cfg.RootCAs.AppendCertsFromPEM([]byte(i.Config.LogsAmqpTlsCert))
-		}
-		if i.Config.LogsAmqpTlsCertPath != "" {
-			cert, err := ioutil.ReadFile(i.Config.LogsAmqpTlsCertPath)
-			if err != nil {
-				return nil, err
-			}
-			cfg.RootCAs.AppendCertsFromPEM(cert)
-		}
-		amqpConn, err = amqp.DialConfig(i.Config.LogsAmqpURI,
-			amqp.Config{
-				Heartbeat:       i.Config.AmqpHeartbeat,
-				Locale:          "en_US",
-				TLSClientConfig: cfg,
-			})
-	} else if i.Config.AmqpInsecure {
-		amqpConn, err = amqp.DialConfig(
-			i.Config.LogsAmqpURI,
-			amqp.Config{
-				Heartbeat:       i.Config.AmqpHeartbeat,
-				Locale:          "en_US",
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			})
-	} else {
-		amqpConn, err = amqp.DialConfig(i.Config.LogsAmqpURI,
-			amqp.Config{
-				Heartbeat: i.Config.AmqpHeartbeat,
-				Locale:    "en_US",
-			})
-	}
+	amqpConn, err := i.dialAMQP("logs_amqp", i.Config.LogsAmqpURI, i.Config.LogsAmqpTlsCert, i.Config.LogsAmqpTlsCertPath)
 	if err != nil {
 		i.logger.WithField("err", err).Error("couldn't connect to the logs AMQP server")
 		return nil, err
 	}
 
-	go i.amqpErrorWatcher(amqpConn)
+	i.HealthChecks.registerAMQPHealthCheck("logs_amqp", amqpConn)
 	i.logger.Debug("connected to the logs AMQP server")
 
 	logWriterFactory, err := NewAMQPLogWriterFactory(amqpConn, i.Config.RabbitMQSharding)
@@ -866,15 +1056,34 @@ func (i *CLI) buildAMQPLogWriterFactory() (*AMQPLogWriterFactory, error) {
 	return logWriterFactory, nil
 }
 
-func (i *CLI) amqpErrorWatcher(amqpConn *amqp.Connection) {
-	errChan := make(chan *amqp.Error)
-	errChan = amqpConn.NotifyClose(errChan)
+// dialAMQP connects to the AMQP server at uri, trusting the given PEM
+// certificate and certificate file if set. The connection is re-established
+// whenever it is lost.
+func (i *CLI) dialAMQP(name, uri, tlsCert, tlsCertPath string) (*AMQPConnection, error) {
+	amqpConfig := amqp.Config{
+		Heartbeat: i.Config.AmqpHeartbeat,
+		Locale:    "en_US",
+	}
 
-	err, ok := <-errChan
-	if ok {
-		i.logger.WithField("err", err).Error("amqp connection errored, terminating")
-		i.cancel()
-		time.Sleep(time.Minute)
-		i.logger.Panic("timed out waiting for shutdown after amqp connection error")
+	if tlsCert != "" || tlsCertPath != "" {
+		cfg := new(tls.Config)
+		cfg.RootCAs = x509.NewCertPool()
+		if tlsCert != "" {
+			cfg.RootCAs.AppendCertsFromPEM([]byte(tlsCert))
+		}
+		if tlsCertPath != "" {
+			cert, err := ioutil.ReadFile(tlsCertPath)
+			if err != nil {
+				return nil, err
+			}
+			cfg.RootCAs.AppendCertsFromPEM(cert)
+		}
+		amqpConfig.TLSClientConfig = cfg
+	} else if i.Config.AmqpInsecure {
+		amqpConfig.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
+
+	return NewAMQPConnection(i.ctx, name, func() (*amqp.Connection, error) {
+		return amqp.DialConfig(uri, amqpConfig)
+	})
 }
@@ -2,7 +2,10 @@
 package main
 
 import (
+	"io"
+	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/travis-ci/worker"
 	"github.com/travis-ci/worker/config"
@@ -19,6 +22,81 @@ func main() {
 
 	app.Flags = config.Flags
 	app.Action = runWorker
+	app.Commands = []cli.Command{
+		{
+			Name:   "validate-config",
+			Usage:  "check the provider config for unknown keys, invalid values, and missing required keys",
+			Flags:  config.Flags,
+			Action: validateConfig,
+		},
+		{
+			Name:      "explain-image",
+			Usage:     "print how the provider would select an image for a job payload, without starting anything",
+			ArgsUsage: "[payload.json]",
+			Flags: append([]cli.Flag{
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the explanation as JSON",
+				},
+			}, config.Flags...),
+			Action: explainImage,
+		},
+		{
+			Name:      "run-job",
+			Usage:     "run a single job payload against the provider, writing the job log to stdout",
+			ArgsUsage: "[payload.json]",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "image",
+					Usage: "use this image instead of selecting one",
+				},
+				cli.StringSliceFlag{
+					Name:  "start-attr",
+					Usage: "override a start attribute as key=value (language, dist, group, os, osx_image, queue, vm_type)",
+				},
+				cli.StringFlag{
+					Name:  "save-script",
+					Usage: "save the generated build script to this path",
+				},
+			}, config.Flags...),
+			Action: runJob,
+		},
+		{
+			Name:  "load-test",
+			Usage: "run synthetic jobs on the fake provider and report throughput, step latencies, goroutines, and memory",
+			Flags: append([]cli.Flag{
+				cli.IntFlag{
+					Name:  "jobs",
+					Value: 100,
+					Usage: "number of jobs to generate",
+				},
+				cli.Float64Flag{
+					Name:  "rate",
+					Value: 10,
+					Usage: "number of jobs to generate per second",
+				},
+				cli.DurationFlag{
+					Name:  "startup-time",
+					Usage: "time each fake instance takes to start",
+				},
+				cli.DurationFlag{
+					Name:  "run-time",
+					Value: time.Second,
+					Usage: "time each job script takes to run",
+				},
+				cli.IntFlag{
+					Name:  "log-bytes",
+					Value: 10000,
+					Usage: "number of bytes of log output per job",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the report as JSON",
+				},
+			}, config.Flags...),
+			Action: loadTest,
+		},
+	}
 
 	app.Run(os.Args)
 }
@@ -34,3 +112,72 @@ func runWorker(c *cli.Context) error {
 	}
 	return nil
 }
+
+func validateConfig(c *cli.Context) error {
+	valid, err := worker.NewCLI(c).ValidateConfig(os.Stdout)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if !valid {
+		return cli.NewExitError("provider config is invalid", 1)
+	}
+	return nil
+}
+
+func explainImage(c *cli.Context) error {
+	in, err := payloadInput(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer in.Close()
+
+	err = worker.NewCLI(c).ExplainImage(in, os.Stdout, c.Bool("json"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
+
+func runJob(c *cli.Context) error {
+	in, err := payloadInput(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	defer in.Close()
+
+	code, err := worker.NewCLI(c).RunJob(in, os.Stdout, &worker.RunJobOptions{
+		ImageName:       c.String("image"),
+		StartAttributes: c.StringSlice("start-attr"),
+		ScriptPath:      c.String("save-script"),
+	})
+	if err != nil {
+		return cli.NewExitError(err.Error(), worker.RunJobExitUnfinished)
+	}
+	if code != 0 {
+		return cli.NewExitError("", code)
+	}
+	return nil
+}
+
+func loadTest(c *cli.Context) error {
+	err := worker.NewCLI(c).LoadTest(os.Stdout, &worker.LoadTestOptions{
+		Jobs:        c.Int("jobs"),
+		Rate:        c.Float64("rate"),
+		StartupTime: c.Duration("startup-time"),
+		RunTime:     c.Duration("run-time"),
+		LogBytes:    c.Int("log-bytes"),
+	}, c.Bool("json"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
+
+// payloadInput opens the job payload file given as the first argument, or
+// stdin if there is none or it is "-".
+func payloadInput(c *cli.Context) (io.ReadCloser, error) {
+	if c.NArg() == 0 || c.Args().First() == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(c.Args().First())
+}
@@ -27,6 +27,10 @@ var (
 	defaultInitialSleep, _        = time.ParseDuration("1s")
 	defaultLogTimeout, _          = time.ParseDuration("10m")
 	defaultMaxLogLength           = 4500000
+	defaultLogTailBufferSize      = 1048576
+	defaultLogTailHistory, _      = time.ParseDuration("5m")
+	defaultReadinessInterval, _   = time.ParseDuration("30s")
+	defaultStatsdFlushInterval, _ = time.ParseDuration("10s")
 	defaultScriptUploadTimeout, _ = time.ParseDuration("3m30s")
 	defaultStartupTimeout, _      = time.ParseDuration("4m")
 
@@ -116,6 +120,20 @@ var (
 			Value: defaultHostname,
 			Usage: "Librato metrics source name",
 		}),
+		NewConfigDef("StatsdAddress", &cli.StringFlag{
+			Usage: "StatsD server address as host:port or udp://host:port, or unix:///path for a Unix domain socket",
+		}),
+		NewConfigDef("StatsdFlavor", &cli.StringFlag{
+			Value: "dogstatsd",
+			Usage: "StatsD protocol flavor (valid values \"dogstatsd\" with tags, or \"statsd\" without)",
+		}),
+		NewConfigDef("StatsdPrefix", &cli.StringFlag{
+			Usage: "Prefix prepended to all metric names sent to StatsD",
+		}),
+		NewConfigDef("StatsdFlushInterval", &cli.DurationFlag{
+			Value: defaultStatsdFlushInterval,
+			Usage: "How often metrics are flushed to StatsD",
+		}),
 		NewConfigDef("SentryDSN", &cli.StringFlag{
 			Usage: "The DSN to send Sentry events to",
 		}),
@@ -166,6 +184,14 @@ var (
 			Value: defaultMaxLogLength,
 			Usage: "The maximum length of a log in bytes",
 		}),
+		NewConfigDef("LogTailBufferSize", &cli.IntFlag{
+			Value: defaultLogTailBufferSize,
+			Usage: "The number of bytes of each job's log kept in memory for the HTTP API log tail endpoint (0 to disable)",
+		}),
+		NewConfigDef("LogTailHistory", &cli.DurationFlag{
+			Value: defaultLogTailHistory,
+			Usage: "How long the log tail of a finished job is kept around",
+		}),
 		NewConfigDef("JobBoardURL", &cli.StringFlag{
 			Usage: "The base URL for job-board used with http queue",
 		}),
@@ -210,6 +236,29 @@ var (
 		NewConfigDef("BuildTraceS3KeyPrefix", &cli.StringFlag{}),
 		NewConfigDef("BuildTraceS3Region", &cli.StringFlag{}),
 
+		NewConfigDef("JobEventsWebhookURL", &cli.StringFlag{
+			Usage: "URL to POST job lifecycle events to as JSON",
+		}),
+		NewConfigDef("JobEventsWebhookSecret", &cli.StringFlag{
+			Usage: "Secret used to sign job event webhooks with HMAC-SHA256 in the Travis-Worker-Signature header",
+		}),
+		NewConfigDef("JobEventsFile", &cli.StringFlag{
+			Usage: "File to append job lifecycle events to as newline-delimited JSON",
+		}),
+		NewConfigDef("ImageSelectorCacheTTL", &cli.DurationFlag{
+			Usage: "How long to reuse answers from the image selector API for the same candidate tags (0 disables)",
+		}),
+		NewConfigDef("ImageSelectorCacheStaleWhileRevalidate", &cli.DurationFlag{
+			Usage: "How long after the image selector cache TTL to keep serving an answer while fetching a fresh one in the background",
+		}),
+		NewConfigDef("ImageSelectorCacheStaleOnError", &cli.BoolFlag{
+			Usage: "Serve the last known good answer when the image selector API fails, instead of the default image",
+		}),
+		NewConfigDef("SecretsRefreshInterval", &cli.DurationFlag{
+			Value: 5 * time.Minute,
+			Usage: "How often to resolve settings referring to secrets via file://, env://, or exec:// again (0 disables)",
+		}),
+
 		// non-config and special case flags
 		NewConfigDef("PayloadFilterExecutable", &cli.StringFlag{
 			Usage: "External executable which will be called to filter the json to be sent to the build script generator",
@@ -227,7 +276,7 @@ var (
 			Usage: "enable pprof http endpoint (and internal http api) at port",
 		}),
 		NewConfigDef("http-api-port", &cli.StringFlag{
-			Usage: "enable http api (and pprof) at port",
+			Usage: "enable http api (and pprof, and prometheus /metrics) at port",
 		}),
 		NewConfigDef("http-api-auth", &cli.StringFlag{
 			Usage: "username:password for http api basic auth",
@@ -235,11 +284,21 @@ var (
 		NewConfigDef("silence-metrics", &cli.BoolFlag{
 			Usage: "deprecated flag",
 		}),
+		NewConfigDef("ReadinessPauseIntake", &cli.BoolFlag{
+			Usage: "Pause job intake while any readiness check (see /readyz) is failing",
+		}),
+		NewConfigDef("ReadinessInterval", &cli.DurationFlag{
+			Value: defaultReadinessInterval,
+			Usage: "How often readiness checks are run when pausing job intake on failure",
+		}),
 		NewConfigDef("log-metrics", &cli.BoolFlag{
 			Usage: "periodically print metrics to the stdout",
 		}),
+		NewConfigDef("config", &cli.StringFlag{
+			Usage: "YAML or TOML config file to read settings from, with a nested section per provider under \"providers\" (flags and env vars take precedence)",
+		}),
 		NewConfigDef("echo-config", &cli.BoolFlag{
-			Usage: "echo parsed config and exit",
+			Usage: "echo parsed config, and the source of each setting, and exit",
 		}),
 		NewConfigDef("list-backend-providers", &cli.BoolFlag{
 			Usage: "echo backend provider list and exit",
@@ -257,7 +316,7 @@ var (
 			Usage: "health check and/or supervisor check URL (expects response: {\"state\": \"(up|down)\"})",
 		}),
 		NewConfigDef("heartbeat-url-auth-token", &cli.StringFlag{
-			Usage: "auth token for health check and/or supervisor check URL (may be \"file://path/to/file\")",
+			Usage: "auth token for health check and/or supervisor check URL (may be a file://, env://, or exec:// secret reference)",
 		}),
 		NewConfigDef("Infra", &cli.StringFlag{
 			Usage: "infra tag, e.g. gce or ec2",
@@ -269,12 +328,27 @@ var (
 			Usage: "google cloud project ID where where traces are exported and viewed",
 		}),
 		NewConfigDef("OpencensusTracingEnabled", &cli.BoolFlag{
-			Usage: "enable tracing for worker with google stackdriver client",
+			Usage: "enable tracing for worker with the configured trace exporters",
 		}),
 		NewConfigDef("OpencensusSamplingRate", &cli.IntFlag{
 			Usage: "sample rate for trace as an inverse fraction - for sample rate n, every nth event will be sampled",
 			Value: 1,
 		}),
+		NewConfigDef("TraceExporters", &cli.StringFlag{
+			Value: "stackdriver",
+			Usage: "comma-delimited list of trace exporters (valid values \"stackdriver\", \"otlp\", or \"file\")",
+		}),
+		NewConfigDef("TraceServiceName", &cli.StringFlag{
+			Value: "travis-worker",
+			Usage: "service name reported to the otlp trace exporter",
+		}),
+		NewConfigDef("OTLPEndpoint", &cli.StringFlag{
+			Value: "http://localhost:4318/v1/traces",
+			Usage: "URL of the OTLP/HTTP collector that spans are sent to as JSON",
+		}),
+		NewConfigDef("TraceFile", &cli.StringFlag{
+			Usage: "file that spans are appended to as JSON lines by the file trace exporter",
+		}),
 	}
 
 	// Flags is the list of all CLI flags accepted by travis-worker
@@ -377,6 +451,10 @@ type Config struct {
 	LibratoEmail         string        `config:"librato-email"`
 	LibratoToken         string        `config:"librato-token"`
 	LibratoSource        string        `config:"librato-source"`
+	StatsdAddress        string        `config:"statsd-address"`
+	StatsdFlavor         string        `config:"statsd-flavor"`
+	StatsdPrefix         string        `config:"statsd-prefix"`
+	StatsdFlushInterval  time.Duration `config:"statsd-flush-interval"`
 	LogsAmqpURI          string        `config:"logs-amqp-uri"`
 	LogsAmqpTlsCert      string        `config:"logs-amqp-tls-cert"`
 	LogsAmqpTlsCertPath  string        `config:"logs-amqp-tls-cert-path"`
@@ -401,14 +479,29 @@ type Config struct {
 	InitialSleep        time.Duration `config:"initial-sleep"`
 	LogTimeout          time.Duration `config:"log-timeout"`
 	MaxLogLength        int           `config:"max-log-length"`
+	LogTailBufferSize   int           `config:"log-tail-buffer-size"`
+	LogTailHistory      time.Duration `config:"log-tail-history"`
 	ScriptUploadTimeout time.Duration `config:"script-upload-timeout"`
 	StartupTimeout      time.Duration `config:"startup-timeout"`
 
+	ReadinessPauseIntake bool          `config:"readiness-pause-intake"`
+	ReadinessInterval    time.Duration `config:"readiness-interval"`
+
 	BuildTraceEnabled     bool   `config:"build-trace-enabled"`
 	BuildTraceS3Bucket    string `config:"build-trace-s3-bucket"`
 	BuildTraceS3KeyPrefix string `config:"build-trace-s3-key-prefix"`
 	BuildTraceS3Region    string `config:"build-trace-s3-region"`
 
+	JobEventsWebhookURL    string `config:"job-events-webhook-url"`
+	JobEventsWebhookSecret string `config:"job-events-webhook-secret"`
+	JobEventsFile          string `config:"job-events-file"`
+
+	ImageSelectorCacheTTL                  time.Duration `config:"image-selector-cache-ttl"`
+	ImageSelectorCacheStaleWhileRevalidate time.Duration `config:"image-selector-cache-stale-while-revalidate"`
+	ImageSelectorCacheStaleOnError         bool          `config:"image-selector-cache-stale-on-error"`
+
+	SecretsRefreshInterval time.Duration `config:"secrets-refresh-interval"`
+
 	SentryHookErrors           bool `config:"sentry-hook-errors"`
 	BuildAPIInsecureSkipVerify bool `config:"build-api-insecure-skip-verify"`
 	SkipShutdownOnLogTimeout   bool `config:"skip-shutdown-on-log-timeout"`
@@ -437,14 +530,29 @@ type Config struct {
 	StackdriverProjectID        string `config:"stackdriver-project-id"`
 	OpencensusTracingEnabled    bool   `config:"opencensus-tracing-enabled"`
 	OpencensusSamplingRate      int    `config:"opencensus-sampling-rate"`
+	TraceExporters              string `config:"trace-exporters"`
+	TraceServiceName            string `config:"trace-service-name"`
+	OTLPEndpoint                string `config:"otlp-endpoint"`
+	TraceFile                   string `config:"trace-file"`
 
 	ProviderConfig *ProviderConfig
+
+	// Sources and ProviderSources record where each setting came from, keyed
+	// by setting name and provider config key respectively.
+	Sources         map[string]string
+	ProviderSources map[string]string
+
+	// SecretRefs and ProviderSecretRefs record the secret references, such
+	// as "file:///path/to/token", that settings were resolved from, keyed
+	// like Sources and ProviderSources.
+	SecretRefs         map[string]string
+	ProviderSecretRefs map[string]string
 }
 
 // FromCLIContext creates a Config using a cli.Context by pulling configuration
 // from the flags in the context.
 func FromCLIContext(c *cli.Context) *Config {
-	cfg := &Config{}
+	cfg := &Config{Sources: map[string]string{}}
 	cfgVal := reflect.ValueOf(cfg).Elem()
 
 	for _, def := range defs {
@@ -453,6 +561,7 @@ func FromCLIContext(c *cli.Context) *Config {
 		}
 
 		field := cfgVal.FieldByName(def.FieldName)
+		cfg.Sources[def.Name] = sourceOf(c, def)
 
 		if _, ok := def.Flag.(*cli.BoolFlag); ok {
 			field.SetBool(c.Bool(def.Name))
@@ -465,14 +574,17 @@ func FromCLIContext(c *cli.Context) *Config {
 		}
 	}
 
-	cfg.ProviderConfig = ProviderConfigFromEnviron(cfg.ProviderName)
+	cfg.ProviderConfig, cfg.ProviderSources = providerConfigFromEnvironWithSources(cfg.ProviderName)
 
 	return cfg
 }
 
 // WriteEnvConfig writes the given configuration to out. The format of the
 // output is a list of environment variables settings suitable to be sourced
-// by a Bourne-like shell.
+// by a Bourne-like shell. If the sources of the settings are known, each
+// setting is followed by a comment naming its source. Settings resolved from
+// secret references are written as the reference, and other secrets are
+// redacted.
 func WriteEnvConfig(cfg *Config, out io.Writer) {
 	cfgMap := map[string]interface{}{}
 	cfgElem := reflect.ValueOf(cfg).Elem()
@@ -484,6 +596,12 @@ func WriteEnvConfig(cfg *Config, out io.Writer) {
 
 		field := cfgElem.FieldByName(def.FieldName)
 		cfgMap[def.Name] = field.Interface()
+
+		if ref, ok := cfg.SecretRefs[def.Name]; ok {
+			cfgMap[def.Name] = ref
+		} else if field.Kind() == reflect.String {
+			cfgMap[def.Name] = redactValue(def.Name, field.String())
+		}
 	}
 
 	sortedCfgMapKeys := []string{}
@@ -497,12 +615,25 @@ func WriteEnvConfig(cfg *Config, out io.Writer) {
 	fmt.Fprintf(out, "# travis-worker env config generated %s\n", time.Now().UTC())
 	for _, key := range sortedCfgMapKeys {
 		envKey := fmt.Sprintf("TRAVIS_WORKER_%s", strings.ToUpper(strings.Replace(key, "-", "_", -1)))
-		fmt.Fprintf(out, "export %s=%q\n", envKey, fmt.Sprintf("%v", cfgMap[key]))
+		fmt.Fprintf(out, "export %s=%q%s\n", envKey, fmt.Sprintf("%v", cfgMap[key]), sourceComment(cfg.Sources, key))
 	}
 	fmt.Fprintf(out, "\n# travis-worker provider config:\n")
 	cfg.ProviderConfig.Each(func(key, value string) {
+		if ref, ok := cfg.ProviderSecretRefs[key]; ok {
+			value = ref
+		} else {
+			value = redactValue(key, value)
+		}
+
 		envKey := strings.ToUpper(fmt.Sprintf("TRAVIS_WORKER_%s_%s", cfg.ProviderName, strings.Replace(key, "-", "_", -1)))
-		fmt.Fprintf(out, "export %s=%q\n", envKey, value)
+		fmt.Fprintf(out, "export %s=%q%s\n", envKey, value, sourceComment(cfg.ProviderSources, key))
 	})
 	fmt.Fprintf(out, "# end travis-worker env config\n")
 }
+
+func sourceComment(sources map[string]string, key string) string {
+	if source, ok := sources[key]; ok {
+		return " # " + source
+	}
+	return ""
+}
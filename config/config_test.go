@@ -144,6 +144,32 @@ func TestFromCLIContext_SetsDurationFlags(t *testing.T) {
 	})
 }
 
+func TestFromCLIContext_Sources(t *testing.T) {
+	os.Setenv("TRAVIS_WORKER_POOL_SIZE", "3")
+	defer os.Unsetenv("TRAVIS_WORKER_POOL_SIZE")
+	os.Setenv("TRAVIS_WORKER_HARD_TIMEOUT", "2h")
+	defer os.Unsetenv("TRAVIS_WORKER_HARD_TIMEOUT")
+
+	runAppTest(t, []string{
+		"--pool-size=3",
+	}, func(c *cli.Context) error {
+		cfg := FromCLIContext(c)
+
+		// the flag is given even though it has the same value as the
+		// environment variable
+		assert.Equal(t, SourceFlag, cfg.Sources["pool-size"])
+		assert.Equal(t, SourceEnv, cfg.Sources["hard-timeout"])
+		assert.Equal(t, SourceDefault, cfg.Sources["log-timeout"])
+
+		// sources don't change when the config is loaded again
+		cfg = FromCLIContext(c)
+		assert.Equal(t, SourceFlag, cfg.Sources["pool-size"])
+		assert.Equal(t, SourceEnv, cfg.Sources["hard-timeout"])
+
+		return nil
+	})
+}
+
 func TestFromCLIContext_SetsProviderConfig(t *testing.T) {
 	i := fmt.Sprintf("%v", rand.Int())
 	os.Setenv("TRAVIS_WORKER_FAKE_FOO", i)
@@ -0,0 +1,306 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/urfave/cli.v1"
+	"gopkg.in/yaml.v2"
+)
+
+// The sources a config value can come from, in order of precedence.
+const (
+	SourceFlag    = "flag"
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceDefault = "default"
+)
+
+// File is the contents of a YAML or TOML config file. Settings are keyed by
+// their flag name, e.g. "pool-size", and provider settings are keyed by the
+// provider name and then the provider config key, e.g. "PROJECT_ID".
+//
+// An example YAML config file:
+//
+//   provider-name: gce
+//   pool-size: 10
+//   hard-timeout: 50m
+//   providers:
+//     gce:
+//       project-id: travis-ci-prod
+//       zone: us-central1-c
+type File struct {
+	Path      string
+	Settings  map[string]interface{}
+	Providers map[string]map[string]string
+}
+
+// LoadFile reads a config file, picking YAML or TOML based on the file
+// extension.
+func LoadFile(path string) (*File, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read config file")
+	}
+
+	raw := map[string]interface{}{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		yamlRaw := map[interface{}]interface{}{}
+		err = yaml.Unmarshal(content, &yamlRaw)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't parse YAML config file")
+		}
+		for key, value := range yamlRaw {
+			raw[fmt.Sprintf("%v", key)] = value
+		}
+	case ".toml":
+		_, err = toml.Decode(string(content), &raw)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't parse TOML config file")
+		}
+	default:
+		return nil, fmt.Errorf("unknown config file extension %q, expected .yml, .yaml, or .toml", filepath.Ext(path))
+	}
+
+	return newFile(path, raw)
+}
+
+func newFile(path string, raw map[string]interface{}) (*File, error) {
+	file := &File{
+		Path:      path,
+		Settings:  map[string]interface{}{},
+		Providers: map[string]map[string]string{},
+	}
+
+	for key, value := range raw {
+		if key != "providers" {
+			file.Settings[fileSettingName(key)] = value
+			continue
+		}
+
+		providers, ok := stringKeyedMap(value)
+		if !ok {
+			return nil, fmt.Errorf("expected providers to be a map of provider names to settings")
+		}
+
+		for providerName, providerValue := range providers {
+			providerSettings, ok := stringKeyedMap(providerValue)
+			if !ok {
+				return nil, fmt.Errorf("expected settings for provider %q to be a map", providerName)
+			}
+
+			settings := map[string]string{}
+			for settingKey, settingValue := range providerSettings {
+				settings[fileProviderKey(settingKey)] = fmt.Sprintf("%v", settingValue)
+			}
+			file.Providers[strings.ToLower(providerName)] = settings
+		}
+	}
+
+	return file, nil
+}
+
+func stringKeyedMap(value interface{}) (map[string]interface{}, bool) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return value, true
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, v := range value {
+			m[fmt.Sprintf("%v", key)] = v
+		}
+		return m, true
+	}
+
+	return nil, false
+}
+
+func fileSettingName(key string) string {
+	return strings.ToLower(strings.Replace(key, "_", "-", -1))
+}
+
+func fileProviderKey(key string) string {
+	return strings.ToUpper(strings.Replace(key, "-", "_", -1))
+}
+
+// Load creates a Config from the flags and environment variables in the
+// cli.Context, and from the config file given with --config, if any. Values
+// are taken from the first of these that sets them: flags, environment
+// variables, the config file, and finally the defaults.
+//
+// String settings and provider config values that refer to secrets using the
+// file://, env://, or exec:// schemes are resolved.
+func Load(c *cli.Context) (*Config, error) {
+	cfg := FromCLIContext(c)
+
+	if path := c.String("config"); path != "" {
+		file, err := LoadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		err = cfg.applyFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid config file %s", path)
+		}
+	}
+
+	err := cfg.resolveSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (cfg *Config) applyFile(file *File) error {
+	cfgVal := reflect.ValueOf(cfg).Elem()
+
+	for name, value := range file.Settings {
+		def := defByName(name)
+		if def == nil {
+			return fmt.Errorf("unknown setting %q", name)
+		}
+		if !def.HasField {
+			return fmt.Errorf("setting %q is not supported in config files, "+
+				"use the flag or environment variable instead", name)
+		}
+
+		if cfg.Sources[def.Name] != SourceDefault {
+			continue
+		}
+
+		err := setFieldFromFile(cfgVal.FieldByName(def.FieldName), value)
+		if err != nil {
+			return errors.Wrapf(err, "invalid value for %q", name)
+		}
+		cfg.Sources[def.Name] = SourceFile
+	}
+
+	if cfg.Sources["provider-name"] == SourceFile {
+		cfg.ProviderConfig, cfg.ProviderSources = providerConfigFromEnvironWithSources(cfg.ProviderName)
+	}
+
+	for key, value := range file.Providers[strings.ToLower(cfg.ProviderName)] {
+		if cfg.ProviderConfig.IsSet(key) {
+			continue
+		}
+
+		cfg.ProviderConfig.Set(key, value)
+		cfg.ProviderSources[key] = SourceFile
+	}
+
+	return nil
+}
+
+func defByName(name string) *ConfigDef {
+	for _, def := range defs {
+		if def.Name == name {
+			return def
+		}
+	}
+
+	return nil
+}
+
+func setFieldFromFile(field reflect.Value, value interface{}) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected a duration string like \"5m\", got %v", value)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.Bool:
+		switch value := value.(type) {
+		case bool:
+			field.SetBool(value)
+		case string:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return err
+			}
+			field.SetBool(b)
+		default:
+			return fmt.Errorf("expected a boolean, got %v", value)
+		}
+	case reflect.Int:
+		switch value := value.(type) {
+		case int:
+			field.SetInt(int64(value))
+		case int64:
+			field.SetInt(value)
+		case string:
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			field.SetInt(int64(i))
+		default:
+			return fmt.Errorf("expected an integer, got %v", value)
+		}
+	case reflect.String:
+		switch value.(type) {
+		case map[string]interface{}, map[interface{}]interface{}, []interface{}:
+			return fmt.Errorf("expected a string, got %v", value)
+		}
+		field.SetString(fmt.Sprintf("%v", value))
+	}
+
+	return nil
+}
+
+func providerConfigFromEnvironWithSources(providerName string) (*ProviderConfig, map[string]string) {
+	pc := ProviderConfigFromEnviron(providerName)
+
+	sources := map[string]string{}
+	pc.Each(func(key, _ string) {
+		sources[key] = SourceEnv
+	})
+
+	return pc, sources
+}
+
+// sourceOf returns where the value of the flag for the given def came from.
+func sourceOf(c *cli.Context, def *ConfigDef) string {
+	if commandLineContext(c).IsSet(def.Name) {
+		return SourceFlag
+	}
+
+	for _, envVar := range twEnvVarsSlice(def.EnvVar) {
+		if _, ok := os.LookupEnv(envVar); ok {
+			return SourceEnv
+		}
+	}
+
+	return SourceDefault
+}
+
+// commandLineContext returns a copy of c without its app and command. IsSet
+// also reports flags whose environment variable is set, which it finds by
+// going through the flags of the app or command, so for the copy it only
+// reports the flags given on the command line. IsSet caches its answers, so
+// it mustn't have been called on c itself.
+func commandLineContext(c *cli.Context) *cli.Context {
+	cmdline := *c
+	cmdline.App = nil
+	cmdline.Command = cli.Command{}
+	return &cmdline
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func writeTestConfigFile(t *testing.T, name, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "travis-worker-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, name)
+	err = ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoad_YAML(t *testing.T) {
+	path, cleanup := writeTestConfigFile(t, "worker.yml", `
+provider-name: fake
+pool-size: 7
+hard-timeout: 2h
+build-paranoid: true
+queue-name: builds.file
+queue_type: http
+providers:
+  fake:
+    log-output: hello from the file
+    STARTUP_DURATION: 1s
+  gce:
+    project-id: ignored
+`)
+	defer cleanup()
+
+	os.Setenv("TRAVIS_WORKER_QUEUE_NAME", "builds.env")
+	defer os.Unsetenv("TRAVIS_WORKER_QUEUE_NAME")
+	os.Setenv("TRAVIS_WORKER_FAKE_STARTUP_DURATION", "2s")
+	defer os.Unsetenv("TRAVIS_WORKER_FAKE_STARTUP_DURATION")
+
+	runAppTest(t, []string{
+		"--config=" + path,
+		"--pool-size=3",
+	}, func(c *cli.Context) error {
+		cfg, err := Load(c)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 3, cfg.PoolSize)
+		assert.Equal(t, SourceFlag, cfg.Sources["pool-size"])
+		assert.Equal(t, "builds.env", cfg.QueueName)
+		assert.Equal(t, SourceEnv, cfg.Sources["queue-name"])
+		assert.Equal(t, "http", cfg.QueueType)
+		assert.Equal(t, SourceFile, cfg.Sources["queue-type"])
+		assert.Equal(t, 2*time.Hour, cfg.HardTimeout)
+		assert.True(t, cfg.BuildParanoid)
+		assert.Equal(t, defaultLogTimeout, cfg.LogTimeout)
+		assert.Equal(t, SourceDefault, cfg.Sources["log-timeout"])
+
+		assert.Equal(t, "fake", cfg.ProviderName)
+		assert.Equal(t, "hello from the file", cfg.ProviderConfig.Get("LOG_OUTPUT"))
+		assert.Equal(t, SourceFile, cfg.ProviderSources["LOG_OUTPUT"])
+		assert.Equal(t, "2s", cfg.ProviderConfig.Get("STARTUP_DURATION"))
+		assert.Equal(t, SourceEnv, cfg.ProviderSources["STARTUP_DURATION"])
+		assert.False(t, cfg.ProviderConfig.IsSet("PROJECT_ID"))
+
+		out := &bytes.Buffer{}
+		WriteEnvConfig(cfg, out)
+		assert.Contains(t, out.String(), "export TRAVIS_WORKER_POOL_SIZE=\"3\" # flag\n")
+		assert.Contains(t, out.String(), "export TRAVIS_WORKER_QUEUE_TYPE=\"http\" # file\n")
+		assert.Contains(t, out.String(), "export TRAVIS_WORKER_FAKE_LOG_OUTPUT=\"hello from the file\" # file\n")
+
+		return nil
+	})
+}
+
+func TestLoad_TOML(t *testing.T) {
+	path, cleanup := writeTestConfigFile(t, "worker.toml", `
+provider-name = "fake"
+pool-size = 5
+startup-timeout = "90s"
+
+[providers.fake]
+log-output = "hello from toml"
+`)
+	defer cleanup()
+
+	runAppTest(t, []string{"--config=" + path}, func(c *cli.Context) error {
+		cfg, err := Load(c)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 5, cfg.PoolSize)
+		assert.Equal(t, 90*time.Second, cfg.StartupTimeout)
+		assert.Equal(t, "hello from toml", cfg.ProviderConfig.Get("LOG_OUTPUT"))
+
+		return nil
+	})
+}
+
+func TestLoad_InvalidFile(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown.yml":  "pool-sise: 3\n",
+		"invalid.yml":  "pool-size: lots\n",
+		"duration.yml": "hard-timeout: 50\n",
+		"worker.json":  "{}",
+	} {
+		path, cleanup := writeTestConfigFile(t, name, content)
+		defer cleanup()
+
+		runAppTest(t, []string{"--config=" + path}, func(c *cli.Context) error {
+			_, err := Load(c)
+			assert.NotNil(t, err, name)
+			return nil
+		})
+	}
+}
+
+func TestLoad_UnsupportedSetting(t *testing.T) {
+	path, cleanup := writeTestConfigFile(t, "worker.yml", "http-api-port: \"8080\"\n")
+	defer cleanup()
+
+	runAppTest(t, []string{"--config=" + path}, func(c *cli.Context) error {
+		_, err := Load(c)
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "not supported in config files")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,287 @@
+package config
+
+import (
+	"bytes"
+	gocontext "context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The schemes a config value can use to refer to a secret stored elsewhere.
+// For example, "file:///etc/travis-worker/token" is replaced by the contents
+// of that file, "env://GCE_ACCOUNT_JSON" by the value of that environment
+// variable, and "exec://vault read -field=token secret/worker" by the output
+// of that command.
+const (
+	SecretSchemeFile = "file://"
+	SecretSchemeEnv  = "env://"
+	SecretSchemeExec = "exec://"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	secretExecTimeout = 30 * time.Second
+
+	// secretNameParts are parts of setting names and provider config keys
+	// whose values are treated as secrets even if they are given inline.
+	secretNameParts = []string{
+		"token", "secret", "password", "passphrase", "account-json",
+		"access-key", "auth", "dsn", "keychain",
+	}
+
+	secretValuesMutex sync.Mutex
+	secretValues      = map[string]bool{}
+)
+
+// IsSecretRef returns true if the value refers to a secret using one of the
+// secret schemes.
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, SecretSchemeFile) ||
+		strings.HasPrefix(value, SecretSchemeEnv) ||
+		strings.HasPrefix(value, SecretSchemeExec)
+}
+
+// ResolveSecret returns the secret the given value refers to, or the value
+// itself if it doesn't use one of the secret schemes. Resolved secrets are
+// registered for redaction.
+func ResolveSecret(value string) (string, error) {
+	var (
+		secret string
+		err    error
+	)
+
+	switch {
+	case strings.HasPrefix(value, SecretSchemeFile):
+		secret, err = resolveFileSecret(strings.TrimPrefix(value, SecretSchemeFile))
+	case strings.HasPrefix(value, SecretSchemeEnv):
+		secret, err = resolveEnvSecret(strings.TrimPrefix(value, SecretSchemeEnv))
+	case strings.HasPrefix(value, SecretSchemeExec):
+		secret, err = resolveExecSecret(strings.TrimPrefix(value, SecretSchemeExec))
+	default:
+		return value, nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	RegisterSecret(secret)
+	return secret, nil
+}
+
+func resolveFileSecret(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't read secret file")
+	}
+
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+func resolveEnvSecret(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("secret environment variable %s is not set", name)
+	}
+
+	return value, nil
+}
+
+func resolveExecSecret(command string) (string, error) {
+	ctx, cancel := gocontext.WithTimeout(gocontext.Background(), secretExecTimeout)
+	defer cancel()
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", errors.Wrapf(err, "couldn't run secret command: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+// RegisterSecret adds a value to the set of secrets replaced by Redact. Very
+// short values are ignored, as redacting them would mangle unrelated text.
+func RegisterSecret(secret string) {
+	if len(secret) < 4 {
+		return
+	}
+
+	secretValuesMutex.Lock()
+	defer secretValuesMutex.Unlock()
+
+	secretValues[secret] = true
+}
+
+// Redact replaces all registered secrets in s with a placeholder. Longer
+// secrets are replaced first, so that a secret containing another one, like a
+// URL and its password, is redacted as a whole.
+func Redact(s string) string {
+	secretValuesMutex.Lock()
+	defer secretValuesMutex.Unlock()
+
+	secrets := make([]string, 0, len(secretValues))
+	for secret := range secretValues {
+		secrets = append(secrets, secret)
+	}
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+
+	for _, secret := range secrets {
+		s = strings.Replace(s, secret, redacted, -1)
+
+		// secrets may be logged quoted, e.g. in %q or %#v output
+		quoted := strconv.Quote(secret)
+		if quoted = quoted[1 : len(quoted)-1]; quoted != secret {
+			s = strings.Replace(s, quoted, redacted, -1)
+		}
+	}
+
+	return s
+}
+
+// isSecretName returns true if the setting or provider config key with the
+// given name holds a secret.
+func isSecretName(name string) bool {
+	name = strings.ToLower(strings.Replace(name, "_", "-", -1))
+	for _, part := range secretNameParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// registerInlineSecrets registers values that are secrets because of their
+// name, and the passwords of URLs with credentials, for redaction.
+func registerInlineSecrets(name, value string) {
+	if value == "" {
+		return
+	}
+
+	if isSecretName(name) {
+		RegisterSecret(value)
+		return
+	}
+
+	if password, ok := urlPassword(value); ok {
+		RegisterSecret(password)
+	}
+}
+
+func urlPassword(value string) (string, bool) {
+	if !strings.Contains(value, "://") {
+		return "", false
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return "", false
+	}
+
+	return u.User.Password()
+}
+
+// redactValue returns the value to show for a setting when writing out the
+// config.
+func redactValue(name, value string) string {
+	if value == "" {
+		return value
+	}
+
+	if isSecretName(name) {
+		return redacted
+	}
+
+	if password, ok := urlPassword(value); ok && password != "" {
+		return strings.Replace(value, ":"+password+"@", ":"+redacted+"@", 1)
+	}
+
+	return value
+}
+
+// resolveSecrets replaces the string settings and provider config values
+// that refer to secrets with the secrets themselves, keeping track of the
+// references in SecretRefs and ProviderSecretRefs.
+func (cfg *Config) resolveSecrets() error {
+	cfg.SecretRefs = map[string]string{}
+	cfg.ProviderSecretRefs = map[string]string{}
+
+	cfgVal := reflect.ValueOf(cfg).Elem()
+
+	for _, def := range defs {
+		if !def.HasField {
+			continue
+		}
+
+		field := cfgVal.FieldByName(def.FieldName)
+		if field.Kind() != reflect.String {
+			continue
+		}
+
+		value := field.String()
+		secret, err := ResolveSecret(value)
+		if err != nil {
+			return errors.Wrapf(err, "couldn't resolve %s", def.Name)
+		}
+
+		if secret != value {
+			cfg.SecretRefs[def.Name] = value
+			field.SetString(secret)
+		}
+		registerInlineSecrets(def.Name, secret)
+	}
+
+	if cfg.ProviderConfig == nil {
+		return nil
+	}
+
+	resolved := map[string]string{}
+	var resolveErr error
+
+	cfg.ProviderConfig.Each(func(key, value string) {
+		if resolveErr != nil {
+			return
+		}
+
+		secret, err := ResolveSecret(value)
+		if err != nil {
+			resolveErr = errors.Wrapf(err, "couldn't resolve provider config %s", key)
+			return
+		}
+
+		if secret != value {
+			cfg.ProviderSecretRefs[key] = value
+			resolved[key] = secret
+		}
+		registerInlineSecrets(key, secret)
+	})
+
+	if resolveErr != nil {
+		return resolveErr
+	}
+
+	for key, secret := range resolved {
+		cfg.ProviderConfig.Set(key, secret)
+	}
+
+	return nil
+}
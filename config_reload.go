@@ -0,0 +1,215 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/travis-ci/worker/backend"
+	"github.com/travis-ci/worker/config"
+)
+
+// reloadableSettings are the settings that can be changed without restarting
+// the worker. Changes to them apply to jobs started after the reload, while
+// running jobs keep the settings they started with.
+var reloadableSettings = map[string]bool{
+	"pool-size":                    true,
+	"hard-timeout":                 true,
+	"initial-sleep":                true,
+	"log-timeout":                  true,
+	"max-log-length":               true,
+	"script-upload-timeout":        true,
+	"startup-timeout":              true,
+	"skip-shutdown-on-log-timeout": true,
+	"payload-filter-executable":    true,
+	"progress-type":                true,
+	"job-board-url":                true,
+}
+
+// ConfigReload describes the changes made by reloading the config.
+type ConfigReload struct {
+	Changed         []string `json:"changed"`
+	ProviderRebuilt bool     `json:"provider_rebuilt"`
+}
+
+// ReloadConfig loads the config from its sources again and applies the
+// changes to the running worker. An error is returned, and nothing is
+// changed, if the new config is invalid or changes settings that can't be
+// applied without a restart.
+func (i *CLI) ReloadConfig() (*ConfigReload, error) {
+	cfg, err := config.Load(i.c)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.applyConfig(cfg)
+}
+
+// currentConfig returns the config the worker is running with, which may be
+// replaced by a reload at any time.
+func (i *CLI) currentConfig() *config.Config {
+	i.reloadMutex.Lock()
+	defer i.reloadMutex.Unlock()
+
+	return i.Config
+}
+
+func (i *CLI) applyConfig(cfg *config.Config) (*ConfigReload, error) {
+	i.reloadMutex.Lock()
+	defer i.reloadMutex.Unlock()
+
+	if cfg.PoolSize < 1 {
+		return nil, fmt.Errorf("pool size must be at least 1, got %d", cfg.PoolSize)
+	}
+
+	if cfg.TravisSite != "" {
+		cfg.ProviderConfig.Set("TRAVIS_SITE", cfg.TravisSite)
+	}
+
+	changed, rejected := diffConfig(i.Config, cfg)
+	if len(rejected) > 0 {
+		return nil, fmt.Errorf("can't change %s without a restart", strings.Join(rejected, ", "))
+	}
+
+	jobBoardURL, err := url.Parse(cfg.JobBoardURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing job board URL")
+	}
+
+	reload := &ConfigReload{Changed: changed}
+	prevProvider := i.BackendProvider
+	provider := prevProvider
+
+	if !reflect.DeepEqual(providerConfigMap(i.Config.ProviderConfig), providerConfigMap(cfg.ProviderConfig)) {
+		problems, err := backend.ValidateProviderConfig(cfg.ProviderName, cfg.ProviderConfig)
+		if err != nil {
+			return nil, err
+		}
+		for _, problem := range problems {
+			if problem.Fatal {
+				return nil, fmt.Errorf("invalid provider config: %s", problem)
+			}
+		}
+
+		newProvider, err := backend.NewBackendProvider(cfg.ProviderName, cfg.ProviderConfig)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create backend provider: %v", err)
+		}
+
+		healthProvider := newHealthTrackingProvider(newProvider)
+		err = healthProvider.Setup(i.ctx)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't setup backend provider: %v", err)
+		}
+
+		i.HealthChecks.Register("provider", healthProvider.checkHealth)
+		provider = healthProvider
+		reload.Changed = append(reload.Changed, "provider config")
+		reload.ProviderRebuilt = true
+	}
+
+	prevPoolSize := i.Config.PoolSize
+
+	i.Config = cfg
+	i.BackendProvider = provider
+	i.ProcessorPool.Reconfigure(cfg, provider)
+
+	if reload.ProviderRebuilt {
+		go i.stopProviderWhenUnused(prevProvider)
+	}
+
+	if i.httpJobQueue != nil {
+		i.httpJobQueue.SetJobBoardURL(jobBoardURL)
+	}
+
+	if cfg.PoolSize != prevPoolSize {
+		_, err := i.ProcessorPool.SetSize(cfg.PoolSize)
+		if err != nil {
+			i.logger.WithField("err", err).Error("couldn't resize processor pool")
+		}
+	}
+
+	i.logger.WithFields(logrus.Fields{
+		"changed":          reload.Changed,
+		"provider_rebuilt": reload.ProviderRebuilt,
+	}).Info("reloaded config")
+
+	return reload, nil
+}
+
+// stopProviderWhenUnused waits until no processor is running a job with the
+// given provider, which has been replaced by a config reload, and then stops
+// it so it doesn't keep any background work or connections around.
+func (i *CLI) stopProviderWhenUnused(provider backend.Provider) {
+	closer, ok := provider.(io.Closer)
+	if !ok {
+		return
+	}
+
+	for i.ProcessorPool.providerInUse(provider) {
+		select {
+		case <-i.ctx.Done():
+			return
+		case <-time.After(i.providerStopSleep):
+		}
+	}
+
+	err := closer.Close()
+	if err != nil {
+		i.logger.WithField("err", err).Error("couldn't stop replaced backend provider")
+		return
+	}
+
+	i.logger.Info("stopped replaced backend provider")
+}
+
+// diffConfig returns the names of the settings that differ between prev and
+// cfg, split into those that can be applied live and those that can't.
+func diffConfig(prev, cfg *config.Config) ([]string, []string) {
+	changed := []string{}
+	rejected := []string{}
+
+	prevVal := reflect.ValueOf(prev).Elem()
+	cfgVal := reflect.ValueOf(cfg).Elem()
+
+	for n := 0; n < prevVal.NumField(); n++ {
+		name := prevVal.Type().Field(n).Tag.Get("config")
+		if name == "" {
+			continue
+		}
+
+		if reflect.DeepEqual(prevVal.Field(n).Interface(), cfgVal.Field(n).Interface()) {
+			continue
+		}
+
+		if reloadableSettings[name] {
+			changed = append(changed, name)
+		} else {
+			rejected = append(rejected, name)
+		}
+	}
+
+	sort.Strings(changed)
+	sort.Strings(rejected)
+
+	return changed, rejected
+}
+
+func providerConfigMap(pc *config.ProviderConfig) map[string]string {
+	m := map[string]string{}
+	if pc == nil {
+		return m
+	}
+
+	pc.Each(func(key, value string) {
+		m[key] = value
+	})
+
+	return m
+}
@@ -0,0 +1,177 @@
+package worker
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/travis-ci/worker/backend"
+	"github.com/travis-ci/worker/config"
+)
+
+func buildTestReloadConfig(i *CLI) *config.Config {
+	cfg := *i.Config
+	cfg.ProviderConfig = config.ProviderConfigFromMap(map[string]string{})
+	return &cfg
+}
+
+func TestCLI_applyConfig(t *testing.T) {
+	i, cancel := buildTestAdminAPICLI()
+	defer cancel()
+
+	cfg := buildTestReloadConfig(i)
+	cfg.HardTimeout = 2 * time.Hour
+	cfg.LogTimeout = time.Minute
+
+	reload, err := i.applyConfig(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"hard-timeout", "log-timeout"}, reload.Changed)
+	assert.False(t, reload.ProviderRebuilt)
+
+	assert.Equal(t, cfg, i.Config)
+	assert.Equal(t, cfg, i.ProcessorPool.Config)
+
+	proc := i.ProcessorPool.processors[0]
+	assert.Equal(t, cfg, proc.pendingConfig)
+
+	proc.applyPendingConfig()
+	assert.Equal(t, cfg, proc.config)
+	assert.Nil(t, proc.pendingConfig)
+}
+
+func TestCLI_applyConfig_RebuildsProvider(t *testing.T) {
+	i, cancel := buildTestAdminAPICLI()
+	defer cancel()
+
+	cfg := buildTestReloadConfig(i)
+	cfg.ProviderConfig.Set("LOG_OUTPUT", "hello")
+
+	reload, err := i.applyConfig(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"provider config"}, reload.Changed)
+	assert.True(t, reload.ProviderRebuilt)
+	assert.NotNil(t, i.BackendProvider)
+	assert.Equal(t, i.BackendProvider, i.ProcessorPool.Provider)
+
+	_, ok := i.BackendProvider.(*healthTrackingProvider)
+	assert.True(t, ok)
+
+	ready, components := i.HealthChecks.Check()
+	assert.True(t, ready)
+	assert.Equal(t, healthStatusOK, components["provider"].Status)
+}
+
+func TestCLI_applyConfig_RejectsRestartOnlySettings(t *testing.T) {
+	i, cancel := buildTestAdminAPICLI()
+	defer cancel()
+
+	prev := i.Config
+	cfg := buildTestReloadConfig(i)
+	cfg.HardTimeout = 2 * time.Hour
+	cfg.QueueName = "builds.other"
+	cfg.ProviderName = "local"
+
+	_, err := i.applyConfig(cfg)
+	assert.EqualError(t, err, "can't change provider-name, queue-name without a restart")
+	assert.Equal(t, prev, i.Config)
+	assert.Equal(t, prev, i.ProcessorPool.Config)
+}
+
+func TestCLI_applyConfig_RejectsInvalidProviderConfig(t *testing.T) {
+	i, cancel := buildTestAdminAPICLI()
+	defer cancel()
+
+	prev := i.Config
+	cfg := buildTestReloadConfig(i)
+	cfg.ProviderConfig.Set("RUN_SLEEP", "forever")
+
+	_, err := i.applyConfig(cfg)
+	assert.EqualError(t, err, `invalid provider config: RUN_SLEEP: invalid duration value "forever"`)
+	assert.Equal(t, prev, i.Config)
+}
+
+func TestCLI_applyConfig_RejectsInvalidPoolSize(t *testing.T) {
+	i, cancel := buildTestAdminAPICLI()
+	defer cancel()
+
+	prev := i.Config
+	cfg := buildTestReloadConfig(i)
+	cfg.PoolSize = 0
+
+	_, err := i.applyConfig(cfg)
+	assert.EqualError(t, err, "pool size must be at least 1, got 0")
+	assert.Equal(t, prev, i.Config)
+	assert.Equal(t, 1, i.ProcessorPool.Size())
+}
+
+func TestCLI_applyConfig_JobBoardURL(t *testing.T) {
+	i, cancel := buildTestAdminAPICLI()
+	defer cancel()
+
+	jobBoardURL, _ := url.Parse("https://old@job-board.example.com")
+	i.httpJobQueue, _ = NewHTTPJobQueue(jobBoardURL, "test", "fake", "builds.fake", nil)
+
+	cfg := buildTestReloadConfig(i)
+	cfg.JobBoardURL = "https://new@job-board.example.com"
+
+	reload, err := i.applyConfig(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"job-board-url"}, reload.Changed)
+
+	u := i.httpJobQueue.currentJobBoardURL()
+	assert.Equal(t, "https://new@job-board.example.com", u.String())
+}
+
+type closeTrackingProvider struct {
+	backend.Provider
+
+	closed chan struct{}
+}
+
+func (p *closeTrackingProvider) Close() error {
+	close(p.closed)
+	return nil
+}
+
+func TestCLI_applyConfig_StopsReplacedProvider(t *testing.T) {
+	i, cancel := buildTestAdminAPICLI()
+	defer cancel()
+	i.providerStopSleep = time.Millisecond
+
+	fakeProvider, err := backend.NewBackendProvider("fake", config.ProviderConfigFromMap(map[string]string{}))
+	assert.Nil(t, err)
+
+	prevProvider := newHealthTrackingProvider(&closeTrackingProvider{
+		Provider: fakeProvider,
+		closed:   make(chan struct{}),
+	})
+	i.BackendProvider = prevProvider
+
+	proc := i.ProcessorPool.processors[0]
+	proc.provider = prevProvider
+	proc.applyPendingConfig()
+
+	cfg := buildTestReloadConfig(i)
+	cfg.ProviderConfig.Set("LOG_OUTPUT", "hello")
+
+	reload, err := i.applyConfig(cfg)
+	assert.Nil(t, err)
+	assert.True(t, reload.ProviderRebuilt)
+
+	closed := prevProvider.Provider.(*closeTrackingProvider).closed
+
+	select {
+	case <-closed:
+		t.Fatal("provider was stopped while a job was still using it")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	proc.releaseProvider()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("provider wasn't stopped after the job finished")
+	}
+}
@@ -29,6 +29,7 @@ const (
 	jwtKey
 	instanceIDKey
 	timingsKey
+	backendKey
 )
 
 // FromUUID generates a new context with the given context as its parent and
@@ -80,6 +81,13 @@ func FromInstanceID(ctx context.Context, instanceID string) context.Context {
 	return context.WithValue(ctx, instanceIDKey, instanceID)
 }
 
+// FromBackend generates a new context with the given context as its parent
+// and stores the given backend provider name with the context. The backend
+// name can be retrieved again using BackendFromContext.
+func FromBackend(ctx context.Context, backend string) context.Context {
+	return context.WithValue(ctx, backendKey, backend)
+}
+
 // WithTimings initializes the timings map in the context, to be mutated
 // by TimeSince for accumulated timings per request
 func WithTimings(ctx context.Context) context.Context {
@@ -155,6 +163,14 @@ func InstanceIDFromContext(ctx context.Context) (string, bool) {
 	return instanceID, ok
 }
 
+// BackendFromContext returns the backend provider name stored in the context
+// with FromBackend. If no backend name was stored in the context, the second
+// argument is false. Otherwise it is true.
+func BackendFromContext(ctx context.Context) (string, bool) {
+	backend, ok := ctx.Value(backendKey).(string)
+	return backend, ok
+}
+
 // TimingsFromContext returns the timings stored within the context
 func TimingsFromContext(ctx context.Context) (map[string]time.Duration, bool) {
 	timings, ok := ctx.Value(timingsKey).(map[string]time.Duration)
@@ -0,0 +1,122 @@
+package errors
+
+// Class is the cause of a job failure, used to decide whether a job is
+// requeued or errored, and reported in metrics and upstream state updates.
+type Class string
+
+const (
+	ClassUnknown            Class = "unknown"
+	ClassJobAborted         Class = "job_aborted"
+	ClassImageNotFound      Class = "image_not_found"
+	ClassQuotaExceeded      Class = "quota_exceeded"
+	ClassBootTimeout        Class = "boot_timeout"
+	ClassSSHUnreachable     Class = "ssh_unreachable"
+	ClassScriptUploadFailed Class = "script_upload_failed"
+	ClassConnectionLost     Class = "connection_lost"
+	ClassStaleVM            Class = "stale_vm"
+	ClassLogSinkFailed      Class = "log_sink_failed"
+)
+
+var nonRetryableClasses = map[Class]string{
+	ClassJobAborted:    "",
+	ClassImageNotFound: "no image could be found for this job's configuration",
+}
+
+// Retryable returns false if a job that failed with this class of error would
+// fail the same way if it were run again, and true otherwise. Unknown errors
+// are retryable.
+func (c Class) Retryable() bool {
+	_, ok := nonRetryableClasses[c]
+	return !ok
+}
+
+// ClassifiedError is implemented by errors that know their class.
+type ClassifiedError interface {
+	error
+	ErrorClass() Class
+}
+
+type classifiedError struct {
+	err   error
+	class Class
+}
+
+// Classify returns an error that wraps err with the given class. The cause of
+// the returned error is err.
+func Classify(err error, class Class) error {
+	if err == nil {
+		return nil
+	}
+
+	return &classifiedError{err: err, class: class}
+}
+
+func (e *classifiedError) Error() string     { return e.err.Error() }
+func (e *classifiedError) Cause() error      { return e.err }
+func (e *classifiedError) ErrorClass() Class { return e.class }
+
+type sentinelError struct {
+	message string
+	class   Class
+}
+
+// New returns an error with the given message and class, meant for sentinel
+// errors that are compared against errors.Cause.
+func New(class Class, message string) error {
+	return &sentinelError{message: message, class: class}
+}
+
+func (e *sentinelError) Error() string     { return e.message }
+func (e *sentinelError) ErrorClass() Class { return e.class }
+
+type causer interface {
+	Cause() error
+}
+
+// ClassOf returns the class of the outermost classified error in the chain of
+// causes of err, or ClassUnknown if there is none.
+func ClassOf(err error) Class {
+	for err != nil {
+		if classified, ok := err.(ClassifiedError); ok && classified.ErrorClass() != ClassUnknown {
+			return classified.ErrorClass()
+		}
+		if _, ok := err.(JobAbortError); ok {
+			return ClassJobAborted
+		}
+
+		cause, ok := err.(causer)
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+
+	return ClassUnknown
+}
+
+// ClassOrDefault returns the class of err, or class if err is unclassified.
+func ClassOrDefault(err error, class Class) Class {
+	if c := ClassOf(err); c != ClassUnknown {
+		return c
+	}
+	return class
+}
+
+// UserFacingMessage returns a message explaining why a job failed with err
+// that is suitable for showing in the job log, or an empty string if there is
+// none.
+func UserFacingMessage(err error) string {
+	for cur := err; cur != nil; {
+		if abortErr, ok := cur.(JobAbortError); ok {
+			return abortErr.UserFacingErrorMessage()
+		}
+
+		cause, ok := cur.(causer)
+		if !ok {
+			break
+		}
+		cur = cause.Cause()
+	}
+
+	return nonRetryableClasses[ClassOf(err)]
+}
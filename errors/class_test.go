@@ -0,0 +1,43 @@
+package errors
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassOf(t *testing.T) {
+	assert.Equal(t, ClassUnknown, ClassOf(nil))
+	assert.Equal(t, ClassUnknown, ClassOf(fmt.Errorf("boom")))
+
+	staleVM := New(ClassStaleVM, "stale vm")
+	assert.Equal(t, ClassStaleVM, ClassOf(errors.Wrap(staleVM, "couldn't upload script")))
+	assert.Equal(t, staleVM, errors.Cause(errors.Wrap(staleVM, "couldn't upload script")))
+
+	sshErr := errors.Wrap(Classify(fmt.Errorf("connection refused"), ClassSSHUnreachable), "couldn't connect")
+	assert.Equal(t, ClassSSHUnreachable, ClassOf(sshErr))
+	assert.Equal(t, "connection refused", errors.Cause(sshErr).Error())
+
+	assert.Equal(t, ClassJobAborted, ClassOf(errors.Wrap(NewWrappedJobAbortError(fmt.Errorf("bad tag")), "couldn't select image")))
+
+	assert.Equal(t, ClassBootTimeout, ClassOrDefault(fmt.Errorf("boom"), ClassBootTimeout))
+	assert.Equal(t, ClassSSHUnreachable, ClassOrDefault(sshErr, ClassBootTimeout))
+
+	assert.Nil(t, Classify(nil, ClassStaleVM))
+}
+
+func TestClass_Retryable(t *testing.T) {
+	assert.True(t, ClassUnknown.Retryable())
+	assert.True(t, ClassStaleVM.Retryable())
+	assert.True(t, ClassQuotaExceeded.Retryable())
+	assert.False(t, ClassImageNotFound.Retryable())
+	assert.False(t, ClassJobAborted.Retryable())
+}
+
+func TestUserFacingMessage(t *testing.T) {
+	assert.Equal(t, "bad tag", UserFacingMessage(errors.Wrap(NewWrappedJobAbortError(fmt.Errorf("bad tag")), "couldn't select image")))
+	assert.Equal(t, "no image could be found for this job's configuration", UserFacingMessage(Classify(fmt.Errorf("no image"), ClassImageNotFound)))
+	assert.Equal(t, "", UserFacingMessage(Classify(fmt.Errorf("refused"), ClassSSHUnreachable)))
+}
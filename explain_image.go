@@ -0,0 +1,90 @@
+package worker
+
+import (
+	gocontext "context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/travis-ci/worker/backend"
+	"github.com/travis-ci/worker/config"
+	"github.com/travis-ci/worker/context"
+)
+
+// ExplainImage reads a job payload and writes how the configured provider
+// would select an image for it, as text or JSON, without starting anything.
+func (i *CLI) ExplainImage(in io.Reader, out io.Writer, asJSON bool) error {
+	cfg, err := config.Load(i.c)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(in)
+	if err != nil {
+		return errors.Wrap(err, "couldn't read job payload")
+	}
+
+	payload, startAttributes, err := parseLocalJobPayload(body, cfg)
+	if err != nil {
+		return err
+	}
+
+	provider, err := backend.NewBackendProvider(cfg.ProviderName, cfg.ProviderConfig)
+	if err != nil {
+		return errors.Wrap(err, "couldn't create backend provider")
+	}
+
+	explainer, ok := provider.(backend.ImageExplainer)
+	if !ok {
+		return fmt.Errorf("the %s provider doesn't select images", cfg.ProviderName)
+	}
+
+	ctx := context.FromJobID(context.FromRepository(gocontext.Background(), payload.Repository.Slug), payload.Job.ID)
+	explanation, err := explainer.ExplainImage(ctx, startAttributes)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(explanation)
+	}
+
+	fmt.Fprintf(out, "job: %d\nrepo: %s\nqueue: %s\n", payload.Job.ID, payload.Repository.Slug, startAttributes.Queue)
+	fmt.Fprintf(out, "language: %s\ndist: %s\ngroup: %s\nos: %s\n",
+		startAttributes.Language, startAttributes.Dist, startAttributes.Group, startAttributes.OS)
+	if startAttributes.OsxImage != "" {
+		fmt.Fprintf(out, "osx_image: %s\n", startAttributes.OsxImage)
+	}
+	fmt.Fprintln(out)
+	explanation.Print(out)
+
+	return nil
+}
+
+// parseLocalJobPayload reads the start attributes from a job payload the
+// same way the job queues do.
+func parseLocalJobPayload(body []byte, cfg *config.Config) (*JobPayload, *backend.StartAttributes, error) {
+	payload := &JobPayload{}
+	err := json.Unmarshal(body, payload)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "couldn't parse job payload")
+	}
+
+	startAttrs := &jobPayloadStartAttrs{Config: &backend.StartAttributes{}}
+	err = json.Unmarshal(body, startAttrs)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "couldn't parse start attributes")
+	}
+
+	startAttributes := startAttrs.Config
+	startAttributes.VMConfig = payload.VMConfig
+	startAttributes.VMType = payload.VMType
+	startAttributes.Queue = payload.Queue
+	startAttributes.SetDefaults(cfg.DefaultLanguage, cfg.DefaultDist, cfg.DefaultGroup, cfg.DefaultOS, VMTypeDefault, VMConfigDefault)
+
+	return payload, startAttributes, nil
+}
@@ -0,0 +1,28 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/travis-ci/worker/config"
+)
+
+func TestParseLocalJobPayload(t *testing.T) {
+	payload, startAttributes, err := parseLocalJobPayload([]byte(`{
+		"job": {"id": 4},
+		"repository": {"slug": "travis-ci/worker"},
+		"queue": "builds.gce",
+		"config": {"language": "ruby", "os": "linux"}
+	}`), &config.Config{DefaultDist: "xenial", DefaultGroup: "stable"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), payload.Job.ID)
+	assert.Equal(t, "travis-ci/worker", payload.Repository.Slug)
+	assert.Equal(t, "ruby", startAttributes.Language)
+	assert.Equal(t, "xenial", startAttributes.Dist)
+	assert.Equal(t, "stable", startAttributes.Group)
+	assert.Equal(t, "builds.gce", startAttributes.Queue)
+
+	_, _, err = parseLocalJobPayload([]byte(`{"job":`), &config.Config{})
+	assert.NotNil(t, err)
+}
@@ -142,6 +142,7 @@ func (f *FileJobQueue) pollInDirTick(ctx gocontext.Context) {
 		buildJob.startAttributes = startAttrs.Config
 		buildJob.startAttributes.VMConfig = buildJob.payload.VMConfig
 		buildJob.startAttributes.VMType = buildJob.payload.VMType
+		buildJob.startAttributes.Queue = buildJob.payload.Queue
 		buildJob.startAttributes.SetDefaults(f.DefaultLanguage, f.DefaultDist, f.DefaultGroup, f.DefaultOS, VMTypeDefault, VMConfigDefault)
 		buildJob.receivedFile = filepath.Join(f.receivedDir, entry.Name())
 		buildJob.startedFile = filepath.Join(f.startedDir, entry.Name())
@@ -1,19 +1,24 @@
 package worker
 
 import (
+	"fmt"
 	"os"
 	"time"
 
 	gocontext "context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/travis-ci/worker/context"
 )
 
 type fileLogWriter struct {
+	logWriterLimits
+
 	ctx     gocontext.Context
 	logFile string
 	fd      *os.File
 
-	timer   *time.Timer
-	timeout time.Duration
+	closeChan chan struct{}
 }
 
 func newFileLogWriter(ctx gocontext.Context, logFile string, timeout time.Duration) (LogWriter, error) {
@@ -23,45 +28,72 @@ func newFileLogWriter(ctx gocontext.Context, logFile string, timeout time.Durati
 	}
 
 	return &fileLogWriter{
-		ctx:     ctx,
+		logWriterLimits: newLogWriterLimits(timeout),
+
+		ctx:     context.FromComponent(ctx, "log_writer"),
 		logFile: logFile,
 		fd:      fd,
 
-		timer:   time.NewTimer(time.Hour),
-		timeout: timeout,
+		closeChan: make(chan struct{}),
 	}, nil
 }
 
 func (w *fileLogWriter) Write(b []byte) (int, error) {
+	if w.closed() {
+		return 0, fmt.Errorf("attempted write to closed log")
+	}
+
+	logger := context.LoggerFromContext(w.ctx).WithFields(logrus.Fields{
+		"self": "file_log_writer",
+		"inst": fmt.Sprintf("%p", w),
+	})
+
+	if !w.accept(logger, b) {
+		return 0, nil
+	}
+
 	return w.fd.Write(b)
 }
 
 func (w *fileLogWriter) Close() error {
-	return w.fd.Close()
-}
+	if w.closed() {
+		return nil
+	}
 
-func (w *fileLogWriter) SetMaxLogLength(n int) {
-	return
+	w.stopTimer()
+
+	close(w.closeChan)
+	return w.fd.Close()
 }
 
 func (w *fileLogWriter) SetJobStarted(meta *JobStartedMeta) {}
 
-func (w *fileLogWriter) SetCancelFunc(cancel gocontext.CancelFunc) {}
+// WriteAndClose works like a Write followed by a Close, but isn't subject to
+// the maximum log length, so that the reason for terminating a job can always
+// be written. Any folds that are still open are closed before b is written.
+func (w *fileLogWriter) WriteAndClose(b []byte) (int, error) {
+	if w.closed() {
+		return 0, fmt.Errorf("log already closed")
+	}
 
-func (w *fileLogWriter) MaxLengthReached() bool {
-	return false
-}
+	w.stopTimer()
 
-func (w *fileLogWriter) Timeout() <-chan time.Time {
-	return w.timer.C
-}
+	close(w.closeChan)
 
-func (w *fileLogWriter) WriteAndClose(b []byte) (int, error) {
-	n, err := w.Write(b)
+	_, err := w.fd.Write(w.closeOpenFolds(b))
 	if err != nil {
-		return n, err
+		w.fd.Close()
+		return 0, err
 	}
 
-	err = w.Close()
-	return n, err
+	return len(b), w.fd.Close()
+}
+
+func (w *fileLogWriter) closed() bool {
+	select {
+	case <-w.closeChan:
+		return true
+	default:
+		return false
+	}
 }
@@ -0,0 +1,122 @@
+package worker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gocontext "context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildTestFileLogWriter(t *testing.T, timeout time.Duration) (string, *fileLogWriter) {
+	dir, err := ioutil.TempDir("", "travis-worker-file-log-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logFile := filepath.Join(dir, "job.log")
+	lw, err := newFileLogWriter(gocontext.TODO(), logFile, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lw.SetMaxLogLength(100)
+	lw.SetCancelFunc(noCancel)
+
+	return logFile, lw.(*fileLogWriter)
+}
+
+func TestFileLogWriter_Write(t *testing.T) {
+	logFile, flw := buildTestFileLogWriter(t, time.Hour)
+	defer os.RemoveAll(filepath.Dir(logFile))
+
+	n, err := flw.Write([]byte("it's a hot one out there"))
+	assert.Nil(t, err)
+	assert.Equal(t, 24, n)
+	assert.False(t, flw.MaxLengthReached())
+
+	assert.Nil(t, flw.Close())
+
+	content, err := ioutil.ReadFile(logFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "it's a hot one out there", string(content))
+
+	n, err = flw.Write([]byte("still hot"))
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestFileLogWriter_Write_HitsMaxLogLength(t *testing.T) {
+	logFile, flw := buildTestFileLogWriter(t, time.Hour)
+	defer os.RemoveAll(filepath.Dir(logFile))
+
+	cancelled := false
+	flw.SetCancelFunc(func() { cancelled = true })
+	flw.bytesWritten = 1000
+
+	n, err := flw.Write([]byte("there's a strong wind blowing"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	assert.True(t, flw.MaxLengthReached())
+	assert.True(t, cancelled)
+
+	n, err = flw.WriteAndClose([]byte("truncated"))
+	assert.Nil(t, err)
+	assert.Equal(t, 9, n)
+
+	content, err := ioutil.ReadFile(logFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "truncated", string(content))
+}
+
+func TestFileLogWriter_Timeout(t *testing.T) {
+	logFile, flw := buildTestFileLogWriter(t, 50*time.Millisecond)
+	defer os.RemoveAll(filepath.Dir(logFile))
+
+	select {
+	case <-flw.Timeout():
+		t.Fatal("timed out before anything was written")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_, err := flw.Write([]byte("hello"))
+	assert.Nil(t, err)
+
+	select {
+	case <-flw.Timeout():
+	case <-time.After(time.Second):
+		t.Fatal("expected log timeout after write")
+	}
+}
+
+func TestFileLogWriter_WriteAndClose_ClosesOpenFolds(t *testing.T) {
+	logFile, flw := buildTestFileLogWriter(t, time.Hour)
+	defer os.RemoveAll(filepath.Dir(logFile))
+
+	flw.SetMaxLogLength(1000)
+
+	_, err := writeFold(flw, "closed", []byte("done\n"))
+	assert.Nil(t, err)
+	_, err = writeFoldStart(flw, "outer", []byte("outer\n"))
+	assert.Nil(t, err)
+	_, err = fmt.Fprintf(flw, "travis_fold:start:inner\r")
+	assert.Nil(t, err)
+
+	_, err = flw.WriteAndClose([]byte("terminated\n"))
+	assert.Nil(t, err)
+
+	content, err := ioutil.ReadFile(logFile)
+	assert.Nil(t, err)
+
+	expected := fmt.Sprintf(travisFoldStart, "closed") + "done\n" + fmt.Sprintf(travisFoldEnd, "closed") +
+		fmt.Sprintf(travisFoldStart, "outer") + "outer\n" +
+		"travis_fold:start:inner\r" +
+		"\n" + fmt.Sprintf(travisFoldEnd, "inner") + fmt.Sprintf(travisFoldEnd, "outer") +
+		"terminated\n"
+	assert.Equal(t, expected, string(content))
+}
@@ -0,0 +1,293 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	gocontext "context"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/travis-ci/worker/backend"
+	"github.com/travis-ci/worker/context"
+)
+
+const (
+	healthStatusOK      = "ok"
+	healthStatusFailing = "failing"
+
+	// providerHealthStartWindow is the number of most recent instance starts
+	// that are considered by the provider readiness check. The provider is only
+	// considered not ready if all of them failed.
+	providerHealthStartWindow = 5
+
+	// providerHealthStartTTL is how long a failed instance start counts
+	// against the provider readiness check. Expiring failures lets a worker
+	// whose intake was paused by this check resume and probe the provider
+	// again, as paused processors don't start any instances themselves.
+	providerHealthStartTTL = 5 * time.Minute
+)
+
+// HealthChecks is a registry of named readiness checks, one per component
+// the worker depends on for running jobs.
+type HealthChecks struct {
+	checksMutex sync.Mutex
+	checks      map[string]func() error
+
+	// pausedIntake is true while job intake is paused because of failing
+	// readiness checks.
+	pausedIntake bool
+}
+
+type healthResponse struct {
+	Status     string                          `json:"status"`
+	Components map[string]*healthComponentInfo `json:"components,omitempty"`
+}
+
+type healthComponentInfo struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// NewHealthChecks creates an empty HealthChecks.
+func NewHealthChecks() *HealthChecks {
+	return &HealthChecks{checks: map[string]func() error{}}
+}
+
+// Register adds a readiness check for the named component, replacing any
+// existing check with the same name. The check returns nil when the component
+// is ready.
+func (h *HealthChecks) Register(name string, check func() error) {
+	h.checksMutex.Lock()
+	defer h.checksMutex.Unlock()
+
+	h.checks[name] = check
+}
+
+// Check runs all registered readiness checks and returns whether all of them
+// passed, along with the result of each.
+func (h *HealthChecks) Check() (bool, map[string]*healthComponentInfo) {
+	h.checksMutex.Lock()
+	checks := map[string]func() error{}
+	for name, check := range h.checks {
+		checks[name] = check
+	}
+	h.checksMutex.Unlock()
+
+	ready := true
+	components := map[string]*healthComponentInfo{}
+	for name, check := range checks {
+		info := &healthComponentInfo{Status: healthStatusOK}
+		if err := check(); err != nil {
+			ready = false
+			info.Status = healthStatusFailing
+			info.Error = err.Error()
+		}
+		components[name] = info
+	}
+
+	return ready, components
+}
+
+// WatchIntake runs the readiness checks at the given interval until the
+// context is done, pausing job intake on the pool while any check is failing
+// and resuming it once they all pass again. Intake that was paused by other
+// means is left alone.
+func (h *HealthChecks) WatchIntake(ctx gocontext.Context, pool *ProcessorPool, interval time.Duration) {
+	logger := context.LoggerFromContext(ctx).WithField("self", "health_checks")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.updateIntake(logger, pool)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *HealthChecks) updateIntake(logger *logrus.Entry, pool *ProcessorPool) {
+	ready, components := h.Check()
+
+	switch {
+	case !ready && !h.pausedIntake && !pool.IntakePaused():
+		fields := logrus.Fields{}
+		for name, info := range components {
+			if info.Error != "" {
+				fields[name] = info.Error
+			}
+		}
+		logger.WithFields(fields).Warn("readiness checks failing, pausing job intake")
+		pool.PauseIntake()
+		h.pausedIntake = true
+	case ready && h.pausedIntake:
+		logger.Info("readiness checks passing again, resuming job intake")
+		pool.ResumeIntake()
+		h.pausedIntake = false
+	}
+}
+
+// serveHealthz reports that the process is alive and serving requests.
+func (i *CLI) serveHealthz(w http.ResponseWriter, req *http.Request) {
+	i.writeJSON(w, http.StatusOK, &healthResponse{Status: healthStatusOK})
+}
+
+// serveReadyz reports whether the worker is ready to run jobs, with the
+// result of each readiness check.
+func (i *CLI) serveReadyz(w http.ResponseWriter, req *http.Request) {
+	ready, components := i.HealthChecks.Check()
+
+	resp := &healthResponse{Status: healthStatusOK, Components: components}
+	status := http.StatusOK
+	if !ready {
+		resp.Status = healthStatusFailing
+		status = http.StatusServiceUnavailable
+	}
+
+	i.writeJSON(w, status, resp)
+}
+
+// registerAMQPHealthCheck registers a readiness check that fails while the
+// given AMQP connection is down.
+func (h *HealthChecks) registerAMQPHealthCheck(name string, amqpConn *AMQPConnection) {
+	h.Register(name, amqpConn.Err)
+}
+
+// checkHTTPLogPartSinksHealth fails if any of the http log part sinks has
+// filled up its buffer and is rejecting log parts.
+func checkHTTPLogPartSinksHealth() error {
+	httpLogPartSinksByURLMutex.Lock()
+	defer httpLogPartSinksByURLMutex.Unlock()
+
+	urls := []string{}
+	for url, lps := range httpLogPartSinksByURL {
+		if lps.backpressured() {
+			urls = append(urls, url)
+		}
+	}
+
+	if len(urls) > 0 {
+		sort.Strings(urls)
+		return fmt.Errorf("log sink buffer full for %v", urls)
+	}
+
+	return nil
+}
+
+// healthTrackingProvider wraps a backend.Provider to keep track of whether
+// Setup succeeded and of the outcome of the most recent instance starts.
+type healthTrackingProvider struct {
+	backend.Provider
+
+	mutex        sync.Mutex
+	setupDone    bool
+	setupErr     error
+	recentStarts []providerStart
+
+	// cancel cancels the context passed to the provider's Setup, stopping
+	// any background work it started.
+	cancel gocontext.CancelFunc
+
+	now func() time.Time
+}
+
+type providerStart struct {
+	at  time.Time
+	err error
+}
+
+func newHealthTrackingProvider(provider backend.Provider) *healthTrackingProvider {
+	return &healthTrackingProvider{Provider: provider, now: time.Now}
+}
+
+func (p *healthTrackingProvider) Setup(ctx gocontext.Context) error {
+	ctx, cancel := gocontext.WithCancel(ctx)
+	err := p.Provider.Setup(ctx)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.setupDone = true
+	p.setupErr = err
+	p.cancel = cancel
+
+	return err
+}
+
+// Close stops the background work started by Setup, and closes the wrapped
+// provider if it holds resources that need to be released. The provider must
+// not be used afterwards.
+func (p *healthTrackingProvider) Close() error {
+	p.mutex.Lock()
+	cancel := p.cancel
+	p.mutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+
+	if closer, ok := p.Provider.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+func (p *healthTrackingProvider) Start(ctx gocontext.Context, startAttributes *backend.StartAttributes) (backend.Instance, error) {
+	inst, err := p.Provider.Start(ctx, startAttributes)
+	p.recordStart(ctx, err)
+	return inst, err
+}
+
+func (p *healthTrackingProvider) StartWithProgress(ctx gocontext.Context, startAttributes *backend.StartAttributes, progresser backend.Progresser) (backend.Instance, error) {
+	inst, err := p.Provider.StartWithProgress(ctx, startAttributes, progresser)
+	p.recordStart(ctx, err)
+	return inst, err
+}
+
+func (p *healthTrackingProvider) recordStart(ctx gocontext.Context, err error) {
+	// Starts that were interrupted because the job was cancelled or timed out
+	// say nothing about the health of the provider.
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.recentStarts = append(p.recentStarts, providerStart{at: p.now(), err: err})
+	if len(p.recentStarts) > providerHealthStartWindow {
+		p.recentStarts = p.recentStarts[len(p.recentStarts)-providerHealthStartWindow:]
+	}
+}
+
+func (p *healthTrackingProvider) checkHealth() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.setupDone {
+		return errors.New("provider setup has not finished")
+	}
+	if p.setupErr != nil {
+		return errors.Wrap(p.setupErr, "provider setup failed")
+	}
+
+	if len(p.recentStarts) < providerHealthStartWindow {
+		return nil
+	}
+	expiry := p.now().Add(-providerHealthStartTTL)
+	for _, start := range p.recentStarts {
+		if start.err == nil || start.at.Before(expiry) {
+			return nil
+		}
+	}
+
+	return errors.Wrapf(p.recentStarts[len(p.recentStarts)-1].err,
+		"last %d instance starts failed", len(p.recentStarts))
+}
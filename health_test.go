@@ -0,0 +1,160 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gocontext "context"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/travis-ci/worker/backend"
+	"github.com/travis-ci/worker/config"
+	"github.com/travis-ci/worker/context"
+)
+
+type healthTestProvider struct {
+	backend.Provider
+
+	startErr error
+}
+
+func (p *healthTestProvider) Setup(ctx gocontext.Context) error { return nil }
+
+func (p *healthTestProvider) Start(ctx gocontext.Context, _ *backend.StartAttributes) (backend.Instance, error) {
+	return nil, p.startErr
+}
+
+func TestHealthChecks_Check(t *testing.T) {
+	h := NewHealthChecks()
+	h.Register("good", func() error { return nil })
+	h.Register("bad", func() error { return errors.New("nope") })
+
+	ready, components := h.Check()
+	assert.False(t, ready)
+	assert.Equal(t, healthStatusOK, components["good"].Status)
+	assert.Equal(t, healthStatusFailing, components["bad"].Status)
+	assert.Equal(t, "nope", components["bad"].Error)
+
+	h.Register("bad", func() error { return nil })
+	ready, _ = h.Check()
+	assert.True(t, ready)
+}
+
+func TestHealthChecks_updateIntake(t *testing.T) {
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	defer cancel()
+
+	pool := NewProcessorPool(&ProcessorPoolConfig{
+		Context: ctx,
+		Config:  &config.Config{},
+	}, nil, nil, nil, NewCancellationBroadcaster())
+	logger := context.LoggerFromContext(ctx)
+
+	var checkErr error
+	h := NewHealthChecks()
+	h.Register("flaky", func() error { return checkErr })
+
+	checkErr = errors.New("down")
+	h.updateIntake(logger, pool)
+	assert.True(t, pool.IntakePaused())
+
+	checkErr = nil
+	h.updateIntake(logger, pool)
+	assert.False(t, pool.IntakePaused())
+
+	// intake that was paused by someone else is left alone
+	pool.PauseIntake()
+	checkErr = errors.New("down")
+	h.updateIntake(logger, pool)
+	checkErr = nil
+	h.updateIntake(logger, pool)
+	assert.True(t, pool.IntakePaused())
+}
+
+func TestHealthTrackingProvider_checkHealth(t *testing.T) {
+	tp := &healthTestProvider{}
+	p := newHealthTrackingProvider(tp)
+	ctx := gocontext.TODO()
+
+	assert.NotNil(t, p.checkHealth())
+	assert.Nil(t, p.Setup(ctx))
+	assert.Nil(t, p.checkHealth())
+
+	tp.startErr = errors.New("no capacity")
+	for i := 0; i < providerHealthStartWindow-1; i++ {
+		_, _ = p.Start(ctx, nil)
+	}
+	assert.Nil(t, p.checkHealth())
+
+	_, _ = p.Start(ctx, nil)
+	assert.NotNil(t, p.checkHealth())
+
+	cancelledCtx, cancel := gocontext.WithCancel(ctx)
+	cancel()
+	tp.startErr = nil
+	_, _ = p.Start(cancelledCtx, nil)
+	assert.Nil(t, p.checkHealth())
+}
+
+func TestHealthTrackingProvider_checkHealthExpiresFailures(t *testing.T) {
+	tp := &healthTestProvider{startErr: errors.New("no capacity")}
+	p := newHealthTrackingProvider(tp)
+	ctx := gocontext.TODO()
+
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	assert.Nil(t, p.Setup(ctx))
+	for i := 0; i < providerHealthStartWindow; i++ {
+		_, _ = p.Start(ctx, nil)
+	}
+	assert.NotNil(t, p.checkHealth())
+
+	now = now.Add(providerHealthStartTTL + time.Second)
+	assert.Nil(t, p.checkHealth())
+
+	_, _ = p.Start(ctx, nil)
+	assert.Nil(t, p.checkHealth())
+}
+
+func TestHTTPLogPartSink_backpressured(t *testing.T) {
+	ctx, cancel := gocontext.WithCancel(gocontext.TODO())
+	cancel()
+
+	lps := newHTTPLogPartSink(ctx, "http://example.org/log-parts/multi", uint64(1))
+	assert.False(t, lps.backpressured())
+
+	lps.partsBuffer = append(lps.partsBuffer, &httpLogPart{JobID: 4})
+	assert.True(t, lps.backpressured())
+}
+
+func TestCLI_serveReadyz(t *testing.T) {
+	i, cancel := buildTestAdminAPICLI()
+	defer cancel()
+
+	i.HealthChecks.Register("provider", func() error { return nil })
+
+	w := httptest.NewRecorder()
+	i.serveReadyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	i.HealthChecks.Register("job_board", func() error { return errors.New("connection refused") })
+
+	w = httptest.NewRecorder()
+	i.serveReadyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	resp := &healthResponse{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(resp))
+	assert.Equal(t, healthStatusFailing, resp.Status)
+	assert.Equal(t, healthStatusOK, resp.Components["provider"].Status)
+	assert.Equal(t, "connection refused", resp.Components["job_board"].Error)
+
+	w = httptest.NewRecorder()
+	i.serveHealthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,222 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/travis-ci/worker/image"
+)
+
+const httpAdminAPIPrefix = "/worker/api/v1/"
+
+type httpAdminAPIInfo struct {
+	Version        string                    `json:"version"`
+	Revision       string                    `json:"revision"`
+	Generated      string                    `json:"generated"`
+	BootTime       time.Time                 `json:"boot_time"`
+	UptimeSeconds  float64                   `json:"uptime_s"`
+	PoolSize       int                       `json:"pool_size"`
+	TotalProcessed int                       `json:"total_processed"`
+	IntakePaused   bool                      `json:"intake_paused"`
+	Config         httpAdminAPIConfigSummary `json:"config"`
+	Processors     []httpAdminAPIProcessor   `json:"processors"`
+}
+
+type httpAdminAPIConfigSummary struct {
+	ProviderName        string `json:"provider_name"`
+	QueueType           string `json:"queue_type"`
+	QueueName           string `json:"queue_name"`
+	Infra               string `json:"infra"`
+	TravisSite          string `json:"travis_site"`
+	Hostname            string `json:"hostname"`
+	PoolSize            int    `json:"pool_size"`
+	HardTimeout         string `json:"hard_timeout"`
+	LogTimeout          string `json:"log_timeout"`
+	StartupTimeout      string `json:"startup_timeout"`
+	ScriptUploadTimeout string `json:"script_upload_timeout"`
+	MaxLogLength        int    `json:"max_log_length"`
+}
+
+type httpAdminAPIProcessor struct {
+	N          int                 `json:"n"`
+	ID         string              `json:"id"`
+	Processed  int                 `json:"processed"`
+	Status     string              `json:"status"`
+	LastJobID  uint64              `json:"last_job_id"`
+	CurrentJob *ProcessorJobStatus `json:"current_job"`
+}
+
+type httpAdminAPIPoolSize struct {
+	Size int `json:"size"`
+}
+
+// httpAdminAPI serves the versioned JSON API under /worker/api/v1/. The
+// caller is expected to have checked authorization already.
+func (i *CLI) httpAdminAPI(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, httpAdminAPIPrefix), "/")
+	parts := strings.Split(path, "/")
+
+	i.logger.WithField("path", req.URL.Path).Debug("web api request received")
+
+	switch {
+	case path == "info" && req.Method == "GET":
+		i.writeJSON(w, http.StatusOK, i.adminAPIInfo())
+	case path == "processors" && req.Method == "GET":
+		i.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"processors": i.adminAPIProcessors(),
+		})
+	case path == "pool" && req.Method == "GET":
+		i.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"size":          i.ProcessorPool.Size(),
+			"intake_paused": i.ProcessorPool.IntakePaused(),
+		})
+	case path == "pool" && req.Method == "PUT":
+		i.adminAPIResizePool(w, req)
+	case path == "intake/pause" && req.Method == "POST":
+		i.logger.Info("web api intake pause received")
+		i.ProcessorPool.PauseIntake()
+		i.writeJSON(w, http.StatusOK, map[string]interface{}{"intake_paused": true})
+	case path == "intake/resume" && req.Method == "POST":
+		i.logger.Info("web api intake resume received")
+		i.ProcessorPool.ResumeIntake()
+		i.writeJSON(w, http.StatusOK, map[string]interface{}{"intake_paused": false})
+	case path == "image-cache" && req.Method == "GET":
+		i.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"enabled": image.DefaultAPICache != nil,
+			"entries": image.DefaultAPICache.Entries(),
+		})
+	case path == "image-cache" && req.Method == "DELETE":
+		i.logger.Info("web api image cache flush received")
+		i.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"flushed": image.DefaultAPICache.Flush(),
+		})
+	case path == "config/reload" && req.Method == "POST":
+		i.adminAPIReloadConfig(w)
+	case len(parts) == 3 && parts[0] == "jobs" && parts[2] == "cancel" && req.Method == "POST":
+		i.adminAPICancelJob(w, parts[1])
+	default:
+		i.writeJSONError(w, http.StatusNotFound, fmt.Sprintf("no such endpoint: %s %s", req.Method, req.URL.Path))
+	}
+}
+
+func (i *CLI) adminAPIInfo() *httpAdminAPIInfo {
+	cfg := i.currentConfig()
+
+	return &httpAdminAPIInfo{
+		Version:        VersionString,
+		Revision:       RevisionString,
+		Generated:      GeneratedString,
+		BootTime:       i.bootTime,
+		UptimeSeconds:  time.Since(i.bootTime).Seconds(),
+		PoolSize:       i.ProcessorPool.Size(),
+		TotalProcessed: i.ProcessorPool.TotalProcessed(),
+		IntakePaused:   i.ProcessorPool.IntakePaused(),
+		Config: httpAdminAPIConfigSummary{
+			ProviderName:        cfg.ProviderName,
+			QueueType:           cfg.QueueType,
+			QueueName:           cfg.QueueName,
+			Infra:               cfg.Infra,
+			TravisSite:          cfg.TravisSite,
+			Hostname:            cfg.Hostname,
+			PoolSize:            cfg.PoolSize,
+			HardTimeout:         cfg.HardTimeout.String(),
+			LogTimeout:          cfg.LogTimeout.String(),
+			StartupTimeout:      cfg.StartupTimeout.String(),
+			ScriptUploadTimeout: cfg.ScriptUploadTimeout.String(),
+			MaxLogLength:        cfg.MaxLogLength,
+		},
+		Processors: i.adminAPIProcessors(),
+	}
+}
+
+func (i *CLI) adminAPIProcessors() []httpAdminAPIProcessor {
+	processors := []httpAdminAPIProcessor{}
+	i.ProcessorPool.Each(func(n int, proc *Processor) {
+		processors = append(processors, httpAdminAPIProcessor{
+			N:          n,
+			ID:         proc.ID,
+			Processed:  proc.ProcessedCount,
+			Status:     proc.CurrentStatus,
+			LastJobID:  proc.LastJobID,
+			CurrentJob: proc.CurrentJob(),
+		})
+	})
+	return processors
+}
+
+func (i *CLI) adminAPIResizePool(w http.ResponseWriter, req *http.Request) {
+	body := &httpAdminAPIPoolSize{Size: -1}
+	err := json.NewDecoder(req.Body).Decode(body)
+	if err != nil {
+		i.writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	if body.Size < 1 {
+		i.writeJSONError(w, http.StatusBadRequest, "size must be given and at least 1")
+		return
+	}
+
+	i.logger.WithField("size", body.Size).Info("web api pool resize received")
+	prev, err := i.ProcessorPool.SetSize(body.Size)
+	if err != nil {
+		i.writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	i.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"previous_size": prev,
+		"size":          body.Size,
+	})
+}
+
+func (i *CLI) adminAPIReloadConfig(w http.ResponseWriter) {
+	i.logger.Info("web api config reload received")
+
+	reload, err := i.ReloadConfig()
+	if err != nil {
+		i.writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	i.writeJSON(w, http.StatusOK, reload)
+}
+
+func (i *CLI) adminAPICancelJob(w http.ResponseWriter, id string) {
+	jobID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		i.writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid job id %q", id))
+		return
+	}
+
+	if _, ok := i.ProcessorPool.FindJob(jobID); !ok {
+		i.writeJSONError(w, http.StatusNotFound, fmt.Sprintf("job %d is not running on this worker", jobID))
+		return
+	}
+
+	i.logger.WithField("job_id", jobID).Info("web api job cancellation received")
+	i.CancellationBroadcaster.Broadcast(jobID)
+
+	i.writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"job_id":    jobID,
+		"cancelled": true,
+	})
+}
+
+func (i *CLI) writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		i.logger.WithField("err", err).Error("couldn't write json response")
+	}
+}
+
+func (i *CLI) writeJSONError(w http.ResponseWriter, status int, message string) {
+	i.writeJSON(w, status, map[string]string{"error": message})
+}
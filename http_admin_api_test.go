@@ -0,0 +1,166 @@
+package worker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gocontext "context"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/travis-ci/worker/config"
+	"github.com/travis-ci/worker/context"
+	"github.com/travis-ci/worker/image"
+)
+
+func buildTestAdminAPICLI() (*CLI, gocontext.CancelFunc) {
+	i := NewCLI(nil)
+
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	i.ctx = ctx
+	i.cancel = cancel
+	i.logger = context.LoggerFromContext(ctx).WithField("self", "http_admin_api_test")
+	i.Config = &config.Config{
+		ProviderName: "fake",
+		QueueType:    "file",
+		PoolSize:     1,
+		HardTimeout:  time.Hour,
+	}
+
+	i.ProcessorPool = NewProcessorPool(&ProcessorPoolConfig{
+		Context: ctx,
+		Config:  i.Config,
+	}, nil, nil, nil, i.CancellationBroadcaster)
+
+	i.ProcessorPool.processors = []*Processor{
+		{
+			ID:            "proc-1",
+			ctx:           ctx,
+			graceful:      make(chan struct{}),
+			CurrentStatus: "processing",
+			LastJobID:     4,
+			currentJob: &ProcessorJobStatus{
+				ID:         4,
+				Repository: "green-eggs/ham",
+				Stage:      "run_script",
+				InstanceID: "i-abc",
+				ImageName:  "travis-ci-garnet",
+			},
+		},
+	}
+	i.ProcessorPool.size = 1
+
+	return i, cancel
+}
+
+func doAdminAPIRequest(i *CLI, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	i.httpAdminAPI(w, req)
+	return w
+}
+
+func TestCLI_httpAdminAPI_Info(t *testing.T) {
+	i, cancel := buildTestAdminAPICLI()
+	defer cancel()
+
+	w := doAdminAPIRequest(i, "GET", "/worker/api/v1/info", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	info := &httpAdminAPIInfo{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(info))
+	assert.Equal(t, 1, info.PoolSize)
+	assert.Equal(t, "fake", info.Config.ProviderName)
+	assert.Equal(t, "1h0m0s", info.Config.HardTimeout)
+	assert.Len(t, info.Processors, 1)
+	assert.Equal(t, "proc-1", info.Processors[0].ID)
+	assert.Equal(t, "run_script", info.Processors[0].CurrentJob.Stage)
+	assert.Equal(t, "i-abc", info.Processors[0].CurrentJob.InstanceID)
+	assert.Equal(t, "travis-ci-garnet", info.Processors[0].CurrentJob.ImageName)
+}
+
+func TestCLI_httpAdminAPI_CancelJob(t *testing.T) {
+	i, cancel := buildTestAdminAPICLI()
+	defer cancel()
+
+	ch := i.CancellationBroadcaster.Subscribe(4)
+
+	w := doAdminAPIRequest(i, "POST", "/worker/api/v1/jobs/5/cancel", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = doAdminAPIRequest(i, "POST", "/worker/api/v1/jobs/4/cancel", "")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("expected job to be cancelled")
+	}
+}
+
+func TestCLI_httpAdminAPI_Intake(t *testing.T) {
+	i, cancel := buildTestAdminAPICLI()
+	defer cancel()
+
+	w := doAdminAPIRequest(i, "POST", "/worker/api/v1/intake/pause", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, i.ProcessorPool.IntakePaused())
+	assert.NotNil(t, i.ProcessorPool.processors[0].intakePausedChan())
+
+	w = doAdminAPIRequest(i, "POST", "/worker/api/v1/intake/resume", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, i.ProcessorPool.IntakePaused())
+	assert.Nil(t, i.ProcessorPool.processors[0].intakePausedChan())
+}
+
+func TestCLI_httpAdminAPI_ResizePool(t *testing.T) {
+	i, cancel := buildTestAdminAPICLI()
+	defer cancel()
+
+	w := doAdminAPIRequest(i, "PUT", "/worker/api/v1/pool", `{"size": -3}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = doAdminAPIRequest(i, "PUT", "/worker/api/v1/pool", `{`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = doAdminAPIRequest(i, "PUT", "/worker/api/v1/pool", `{"size": 0}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, 1, i.ProcessorPool.Size())
+
+	w = doAdminAPIRequest(i, "PUT", "/worker/api/v1/pool", `{"size": 1}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"previous_size": 1, "size": 1}`, w.Body.String())
+}
+
+func TestCLI_httpAdminAPI_ImageCache(t *testing.T) {
+	i, cancel := buildTestAdminAPICLI()
+	defer cancel()
+
+	w := doAdminAPIRequest(i, "GET", "/worker/api/v1/image-cache", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"enabled": false, "entries": []}`, w.Body.String())
+
+	image.DefaultAPICache = image.NewAPICache(time.Minute, 0, true)
+	defer func() { image.DefaultAPICache = nil }()
+
+	w = doAdminAPIRequest(i, "GET", "/worker/api/v1/image-cache", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"enabled": true, "entries": []}`, w.Body.String())
+
+	w = doAdminAPIRequest(i, "DELETE", "/worker/api/v1/image-cache", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"flushed": 0}`, w.Body.String())
+}
+
+func TestCLI_httpAdminAPI_NotFound(t *testing.T) {
+	i, cancel := buildTestAdminAPICLI()
+	defer cancel()
+
+	w := doAdminAPIRequest(i, "DELETE", "/worker/api/v1/info", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"error"`)
+}
@@ -160,7 +160,7 @@ func (j *httpJob) Generate(ctx gocontext.Context, job Job) ([]byte, error) {
 	return script, nil
 }
 
-func (j *httpJob) createStateUpdateBody(curState, newState string) map[string]interface{} {
+func (j *httpJob) createStateUpdateBody(ctx gocontext.Context, curState, newState string) map[string]interface{} {
 	body := map[string]interface{}{
 		"id":    j.Payload().Job.ID,
 		"state": newState,
@@ -188,12 +188,14 @@ func (j *httpJob) createStateUpdateBody(curState, newState string) map[string]in
 		body["trace"] = true
 	}
 
+	addJobFailureMeta(ctx, body)
+
 	return body
 }
 
 func (j *httpJob) sendStateUpdate(ctx gocontext.Context, curState, newState string) error {
 	j.stateCount++
-	payload := j.createStateUpdateBody(curState, newState)
+	payload := j.createStateUpdateBody(ctx, curState, newState)
 
 	encodedPayload, err := json.Marshal(payload)
 	if err != nil {
@@ -220,6 +222,7 @@ func (j *httpJob) sendStateUpdate(ctx gocontext.Context, curState, newState stri
 
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", j.payload.JWT))
 	req.Header.Set("Content-Type", "application/json")
+	setTraceParentHeader(req, ctx)
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
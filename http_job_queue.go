@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/bitly/go-simplejson"
@@ -27,6 +28,7 @@ var (
 
 // HTTPJobQueue is a JobQueue that uses http
 type HTTPJobQueue struct {
+	jobBoardURLMutex     sync.Mutex
 	jobBoardURL          *url.URL
 	site                 string
 	providerName         string
@@ -35,6 +37,9 @@ type HTTPJobQueue struct {
 	refreshClaimInterval time.Duration
 	cb                   *CancellationBroadcaster
 
+	lastPollMutex sync.Mutex
+	lastPollErr   error
+
 	DefaultLanguage, DefaultDist, DefaultGroup, DefaultOS string
 }
 
@@ -84,6 +89,22 @@ func NewHTTPJobQueueWithIntervals(jobBoardURL *url.URL, site, providerName, queu
 	}, nil
 }
 
+// SetJobBoardURL replaces the job board URL, such as when its credentials
+// have been refreshed. Requests made after the call use the new URL.
+func (q *HTTPJobQueue) SetJobBoardURL(jobBoardURL *url.URL) {
+	q.jobBoardURLMutex.Lock()
+	defer q.jobBoardURLMutex.Unlock()
+
+	q.jobBoardURL = jobBoardURL
+}
+
+func (q *HTTPJobQueue) currentJobBoardURL() url.URL {
+	q.jobBoardURLMutex.Lock()
+	defer q.jobBoardURLMutex.Unlock()
+
+	return *q.jobBoardURL
+}
+
 // Jobs consumes new jobs from job-board
 func (q *HTTPJobQueue) Jobs(ctx gocontext.Context) (outChan <-chan Job, err error) {
 	buildJobChan := make(chan Job)
@@ -180,7 +201,7 @@ func (q *HTTPJobQueue) fetchJobID(ctx gocontext.Context) (time.Duration, uint64,
 		processorID = "unknown-processor"
 	}
 
-	u := *q.jobBoardURL
+	u := q.currentJobBoardURL()
 
 	query := u.Query()
 	query.Add("queue", q.queue)
@@ -202,11 +223,19 @@ func (q *HTTPJobQueue) fetchJobID(ctx gocontext.Context) (time.Duration, uint64,
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return q.pollInterval, 0, errors.Wrap(err, "failed to make job-board job pop request")
+		err = errors.Wrap(err, "failed to make job-board job pop request")
+		q.setLastPollErr(err)
+		return q.pollInterval, 0, err
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= http.StatusInternalServerError {
+		q.setLastPollErr(errors.Errorf("job-board job pop request failed with status %d", resp.StatusCode))
+	} else {
+		q.setLastPollErr(nil)
+	}
+
 	pollInterval := q.pollInterval
 	if v, err := strconv.ParseUint(resp.Header.Get("Travis-Pop-Interval"), 10, 64); err == nil {
 		pollInterval = time.Duration(v) * time.Second
@@ -231,6 +260,20 @@ func (q *HTTPJobQueue) fetchJobID(ctx gocontext.Context) (time.Duration, uint64,
 	return pollInterval, fetchedJobID, nil
 }
 
+func (q *HTTPJobQueue) setLastPollErr(err error) {
+	q.lastPollMutex.Lock()
+	defer q.lastPollMutex.Unlock()
+	q.lastPollErr = err
+}
+
+// checkHealth returns the error of the most recent attempt to pop a job from
+// job-board if it couldn't be reached, and nil otherwise.
+func (q *HTTPJobQueue) checkHealth() error {
+	q.lastPollMutex.Lock()
+	defer q.lastPollMutex.Unlock()
+	return q.lastPollErr
+}
+
 func (q *HTTPJobQueue) deleteJob(ctx gocontext.Context, jobID uint64) error {
 	logger := context.LoggerFromContext(ctx).WithFields(logrus.Fields{
 		"self": "http_job_queue",
@@ -248,7 +291,7 @@ func (q *HTTPJobQueue) deleteJob(ctx gocontext.Context, jobID uint64) error {
 		processorID = "unknown-processor"
 	}
 
-	u := *q.jobBoardURL
+	u := q.currentJobBoardURL()
 	u.Path = fmt.Sprintf("/jobs/%d", jobID)
 	u.User = nil
 
@@ -326,7 +369,7 @@ func (q *HTTPJobQueue) refreshJobClaim(ctx gocontext.Context, jobID uint64) (tim
 		processorID = "unknown-processor"
 	}
 
-	u := *q.jobBoardURL
+	u := q.currentJobBoardURL()
 	u.User = nil
 
 	query := u.Query()
@@ -402,7 +445,7 @@ func (q *HTTPJobQueue) fetchJob(ctx gocontext.Context, jobID uint64) (Job, <-cha
 		},
 	}
 
-	u := *q.jobBoardURL
+	u := q.currentJobBoardURL()
 	u.Path = fmt.Sprintf("/jobs/%d", jobID)
 
 	req, err := http.NewRequest("GET", u.String(), nil)
@@ -484,6 +527,7 @@ func (q *HTTPJobQueue) fetchJob(ctx gocontext.Context, jobID uint64) (Job, <-cha
 	buildJob.startAttributes = startAttrs.Data.Config
 	buildJob.startAttributes.VMConfig = buildJob.payload.Data.VMConfig
 	buildJob.startAttributes.VMType = buildJob.payload.Data.VMType
+	buildJob.startAttributes.Queue = buildJob.payload.Data.Queue
 	buildJob.startAttributes.SetDefaults(q.DefaultLanguage, q.DefaultDist, q.DefaultGroup, q.DefaultOS, VMTypeDefault, VMConfigDefault)
 
 	return buildJob, readyChan, nil
@@ -11,7 +11,9 @@ import (
 	gocontext "context"
 
 	"github.com/bitly/go-simplejson"
+	"github.com/stretchr/testify/assert"
 	"github.com/travis-ci/worker/backend"
+	"go.opencensus.io/trace"
 )
 
 func newTestHTTPJob(t *testing.T) *httpJob {
@@ -145,6 +147,28 @@ func TestHTTPJob_Received(t *testing.T) {
 	}
 }
 
+func TestHTTPJob_ReceivedSendsTraceParent(t *testing.T) {
+	traceParents := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceParents <- r.Header.Get("traceparent")
+		fmt.Fprintln(w, "hai")
+	}))
+	defer ts.Close()
+	job := newTestHTTPJob(t)
+	job.payload.JobStateURL = ts.URL
+
+	ctx, span := trace.StartSpan(gocontext.TODO(), "test", trace.WithSampler(trace.AlwaysSample()))
+	defer span.End()
+
+	err := job.Received(ctx)
+	if err != nil {
+		t.Error(err)
+	}
+
+	sc := span.SpanContext()
+	assert.Equal(t, fmt.Sprintf("00-%s-%s-01", sc.TraceID, sc.SpanID), <-traceParents)
+}
+
 func TestHTTPJob_Started(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, "hai")
@@ -19,6 +19,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/travis-ci/worker/context"
+	"go.opencensus.io/trace"
 )
 
 var (
@@ -104,6 +105,11 @@ func (lps *httpLogPartSink) Add(ctx gocontext.Context, part *httpLogPart) error
 		lps.flushChan <- struct{}{}
 	}
 
+	if span := trace.FromContext(ctx); span != nil && part.spanContext == nil {
+		sc := span.SpanContext()
+		part.spanContext = &sc
+	}
+
 	lps.partsBufferMutex.Lock()
 	lps.partsBuffer = append(lps.partsBuffer, part)
 	lps.partsBufferMutex.Unlock()
@@ -113,6 +119,15 @@ func (lps *httpLogPartSink) Add(ctx gocontext.Context, part *httpLogPart) error
 	return nil
 }
 
+// backpressured returns true if the buffer is full, in which case any log
+// parts added are rejected until it has been flushed.
+func (lps *httpLogPartSink) backpressured() bool {
+	lps.partsBufferMutex.Lock()
+	defer lps.partsBufferMutex.Unlock()
+
+	return uint64(len(lps.partsBuffer)) >= lps.maxBufferSize
+}
+
 func (lps *httpLogPartSink) flushRegularly(ctx gocontext.Context) {
 	logger := context.LoggerFromContext(ctx).WithField("self", "http_log_part_sink")
 	ticker := time.NewTicker(LogWriterTick)
@@ -150,9 +165,24 @@ func (lps *httpLogPartSink) flush(ctx gocontext.Context) error {
 
 	lps.partsBufferMutex.Unlock()
 
+	// the parts of many jobs are sent in one request, so the request gets a
+	// span of its own, linked to the spans of those jobs
+	ctx, span := trace.StartSpan(ctx, "LogPartSink.Publish")
+	defer span.End()
+
 	payload := []*httpLogPartEncodedPayload{}
+	linked := map[trace.SpanID]bool{}
 
 	for _, part := range bufferSample {
+		if part.spanContext != nil && !linked[part.spanContext.SpanID] {
+			linked[part.spanContext.SpanID] = true
+			span.AddLink(trace.Link{
+				TraceID: part.spanContext.TraceID,
+				SpanID:  part.spanContext.SpanID,
+				Type:    trace.LinkTypeParent,
+			})
+		}
+
 		logger.WithFields(logrus.Fields{
 			"job_id": part.JobID,
 			"number": part.Number,
@@ -221,6 +251,7 @@ func (lps *httpLogPartSink) publishLogParts(ctx gocontext.Context, payload []*ht
 
 		req.Header.Set("Authorization", fmt.Sprintf("token sig:%s", lps.generatePayloadSignature(payload)))
 		req.Header.Set("Content-Type", "application/json")
+		setTraceParentHeader(req, ctx)
 		req = req.WithContext(ctx)
 
 		logger.WithField("req", req).Debug("attempting to publish log parts")
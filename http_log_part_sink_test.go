@@ -8,6 +8,7 @@ import (
 	gocontext "context"
 
 	"github.com/stretchr/testify/assert"
+	"go.opencensus.io/trace"
 )
 
 func TestNewHTTPLogPartSink(t *testing.T) {
@@ -55,3 +56,35 @@ func TestHTTPLogPartSink_flush(t *testing.T) {
 	assert.Len(t, lps.partsBuffer, 0)
 	lps.partsBufferMutex.Unlock()
 }
+
+func TestHTTPLogPartSink_flushSendsTraceParent(t *testing.T) {
+	traceParents := make(chan string, 1)
+	lss := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceParents <- r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer lss.Close()
+
+	ctx, cancel := gocontext.WithCancel(gocontext.TODO())
+	defer cancel()
+
+	jobCtx, span := trace.StartSpan(ctx, "test", trace.WithSampler(trace.AlwaysSample()))
+	defer span.End()
+
+	lps := newHTTPLogPartSink(ctx, lss.URL, uint64(10))
+	assert.Nil(t, lps.Add(jobCtx, &httpLogPart{JobID: uint64(4), Content: "wat", Number: 3}))
+
+	lps.partsBufferMutex.Lock()
+	part := lps.partsBuffer[0]
+	lps.partsBufferMutex.Unlock()
+	if assert.NotNil(t, part.spanContext) {
+		assert.Equal(t, span.SpanContext().SpanID, part.spanContext.SpanID)
+	}
+
+	assert.Nil(t, lps.flush(ctx))
+
+	// the request is sent in a span of its own, linked to the job's span
+	traceParent := <-traceParents
+	assert.Regexp(t, `^00-[0-9a-f]{32}-[0-9a-f]{16}-0[01]$`, traceParent)
+	assert.NotContains(t, traceParent, span.SpanContext().SpanID.String())
+}
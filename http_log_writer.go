@@ -8,6 +8,7 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/travis-ci/worker/context"
+	"go.opencensus.io/trace"
 )
 
 type httpLogPart struct {
@@ -16,11 +17,15 @@ type httpLogPart struct {
 	JobID   uint64
 	Number  uint64
 	Token   string
+
+	// spanContext is the span of the job that wrote the part, if any.
+	spanContext *trace.SpanContext
 }
 
 type httpLogWriter struct {
+	logWriterLimits
+
 	ctx       gocontext.Context
-	cancel    gocontext.CancelFunc
 	jobID     uint64
 	authToken string
 
@@ -28,24 +33,17 @@ type httpLogWriter struct {
 
 	logPartNumber uint64
 
-	bytesWritten     int
-	maxLength        int
-	maxLengthReached bool
-
 	lps *httpLogPartSink
-
-	timer   *time.Timer
-	timeout time.Duration
 }
 
 func newHTTPLogWriter(ctx gocontext.Context, url string, authToken string, jobID uint64, timeout time.Duration) (*httpLogWriter, error) {
 	writer := &httpLogWriter{
+		logWriterLimits: newLogWriterLimits(timeout),
+
 		ctx:       context.FromComponent(ctx, "log_writer"),
 		jobID:     jobID,
 		authToken: authToken,
 		closeChan: make(chan struct{}),
-		timer:     time.NewTimer(time.Hour),
-		timeout:   timeout,
 		lps:       getHTTPLogPartSinkByURL(url),
 	}
 
@@ -67,17 +65,7 @@ func (w *httpLogWriter) Write(p []byte) (int, error) {
 		"bytes":  string(p),
 	}).Debug("begin writing bytes")
 
-	w.timer.Reset(w.timeout)
-
-	w.bytesWritten += len(p)
-	if w.bytesWritten > w.maxLength {
-		logger.Info("wrote past maximum log length - cancelling context")
-		w.maxLengthReached = true
-		if w.cancel == nil {
-			logger.Error("cancel function does not exist")
-		} else {
-			w.cancel()
-		}
+	if !w.accept(logger, p) {
 		return 0, nil
 	}
 
@@ -104,7 +92,7 @@ func (w *httpLogWriter) Close() error {
 		return nil
 	}
 
-	w.timer.Stop()
+	w.stopTimer()
 
 	close(w.closeChan)
 
@@ -127,35 +115,19 @@ func (w *httpLogWriter) Close() error {
 	return nil
 }
 
-func (w *httpLogWriter) Timeout() <-chan time.Time {
-	return w.timer.C
-}
-
-func (w *httpLogWriter) SetMaxLogLength(bytes int) {
-	w.maxLength = bytes
-}
-
 func (w *httpLogWriter) SetJobStarted(meta *JobStartedMeta) {}
 
-func (w *httpLogWriter) SetCancelFunc(cancel gocontext.CancelFunc) {
-	w.cancel = cancel
-}
-
-func (w *httpLogWriter) MaxLengthReached() bool {
-	return w.maxLengthReached == true
-}
-
 func (w *httpLogWriter) WriteAndClose(p []byte) (int, error) {
 	if w.closed() {
 		return 0, fmt.Errorf("log already closed")
 	}
 
-	w.timer.Stop()
+	w.stopTimer()
 
 	close(w.closeChan)
 
 	err := w.lps.Add(w.ctx, &httpLogPart{
-		Content: string(p),
+		Content: string(w.closeOpenFolds(p)),
 		JobID:   w.jobID,
 		Number:  w.logPartNumber,
 		Token:   w.authToken,
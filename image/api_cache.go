@@ -0,0 +1,183 @@
+package image
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/travis-ci/worker/metrics"
+)
+
+// DefaultAPICache is the cache used by APISelectors created with
+// NewAPISelector. Caching is disabled while it is nil.
+var DefaultAPICache *APICache
+
+// APICache caches the image names selected by APISelectors, keyed by the
+// image API and the candidate tag sets that were queried.
+//
+// Answers younger than the TTL are served from the cache. Answers that are
+// older than the TTL but younger than TTL plus StaleWhileRevalidate are served
+// from the cache while a fresh answer is fetched in the background. If
+// StaleOnError is set, the last known good answer is served, regardless of
+// its age, when querying the image API fails.
+type APICache struct {
+	TTL                  time.Duration
+	StaleWhileRevalidate time.Duration
+	StaleOnError         bool
+
+	mutex        sync.Mutex
+	entries      map[string]*apiCacheEntry
+	revalidating map[string]bool
+}
+
+type apiCacheEntry struct {
+	imageName string
+	fetchedAt time.Time
+}
+
+// APICacheEntry describes a cached answer, for debugging.
+type APICacheEntry struct {
+	Key        string    `json:"key"`
+	ImageName  string    `json:"image"`
+	FetchedAt  time.Time `json:"fetched_at"`
+	AgeSeconds float64   `json:"age_s"`
+	Fresh      bool      `json:"fresh"`
+}
+
+// NewAPICache creates an empty APICache.
+func NewAPICache(ttl, staleWhileRevalidate time.Duration, staleOnError bool) *APICache {
+	return &APICache{
+		TTL:                  ttl,
+		StaleWhileRevalidate: staleWhileRevalidate,
+		StaleOnError:         staleOnError,
+
+		entries:      map[string]*apiCacheEntry{},
+		revalidating: map[string]bool{},
+	}
+}
+
+// Entries returns the cached answers sorted by key.
+func (c *APICache) Entries() []*APICacheEntry {
+	if c == nil {
+		return []*APICacheEntry{}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entries := []*APICacheEntry{}
+	for key, entry := range c.entries {
+		age := time.Since(entry.fetchedAt)
+		entries = append(entries, &APICacheEntry{
+			Key:        key,
+			ImageName:  entry.imageName,
+			FetchedAt:  entry.fetchedAt,
+			AgeSeconds: age.Seconds(),
+			Fresh:      age < c.TTL,
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+
+	return entries
+}
+
+// Flush removes all cached answers and returns how many there were.
+func (c *APICache) Flush() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	n := len(c.entries)
+	c.entries = map[string]*apiCacheEntry{}
+
+	return n
+}
+
+// fetch returns the cached answer for the key if there is a usable one, and
+// calls query otherwise.
+func (c *APICache) fetch(key string, query func() (string, error)) (string, error) {
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	c.mutex.Unlock()
+
+	if ok {
+		age := time.Since(entry.fetchedAt)
+
+		if age < c.TTL {
+			metrics.Mark("travis.worker.image.api_cache.hit")
+			return entry.imageName, nil
+		}
+
+		if age < c.TTL+c.StaleWhileRevalidate {
+			metrics.Mark("travis.worker.image.api_cache.stale")
+			c.revalidate(key, query)
+			return entry.imageName, nil
+		}
+	}
+
+	metrics.Mark("travis.worker.image.api_cache.miss")
+
+	imageName, err := query()
+	if err != nil {
+		if ok && c.StaleOnError {
+			metrics.Mark("travis.worker.image.api_cache.stale_on_error")
+			return entry.imageName, nil
+		}
+		return "", err
+	}
+
+	c.store(key, imageName)
+	return imageName, nil
+}
+
+func (c *APICache) revalidate(key string, query func() (string, error)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.revalidating[key] {
+		return
+	}
+	c.revalidating[key] = true
+
+	go func() {
+		imageName, err := query()
+
+		c.mutex.Lock()
+		delete(c.revalidating, key)
+		c.mutex.Unlock()
+
+		if err != nil {
+			metrics.Mark("travis.worker.image.api_cache.revalidate_error")
+			return
+		}
+
+		c.store(key, imageName)
+	}()
+}
+
+func (c *APICache) store(key, imageName string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries[key] = &apiCacheEntry{imageName: imageName, fetchedAt: time.Now()}
+}
+
+// apiCacheKey identifies a query by the image API it is sent to, the infra,
+// and the candidate tag sets. The job ID and repository sent along with the
+// tag sets are not part of the key.
+func apiCacheKey(endpoint, infra string, tagSets []*tagSet) string {
+	parts := []string{}
+	for _, ts := range tagSets {
+		parts = append(parts, fmt.Sprintf("%s:%v", strings.Join(ts.Tags, ","), ts.IsDefault))
+	}
+
+	return fmt.Sprintf("%s infra=%s tags=%s", endpoint, infra, strings.Join(parts, ";"))
+}
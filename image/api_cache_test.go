@@ -0,0 +1,170 @@
+package image
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testImageAPI struct {
+	mutex    sync.Mutex
+	requests int
+	failing  bool
+	name     string
+}
+
+func (api *testImageAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.requests++
+	if api.failing {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	fmt.Fprintf(w, `{"data": [{"id": 1, "infra": "test", "name": %q}]}`, api.name)
+}
+
+func (api *testImageAPI) set(name string, failing bool) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.name = name
+	api.failing = failing
+}
+
+func (api *testImageAPI) requestCount() int {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return api.requests
+}
+
+func newTestCachingAPISelector(t *testing.T, cache *APICache) (*APISelector, *testImageAPI, func()) {
+	api := &testImageAPI{name: "travis-ci-first"}
+	ts := httptest.NewServer(api)
+
+	u, _ := url.Parse(ts.URL)
+	u.User = url.UserPassword("foo", "bar")
+
+	as := NewAPISelector(u)
+	as.SetMaxInterval(time.Millisecond)
+	as.SetMaxElapsedTime(10 * time.Millisecond)
+	as.SetCache(cache)
+
+	return as, api, ts.Close
+}
+
+func TestAPICache_Hit(t *testing.T) {
+	cache := NewAPICache(time.Hour, 0, false)
+	as, api, cleanup := newTestCachingAPISelector(t, cache)
+	defer cleanup()
+
+	params := &Params{Infra: "test", Language: "ruby", JobID: 4, Repo: "corp/frob"}
+	otherJob := &Params{Infra: "test", Language: "ruby", JobID: 5, Repo: "corp/other"}
+
+	for _, p := range []*Params{params, otherJob} {
+		actual, err := as.Select(p)
+		assert.Nil(t, err)
+		assert.Equal(t, "travis-ci-first", actual)
+	}
+	assert.Equal(t, 1, api.requestCount())
+
+	actual, err := as.Select(&Params{Infra: "test", Language: "go"})
+	assert.Nil(t, err)
+	assert.Equal(t, "travis-ci-first", actual)
+	assert.Equal(t, 2, api.requestCount())
+
+	entries := cache.Entries()
+	assert.Len(t, entries, 2)
+	assert.True(t, entries[0].Fresh)
+	assert.NotContains(t, entries[0].Key, "foo:bar")
+
+	assert.Equal(t, 2, cache.Flush())
+	assert.Empty(t, cache.Entries())
+
+	_, err = as.Select(params)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, api.requestCount())
+}
+
+func TestAPICache_StaleWhileRevalidate(t *testing.T) {
+	cache := NewAPICache(time.Nanosecond, time.Hour, false)
+	as, api, cleanup := newTestCachingAPISelector(t, cache)
+	defer cleanup()
+
+	params := &Params{Infra: "test", Language: "ruby"}
+
+	actual, err := as.Select(params)
+	assert.Nil(t, err)
+	assert.Equal(t, "travis-ci-first", actual)
+
+	api.set("travis-ci-second", false)
+
+	actual, err = as.Select(params)
+	assert.Nil(t, err)
+	assert.Equal(t, "travis-ci-first", actual)
+
+	for i := 0; i < 100 && api.requestCount() < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 100 && cache.Entries()[0].ImageName != "travis-ci-second"; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	actual, err = as.Select(params)
+	assert.Nil(t, err)
+	assert.Equal(t, "travis-ci-second", actual)
+}
+
+func TestAPICache_StaleOnError(t *testing.T) {
+	cache := NewAPICache(0, 0, true)
+	as, api, cleanup := newTestCachingAPISelector(t, cache)
+	defer cleanup()
+
+	params := &Params{Infra: "test", Language: "ruby"}
+
+	actual, err := as.Select(params)
+	assert.Nil(t, err)
+	assert.Equal(t, "travis-ci-first", actual)
+
+	api.set("travis-ci-second", true)
+
+	actual, err = as.Select(params)
+	assert.Nil(t, err)
+	assert.Equal(t, "travis-ci-first", actual)
+
+	actual, err = as.Select(&Params{Infra: "test", Language: "go"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "default", actual)
+}
+
+func TestAPICache_NoStaleOnError(t *testing.T) {
+	cache := NewAPICache(0, 0, false)
+	as, api, cleanup := newTestCachingAPISelector(t, cache)
+	defer cleanup()
+
+	params := &Params{Infra: "test", Language: "ruby"}
+
+	_, err := as.Select(params)
+	assert.Nil(t, err)
+
+	api.set("travis-ci-second", true)
+
+	actual, err := as.Select(params)
+	assert.NotNil(t, err)
+	assert.Equal(t, "default", actual)
+}
+
+func TestAPICache_Nil(t *testing.T) {
+	var cache *APICache
+	assert.Empty(t, cache.Entries())
+	assert.Equal(t, 0, cache.Flush())
+}
@@ -21,6 +21,7 @@ const (
 
 type APISelector struct {
 	baseURL *url.URL
+	cache   *APICache
 
 	maxInterval    time.Duration
 	maxElapsedTime time.Duration
@@ -29,12 +30,19 @@ type APISelector struct {
 func NewAPISelector(u *url.URL) *APISelector {
 	return &APISelector{
 		baseURL: u,
+		cache:   DefaultAPICache,
 
 		maxInterval:    10 * time.Second,
 		maxElapsedTime: time.Minute,
 	}
 }
 
+// SetCache sets the cache used for answers from the image API, or disables
+// caching if cache is nil.
+func (as *APISelector) SetCache(cache *APICache) {
+	as.cache = cache
+}
+
 func (as *APISelector) SetMaxInterval(maxInterval time.Duration) {
 	as.maxInterval = maxInterval
 }
@@ -49,7 +57,16 @@ func (as *APISelector) Select(params *Params) (string, error) {
 		return "default", err
 	}
 
-	imageName, err := as.queryWithTags(params.Infra, tagSets)
+	query := func() (string, error) {
+		return as.queryWithTags(params.Infra, tagSets)
+	}
+
+	var imageName string
+	if as.cache != nil {
+		imageName, err = as.cache.fetch(apiCacheKey(as.cacheEndpoint(), params.Infra, tagSets), query)
+	} else {
+		imageName, err = query()
+	}
 	if err != nil {
 		return "default", err
 	}
@@ -61,37 +78,91 @@ func (as *APISelector) Select(params *Params) (string, error) {
 	return "default", nil
 }
 
+// Explain queries the image API for each candidate tag set in turn, bypassing
+// the cache, and reports the first one answered with an image.
+func (as *APISelector) Explain(params *Params) *Explanation {
+	explanation := &Explanation{Selector: "api", Image: "default"}
+
+	tagSets, err := as.buildCandidateTags(params)
+	if err != nil {
+		explanation.Error = err.Error()
+		return explanation
+	}
+
+	lastJobID := uint64(0)
+	lastRepo := ""
+	for _, ts := range tagSets {
+		candidate := &Candidate{Name: describeTagSet(ts)}
+		explanation.Candidates = append(explanation.Candidates, candidate)
+		lastJobID = ts.JobID
+		lastRepo = ts.Repo
+
+		imageName, err := as.queryOne(params.Infra, ts)
+		if err != nil {
+			explanation.Error = err.Error()
+			return explanation
+		}
+
+		candidate.Image = imageName
+		if imageName != "" && explanation.Image == "default" {
+			candidate.Matched = true
+			explanation.Image = imageName
+		}
+	}
+
+	if explanation.Image == "default" {
+		imageName, err := as.queryOne(params.Infra, &tagSet{IsDefault: true, JobID: lastJobID, Repo: lastRepo})
+		if err != nil {
+			explanation.Error = err.Error()
+			return explanation
+		}
+
+		explanation.Candidates = append(explanation.Candidates, &Candidate{
+			Name:    "infra default",
+			Image:   imageName,
+			Matched: imageName != "",
+		})
+		if imageName != "" {
+			explanation.Image = imageName
+		}
+	}
+
+	return explanation
+}
+
+// queryOne asks the image API about a single tag set.
+func (as *APISelector) queryOne(infra string, ts *tagSet) (string, error) {
+	imageResp, err := as.makeImageRequest(as.baseURL.String(), []string{tagSetQuery(infra, ts)})
+	if err != nil {
+		return "", err
+	}
+
+	if len(imageResp.Data) == 0 {
+		return "", nil
+	}
+
+	return imageResp.Data[0].Name, nil
+}
+
+// cacheEndpoint is the image API URL without credentials.
+func (as *APISelector) cacheEndpoint() string {
+	u := *as.baseURL
+	u.User = nil
+	return u.String()
+}
+
 func (as *APISelector) queryWithTags(infra string, tags []*tagSet) (string, error) {
 	bodyLines := []string{}
 	lastJobID := uint64(0)
 	lastRepo := ""
 
 	for _, ts := range tags {
-		qs := url.Values{}
-		qs.Set("infra", infra)
-		qs.Set("fields[images]", "name")
-		qs.Set("limit", "1")
-		qs.Set("job_id", fmt.Sprintf("%v", ts.JobID))
-		qs.Set("repo", ts.Repo)
-		qs.Set("is_default", fmt.Sprintf("%v", ts.IsDefault))
-		if len(ts.Tags) > 0 {
-			qs.Set("tags", strings.Join(ts.Tags, ","))
-		}
-
-		bodyLines = append(bodyLines, qs.Encode())
+		bodyLines = append(bodyLines, tagSetQuery(infra, ts))
 		lastJobID = ts.JobID
 		lastRepo = ts.Repo
 	}
 
-	qs := url.Values{}
-	qs.Set("infra", infra)
-	qs.Set("is_default", "true")
-	qs.Set("fields[images]", "name")
-	qs.Set("limit", "1")
-	qs.Set("job_id", fmt.Sprintf("%v", lastJobID))
-	qs.Set("repo", lastRepo)
-
-	bodyLines = append(bodyLines, qs.Encode())
+	bodyLines = append(bodyLines, tagSetQuery(infra, &tagSet{IsDefault: true, JobID: lastJobID, Repo: lastRepo}))
 
 	u, err := url.Parse(as.baseURL.String())
 	if err != nil {
@@ -110,6 +181,23 @@ func (as *APISelector) queryWithTags(infra string, tags []*tagSet) (string, erro
 	return imageResp.Data[0].Name, nil
 }
 
+// tagSetQuery builds the request body line asking for an image matching the
+// tag set.
+func tagSetQuery(infra string, ts *tagSet) string {
+	qs := url.Values{}
+	qs.Set("infra", infra)
+	qs.Set("fields[images]", "name")
+	qs.Set("limit", "1")
+	qs.Set("job_id", fmt.Sprintf("%v", ts.JobID))
+	qs.Set("repo", ts.Repo)
+	qs.Set("is_default", fmt.Sprintf("%v", ts.IsDefault))
+	if len(ts.Tags) > 0 {
+		qs.Set("tags", strings.Join(ts.Tags, ","))
+	}
+
+	return qs.Encode()
+}
+
 func (as *APISelector) makeImageRequest(urlString string, bodyLines []string) (*apiSelectorImageResponse, error) {
 	var responseBody []byte
 
@@ -0,0 +1,222 @@
+package image
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/travis-ci/worker/config"
+	"github.com/travis-ci/worker/metrics"
+)
+
+// ChainSelector implements Selector by trying a list of selectors in order,
+// using the first image that isn't "default". A deterministic percentage of
+// the jobs matching IMAGE_SELECTOR_CANARY_MATCH may be sent to a canary image
+// instead, keyed by repository or job ID, so that a given repository stays on
+// the same side of a rollout.
+//
+// The arm that chose the image ("canary", the selector name, or "default") is
+// marked as a travis.worker.image.chain.arm.{arm} metric and recorded in
+// Params.Selection, if set.
+type ChainSelector struct {
+	arms []*chainArm
+
+	canaryImage   string
+	canaryPercent int
+	canaryHash    string
+	canaryMatch   *fileSelectorRule
+}
+
+type chainArm struct {
+	name     string
+	selector Selector
+}
+
+// Selection records how an image was selected.
+type Selection struct {
+	Arm   string
+	Image string
+}
+
+// NewChainSelector builds a new ChainSelector from the given
+// *config.ProviderConfig. The selector types listed in IMAGE_SELECTOR_CHAIN
+// are built with the given function, which is usually the backend's own
+// selector builder.
+func NewChainSelector(cfg *config.ProviderConfig, build func(string) (Selector, error)) (*ChainSelector, error) {
+	cs := &ChainSelector{canaryHash: "repo"}
+
+	if !cfg.IsSet("IMAGE_SELECTOR_CHAIN") {
+		return nil, errors.New("missing IMAGE_SELECTOR_CHAIN")
+	}
+
+	for _, selectorType := range strings.Split(cfg.Get("IMAGE_SELECTOR_CHAIN"), ",") {
+		selectorType = strings.TrimSpace(selectorType)
+		if selectorType == "" {
+			continue
+		}
+		if selectorType == "chain" {
+			return nil, errors.New("image selector chains can't be nested")
+		}
+
+		selector, err := build(selectorType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't build %q image selector for chain", selectorType)
+		}
+
+		cs.arms = append(cs.arms, &chainArm{name: selectorType, selector: selector})
+	}
+
+	if cfg.IsSet("IMAGE_SELECTOR_CANARY_PERCENT") {
+		percent, err := strconv.Atoi(cfg.Get("IMAGE_SELECTOR_CANARY_PERCENT"))
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("invalid canary percentage %q", cfg.Get("IMAGE_SELECTOR_CANARY_PERCENT"))
+		}
+		cs.canaryPercent = percent
+	}
+
+	cs.canaryImage = cfg.Get("IMAGE_SELECTOR_CANARY_IMAGE")
+	if cs.canaryPercent > 0 && cs.canaryImage == "" {
+		return nil, errors.New("missing IMAGE_SELECTOR_CANARY_IMAGE")
+	}
+
+	if cfg.IsSet("IMAGE_SELECTOR_CANARY_MATCH") {
+		match, err := parseCanaryMatch(cfg.Get("IMAGE_SELECTOR_CANARY_MATCH"))
+		if err != nil {
+			return nil, err
+		}
+		cs.canaryMatch = match
+	}
+	if cs.canaryPercent > 0 && cs.canaryMatch == nil {
+		return nil, errors.New("missing IMAGE_SELECTOR_CANARY_MATCH")
+	}
+
+	if cfg.IsSet("IMAGE_SELECTOR_CANARY_HASH") {
+		cs.canaryHash = cfg.Get("IMAGE_SELECTOR_CANARY_HASH")
+		if cs.canaryHash != "repo" && cs.canaryHash != "job_id" {
+			return nil, fmt.Errorf("invalid canary hash key %q", cs.canaryHash)
+		}
+	}
+
+	return cs, nil
+}
+
+// parseCanaryMatch parses the comma-delimited field=pattern pairs that scope
+// the canary, using the fields and patterns of FileSelector rules.
+func parseCanaryMatch(s string) (*fileSelectorRule, error) {
+	match := &fileSelectorRule{Match: map[string]string{}}
+
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("IMAGE_SELECTOR_CANARY_MATCH has no pattern in %q", pair)
+		}
+		match.Match[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	if len(match.Match) == 0 {
+		return nil, errors.New("IMAGE_SELECTOR_CANARY_MATCH has no fields")
+	}
+
+	err := match.compile()
+	if err != nil {
+		return nil, fmt.Errorf("IMAGE_SELECTOR_CANARY_MATCH %v", err)
+	}
+
+	return match, nil
+}
+
+func (cs *ChainSelector) Select(params *Params) (string, error) {
+	arm, imageName := cs.selectArm(params)
+
+	metrics.Mark("travis.worker.image.chain.arm." + arm)
+	if params.Selection != nil {
+		params.Selection.Arm = arm
+		params.Selection.Image = imageName
+	}
+
+	return imageName, nil
+}
+
+// Explain explains each selector tried in turn and the canary decision.
+func (cs *ChainSelector) Explain(params *Params) *Explanation {
+	explanation := &Explanation{Selector: "chain"}
+
+	if cs.canaryPercent > 0 {
+		if !cs.canaryMatch.matches(params) {
+			explanation.Notef("job doesn't match the canary (%s)", cs.canaryMatch.describe())
+		} else if cs.isCanary(params) {
+			explanation.Notef("%s hashes into the %d%% canary", cs.canaryHash, cs.canaryPercent)
+			explanation.Notef("arm: canary")
+			explanation.Image = cs.canaryImage
+			return explanation
+		} else {
+			explanation.Notef("%s doesn't hash into the %d%% canary", cs.canaryHash, cs.canaryPercent)
+		}
+	}
+
+	for _, arm := range cs.arms {
+		armExplanation := Explain(arm.selector, params)
+		explanation.Arms = append(explanation.Arms, armExplanation)
+
+		if armExplanation.Error == "" && armExplanation.Image != "" && armExplanation.Image != "default" {
+			explanation.Notef("arm: %s", arm.name)
+			explanation.Image = armExplanation.Image
+			return explanation
+		}
+	}
+
+	explanation.Notef("arm: default")
+	explanation.Image = "default"
+	return explanation
+}
+
+func (cs *ChainSelector) selectArm(params *Params) (string, string) {
+	if cs.isCanary(params) {
+		return "canary", cs.canaryImage
+	}
+
+	for _, arm := range cs.arms {
+		imageName, err := arm.selector.Select(params)
+		if err != nil {
+			metrics.Mark("travis.worker.image.chain.error." + arm.name)
+			continue
+		}
+
+		if imageName != "" && imageName != "default" {
+			return arm.name, imageName
+		}
+	}
+
+	return "default", "default"
+}
+
+// isCanary returns true if the job matches the canary and falls into the
+// canary percentage. Jobs without a value for the hash key never do.
+func (cs *ChainSelector) isCanary(params *Params) bool {
+	if cs.canaryPercent == 0 || !cs.canaryMatch.matches(params) {
+		return false
+	}
+
+	key := params.Repo
+	if cs.canaryHash == "job_id" {
+		if params.JobID == 0 {
+			return false
+		}
+		key = strconv.FormatUint(params.JobID, 10)
+	}
+	if key == "" {
+		return false
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	return int(h.Sum32()%100) < cs.canaryPercent
+}
@@ -0,0 +1,142 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/travis-ci/worker/config"
+)
+
+type testStaticSelector struct {
+	imageName string
+	err       error
+}
+
+func (s *testStaticSelector) Select(params *Params) (string, error) {
+	return s.imageName, s.err
+}
+
+func newTestChainSelector(t *testing.T, cfg map[string]string, selectors map[string]Selector) (*ChainSelector, error) {
+	return NewChainSelector(config.ProviderConfigFromMap(cfg), func(selectorType string) (Selector, error) {
+		if s, ok := selectors[selectorType]; ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("invalid image selector type %q", selectorType)
+	})
+}
+
+func TestChainSelector_Select(t *testing.T) {
+	for _, tc := range []struct {
+		Selectors map[string]Selector
+		Arm       string
+		Image     string
+	}{
+		{
+			Selectors: map[string]Selector{
+				"api": &testStaticSelector{imageName: "travis-ci-api"},
+				"env": &testStaticSelector{imageName: "travis-ci-env"},
+			},
+			Arm:   "api",
+			Image: "travis-ci-api",
+		},
+		{
+			Selectors: map[string]Selector{
+				"api": &testStaticSelector{imageName: "default", err: errors.New("no")},
+				"env": &testStaticSelector{imageName: "travis-ci-env"},
+			},
+			Arm:   "env",
+			Image: "travis-ci-env",
+		},
+		{
+			Selectors: map[string]Selector{
+				"api": &testStaticSelector{imageName: "default"},
+				"env": &testStaticSelector{imageName: "default"},
+			},
+			Arm:   "default",
+			Image: "default",
+		},
+	} {
+		cs, err := newTestChainSelector(t, map[string]string{"IMAGE_SELECTOR_CHAIN": "api, env"}, tc.Selectors)
+		assert.Nil(t, err)
+
+		selection := &Selection{}
+		actual, err := cs.Select(&Params{Repo: "travis-ci/worker", Selection: selection})
+		assert.Nil(t, err)
+		assert.Equal(t, tc.Image, actual)
+		assert.Equal(t, &Selection{Arm: tc.Arm, Image: tc.Image}, selection)
+	}
+}
+
+func TestChainSelector_Canary(t *testing.T) {
+	selectors := map[string]Selector{"env": &testStaticSelector{imageName: "travis-ci-stable"}}
+
+	for _, hash := range []string{"repo", "job_id"} {
+		cs, err := newTestChainSelector(t, map[string]string{
+			"IMAGE_SELECTOR_CHAIN":          "env",
+			"IMAGE_SELECTOR_CANARY_IMAGE":   "travis-ci-canary",
+			"IMAGE_SELECTOR_CANARY_PERCENT": "20",
+			"IMAGE_SELECTOR_CANARY_HASH":    hash,
+			"IMAGE_SELECTOR_CANARY_MATCH":   "dist=xenial",
+		}, selectors)
+		assert.Nil(t, err)
+
+		canaries := 0
+		for i := uint64(1); i <= 1000; i++ {
+			params := &Params{JobID: i, Repo: fmt.Sprintf("travis-ci/repo-%d", i), Dist: "xenial"}
+
+			first, _ := cs.Select(params)
+			second, _ := cs.Select(params)
+			assert.Equal(t, first, second)
+
+			if first == "travis-ci-canary" {
+				canaries++
+			} else {
+				assert.Equal(t, "travis-ci-stable", first)
+			}
+		}
+
+		assert.InDelta(t, 200, canaries, 50, hash)
+	}
+
+	cs, err := newTestChainSelector(t, map[string]string{
+		"IMAGE_SELECTOR_CHAIN":          "env",
+		"IMAGE_SELECTOR_CANARY_IMAGE":   "travis-ci-canary",
+		"IMAGE_SELECTOR_CANARY_PERCENT": "100",
+		"IMAGE_SELECTOR_CANARY_MATCH":   "os=linux, dist=/^(xenial|bionic)$/",
+	}, selectors)
+	assert.Nil(t, err)
+
+	actual, _ := cs.Select(&Params{Repo: "travis-ci/worker", OS: "linux", Dist: "bionic"})
+	assert.Equal(t, "travis-ci-canary", actual)
+
+	actual, _ = cs.Select(&Params{OS: "linux", Dist: "bionic"})
+	assert.Equal(t, "travis-ci-stable", actual)
+
+	actual, _ = cs.Select(&Params{Repo: "travis-ci/worker", OS: "linux", Dist: "trusty"})
+	assert.Equal(t, "travis-ci-stable", actual)
+
+	actual, _ = cs.Select(&Params{Repo: "travis-ci/worker", OS: "osx", Dist: "xenial"})
+	assert.Equal(t, "travis-ci-stable", actual)
+}
+
+func TestNewChainSelector_Invalid(t *testing.T) {
+	selectors := map[string]Selector{"env": &testStaticSelector{imageName: "travis-ci-stable"}}
+
+	for _, cfg := range []map[string]string{
+		{},
+		{"IMAGE_SELECTOR_CHAIN": "env,chain"},
+		{"IMAGE_SELECTOR_CHAIN": "env,bogus"},
+		{"IMAGE_SELECTOR_CHAIN": "env", "IMAGE_SELECTOR_CANARY_PERCENT": "101", "IMAGE_SELECTOR_CANARY_IMAGE": "x", "IMAGE_SELECTOR_CANARY_MATCH": "dist=xenial"},
+		{"IMAGE_SELECTOR_CHAIN": "env", "IMAGE_SELECTOR_CANARY_PERCENT": "10", "IMAGE_SELECTOR_CANARY_MATCH": "dist=xenial"},
+		{"IMAGE_SELECTOR_CHAIN": "env", "IMAGE_SELECTOR_CANARY_PERCENT": "10", "IMAGE_SELECTOR_CANARY_IMAGE": "x"},
+		{"IMAGE_SELECTOR_CHAIN": "env", "IMAGE_SELECTOR_CANARY_MATCH": "flavor=spicy"},
+		{"IMAGE_SELECTOR_CHAIN": "env", "IMAGE_SELECTOR_CANARY_MATCH": "dist"},
+		{"IMAGE_SELECTOR_CHAIN": "env", "IMAGE_SELECTOR_CANARY_MATCH": "repo=["},
+		{"IMAGE_SELECTOR_CHAIN": "env", "IMAGE_SELECTOR_CANARY_HASH": "group"},
+	} {
+		_, err := newTestChainSelector(t, cfg, selectors)
+		assert.NotNil(t, err, "%v", cfg)
+	}
+}
@@ -53,6 +53,40 @@ func (es *EnvSelector) Select(params *Params) (string, error) {
 	return imageName, nil
 }
 
+// Explain lists the candidate keys in the order they are tried.
+func (es *EnvSelector) Explain(params *Params) *Explanation {
+	explanation := &Explanation{Selector: "env", Image: "default"}
+
+	matched := false
+	for _, key := range es.buildCandidateKeys(params) {
+		if key == "" {
+			continue
+		}
+
+		candidate := &Candidate{Name: "IMAGE_" + strings.ToUpper(key)}
+		if s, ok := es.lookup[key]; ok {
+			candidate.Image = s
+			if !matched {
+				candidate.Matched = true
+				explanation.Image = s
+				matched = true
+			}
+		}
+		explanation.Candidates = append(explanation.Candidates, candidate)
+	}
+
+	if !matched {
+		explanation.Notef("no candidate key is set")
+	}
+
+	if selected, ok := es.lookup[explanation.Image]; ok {
+		explanation.Notef("%s is an alias for %s", explanation.Image, selected)
+		explanation.Image = selected
+	}
+
+	return explanation
+}
+
 func (es *EnvSelector) buildCandidateKeys(params *Params) []string {
 	fullKey := []string{}
 	candidateKeys := []string{}
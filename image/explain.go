@@ -0,0 +1,99 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Explanation describes how a selector chose, or would choose, an image for
+// a job.
+type Explanation struct {
+	Selector   string         `json:"selector"`
+	Candidates []*Candidate   `json:"candidates,omitempty"`
+	Arms       []*Explanation `json:"arms,omitempty"`
+	Notes      []string       `json:"notes,omitempty"`
+	Image      string         `json:"image"`
+	Error      string         `json:"error,omitempty"`
+}
+
+// Candidate is an env key, API tag set, or rule that a selector considered.
+type Candidate struct {
+	Name    string `json:"name"`
+	Image   string `json:"image,omitempty"`
+	Matched bool   `json:"matched"`
+}
+
+// Explainer is implemented by selectors that can explain their choice.
+// Explaining bypasses any caching, but may still query the image API.
+type Explainer interface {
+	Explain(*Params) *Explanation
+}
+
+// Explain returns the selector's explanation for the given params if it is
+// an Explainer, or just the image it selects otherwise.
+func Explain(selector Selector, params *Params) *Explanation {
+	if explainer, ok := selector.(Explainer); ok {
+		return explainer.Explain(params)
+	}
+
+	imageName, err := selector.Select(params)
+	explanation := &Explanation{
+		Selector: fmt.Sprintf("%T", selector),
+		Image:    imageName,
+	}
+	if err != nil {
+		explanation.Error = err.Error()
+	}
+
+	return explanation
+}
+
+// Notef adds a note to the explanation.
+func (e *Explanation) Notef(format string, args ...interface{}) {
+	e.Notes = append(e.Notes, fmt.Sprintf(format, args...))
+}
+
+// Print writes the explanation in a human-readable form.
+func (e *Explanation) Print(w io.Writer) {
+	e.print(w, "")
+}
+
+func (e *Explanation) print(w io.Writer, indent string) {
+	fmt.Fprintf(w, "%sselector: %s\n", indent, e.Selector)
+
+	if len(e.Candidates) > 0 {
+		fmt.Fprintf(w, "%scandidates:\n", indent)
+	}
+	for _, candidate := range e.Candidates {
+		marker := " "
+		if candidate.Matched {
+			marker = "*"
+		}
+
+		line := fmt.Sprintf("%s  %s %s", indent, marker, candidate.Name)
+		if candidate.Image != "" {
+			line += " => " + candidate.Image
+		}
+		fmt.Fprintln(w, line)
+	}
+
+	for _, arm := range e.Arms {
+		fmt.Fprintf(w, "%sarm:\n", indent)
+		arm.print(w, indent+"  ")
+	}
+
+	for _, note := range e.Notes {
+		fmt.Fprintf(w, "%snote: %s\n", indent, note)
+	}
+
+	if e.Error != "" {
+		fmt.Fprintf(w, "%serror: %s\n", indent, e.Error)
+	}
+
+	fmt.Fprintf(w, "%simage: %s\n", indent, e.Image)
+}
+
+func describeTagSet(ts *tagSet) string {
+	return fmt.Sprintf("tags=%s is_default=%v", strings.Join(ts.Tags, ","), ts.IsDefault)
+}
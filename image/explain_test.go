@@ -0,0 +1,141 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/travis-ci/worker/config"
+)
+
+func TestEnvSelector_Explain(t *testing.T) {
+	es, err := NewEnvSelector(config.ProviderConfigFromMap(map[string]string{
+		"IMAGE_DIST_XENIAL_RUBY": "xenial",
+		"IMAGE_RUBY":             "travis-ci-ruby",
+		"IMAGE_XENIAL":           "travis-ci-xenial-1530230255",
+	}))
+	assert.Nil(t, err)
+
+	explanation := Explain(es, &Params{Language: "ruby", Dist: "xenial"})
+	assert.Equal(t, "env", explanation.Selector)
+	assert.Equal(t, "travis-ci-xenial-1530230255", explanation.Image)
+	assert.Equal(t, []string{"xenial is an alias for travis-ci-xenial-1530230255"}, explanation.Notes)
+
+	matched := []*Candidate{}
+	for _, candidate := range explanation.Candidates {
+		if candidate.Image != "" {
+			matched = append(matched, candidate)
+		}
+	}
+	assert.Equal(t, []*Candidate{
+		{Name: "IMAGE_DIST_XENIAL_RUBY", Image: "xenial", Matched: true},
+		{Name: "IMAGE_XENIAL", Image: "travis-ci-xenial-1530230255"},
+		{Name: "IMAGE_RUBY", Image: "travis-ci-ruby"},
+		{Name: "IMAGE_RUBY", Image: "travis-ci-ruby"},
+	}, matched)
+
+	explanation = Explain(es, &Params{Language: "go"})
+	assert.Equal(t, "default", explanation.Image)
+	assert.Equal(t, []string{"no candidate key is set"}, explanation.Notes)
+}
+
+func TestAPISelector_Explain(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		body, _ := ioutil.ReadAll(req.Body)
+		if strings.HasSuffix(strings.TrimSpace(string(body)), "tags=language_ruby%3Atrue") {
+			fmt.Fprintf(w, testAPIServerString)
+			return
+		}
+		fmt.Fprintf(w, testAPIServerEmptyResponseString)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	as := NewAPISelector(u)
+	as.SetCache(NewAPICache(time.Hour, 0, false))
+
+	explanation := Explain(as, &Params{Infra: "test", Language: "ruby", OS: "linux"})
+	assert.Equal(t, "api", explanation.Selector)
+	assert.Equal(t, "travis-ci-awesome", explanation.Image)
+	assert.Equal(t, "", explanation.Error)
+	assert.Equal(t, len(explanation.Candidates), requests)
+
+	matched := 0
+	for _, candidate := range explanation.Candidates {
+		if candidate.Matched {
+			matched++
+			assert.Equal(t, "tags=language_ruby:true is_default=true", candidate.Name)
+		}
+	}
+	assert.Equal(t, 1, matched)
+
+	explanation = Explain(as, &Params{Infra: "test", Language: "go", OS: "linux"})
+	assert.Equal(t, "default", explanation.Image)
+	assert.Equal(t, "infra default", explanation.Candidates[len(explanation.Candidates)-1].Name)
+}
+
+func TestFileSelector_Explain(t *testing.T) {
+	path, cleanup := writeTestFileSelectorRules(t, testFileSelectorRules)
+	defer cleanup()
+
+	fs, err := NewFileSelector(path)
+	assert.Nil(t, err)
+
+	explanation := Explain(fs, &Params{Language: "python", Queue: "builds.gce"})
+	assert.Equal(t, "travis-ci-scripting-1530230255", explanation.Image)
+	assert.Len(t, explanation.Candidates, 4)
+	assert.Equal(t, &Candidate{Name: "rule 1 (dist=xenial repo=travis-ci/*)", Image: "xenial"}, explanation.Candidates[0])
+	assert.True(t, explanation.Candidates[1].Matched)
+
+	explanation = Explain(fs, &Params{Language: "go"})
+	assert.Equal(t, "travis-ci-garnet-1410230255", explanation.Image)
+	assert.Equal(t, []string{"no rule matches"}, explanation.Notes)
+}
+
+func TestChainSelector_Explain(t *testing.T) {
+	cs, err := newTestChainSelector(t, map[string]string{
+		"IMAGE_SELECTOR_CHAIN":          "api,env",
+		"IMAGE_SELECTOR_CANARY_IMAGE":   "travis-ci-canary",
+		"IMAGE_SELECTOR_CANARY_PERCENT": "1",
+		"IMAGE_SELECTOR_CANARY_HASH":    "job_id",
+		"IMAGE_SELECTOR_CANARY_MATCH":   "dist=xenial",
+	}, map[string]Selector{
+		"api": &testStaticSelector{imageName: "default"},
+		"env": &testStaticSelector{imageName: "travis-ci-env"},
+	})
+	assert.Nil(t, err)
+
+	params := &Params{Dist: "xenial"}
+	explanation := Explain(cs, params)
+	assert.Equal(t, "travis-ci-env", explanation.Image)
+	assert.Len(t, explanation.Arms, 2)
+	assert.Equal(t, "default", explanation.Arms[0].Image)
+	assert.Equal(t, []string{"job_id doesn't hash into the 1% canary", "arm: env"}, explanation.Notes)
+
+	out := &bytes.Buffer{}
+	explanation.Print(out)
+	assert.Equal(t, `selector: chain
+arm:
+  selector: *image.testStaticSelector
+  image: default
+arm:
+  selector: *image.testStaticSelector
+  image: travis-ci-env
+note: job_id doesn't hash into the 1% canary
+note: arm: env
+image: travis-ci-env
+`, out.String())
+
+	explanation = Explain(cs, &Params{Dist: "trusty", JobID: 4})
+	assert.Equal(t, "travis-ci-env", explanation.Image)
+	assert.Equal(t, []string{"job doesn't match the canary (dist=xenial)", "arm: env"}, explanation.Notes)
+}
@@ -0,0 +1,293 @@
+package image
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/travis-ci/worker/metrics"
+	"gopkg.in/yaml.v2"
+)
+
+// FileSelector implements Selector using ordered rules loaded from a YAML
+// file. The first rule matching the job wins. The file is reloaded when it
+// changes.
+//
+// An example rules file:
+//
+//   aliases:
+//     xenial: travis-ci-xenial-1530230255
+//   rules:
+//     - match:
+//         repo: travis-ci/*
+//         dist: xenial
+//       image: xenial
+//     - match:
+//         language: /^(ruby|python)$/
+//         queue: builds.gce
+//       image: travis-ci-scripting-1530230255
+//   default: travis-ci-garnet-1410230255
+//
+// Patterns are globs, or regular expressions if enclosed in slashes. Rules
+// may match on infra, language, osx_image, dist, group, os, repo, job_id, and
+// queue. Images may be given as names or as aliases.
+type FileSelector struct {
+	path           string
+	reloadInterval time.Duration
+
+	mutex     sync.Mutex
+	rules     *fileSelectorRules
+	modTime   time.Time
+	size      int64
+	checkedAt time.Time
+}
+
+type fileSelectorRules struct {
+	Aliases map[string]string   `yaml:"aliases"`
+	Rules   []*fileSelectorRule `yaml:"rules"`
+	Default string              `yaml:"default"`
+}
+
+type fileSelectorRule struct {
+	Match map[string]string `yaml:"match"`
+	Image string            `yaml:"image"`
+
+	matchers map[string]func(string) bool
+}
+
+var fileSelectorFields = map[string]func(*Params) string{
+	"infra":     func(p *Params) string { return p.Infra },
+	"language":  func(p *Params) string { return p.Language },
+	"osx_image": func(p *Params) string { return p.OsxImage },
+	"dist":      func(p *Params) string { return p.Dist },
+	"group":     func(p *Params) string { return p.Group },
+	"os":        func(p *Params) string { return p.OS },
+	"repo":      func(p *Params) string { return p.Repo },
+	"job_id":    func(p *Params) string { return strconv.FormatUint(p.JobID, 10) },
+	"queue":     func(p *Params) string { return p.Queue },
+}
+
+// NewFileSelector builds a new FileSelector from the rules file at the given
+// path, returning an error if the file is invalid.
+func NewFileSelector(path string) (*FileSelector, error) {
+	fs := &FileSelector{
+		path:           path,
+		reloadInterval: 5 * time.Second,
+	}
+
+	err := fs.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// SetReloadInterval sets how often the rules file is checked for changes.
+func (fs *FileSelector) SetReloadInterval(reloadInterval time.Duration) {
+	fs.reloadInterval = reloadInterval
+}
+
+func (fs *FileSelector) Select(params *Params) (string, error) {
+	rules := fs.currentRules()
+
+	for _, rule := range rules.Rules {
+		if rule.matches(params) {
+			return rules.resolve(rule.Image), nil
+		}
+	}
+
+	if rules.Default != "" {
+		return rules.resolve(rules.Default), nil
+	}
+
+	return "default", nil
+}
+
+// Explain lists the rules in order, marking the first one that matches.
+func (fs *FileSelector) Explain(params *Params) *Explanation {
+	rules := fs.currentRules()
+	explanation := &Explanation{Selector: "file " + fs.path}
+
+	for n, rule := range rules.Rules {
+		candidate := &Candidate{
+			Name:  fmt.Sprintf("rule %d (%s)", n+1, rule.describe()),
+			Image: rule.Image,
+		}
+		if explanation.Image == "" && rule.matches(params) {
+			candidate.Matched = true
+			explanation.Image = rules.resolve(rule.Image)
+		}
+		explanation.Candidates = append(explanation.Candidates, candidate)
+	}
+
+	if explanation.Image == "" {
+		explanation.Notef("no rule matches")
+		explanation.Image = "default"
+		if rules.Default != "" {
+			explanation.Image = rules.resolve(rules.Default)
+		}
+	}
+
+	return explanation
+}
+
+// currentRules returns the loaded rules, reloading the file first if it has
+// changed. If the changed file is invalid, the previous rules are kept.
+func (fs *FileSelector) currentRules() *fileSelectorRules {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if time.Since(fs.checkedAt) < fs.reloadInterval {
+		return fs.rules
+	}
+	fs.checkedAt = time.Now()
+
+	info, err := os.Stat(fs.path)
+	if err != nil || (info.ModTime().Equal(fs.modTime) && info.Size() == fs.size) {
+		return fs.rules
+	}
+
+	err = fs.loadLocked()
+	if err != nil {
+		metrics.Mark("travis.worker.image.file_selector.reload_error")
+		return fs.rules
+	}
+
+	metrics.Mark("travis.worker.image.file_selector.reloaded")
+	return fs.rules
+}
+
+func (fs *FileSelector) load() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	fs.checkedAt = time.Now()
+	return fs.loadLocked()
+}
+
+func (fs *FileSelector) loadLocked() error {
+	info, err := os.Stat(fs.path)
+	if err != nil {
+		return errors.Wrap(err, "couldn't read image selector rules file")
+	}
+
+	content, err := ioutil.ReadFile(fs.path)
+	if err != nil {
+		return errors.Wrap(err, "couldn't read image selector rules file")
+	}
+
+	rules, err := parseFileSelectorRules(content)
+	if err != nil {
+		return errors.Wrapf(err, "invalid image selector rules file %s", fs.path)
+	}
+
+	fs.rules = rules
+	fs.modTime = info.ModTime()
+	fs.size = info.Size()
+
+	return nil
+}
+
+func parseFileSelectorRules(content []byte) (*fileSelectorRules, error) {
+	rules := &fileSelectorRules{}
+
+	err := yaml.UnmarshalStrict(content, rules)
+	if err != nil {
+		return nil, err
+	}
+
+	for alias, imageName := range rules.Aliases {
+		if imageName == "" {
+			return nil, fmt.Errorf("alias %q has no image", alias)
+		}
+	}
+
+	for n, rule := range rules.Rules {
+		if rule.Image == "" {
+			return nil, fmt.Errorf("rule %d has no image", n+1)
+		}
+
+		err := rule.compile()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d %v", n+1, err)
+		}
+	}
+
+	return rules, nil
+}
+
+// compile builds the matchers for the patterns in Match. Errors are worded
+// to follow the name of the rule.
+func (r *fileSelectorRule) compile() error {
+	r.matchers = map[string]func(string) bool{}
+	for field, pattern := range r.Match {
+		if _, ok := fileSelectorFields[field]; !ok {
+			return fmt.Errorf("matches on unknown field %q", field)
+		}
+
+		matcher, err := fileSelectorMatcher(pattern)
+		if err != nil {
+			return errors.Wrapf(err, "has an invalid pattern for %s", field)
+		}
+		r.matchers[field] = matcher
+	}
+
+	return nil
+}
+
+func fileSelectorMatcher(pattern string) (func(string) bool, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+
+	_, err := path.Match(pattern, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return func(value string) bool {
+		matched, _ := path.Match(pattern, value)
+		return matched
+	}, nil
+}
+
+func (r *fileSelectorRule) matches(params *Params) bool {
+	for field, matcher := range r.matchers {
+		if !matcher(fileSelectorFields[field](params)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *fileSelectorRule) describe() string {
+	fields := []string{}
+	for field, pattern := range r.Match {
+		fields = append(fields, field+"="+pattern)
+	}
+	sort.Strings(fields)
+
+	return strings.Join(fields, " ")
+}
+
+func (r *fileSelectorRules) resolve(imageName string) string {
+	if aliased, ok := r.Aliases[imageName]; ok {
+		return aliased
+	}
+
+	return imageName
+}
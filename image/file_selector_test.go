@@ -0,0 +1,134 @@
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testFileSelectorRules = `
+aliases:
+  xenial: travis-ci-xenial-1530230255
+  scripting: travis-ci-scripting-1530230255
+rules:
+  - match:
+      repo: travis-ci/*
+      dist: xenial
+    image: xenial
+  - match:
+      language: /^(ruby|python)$/
+      queue: builds.gce
+    image: scripting
+  - match:
+      job_id: "4*"
+    image: travis-ci-forty
+  - match:
+      os: osx
+      osx_image: xcode*
+    image: travis-ci-mac
+default: travis-ci-garnet-1410230255
+`
+
+func writeTestFileSelectorRules(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "travis-worker-image-rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "rules.yml")
+	err = ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestFileSelector_Select(t *testing.T) {
+	path, cleanup := writeTestFileSelectorRules(t, testFileSelectorRules)
+	defer cleanup()
+
+	fs, err := NewFileSelector(path)
+	assert.Nil(t, err)
+
+	for _, tc := range []struct {
+		P *Params
+		E string
+	}{
+		{P: &Params{Repo: "travis-ci/worker", Dist: "xenial"}, E: "travis-ci-xenial-1530230255"},
+		{P: &Params{Repo: "travis-ci/worker", Dist: "trusty"}, E: "travis-ci-garnet-1410230255"},
+		{P: &Params{Repo: "other/worker", Dist: "xenial"}, E: "travis-ci-garnet-1410230255"},
+		{P: &Params{Language: "python", Queue: "builds.gce"}, E: "travis-ci-scripting-1530230255"},
+		{P: &Params{Language: "python3", Queue: "builds.gce"}, E: "travis-ci-garnet-1410230255"},
+		{P: &Params{Language: "ruby", Queue: "builds.ec2"}, E: "travis-ci-garnet-1410230255"},
+		{P: &Params{JobID: 42}, E: "travis-ci-forty"},
+		{P: &Params{OS: "osx", OsxImage: "xcode9.4"}, E: "travis-ci-mac"},
+	} {
+		actual, err := fs.Select(tc.P)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.E, actual, "%#v", tc.P)
+	}
+}
+
+func TestFileSelector_SelectWithoutDefault(t *testing.T) {
+	path, cleanup := writeTestFileSelectorRules(t, "rules: []\n")
+	defer cleanup()
+
+	fs, err := NewFileSelector(path)
+	assert.Nil(t, err)
+
+	actual, err := fs.Select(&Params{Language: "ruby"})
+	assert.Nil(t, err)
+	assert.Equal(t, "default", actual)
+}
+
+func TestFileSelector_Invalid(t *testing.T) {
+	for content, message := range map[string]string{
+		"rules:\n  - match: {flavor: spicy}\n    image: x\n": `rule 1 matches on unknown field "flavor"`,
+		"rules:\n  - match: {repo: \"/[/\"}\n    image: x\n": "rule 1 has an invalid pattern for repo",
+		"rules:\n  - match: {repo: \"[\"}\n    image: x\n":   "rule 1 has an invalid pattern for repo",
+		"rules:\n  - match: {repo: x}\n":                     "rule 1 has no image",
+		"aliases:\n  x: \"\"\n":                              `alias "x" has no image`,
+		"rulez: []\n":                                        "field rulez not found",
+	} {
+		path, cleanup := writeTestFileSelectorRules(t, content)
+
+		_, err := NewFileSelector(path)
+		if assert.NotNil(t, err, content) {
+			assert.Contains(t, err.Error(), message)
+		}
+
+		cleanup()
+	}
+
+	_, err := NewFileSelector("/nonexistent/travis-worker/rules.yml")
+	assert.NotNil(t, err)
+}
+
+func TestFileSelector_Reload(t *testing.T) {
+	path, cleanup := writeTestFileSelectorRules(t, "default: travis-ci-first\n")
+	defer cleanup()
+
+	fs, err := NewFileSelector(path)
+	assert.Nil(t, err)
+	fs.SetReloadInterval(0)
+
+	actual, _ := fs.Select(&Params{})
+	assert.Equal(t, "travis-ci-first", actual)
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte("default: travis-ci-second-and-longer\n"), 0644))
+
+	actual, _ = fs.Select(&Params{})
+	assert.Equal(t, "travis-ci-second-and-longer", actual)
+
+	// invalid files are ignored, keeping the previous rules
+	assert.Nil(t, ioutil.WriteFile(path, []byte("rules: [{image: \"\"}]\n"), 0644))
+	assert.Nil(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+
+	actual, _ = fs.Select(&Params{})
+	assert.Equal(t, "travis-ci-second-and-longer", actual)
+}
@@ -10,4 +10,9 @@ type Params struct {
 
 	JobID uint64
 	Repo  string
+	Queue string
+
+	// Selection, if set, is filled in by selectors that choose between
+	// several sources of images, such as ChainSelector.
+	Selection *Selection
 }
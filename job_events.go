@@ -0,0 +1,416 @@
+package worker
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	gocontext "context"
+
+	"github.com/cenk/backoff"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/travis-ci/worker/context"
+	"github.com/travis-ci/worker/metrics"
+)
+
+// Job event types, one for each job lifecycle transition.
+const (
+	JobEventInstanceStarted = "instance_started"
+	JobEventInstanceStopped = "instance_stopped"
+	JobEventScriptUploaded  = "script_uploaded"
+	JobEventCancelled       = "cancelled"
+	JobEventRequeued        = "requeued"
+	JobEventFinished        = "finished"
+
+	jobEventSinkBufferSize = 1000
+)
+
+// JobEvent is a structured record of a job lifecycle transition.
+type JobEvent struct {
+	Type           string                 `json:"type"`
+	Time           time.Time              `json:"time"`
+	JobID          uint64                 `json:"job_id"`
+	UUID           string                 `json:"uuid,omitempty"`
+	Repository     string                 `json:"repository,omitempty"`
+	Processor      string                 `json:"processor,omitempty"`
+	Hostname       string                 `json:"hostname,omitempty"`
+	Provider       string                 `json:"provider,omitempty"`
+	Site           string                 `json:"site,omitempty"`
+	InstanceID     string                 `json:"instance_id,omitempty"`
+	ImageName      string                 `json:"image_name,omitempty"`
+	BootDurationMs float64                `json:"boot_duration_ms,omitempty"`
+	State          string                 `json:"state,omitempty"`
+	Reason         string                 `json:"reason,omitempty"`
+	Error          string                 `json:"error,omitempty"`
+	Timings        map[string]interface{} `json:"timings,omitempty"`
+}
+
+// A JobEventSink delivers job events somewhere. Send is never called
+// concurrently for the same sink.
+type JobEventSink interface {
+	Name() string
+	Send(*JobEvent) error
+}
+
+// JobEventEmitter publishes job events to a set of sinks. Every sink is fed
+// from its own buffer, so that a slow sink can't hold up jobs or other sinks.
+// Events are dropped if a sink's buffer is full.
+type JobEventEmitter struct {
+	hostname string
+	provider string
+	site     string
+
+	mutex  sync.RWMutex
+	closed bool
+	queues []*jobEventQueue
+}
+
+type jobEventQueue struct {
+	sink   JobEventSink
+	events chan *JobEvent
+	done   chan struct{}
+}
+
+// NewJobEventEmitter creates a JobEventEmitter that sends events to the given
+// sinks until it is closed. The hostname, provider, and site are added to
+// every event.
+func NewJobEventEmitter(ctx gocontext.Context, hostname, provider, site string, sinks ...JobEventSink) *JobEventEmitter {
+	e := &JobEventEmitter{
+		hostname: hostname,
+		provider: provider,
+		site:     site,
+	}
+
+	for _, sink := range sinks {
+		q := &jobEventQueue{
+			sink:   sink,
+			events: make(chan *JobEvent, jobEventSinkBufferSize),
+			done:   make(chan struct{}),
+		}
+		e.queues = append(e.queues, q)
+		go q.run(ctx)
+	}
+
+	return e
+}
+
+// run sends the queued events to the sink until the queue is closed. It
+// keeps going after ctx is done, so that the events of jobs stopped by a
+// shutdown are still sent.
+func (q *jobEventQueue) run(ctx gocontext.Context) {
+	defer close(q.done)
+
+	logger := context.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"self": "job_event_emitter",
+		"sink": q.sink.Name(),
+	})
+
+	for event := range q.events {
+		err := q.sink.Send(event)
+		if err != nil {
+			metrics.Mark("travis.worker.job_events.error")
+			logger.WithFields(logrus.Fields{
+				"err":    err,
+				"type":   event.Type,
+				"job_id": event.JobID,
+			}).Error("couldn't send job event")
+		}
+	}
+}
+
+// Close stops accepting events and waits until the events already queued
+// have been sent or ctx is done, and then closes the sinks that implement
+// io.Closer. It is safe to call on a nil JobEventEmitter.
+func (e *JobEventEmitter) Close(ctx gocontext.Context) error {
+	if e == nil {
+		return nil
+	}
+
+	e.mutex.Lock()
+	if e.closed {
+		e.mutex.Unlock()
+		return nil
+	}
+	e.closed = true
+	for _, q := range e.queues {
+		close(q.events)
+	}
+	e.mutex.Unlock()
+
+	var flushErr error
+	for _, q := range e.queues {
+		select {
+		case <-q.done:
+		case <-ctx.Done():
+			flushErr = errors.Wrapf(ctx.Err(), "couldn't send all job events to %s sink", q.sink.Name())
+		}
+		if flushErr != nil {
+			break
+		}
+	}
+
+	for _, q := range e.queues {
+		if closer, ok := q.sink.(io.Closer); ok {
+			err := closer.Close()
+			if err != nil && flushErr == nil {
+				flushErr = errors.Wrapf(err, "couldn't close %s sink", q.sink.Name())
+			}
+		}
+	}
+
+	return flushErr
+}
+
+// Emit publishes an event of the given type for the job in the context,
+// filling in whatever is known about the job from the context. It is safe to
+// call on a nil JobEventEmitter, in which case it does nothing.
+func (e *JobEventEmitter) Emit(ctx gocontext.Context, event *JobEvent) {
+	if e == nil {
+		return
+	}
+
+	event.Time = time.Now().UTC()
+	event.Hostname = e.hostname
+	event.Provider = e.provider
+	event.Site = e.site
+
+	if event.JobID == 0 {
+		event.JobID, _ = context.JobIDFromContext(ctx)
+	}
+	if event.UUID == "" {
+		event.UUID, _ = context.UUIDFromContext(ctx)
+	}
+	if event.Repository == "" {
+		event.Repository, _ = context.RepositoryFromContext(ctx)
+	}
+	if event.Processor == "" {
+		event.Processor, _ = context.ProcessorFromContext(ctx)
+	}
+	if event.InstanceID == "" {
+		event.InstanceID, _ = context.InstanceIDFromContext(ctx)
+	}
+
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	if e.closed {
+		metrics.Mark("travis.worker.job_events.dropped")
+		return
+	}
+
+	for _, q := range e.queues {
+		select {
+		case q.events <- event:
+		default:
+			metrics.Mark("travis.worker.job_events.dropped")
+		}
+	}
+}
+
+// wrapJob returns a Job that emits requeued, cancelled, and finished events
+// when the job is requeued or finished. If job generates its own build script
+// or can be nacked, so can the returned Job, so that the steps checking for
+// those still find them.
+func (e *JobEventEmitter) wrapJob(job Job) Job {
+	if e == nil {
+		return job
+	}
+
+	wrapped := &eventEmittingJob{Job: job, emitter: e}
+
+	generator, isGenerator := job.(BuildScriptGenerator)
+	nacker, isNacker := job.(jobNacker)
+	switch {
+	case isGenerator && isNacker:
+		return &struct {
+			*eventEmittingJob
+			BuildScriptGenerator
+			jobNacker
+		}{wrapped, generator, nacker}
+	case isGenerator:
+		return &struct {
+			*eventEmittingJob
+			BuildScriptGenerator
+		}{wrapped, generator}
+	case isNacker:
+		return &struct {
+			*eventEmittingJob
+			jobNacker
+		}{wrapped, nacker}
+	}
+
+	return wrapped
+}
+
+type eventEmittingJob struct {
+	Job
+
+	emitter *JobEventEmitter
+}
+
+func (j *eventEmittingJob) Requeue(ctx gocontext.Context) error {
+	event := &JobEvent{
+		Type:       JobEventRequeued,
+		JobID:      j.Payload().Job.ID,
+		UUID:       j.Payload().UUID,
+		Repository: j.Payload().Repository.Slug,
+	}
+	event.addFailure(ctx)
+
+	err := j.Job.Requeue(ctx)
+	if err != nil {
+		return err
+	}
+
+	j.emitter.Emit(ctx, event)
+	return nil
+}
+
+func (j *eventEmittingJob) Finish(ctx gocontext.Context, state FinishState) error {
+	err := j.Job.Finish(ctx, state)
+
+	if state == FinishStateCancelled {
+		j.emitter.Emit(ctx, &JobEvent{
+			Type:       JobEventCancelled,
+			JobID:      j.Payload().Job.ID,
+			UUID:       j.Payload().UUID,
+			Repository: j.Payload().Repository.Slug,
+		})
+	}
+
+	event := &JobEvent{
+		Type:       JobEventFinished,
+		JobID:      j.Payload().Job.ID,
+		UUID:       j.Payload().UUID,
+		Repository: j.Payload().Repository.Slug,
+		State:      string(state),
+		Timings:    context.LoggerTimingsFromContext(ctx),
+	}
+	event.addFailure(ctx)
+	j.emitter.Emit(ctx, event)
+
+	return err
+}
+
+// addFailure sets the reason and error of the event from the job failure in
+// the context, if any.
+func (e *JobEvent) addFailure(ctx gocontext.Context) {
+	failure, ok := jobFailureFromContext(ctx)
+	if !ok {
+		return
+	}
+
+	e.Reason = string(failure.class)
+	if failure.err != nil {
+		e.Error = failure.err.Error()
+	}
+}
+
+// webhookJobEventSink POSTs every event as JSON to a URL, signed with an
+// HMAC-SHA256 of the body in the Travis-Worker-Signature header, and retries
+// with exponential backoff on errors.
+type webhookJobEventSink struct {
+	url        string
+	secret     []byte
+	httpClient *http.Client
+
+	maxElapsedTime time.Duration
+}
+
+// NewWebhookJobEventSink creates a JobEventSink that sends events to the
+// given URL, signed with the given secret.
+func NewWebhookJobEventSink(url, secret string) JobEventSink {
+	return &webhookJobEventSink{
+		url:            url,
+		secret:         []byte(secret),
+		httpClient:     &http.Client{Timeout: 10 * time.Second},
+		maxElapsedTime: time.Minute,
+	}
+}
+
+func (s *webhookJobEventSink) Name() string { return "webhook" }
+
+func (s *webhookJobEventSink) Send(event *JobEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "couldn't marshal job event")
+	}
+
+	mac := hmac.New(sha256.New, s.secret)
+	_, _ = mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	webhookBackOff := backoff.NewExponentialBackOff()
+	webhookBackOff.MaxInterval = 10 * time.Second
+	webhookBackOff.MaxElapsedTime = s.maxElapsedTime
+
+	err = backoff.Retry(func() error {
+		req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Travis-Worker-Signature", signature)
+
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("expected 2xx but got %d", resp.StatusCode)
+		}
+		return nil
+	}, webhookBackOff)
+
+	return errors.Wrap(err, "couldn't send job event webhook with retries")
+}
+
+// fileJobEventSink appends every event as a line of JSON to a file.
+type fileJobEventSink struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewFileJobEventSink creates a JobEventSink that appends events to the file
+// at the given path as newline-delimited JSON.
+func NewFileJobEventSink(path string) (JobEventSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't open job events file")
+	}
+
+	return &fileJobEventSink{file: file}, nil
+}
+
+func (s *fileJobEventSink) Name() string { return "file" }
+
+func (s *fileJobEventSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.file.Close()
+}
+
+func (s *fileJobEventSink) Send(event *JobEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "couldn't marshal job event")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,235 @@
+package worker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gocontext "context"
+
+	"github.com/mitchellh/multistep"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	workererrors "github.com/travis-ci/worker/errors"
+)
+
+type recordingJobEventSink struct {
+	events chan *JobEvent
+}
+
+func (s *recordingJobEventSink) Name() string { return "recording" }
+
+func (s *recordingJobEventSink) Send(event *JobEvent) error {
+	s.events <- event
+	return nil
+}
+
+func (s *recordingJobEventSink) next(t *testing.T) *JobEvent {
+	select {
+	case event := <-s.events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a job event")
+	}
+	return nil
+}
+
+func TestJobEventEmitter_WrapJob(t *testing.T) {
+	ctx, cancel := gocontext.WithCancel(gocontext.TODO())
+	defer cancel()
+
+	sink := &recordingJobEventSink{events: make(chan *JobEvent, 10)}
+	emitter := NewJobEventEmitter(ctx, "worker-1", "fake", "org", sink)
+
+	job := emitter.wrapJob(&fakeJob{
+		payload: &JobPayload{
+			Job:        JobJobPayload{ID: 4},
+			UUID:       "4e1f6b1c-2d3a-4b9c-a1d2-6f3e2a1b0c9d",
+			Repository: RepositoryPayload{Slug: "travis-ci/worker"},
+		},
+	})
+
+	err := job.Requeue(withJobFailure(ctx, workererrors.ClassQuotaExceeded, errors.New("no capacity")))
+	assert.Nil(t, err)
+
+	event := sink.next(t)
+	assert.Equal(t, JobEventRequeued, event.Type)
+	assert.Equal(t, uint64(4), event.JobID)
+	assert.Equal(t, "4e1f6b1c-2d3a-4b9c-a1d2-6f3e2a1b0c9d", event.UUID)
+	assert.Equal(t, "travis-ci/worker", event.Repository)
+	assert.Equal(t, "worker-1", event.Hostname)
+	assert.Equal(t, "fake", event.Provider)
+	assert.Equal(t, "org", event.Site)
+	assert.Equal(t, "quota_exceeded", event.Reason)
+	assert.Equal(t, "no capacity", event.Error)
+
+	err = job.Finish(ctx, FinishStateCancelled)
+	assert.Nil(t, err)
+
+	assert.Equal(t, JobEventCancelled, sink.next(t).Type)
+	event = sink.next(t)
+	assert.Equal(t, JobEventFinished, event.Type)
+	assert.Equal(t, "cancelled", event.State)
+}
+
+func TestJobEventEmitter_WrapJobFailedRequeue(t *testing.T) {
+	ctx, cancel := gocontext.WithCancel(gocontext.TODO())
+	defer cancel()
+
+	sink := &recordingJobEventSink{events: make(chan *JobEvent, 10)}
+	emitter := NewJobEventEmitter(ctx, "worker-1", "fake", "org", sink)
+
+	job := emitter.wrapJob(&fakeJob{payload: &JobPayload{Job: JobJobPayload{ID: 4}}})
+
+	// the fake job fails to requeue once its context is done
+	requeueCtx, cancelRequeue := gocontext.WithCancel(ctx)
+	cancelRequeue()
+	assert.NotNil(t, job.Requeue(requeueCtx))
+
+	assert.Nil(t, emitter.Close(ctx))
+	assert.Len(t, sink.events, 0)
+}
+
+func TestJobEventEmitter_WrapJobKeepsOptionalInterfaces(t *testing.T) {
+	ctx, cancel := gocontext.WithCancel(gocontext.TODO())
+	defer cancel()
+
+	emitter := NewJobEventEmitter(ctx, "worker-1", "fake", "org", &recordingJobEventSink{events: make(chan *JobEvent, 10)})
+
+	httpJob := emitter.wrapJob(newTestHTTPJob(t))
+	_, ok := httpJob.(jobNacker)
+	assert.False(t, ok)
+
+	state := &multistep.BasicStateBag{}
+	state.Put("buildJob", httpJob)
+	state.Put("ctx", ctx)
+
+	step := &stepGenerateScript{
+		generator: buildScriptGeneratorFunction(func(gocontext.Context, Job) ([]byte, error) {
+			return []byte("from the build script generator"), nil
+		}),
+	}
+	assert.Equal(t, multistep.ActionContinue, step.Run(state))
+	assert.Equal(t, "#!/usr/bin/env bash\necho wut\n", string(state.Get("script").([]byte)))
+
+	amqpJob := emitter.wrapJob(&amqpJob{})
+	_, ok = amqpJob.(jobNacker)
+	assert.True(t, ok)
+	_, ok = amqpJob.(BuildScriptGenerator)
+	assert.False(t, ok)
+}
+
+func TestJobEventEmitter_Close(t *testing.T) {
+	dir, err := ioutil.TempDir("", "travis-worker-job-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink, err := NewFileJobEventSink(filepath.Join(dir, "events.jsonl"))
+	assert.Nil(t, err)
+
+	ctx, cancel := gocontext.WithCancel(gocontext.TODO())
+	emitter := NewJobEventEmitter(ctx, "worker-1", "fake", "org", sink)
+
+	// events are still sent after the context is done, as on shutdown
+	cancel()
+	emitter.Emit(ctx, &JobEvent{Type: JobEventFinished, JobID: 4})
+	assert.Nil(t, emitter.Close(gocontext.TODO()))
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "events.jsonl"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), `"type":"finished"`)
+
+	assert.NotNil(t, sink.Send(&JobEvent{Type: JobEventFinished, JobID: 5}), "sink wasn't closed")
+
+	// events emitted after closing are dropped
+	emitter.Emit(gocontext.TODO(), &JobEvent{Type: JobEventFinished, JobID: 6})
+	assert.Nil(t, emitter.Close(gocontext.TODO()))
+}
+
+func TestJobEventEmitter_NilIsNoop(t *testing.T) {
+	var emitter *JobEventEmitter
+
+	job := &fakeJob{}
+	assert.Equal(t, job, emitter.wrapJob(job))
+	emitter.Emit(gocontext.TODO(), &JobEvent{Type: JobEventScriptUploaded})
+	assert.Nil(t, emitter.Close(gocontext.TODO()))
+}
+
+func TestWebhookJobEventSink(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		body, err := ioutil.ReadAll(req.Body)
+		assert.Nil(t, err)
+
+		mac := hmac.New(sha256.New, []byte("secret"))
+		_, _ = mac.Write(body)
+		assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), req.Header.Get("Travis-Worker-Signature"))
+
+		event := &JobEvent{}
+		assert.Nil(t, json.Unmarshal(body, event))
+		assert.Equal(t, JobEventScriptUploaded, event.Type)
+		assert.Equal(t, uint64(4), event.JobID)
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	sink := NewWebhookJobEventSink(ts.URL, "secret")
+	err := sink.Send(&JobEvent{Type: JobEventScriptUploaded, JobID: 4})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, requests)
+}
+
+func TestWebhookJobEventSink_GivesUp(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	sink := NewWebhookJobEventSink(ts.URL, "secret").(*webhookJobEventSink)
+	sink.maxElapsedTime = 100 * time.Millisecond
+
+	assert.NotNil(t, sink.Send(&JobEvent{Type: JobEventScriptUploaded}))
+}
+
+func TestFileJobEventSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "travis-worker-job-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink, err := NewFileJobEventSink(filepath.Join(dir, "events.jsonl"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, sink.Send(&JobEvent{Type: JobEventInstanceStarted, JobID: 4, InstanceID: "i-abc"}))
+	assert.Nil(t, sink.Send(&JobEvent{Type: JobEventInstanceStopped, JobID: 4, InstanceID: "i-abc"}))
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "events.jsonl"))
+	assert.Nil(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Len(t, lines, 2)
+
+	event := &JobEvent{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), event))
+	assert.Equal(t, JobEventInstanceStopped, event.Type)
+	assert.Equal(t, "i-abc", event.InstanceID)
+}
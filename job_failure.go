@@ -0,0 +1,81 @@
+package worker
+
+import (
+	gocontext "context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/travis-ci/worker/context"
+	workererrors "github.com/travis-ci/worker/errors"
+	"github.com/travis-ci/worker/metrics"
+)
+
+type jobFailureKey struct{}
+
+// jobFailure is the classified cause of a job being requeued or errored.
+type jobFailure struct {
+	class workererrors.Class
+	err   error
+}
+
+func withJobFailure(ctx gocontext.Context, class workererrors.Class, err error) gocontext.Context {
+	return gocontext.WithValue(ctx, jobFailureKey{}, &jobFailure{class: class, err: err})
+}
+
+func jobFailureFromContext(ctx gocontext.Context) (*jobFailure, bool) {
+	failure, ok := ctx.Value(jobFailureKey{}).(*jobFailure)
+	return failure, ok
+}
+
+// addJobFailureMeta adds the class of the job failure in the context, if any,
+// to the meta of a state update body.
+func addJobFailureMeta(ctx gocontext.Context, body map[string]interface{}) {
+	failure, ok := jobFailureFromContext(ctx)
+	if !ok {
+		return
+	}
+
+	meta := body["meta"].(map[string]interface{})
+	meta["error_class"] = string(failure.class)
+	meta["error_retryable"] = failure.class.Retryable()
+}
+
+// failJob classifies err, falling back to the given class if err isn't
+// classified, and then requeues the job if the class is retryable, or marks
+// it as errored otherwise. If logWriter isn't nil, an explanation is written
+// to the job log before marking it errored.
+func failJob(ctx gocontext.Context, buildJob Job, logWriter LogWriter, err error, fallback workererrors.Class) {
+	class := workererrors.ClassOrDefault(err, fallback)
+	ctx = withJobFailure(ctx, class, err)
+
+	logger := context.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"self":        "job_failure",
+		"err":         err,
+		"error_class": class,
+	})
+
+	metrics.Mark("travis.worker.job.failure." + string(class))
+
+	if class.Retryable() {
+		logger.Info("requeueing job")
+
+		err := buildJob.Requeue(ctx)
+		if err != nil {
+			logger.WithField("err", err).Error("couldn't requeue job")
+		}
+		return
+	}
+
+	logger.Info("marking job as errored")
+
+	if message := workererrors.UserFacingMessage(err); logWriter != nil && message != "" {
+		_, err := logWriter.WriteAndClose([]byte(message))
+		if err != nil {
+			logger.WithField("err", err).Error("couldn't write error to log")
+		}
+	}
+
+	err = buildJob.Finish(ctx, FinishStateErrored)
+	if err != nil {
+		logger.WithField("err", err).WithField("state", FinishStateErrored).Error("couldn't mark job as finished")
+	}
+}
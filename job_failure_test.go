@@ -0,0 +1,35 @@
+package worker
+
+import (
+	"fmt"
+	"testing"
+
+	gocontext "context"
+
+	"github.com/stretchr/testify/assert"
+	workererrors "github.com/travis-ci/worker/errors"
+)
+
+func TestFailJob_RequeuesRetryableFailures(t *testing.T) {
+	job := &fakeJob{}
+	failJob(gocontext.TODO(), job, nil, fmt.Errorf("connection reset"), workererrors.ClassConnectionLost)
+	assert.Equal(t, []string{"requeued"}, job.events)
+}
+
+func TestFailJob_ErrorsNonRetryableFailures(t *testing.T) {
+	job := &fakeJob{}
+	err := workererrors.Classify(fmt.Errorf("no image found"), workererrors.ClassImageNotFound)
+	failJob(gocontext.TODO(), job, &fakeLogWriter{}, err, workererrors.ClassUnknown)
+	assert.Equal(t, []string{"errored"}, job.events)
+}
+
+func TestAddJobFailureMeta(t *testing.T) {
+	body := map[string]interface{}{"meta": map[string]interface{}{}}
+	addJobFailureMeta(gocontext.TODO(), body)
+	assert.NotContains(t, body["meta"], "error_class")
+
+	ctx := withJobFailure(gocontext.TODO(), workererrors.ClassImageNotFound, fmt.Errorf("no image found"))
+	addJobFailureMeta(ctx, body)
+	assert.Equal(t, "image_not_found", body["meta"].(map[string]interface{})["error_class"])
+	assert.Equal(t, false, body["meta"].(map[string]interface{})["error_retryable"])
+}
@@ -0,0 +1,507 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	gocontext "context"
+
+	"github.com/Jeffail/tunny"
+	simplejson "github.com/bitly/go-simplejson"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"github.com/travis-ci/worker/backend"
+	"github.com/travis-ci/worker/config"
+)
+
+// LoadTestOptions configures a synthetic load test run with LoadTest.
+type LoadTestOptions struct {
+	// Jobs is the number of jobs to generate.
+	Jobs int
+
+	// Rate is the number of jobs generated per second.
+	Rate float64
+
+	// PoolSize is the number of processors running jobs, taken from the
+	// config if 0.
+	PoolSize int
+
+	// StartupTime and RunTime are how long each fake instance takes to start
+	// and to run its script.
+	StartupTime time.Duration
+	RunTime     time.Duration
+
+	// LogBytes is the number of bytes of log output written by each job.
+	LogBytes int
+}
+
+// LoadTestReport holds the results of a load test run.
+type LoadTestReport struct {
+	Jobs          int                     `json:"jobs"`
+	States        map[string]int          `json:"states"`
+	Duration      time.Duration           `json:"duration_ns"`
+	Throughput    float64                 `json:"jobs_per_second"`
+	Latencies     map[string]*LatencyDist `json:"latencies"`
+	LogParts      int                     `json:"log_parts"`
+	LogPartBytes  int64                   `json:"log_part_bytes"`
+	StateUpdates  int                     `json:"state_updates"`
+	MaxGoroutines int                     `json:"max_goroutines"`
+	Goroutines    int                     `json:"goroutines"`
+	MaxHeapAlloc  uint64                  `json:"max_heap_alloc_bytes"`
+	TotalAlloc    uint64                  `json:"total_alloc_bytes"`
+	NumGC         uint32                  `json:"num_gc"`
+}
+
+// LatencyDist summarizes the latencies observed for a step.
+type LatencyDist struct {
+	Count int           `json:"count"`
+	P50   time.Duration `json:"p50_ns"`
+	P90   time.Duration `json:"p90_ns"`
+	P99   time.Duration `json:"p99_ns"`
+	Max   time.Duration `json:"max_ns"`
+}
+
+// LoadTest runs synthetic jobs through a processor pool on the fake provider
+// and writes a report on throughput, per step latencies, goroutines, and
+// memory to out, as text or JSON.
+func (i *CLI) LoadTest(out io.Writer, opts *LoadTestOptions, asJSON bool) error {
+	cfg, err := config.Load(i.c)
+	if err != nil {
+		return err
+	}
+
+	if opts.Jobs <= 0 || opts.Rate <= 0 {
+		return errors.New("the number of jobs and the rate must be positive")
+	}
+	if cfg.StateUpdatePoolSize < 1 || cfg.LogPoolSize < 1 {
+		return errors.New("the state update and log pool sizes must be positive")
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = cfg.PoolSize
+	}
+
+	report, err := runLoadTest(gocontext.Background(), cfg, opts)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	report.Print(out)
+	return nil
+}
+
+// runLoadTest generates opts.Jobs jobs at opts.Rate per second and runs them
+// on a processor pool until they're all done. The jobs are AMQP jobs, whose
+// log parts go through the AMQP log writer factory and whose state updates go
+// through a state update pool of cfg.StateUpdatePoolSize workers, but which
+// are published to an in-process publisher instead of RabbitMQ.
+func runLoadTest(ctx gocontext.Context, cfg *config.Config, opts *LoadTestOptions) (*LoadTestReport, error) {
+	loadCfg := *cfg
+	loadCfg.ProviderName = "fake"
+	loadCfg.ProviderConfig = config.ProviderConfigFromMap(map[string]string{
+		"STARTUP_DURATION":  opts.StartupTime.String(),
+		"FAULT_START_DELAY": opts.StartupTime.String(),
+		"RUN_SLEEP":         opts.RunTime.String(),
+		"LOG_BYTES":         strconv.Itoa(opts.LogBytes),
+	})
+	loadCfg.InitialSleep = 0
+	loadCfg.PayloadFilterExecutable = ""
+	if loadCfg.MaxLogLength < opts.LogBytes+1024 {
+		loadCfg.MaxLogLength = opts.LogBytes + 1024
+	}
+
+	provider, err := backend.NewBackendProvider("fake", loadCfg.ProviderConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := gocontext.WithCancel(ctx)
+	defer cancel()
+
+	logPublisher := newLoadTestPublisher(loadCfg.LogPoolSize)
+	defer logPublisher.stop()
+
+	statePublisher := newLoadTestPublisher(loadCfg.StateUpdatePoolSize)
+	defer statePublisher.stop()
+
+	stateUpdatePool := newStateUpdatePool(loadCfg.StateUpdatePoolSize, func() amqpPublishChannel {
+		return statePublisher
+	})
+	defer stateUpdatePool.Close()
+
+	logWriterFactory := &AMQPLogWriterFactory{
+		withLogSharding: loadCfg.RabbitMQSharding,
+		logWriterChan:   logPublisher,
+	}
+
+	events := &loadTestEventSink{events: map[uint64]*JobEvent{}, boots: map[uint64]time.Duration{}}
+
+	jobEvents := NewJobEventEmitter(ctx, loadCfg.Hostname, loadCfg.ProviderName, loadCfg.TravisSite, events)
+
+	pool := NewProcessorPool(&ProcessorPoolConfig{
+		Hostname:  loadCfg.Hostname,
+		Context:   ctx,
+		Config:    &loadCfg,
+		JobEvents: jobEvents,
+	}, provider, loadTestGenerator{}, nil, NewCancellationBroadcaster())
+
+	jobsChan := make(chan Job, opts.Jobs)
+	queue := &localJobQueue{jobs: jobsChan}
+	sampler := newRuntimeSampler(ctx, 100*time.Millisecond)
+
+	jobs := make([]*amqpJob, opts.Jobs)
+	for n := range jobs {
+		jobs[n] = newLoadTestJob(uint64(n+1), stateUpdatePool, logPublisher, loadCfg.RabbitMQSharding)
+	}
+
+	start := time.Now()
+
+	go func() {
+		defer close(jobsChan)
+
+		interval := time.Duration(float64(time.Second) / opts.Rate)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for n, job := range jobs {
+			if n > 0 {
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			queuedAt := time.Now()
+			job.payload.Job.QueuedAt = &queuedAt
+			jobsChan <- job
+		}
+	}()
+
+	pool.Run(opts.PoolSize, queue, logWriterFactory)
+
+	duration := time.Since(start)
+	sampler.stop()
+
+	err = jobEvents.Close(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := newLoadTestReport(jobs, events.byJobID(), events.bootDurations(), duration, sampler)
+	report.LogParts, report.LogPartBytes = logPublisher.counts()
+	report.StateUpdates, _ = statePublisher.counts()
+
+	return report, nil
+}
+
+func newLoadTestReport(jobs []*amqpJob, events map[uint64]*JobEvent, boots []time.Duration, duration time.Duration, sampler *runtimeSampler) *LoadTestReport {
+	report := &LoadTestReport{
+		Jobs:          len(jobs),
+		States:        map[string]int{},
+		Duration:      duration,
+		Latencies:     map[string]*LatencyDist{},
+		MaxGoroutines: sampler.maxGoroutines,
+		Goroutines:    runtime.NumGoroutine(),
+		MaxHeapAlloc:  sampler.maxHeapAlloc,
+		TotalAlloc:    sampler.totalAlloc,
+		NumGC:         sampler.numGC,
+	}
+
+	if duration > 0 {
+		report.Throughput = float64(len(jobs)) / duration.Seconds()
+	}
+
+	latencies := map[string][]time.Duration{}
+	if len(boots) > 0 {
+		latencies["boot"] = boots
+	}
+	for _, job := range jobs {
+		queuedAt := job.payload.Job.QueuedAt
+		if queuedAt != nil && !job.received.IsZero() {
+			latencies["queue"] = append(latencies["queue"], job.received.Sub(*queuedAt))
+		}
+		if queuedAt != nil && !job.finished.IsZero() {
+			latencies["total"] = append(latencies["total"], job.finished.Sub(*queuedAt))
+		}
+
+		event, ok := events[job.payload.Job.ID]
+		if !ok {
+			report.States["unfinished"]++
+			continue
+		}
+
+		if event.Type == JobEventRequeued {
+			report.States["requeued"]++
+		} else {
+			report.States[event.State]++
+		}
+
+		for name, ms := range event.Timings {
+			if ms, ok := ms.(int64); ok {
+				step := strings.TrimSuffix(name, "_ms")
+				latencies[step] = append(latencies[step], time.Duration(ms)*time.Millisecond)
+			}
+		}
+	}
+
+	for step, ds := range latencies {
+		report.Latencies[step] = newLatencyDist(ds)
+	}
+
+	return report
+}
+
+func newLatencyDist(ds []time.Duration) *LatencyDist {
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+
+	percentile := func(p float64) time.Duration {
+		return ds[int(p*float64(len(ds)-1))]
+	}
+
+	return &LatencyDist{
+		Count: len(ds),
+		P50:   percentile(0.5),
+		P90:   percentile(0.9),
+		P99:   percentile(0.99),
+		Max:   ds[len(ds)-1],
+	}
+}
+
+// Print writes the report as text.
+func (r *LoadTestReport) Print(w io.Writer) {
+	states := []string{}
+	for state := range r.States {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+
+	fmt.Fprintf(w, "jobs: %d in %s (%.2f jobs/s)\n", r.Jobs, r.Duration, r.Throughput)
+	for _, state := range states {
+		fmt.Fprintf(w, "  %s: %d\n", state, r.States[state])
+	}
+
+	steps := []string{}
+	for step := range r.Latencies {
+		steps = append(steps, step)
+	}
+	sort.Strings(steps)
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "latency\tcount\tp50\tp90\tp99\tmax")
+	for _, step := range steps {
+		l := r.Latencies[step]
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", step, l.Count, l.P50, l.P90, l.P99, l.Max)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "published: %d log parts (%.1f MiB), %d state updates\n",
+		r.LogParts, float64(r.LogPartBytes)/(1<<20), r.StateUpdates)
+	fmt.Fprintf(w, "goroutines: max %d, after run %d\n", r.MaxGoroutines, r.Goroutines)
+	fmt.Fprintf(w, "memory: max heap %.1f MiB, allocated %.1f MiB, %d GCs\n",
+		float64(r.MaxHeapAlloc)/(1<<20), float64(r.TotalAlloc)/(1<<20), r.NumGC)
+}
+
+// runtimeSampler records the peak goroutine count and heap size while it
+// runs, and the memory allocated and GCs run in that time.
+type runtimeSampler struct {
+	done    chan struct{}
+	stopped chan struct{}
+
+	initial runtime.MemStats
+
+	maxGoroutines int
+	maxHeapAlloc  uint64
+	totalAlloc    uint64
+	numGC         uint32
+}
+
+func newRuntimeSampler(ctx gocontext.Context, interval time.Duration) *runtimeSampler {
+	s := &runtimeSampler{
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	runtime.ReadMemStats(&s.initial)
+
+	go func() {
+		defer close(s.stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			s.sample()
+
+			select {
+			case <-ticker.C:
+			case <-s.done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return s
+}
+
+func (s *runtimeSampler) sample() {
+	if n := runtime.NumGoroutine(); n > s.maxGoroutines {
+		s.maxGoroutines = n
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	if stats.HeapAlloc > s.maxHeapAlloc {
+		s.maxHeapAlloc = stats.HeapAlloc
+	}
+	s.totalAlloc = stats.TotalAlloc - s.initial.TotalAlloc
+	s.numGC = stats.NumGC - s.initial.NumGC
+}
+
+func (s *runtimeSampler) stop() {
+	close(s.done)
+	<-s.stopped
+	s.sample()
+}
+
+// loadTestGenerator returns the same small script for every job.
+type loadTestGenerator struct{}
+
+func (loadTestGenerator) Generate(gocontext.Context, Job) ([]byte, error) {
+	return []byte("#!/bin/bash\necho load test\n"), nil
+}
+
+// newLoadTestJob returns a synthetic AMQP job, whose delivery is acked by a
+// loadTestAcknowledger.
+func newLoadTestJob(id uint64, stateUpdatePool *tunny.Pool, logWriterChan amqpPublishChannel, sharded bool) *amqpJob {
+	payload := &JobPayload{
+		Type:       "job:test:load",
+		Job:        JobJobPayload{ID: id, Number: fmt.Sprintf("%d.1", id)},
+		Repository: RepositoryPayload{ID: 1, Slug: "travis-ci/load-test"},
+		Config:     map[string]interface{}{"language": "ruby"},
+	}
+
+	rawPayload := simplejson.New()
+	rawPayload.Set("config", payload.Config)
+
+	return &amqpJob{
+		stateUpdatePool: stateUpdatePool,
+		logWriterChan:   logWriterChan,
+		delivery:        amqp.Delivery{Acknowledger: loadTestAcknowledger{}, DeliveryTag: id},
+		payload:         payload,
+		rawPayload:      rawPayload,
+		startAttributes: &backend.StartAttributes{Language: "ruby", Dist: "trusty", Group: "stable", OS: "linux"},
+		withLogSharding: sharded,
+	}
+}
+
+// loadTestAcknowledger accepts the acks and nacks of load test jobs.
+type loadTestAcknowledger struct{}
+
+func (loadTestAcknowledger) Ack(uint64, bool) error { return nil }
+
+func (loadTestAcknowledger) Nack(uint64, bool, bool) error { return nil }
+
+func (loadTestAcknowledger) Reject(uint64, bool) error { return nil }
+
+// loadTestPublisher stands in for the AMQP channels of the log writers and
+// the state update workers. Every message is handed to a pool of workers,
+// which only count it, so that a small LogPoolSize or StateUpdatePoolSize
+// shows up in the latencies.
+type loadTestPublisher struct {
+	pool *tunny.Pool
+
+	messages int64
+	bytes    int64
+}
+
+func newLoadTestPublisher(poolSize int) *loadTestPublisher {
+	p := &loadTestPublisher{}
+	p.pool = tunny.NewFunc(poolSize, func(payload interface{}) interface{} {
+		atomic.AddInt64(&p.messages, 1)
+		atomic.AddInt64(&p.bytes, int64(len(payload.([]byte))))
+		return nil
+	})
+	return p
+}
+
+func (p *loadTestPublisher) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	p.pool.Process(msg.Body)
+	return nil
+}
+
+// Close is a no-op, as the publisher is shared by all log writers and state
+// update workers.
+func (p *loadTestPublisher) Close() error { return nil }
+
+func (p *loadTestPublisher) counts() (int, int64) {
+	return int(atomic.LoadInt64(&p.messages)), atomic.LoadInt64(&p.bytes)
+}
+
+func (p *loadTestPublisher) stop() {
+	p.pool.Close()
+}
+
+// loadTestEventSink collects the finished and requeued events of load test
+// jobs, which carry the state each job finished with and its step timings,
+// and the boot durations of their instances.
+type loadTestEventSink struct {
+	mutex  sync.Mutex
+	events map[uint64]*JobEvent
+	boots  map[uint64]time.Duration
+}
+
+func (s *loadTestEventSink) Name() string { return "load-test" }
+
+func (s *loadTestEventSink) Send(event *JobEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch event.Type {
+	case JobEventInstanceStarted:
+		s.boots[event.JobID] = time.Duration(event.BootDurationMs * float64(time.Millisecond))
+	case JobEventFinished, JobEventRequeued:
+		s.events[event.JobID] = event
+	}
+
+	return nil
+}
+
+func (s *loadTestEventSink) byJobID() map[uint64]*JobEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	events := make(map[uint64]*JobEvent, len(s.events))
+	for id, event := range s.events {
+		events[id] = event
+	}
+	return events
+}
+
+func (s *loadTestEventSink) bootDurations() []time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	boots := make([]time.Duration, 0, len(s.boots))
+	for _, boot := range s.boots {
+		boots = append(boots, boot)
+	}
+	return boots
+}
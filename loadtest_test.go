@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	gocontext "context"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travis-ci/worker/config"
+)
+
+func TestRunLoadTest(t *testing.T) {
+	cfg := &config.Config{
+		HardTimeout:         time.Minute,
+		LogTimeout:          time.Minute,
+		StartupTimeout:      time.Minute,
+		ScriptUploadTimeout: time.Minute,
+		StateUpdatePoolSize: 2,
+		LogPoolSize:         2,
+	}
+
+	report, err := runLoadTest(gocontext.TODO(), cfg, &LoadTestOptions{
+		Jobs:        5,
+		Rate:        1000,
+		PoolSize:    2,
+		StartupTime: 20 * time.Millisecond,
+		RunTime:     time.Millisecond,
+		LogBytes:    5000,
+	})
+	require.Nil(t, err)
+
+	assert.Equal(t, 5, report.Jobs)
+	assert.Equal(t, map[string]int{"passed": 5}, report.States)
+	assert.True(t, report.Throughput > 0)
+	assert.True(t, report.LogParts > 0)
+	assert.True(t, report.LogPartBytes > 5000)
+	// received, started, and finished for each job
+	assert.Equal(t, 15, report.StateUpdates)
+	assert.True(t, report.MaxGoroutines > 0)
+	assert.True(t, report.TotalAlloc > 0)
+
+	require.Contains(t, report.Latencies, "total")
+	assert.Equal(t, 5, report.Latencies["total"].Count)
+	assert.Contains(t, report.Latencies, "queue")
+	assert.Contains(t, report.Latencies, "step_run_script_run")
+
+	// the fake instances take the startup time to start
+	require.Contains(t, report.Latencies, "boot")
+	assert.Equal(t, 5, report.Latencies["boot"].Count)
+	assert.True(t, report.Latencies["boot"].P50 >= 20*time.Millisecond)
+	require.Contains(t, report.Latencies, "step_start_instance_run")
+	assert.True(t, report.Latencies["step_start_instance_run"].P50 >= 20*time.Millisecond)
+
+	out := &bytes.Buffer{}
+	report.Print(out)
+	assert.Contains(t, out.String(), "jobs: 5 in ")
+	assert.Contains(t, out.String(), "passed: 5")
+	assert.Contains(t, out.String(), "step_run_script_run")
+	assert.Contains(t, out.String(), "15 state updates")
+}
+
+func TestNewLatencyDist(t *testing.T) {
+	ds := []time.Duration{}
+	for i := 100; i > 0; i-- {
+		ds = append(ds, time.Duration(i)*time.Millisecond)
+	}
+
+	l := newLatencyDist(ds)
+	assert.Equal(t, 100, l.Count)
+	assert.Equal(t, 50*time.Millisecond, l.P50)
+	assert.Equal(t, 90*time.Millisecond, l.P90)
+	assert.Equal(t, 99*time.Millisecond, l.P99)
+	assert.Equal(t, 100*time.Millisecond, l.Max)
+}
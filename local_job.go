@@ -0,0 +1,119 @@
+package worker
+
+import (
+	"io"
+	"time"
+
+	gocontext "context"
+
+	simplejson "github.com/bitly/go-simplejson"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/travis-ci/worker/backend"
+	"github.com/travis-ci/worker/config"
+	"github.com/travis-ci/worker/context"
+)
+
+// localJob is a Job read from a payload file, which writes its log to an
+// io.Writer and remembers the state it finished with.
+type localJob struct {
+	payload         *JobPayload
+	rawPayload      *simplejson.Json
+	startAttributes *backend.StartAttributes
+	out             io.Writer
+
+	finishState FinishState
+	requeued    bool
+}
+
+func newLocalJob(body []byte, cfg *config.Config, out io.Writer) (*localJob, error) {
+	payload, startAttributes, err := parseLocalJobPayload(body, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	rawPayload, err := simplejson.NewJson(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't parse job payload")
+	}
+
+	return &localJob{
+		payload:         payload,
+		rawPayload:      rawPayload,
+		startAttributes: startAttributes,
+		out:             out,
+	}, nil
+}
+
+func (j *localJob) Payload() *JobPayload {
+	return j.payload
+}
+
+func (j *localJob) RawPayload() *simplejson.Json {
+	return j.rawPayload
+}
+
+func (j *localJob) StartAttributes() *backend.StartAttributes {
+	return j.startAttributes
+}
+
+func (j *localJob) Received(_ gocontext.Context) error {
+	return nil
+}
+
+func (j *localJob) Started(_ gocontext.Context) error {
+	return nil
+}
+
+func (j *localJob) Error(ctx gocontext.Context, errMessage string) error {
+	log, err := j.LogWriter(ctx, time.Minute)
+	if err != nil {
+		return err
+	}
+
+	_, err = log.WriteAndClose([]byte(errMessage))
+	if err != nil {
+		return err
+	}
+
+	return j.Finish(ctx, FinishStateErrored)
+}
+
+func (j *localJob) Requeue(ctx gocontext.Context) error {
+	context.LoggerFromContext(ctx).WithField("self", "local_job").Info("job would be requeued")
+	j.requeued = true
+	return nil
+}
+
+func (j *localJob) Finish(ctx gocontext.Context, state FinishState) error {
+	context.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"state": state,
+		"self":  "local_job",
+	}).Info("finishing job")
+
+	j.finishState = state
+	return nil
+}
+
+func (j *localJob) LogWriter(ctx gocontext.Context, defaultLogTimeout time.Duration) (LogWriter, error) {
+	logTimeout := time.Duration(j.payload.Timeouts.LogSilence) * time.Second
+	if logTimeout == 0 {
+		logTimeout = defaultLogTimeout
+	}
+
+	return newWriterLogWriter(ctx, j.out, logTimeout), nil
+}
+
+func (j *localJob) SetupContext(ctx gocontext.Context) gocontext.Context { return ctx }
+
+func (j *localJob) Name() string { return "local" }
+
+func (j *localJob) exitCode() int {
+	if code, ok := runJobExitCodes[j.finishState]; ok {
+		return code
+	}
+	if j.requeued {
+		return RunJobExitRequeued
+	}
+	return RunJobExitUnfinished
+}
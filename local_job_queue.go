@@ -0,0 +1,32 @@
+package worker
+
+import (
+	gocontext "context"
+)
+
+// localJobQueue is a JobQueue handing out jobs that don't come from a real
+// queue, such as those of run-job and load-test. Processors using the queue
+// return once its jobs channel is closed.
+type localJobQueue struct {
+	jobs <-chan Job
+}
+
+// newLocalJobQueue returns a localJobQueue that hands out the given jobs and
+// is closed after that.
+func newLocalJobQueue(jobs ...Job) *localJobQueue {
+	jobsChan := make(chan Job, len(jobs))
+	for _, job := range jobs {
+		jobsChan <- job
+	}
+	close(jobsChan)
+
+	return &localJobQueue{jobs: jobsChan}
+}
+
+func (q *localJobQueue) Jobs(ctx gocontext.Context) (<-chan Job, error) {
+	return q.jobs, nil
+}
+
+func (q *localJobQueue) Name() string { return "local" }
+
+func (q *localJobQueue) Cleanup() error { return nil }
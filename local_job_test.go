@@ -0,0 +1,27 @@
+package worker
+
+import (
+	"testing"
+
+	gocontext "context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalJob_ExitCode(t *testing.T) {
+	for state, code := range map[FinishState]int{
+		FinishStatePassed:    0,
+		FinishStateFailed:    1,
+		FinishStateErrored:   2,
+		FinishStateCancelled: 3,
+	} {
+		j := &localJob{}
+		assert.Nil(t, j.Finish(gocontext.TODO(), state))
+		assert.Equal(t, code, j.exitCode(), "state %s", state)
+	}
+
+	j := &localJob{}
+	assert.Equal(t, RunJobExitUnfinished, j.exitCode())
+	assert.Nil(t, j.Requeue(gocontext.TODO()))
+	assert.Equal(t, RunJobExitRequeued, j.exitCode())
+}
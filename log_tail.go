@@ -0,0 +1,332 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogTailer keeps the most recent output of every running job in a bounded
+// in-memory ring buffer, so that it can be streamed over the HTTP API without
+// depending on the log pipeline. The output of a finished job is kept for the
+// configured history duration, after which it is dropped.
+type LogTailer struct {
+	size    int
+	history time.Duration
+
+	tailsMutex sync.Mutex
+	tails      map[uint64]*logTail
+}
+
+// NewLogTailer creates a LogTailer that keeps up to size bytes of output per
+// job, and forgets about finished jobs after the given history duration.
+func NewLogTailer(size int, history time.Duration) *LogTailer {
+	return &LogTailer{
+		size:    size,
+		history: history,
+		tails:   map[uint64]*logTail{},
+	}
+}
+
+// LogWriter returns a LogWriter that copies everything successfully written
+// to w into the tail for the given job. Any previous tail for the same job,
+// e.g. from before a requeue, is replaced.
+func (t *LogTailer) LogWriter(jobID uint64, w LogWriter) LogWriter {
+	tail := newLogTail(t.size)
+	tail.onFinish = func() { t.forget(jobID, tail) }
+
+	t.tailsMutex.Lock()
+	t.prune(time.Now())
+	if prev, ok := t.tails[jobID]; ok {
+		prev.finish()
+	}
+	t.tails[jobID] = tail
+	t.tailsMutex.Unlock()
+
+	return &tailingLogWriter{LogWriter: w, tail: tail}
+}
+
+func (t *LogTailer) get(jobID uint64) (*logTail, bool) {
+	t.tailsMutex.Lock()
+	defer t.tailsMutex.Unlock()
+
+	t.prune(time.Now())
+	tail, ok := t.tails[jobID]
+	return tail, ok
+}
+
+// forget drops the tail of the given job once the history duration has
+// passed, unless it has been replaced in the meantime.
+func (t *LogTailer) forget(jobID uint64, tail *logTail) {
+	time.AfterFunc(t.history, func() {
+		t.tailsMutex.Lock()
+		defer t.tailsMutex.Unlock()
+
+		if t.tails[jobID] == tail {
+			delete(t.tails, jobID)
+		}
+	})
+}
+
+// prune must be called with tailsMutex held.
+func (t *LogTailer) prune(now time.Time) {
+	for jobID, tail := range t.tails {
+		if tail.expired(now, t.history) {
+			delete(t.tails, jobID)
+		}
+	}
+}
+
+// serveJobLog streams the tail of the given job's log, starting at the offset
+// given in the "from" query parameter, until the job finishes or the client
+// goes away. Clients that ask for "text/event-stream" get server-sent events,
+// everyone else gets the raw bytes in a chunked response.
+func (t *LogTailer) serveJobLog(w http.ResponseWriter, req *http.Request, jobID uint64) {
+	tail, ok := t.get(jobID)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	offset := int64(0)
+	from := req.URL.Query().Get("from")
+	if from == "" {
+		from = req.Header.Get("Last-Event-ID")
+	}
+	if from != "" {
+		var err error
+		offset, err = strconv.ParseInt(from, 10, 64)
+		if err != nil || offset < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "invalid offset %q\n", from)
+			return
+		}
+	}
+
+	sse := strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "text/plain;charset=utf-8")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	// If the requested offset has already been dropped from the buffer, the
+	// stream starts at the oldest offset still available instead.
+	content, start, finished, changed := tail.read(offset)
+	w.Header().Set("Travis-Worker-Log-Offset", strconv.FormatInt(start, 10))
+	w.WriteHeader(http.StatusOK)
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+
+	for ; ; content, start, finished, changed = tail.read(offset) {
+		offset = start + int64(len(content))
+
+		if len(content) > 0 {
+			var err error
+			if sse {
+				err = writeLogTailEvent(w, "log", offset, string(content))
+			} else {
+				_, err = w.Write(content)
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+			continue
+		}
+
+		if finished {
+			if sse {
+				_ = writeLogTailEvent(w, "end", offset, "")
+			}
+			flusher.Flush()
+			return
+		}
+
+		flusher.Flush()
+
+		select {
+		case <-changed:
+		case <-keepalive.C:
+			if sse {
+				fmt.Fprintf(w, ": keepalive\n\n")
+			}
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func writeLogTailEvent(w http.ResponseWriter, event string, offset int64, content string) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"offset":  offset,
+		"content": content,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", offset, event, data)
+	return err
+}
+
+// logTail is a ring buffer holding the last size bytes written to a job's
+// log. The buffer grows as the log is written until it reaches size, so that
+// short logs don't take up the whole size. Offsets are absolute positions in
+// the log, so that readers can resume where they left off.
+type logTail struct {
+	mutex sync.Mutex
+
+	size       int
+	buf        []byte
+	written    int64
+	finishedAt time.Time
+
+	// changed is closed and replaced on every write, and closed on finish.
+	changed chan struct{}
+
+	// onFinish is called once the log has finished.
+	onFinish func()
+}
+
+func newLogTail(size int) *logTail {
+	return &logTail{
+		size:    size,
+		changed: make(chan struct{}),
+	}
+}
+
+func (l *logTail) write(p []byte) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.finishedAt.IsZero() || l.size == 0 {
+		return
+	}
+
+	defer func() {
+		close(l.changed)
+		l.changed = make(chan struct{})
+	}()
+
+	// until the buffer is full, the log is kept from its start
+	if l.written+int64(len(p)) <= int64(l.size) {
+		l.buf = append(l.buf, p...)
+		l.written += int64(len(p))
+		return
+	}
+	if len(l.buf) < l.size {
+		buf := make([]byte, l.size)
+		copy(buf, l.buf)
+		l.buf = buf
+	}
+
+	size := int64(l.size)
+	l.written += int64(len(p))
+	if int64(len(p)) > size {
+		p = p[int64(len(p))-size:]
+	}
+
+	pos := (l.written - int64(len(p))) % size
+	n := copy(l.buf[pos:], p)
+	copy(l.buf, p[n:])
+}
+
+// read returns the bytes from the given offset up to the end of what has been
+// written so far. If the offset is no longer in the buffer, the oldest bytes
+// still available are returned, and start is set accordingly. The returned
+// channel is closed as soon as more bytes are available or the log finishes.
+func (l *logTail) read(offset int64) (content []byte, start int64, finished bool, changed <-chan struct{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	size := int64(len(l.buf))
+	oldest := l.written - int64(l.size)
+	if oldest < 0 {
+		oldest = 0
+	}
+
+	start = offset
+	if start < oldest {
+		start = oldest
+	}
+	if start > l.written {
+		start = l.written
+	}
+
+	content = make([]byte, l.written-start)
+	if len(content) > 0 {
+		pos := start % size
+		n := copy(content, l.buf[pos:])
+		copy(content[n:], l.buf)
+	}
+
+	return content, start, !l.finishedAt.IsZero(), l.changed
+}
+
+func (l *logTail) finish() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.finishedAt.IsZero() {
+		return
+	}
+
+	l.finishedAt = time.Now()
+	close(l.changed)
+
+	if l.onFinish != nil {
+		l.onFinish()
+	}
+}
+
+func (l *logTail) expired(now time.Time, history time.Duration) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return !l.finishedAt.IsZero() && now.Sub(l.finishedAt) > history
+}
+
+// tailingLogWriter copies everything written to the wrapped LogWriter into a
+// logTail, and marks the tail as finished when the LogWriter is closed.
+type tailingLogWriter struct {
+	LogWriter
+
+	tail *logTail
+}
+
+func (w *tailingLogWriter) Write(p []byte) (int, error) {
+	n, err := w.LogWriter.Write(p)
+	if n > 0 {
+		w.tail.write(p[:n])
+	}
+	return n, err
+}
+
+func (w *tailingLogWriter) Close() error {
+	defer w.tail.finish()
+	return w.LogWriter.Close()
+}
+
+func (w *tailingLogWriter) WriteAndClose(p []byte) (int, error) {
+	defer w.tail.finish()
+
+	n, err := w.LogWriter.WriteAndClose(p)
+	if n > 0 {
+		w.tail.write(p[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,187 @@
+package worker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogTail_ReadWraps(t *testing.T) {
+	tail := newLogTail(8)
+
+	tail.write([]byte("hello "))
+	content, start, finished, _ := tail.read(0)
+	assert.Equal(t, "hello ", string(content))
+	assert.Equal(t, int64(0), start)
+	assert.False(t, finished)
+
+	tail.write([]byte("world"))
+	content, start, _, _ = tail.read(0)
+	assert.Equal(t, "lo world", string(content))
+	assert.Equal(t, int64(3), start)
+
+	content, start, _, _ = tail.read(9)
+	assert.Equal(t, "ld", string(content))
+	assert.Equal(t, int64(9), start)
+
+	tail.write([]byte("0123456789"))
+	content, start, _, _ = tail.read(11)
+	assert.Equal(t, "23456789", string(content))
+	assert.Equal(t, int64(13), start)
+
+	content, start, _, _ = tail.read(100)
+	assert.Equal(t, "", string(content))
+	assert.Equal(t, int64(21), start)
+}
+
+func TestLogTail_GrowsLazily(t *testing.T) {
+	tail := newLogTail(1024)
+	assert.Equal(t, 0, cap(tail.buf))
+
+	tail.write([]byte("hello"))
+	assert.True(t, cap(tail.buf) < 1024)
+
+	tail.write([]byte(strings.Repeat("x", 1020)))
+	assert.Len(t, tail.buf, 1024)
+
+	content, start, _, _ := tail.read(0)
+	assert.Equal(t, int64(1), start)
+	assert.Equal(t, "ello"+strings.Repeat("x", 1020), string(content))
+}
+
+func TestLogTail_FinishNotifies(t *testing.T) {
+	tail := newLogTail(8)
+	_, _, _, changed := tail.read(0)
+
+	tail.finish()
+
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("expected changed channel to be closed")
+	}
+
+	_, _, finished, _ := tail.read(0)
+	assert.True(t, finished)
+	assert.False(t, tail.expired(time.Now(), time.Minute))
+	assert.True(t, tail.expired(time.Now().Add(2*time.Minute), time.Minute))
+}
+
+func TestLogTailer_LogWriter(t *testing.T) {
+	logFile, flw := buildTestFileLogWriter(t, time.Hour)
+	defer os.RemoveAll(filepath.Dir(logFile))
+
+	tailer := NewLogTailer(1024, time.Minute)
+	lw := tailer.LogWriter(4, flw)
+
+	_, err := fmt.Fprintf(lw, "Hello, ")
+	assert.Nil(t, err)
+	_, err = lw.WriteAndClose([]byte("world!"))
+	assert.Nil(t, err)
+
+	tail, ok := tailer.get(4)
+	assert.True(t, ok)
+
+	content, _, finished, _ := tail.read(0)
+	assert.Equal(t, "Hello, world!", string(content))
+	assert.True(t, finished)
+
+	_, ok = tailer.get(5)
+	assert.False(t, ok)
+}
+
+func TestLogTailer_DropsFinishedTails(t *testing.T) {
+	logFile, flw := buildTestFileLogWriter(t, time.Hour)
+	defer os.RemoveAll(filepath.Dir(logFile))
+
+	tailer := NewLogTailer(1024, 10*time.Millisecond)
+	lw := tailer.LogWriter(4, flw)
+
+	_, err := lw.WriteAndClose([]byte("bye"))
+	assert.Nil(t, err)
+
+	_, ok := tailer.get(4)
+	assert.True(t, ok)
+
+	// the tail is dropped once the history has passed, without any other
+	// calls to the tailer
+	for i := 0; i < 100; i++ {
+		tailer.tailsMutex.Lock()
+		_, ok = tailer.tails[4]
+		tailer.tailsMutex.Unlock()
+		if !ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, ok)
+}
+
+func TestLogTailer_serveJobLog(t *testing.T) {
+	logFile, flw := buildTestFileLogWriter(t, time.Hour)
+	defer os.RemoveAll(filepath.Dir(logFile))
+
+	tailer := NewLogTailer(1024, time.Minute)
+	lw := tailer.LogWriter(4, flw)
+	_, err := fmt.Fprintf(lw, "Hello, world!")
+	assert.Nil(t, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tailer.serveJobLog(w, req, 4)
+	}))
+	defer ts.Close()
+
+	done := make(chan string)
+	go func() {
+		resp, err := http.Get(ts.URL + "?from=7")
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, "7", resp.Header.Get("Travis-Worker-Log-Offset"))
+		body, _ := ioutil.ReadAll(resp.Body)
+		done <- string(body)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	_, err = lw.WriteAndClose([]byte(" Bye!"))
+	assert.Nil(t, err)
+
+	select {
+	case body := <-done:
+		assert.Equal(t, "world! Bye!", body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected log stream to end after the log was closed")
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(string(body), "id: 18\nevent: log\n"))
+	assert.Contains(t, string(body), `"content":"Hello, world! Bye!"`)
+	assert.True(t, strings.HasSuffix(string(body), "id: 18\nevent: end\ndata: {\"content\":\"\",\"offset\":18}\n\n"))
+}
+
+func TestLogTailer_serveJobLog_NotFound(t *testing.T) {
+	tailer := NewLogTailer(1024, time.Minute)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/worker/jobs/4/log", nil)
+	tailer.serveJobLog(w, req, 4)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -1,10 +1,14 @@
 package worker
 
 import (
+	"fmt"
 	"io"
+	"regexp"
 	"time"
 
 	gocontext "context"
+
+	"github.com/sirupsen/logrus"
 )
 
 var (
@@ -31,6 +35,8 @@ var (
 	// out the worst-case logs to be quite unlikely, so I'm willing to live
 	// with that. --Sarah
 	LogChunkSize = 1653
+
+	travisFoldRegexp = regexp.MustCompile(`travis_fold:(start|end):([^\s\x1b]+)`)
 )
 
 // JobStartedMeta is metadata that is useful for computing time to first
@@ -55,3 +61,102 @@ type LogWriter interface {
 	SetCancelFunc(gocontext.CancelFunc)
 	MaxLengthReached() bool
 }
+
+// logWriterLimits holds the log silence timer and the log length bookkeeping
+// shared by the LogWriter implementations. It also keeps track of the folds
+// that are currently open, so that a termination message isn't hidden inside
+// a collapsed fold.
+type logWriterLimits struct {
+	cancel gocontext.CancelFunc
+
+	bytesWritten     int
+	maxLength        int
+	maxLengthReached bool
+
+	openFolds []string
+
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func newLogWriterLimits(timeout time.Duration) logWriterLimits {
+	return logWriterLimits{
+		timer:   time.NewTimer(time.Hour),
+		timeout: timeout,
+	}
+}
+
+func (l *logWriterLimits) Timeout() <-chan time.Time {
+	return l.timer.C
+}
+
+func (l *logWriterLimits) SetMaxLogLength(bytes int) {
+	l.maxLength = bytes
+}
+
+func (l *logWriterLimits) SetCancelFunc(cancel gocontext.CancelFunc) {
+	l.cancel = cancel
+}
+
+func (l *logWriterLimits) MaxLengthReached() bool {
+	return l.maxLengthReached
+}
+
+// accept resets the log silence timer and adds p to the number of bytes
+// written. If that takes the log past the maximum length, the cancel function
+// is called and accept returns false, in which case p must not be written.
+func (l *logWriterLimits) accept(logger *logrus.Entry, p []byte) bool {
+	l.timer.Reset(l.timeout)
+
+	l.bytesWritten += len(p)
+	if l.bytesWritten > l.maxLength {
+		logger.Info("wrote past maximum log length - cancelling context")
+		l.maxLengthReached = true
+		if l.cancel == nil {
+			logger.Error("cancel function does not exist")
+		} else {
+			l.cancel()
+		}
+		return false
+	}
+
+	l.trackFolds(p)
+	return true
+}
+
+func (l *logWriterLimits) stopTimer() {
+	l.timer.Stop()
+}
+
+func (l *logWriterLimits) trackFolds(p []byte) {
+	for _, match := range travisFoldRegexp.FindAllSubmatch(p, -1) {
+		name := string(match[2])
+		if string(match[1]) == "start" {
+			l.openFolds = append(l.openFolds, name)
+			continue
+		}
+
+		for i := len(l.openFolds) - 1; i >= 0; i-- {
+			if l.openFolds[i] == name {
+				l.openFolds = append(l.openFolds[:i], l.openFolds[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// closeOpenFolds returns p prefixed with the end markers of all folds that
+// are still open, innermost first.
+func (l *logWriterLimits) closeOpenFolds(p []byte) []byte {
+	if len(l.openFolds) == 0 {
+		return p
+	}
+
+	closed := []byte("\n")
+	for i := len(l.openFolds) - 1; i >= 0; i-- {
+		closed = append(closed, []byte(fmt.Sprintf(travisFoldEnd, l.openFolds[i]))...)
+	}
+	l.openFolds = nil
+
+	return append(closed, p...)
+}
@@ -0,0 +1,240 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+	jobStepDurationName = "travis_worker_job_step_duration_seconds"
+)
+
+var (
+	prometheusNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+	prometheusQuantiles = []float64{0.5, 0.75, 0.9, 0.95, 0.99}
+
+	// JobStepBuckets are the upper bounds in seconds of the buckets used for
+	// the per-job step duration histograms.
+	JobStepBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800, 3600, 7200}
+
+	jobSteps = &stepHistograms{histograms: map[string]*stepHistogram{}}
+)
+
+// TimeJobStep records the total time a single job spent in the given step in
+// the step duration histograms exposed by PrometheusHandler.
+func TimeJobStep(step string, duration time.Duration) {
+	jobSteps.observe(step, duration.Seconds())
+}
+
+type stepHistograms struct {
+	mutex      sync.Mutex
+	histograms map[string]*stepHistogram
+}
+
+type stepHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (s *stepHistograms) observe(step string, value float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	h, ok := s.histograms[step]
+	if !ok {
+		h = &stepHistogram{counts: make([]uint64, len(JobStepBuckets))}
+		s.histograms[step] = h
+	}
+
+	for i, upper := range JobStepBuckets {
+		if value <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// PrometheusHandler returns an http.Handler that serves all metrics in the
+// given registry, plus the per-job step duration histograms, in the
+// Prometheus text exposition format. Meters become counters, gauges stay
+// gauges, and timers become summaries in seconds. The given labels are added
+// to every sample.
+func PrometheusHandler(registry metrics.Registry, labels map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", prometheusContentType)
+
+		bw := bufio.NewWriter(w)
+		writePrometheus(bw, registry, labels)
+		_ = bw.Flush()
+	})
+}
+
+func writePrometheus(w *bufio.Writer, registry metrics.Registry, labels map[string]string) {
+	constLabels := prometheusLabels(labels)
+
+	all := map[string]interface{}{}
+	registry.Each(func(name string, metric interface{}) {
+		all[name] = metric
+	})
+
+	names := []string{}
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		promName := PrometheusName(name)
+
+		switch metric := all[name].(type) {
+		case metrics.Meter:
+			writePrometheusHeader(w, promName+"_total", name, "counter")
+			writePrometheusSample(w, promName+"_total", constLabels, "", float64(metric.Count()))
+		case metrics.Counter:
+			writePrometheusHeader(w, promName+"_total", name, "counter")
+			writePrometheusSample(w, promName+"_total", constLabels, "", float64(metric.Count()))
+		case metrics.Gauge:
+			writePrometheusHeader(w, promName, name, "gauge")
+			writePrometheusSample(w, promName, constLabels, "", float64(metric.Value()))
+		case metrics.GaugeFloat64:
+			writePrometheusHeader(w, promName, name, "gauge")
+			writePrometheusSample(w, promName, constLabels, "", metric.Value())
+		case metrics.Timer:
+			snapshot := metric.Snapshot()
+			promName += "_seconds"
+			writePrometheusHeader(w, promName, name, "summary")
+			for i, value := range snapshot.Percentiles(prometheusQuantiles) {
+				writePrometheusSample(w, promName, constLabels,
+					prometheusLabel("quantile", formatPrometheusValue(prometheusQuantiles[i])),
+					value/float64(time.Second))
+			}
+			writePrometheusSample(w, promName+"_sum", constLabels, "", float64(snapshot.Sum())/float64(time.Second))
+			writePrometheusSample(w, promName+"_count", constLabels, "", float64(snapshot.Count()))
+		case metrics.Histogram:
+			snapshot := metric.Snapshot()
+			writePrometheusHeader(w, promName, name, "summary")
+			for i, value := range snapshot.Percentiles(prometheusQuantiles) {
+				writePrometheusSample(w, promName, constLabels,
+					prometheusLabel("quantile", formatPrometheusValue(prometheusQuantiles[i])),
+					value)
+			}
+			writePrometheusSample(w, promName+"_sum", constLabels, "", float64(snapshot.Sum()))
+			writePrometheusSample(w, promName+"_count", constLabels, "", float64(snapshot.Count()))
+		}
+	}
+
+	writeJobStepHistograms(w, constLabels)
+}
+
+func writeJobStepHistograms(w *bufio.Writer, constLabels string) {
+	jobSteps.mutex.Lock()
+	defer jobSteps.mutex.Unlock()
+
+	if len(jobSteps.histograms) == 0 {
+		return
+	}
+
+	steps := []string{}
+	for step := range jobSteps.histograms {
+		steps = append(steps, step)
+	}
+	sort.Strings(steps)
+
+	writePrometheusHeader(w, jobStepDurationName, "time spent by each job in a step", "histogram")
+	for _, step := range steps {
+		h := jobSteps.histograms[step]
+		stepLabel := prometheusLabel("step", step)
+
+		for i, upper := range JobStepBuckets {
+			writePrometheusSample(w, jobStepDurationName+"_bucket", constLabels,
+				stepLabel+","+prometheusLabel("le", formatPrometheusValue(upper)),
+				float64(h.counts[i]))
+		}
+		writePrometheusSample(w, jobStepDurationName+"_bucket", constLabels,
+			stepLabel+","+prometheusLabel("le", "+Inf"), float64(h.count))
+		writePrometheusSample(w, jobStepDurationName+"_sum", constLabels, stepLabel, h.sum)
+		writePrometheusSample(w, jobStepDurationName+"_count", constLabels, stepLabel, float64(h.count))
+	}
+}
+
+// PrometheusName converts a dotted go-metrics name such as
+// "travis.worker.job.start_time" into a valid Prometheus metric name such as
+// "travis_worker_job_start_time".
+func PrometheusName(name string) string {
+	name = prometheusNameRegexp.ReplaceAllString(name, "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+func writePrometheusHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func writePrometheusSample(w *bufio.Writer, name, constLabels, extraLabels string, value float64) {
+	labels := constLabels
+	if extraLabels != "" {
+		if labels != "" {
+			labels += ","
+		}
+		labels += extraLabels
+	}
+
+	if labels == "" {
+		fmt.Fprintf(w, "%s %s\n", name, formatPrometheusValue(value))
+		return
+	}
+
+	fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatPrometheusValue(value))
+}
+
+func prometheusLabels(labels map[string]string) string {
+	keys := []string{}
+	for key, value := range labels {
+		if value == "" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := []string{}
+	for _, key := range keys {
+		pairs = append(pairs, prometheusLabel(PrometheusName(key), labels[key]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func prometheusLabel(key, value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return fmt.Sprintf(`%s="%s"`, key, value)
+}
+
+func formatPrometheusValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
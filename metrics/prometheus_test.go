@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusName(t *testing.T) {
+	assert.Equal(t, "travis_worker_job_start_time", PrometheusName("travis.worker.job.start_time"))
+	assert.Equal(t, "travis_worker_vm_provider_gce_rate_limit", PrometheusName("travis.worker.vm.provider.gce.rate-limit"))
+	assert.Equal(t, "_1_up", PrometheusName("1.up"))
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	registry := metrics.NewRegistry()
+	metrics.GetOrRegisterMeter("travis.worker.job.requeue", registry).Mark(3)
+	metrics.GetOrRegisterGauge("travis.worker.goroutines", registry).Update(42)
+	metrics.GetOrRegisterTimer("travis.worker.job.start_time", registry).Update(2 * time.Second)
+
+	TimeJobStep("boot_poll_ssh", 7*time.Second)
+
+	w := httptest.NewRecorder()
+	PrometheusHandler(registry, map[string]string{
+		"provider": "fake",
+		"queue":    "builds.test",
+		"infra":    "",
+	}).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := w.Body.String()
+	assert.Equal(t, prometheusContentType, w.Header().Get("Content-Type"))
+
+	assert.Contains(t, body, "# TYPE travis_worker_job_requeue_total counter\n")
+	assert.Contains(t, body, `travis_worker_job_requeue_total{provider="fake",queue="builds.test"} 3`+"\n")
+	assert.Contains(t, body, "# TYPE travis_worker_goroutines gauge\n")
+	assert.Contains(t, body, `travis_worker_goroutines{provider="fake",queue="builds.test"} 42`+"\n")
+	assert.Contains(t, body, "# TYPE travis_worker_job_start_time_seconds summary\n")
+	assert.Contains(t, body, `travis_worker_job_start_time_seconds{provider="fake",queue="builds.test",quantile="0.5"} 2`+"\n")
+	assert.Contains(t, body, `travis_worker_job_start_time_seconds_count{provider="fake",queue="builds.test"} 1`+"\n")
+	assert.Contains(t, body, "# TYPE travis_worker_job_step_duration_seconds histogram\n")
+	assert.Contains(t, body, `travis_worker_job_step_duration_seconds_bucket{provider="fake",queue="builds.test",step="boot_poll_ssh",le="5"} 0`+"\n")
+	assert.Contains(t, body, `travis_worker_job_step_duration_seconds_bucket{provider="fake",queue="builds.test",step="boot_poll_ssh",le="10"} 1`+"\n")
+	assert.Contains(t, body, `travis_worker_job_step_duration_seconds_bucket{provider="fake",queue="builds.test",step="boot_poll_ssh",le="+Inf"} 1`+"\n")
+	assert.Contains(t, body, `travis_worker_job_step_duration_seconds_sum{provider="fake",queue="builds.test",step="boot_poll_ssh"} 7`+"\n")
+	assert.NotContains(t, body, "infra=")
+}
+
+func TestPrometheusLabel_Escapes(t *testing.T) {
+	assert.Equal(t, `repo="a\"b\\c\nd"`, prometheusLabel("repo", "a\"b\\c\nd"))
+}
@@ -0,0 +1,324 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rcrowley/go-metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// statsdUDPPacketSize keeps UDP packets below the common 1500 byte MTU.
+	statsdUDPPacketSize = 1432
+	// statsdUDSPacketSize is the largest datagram the DogStatsD agent accepts
+	// on its Unix domain socket by default.
+	statsdUDSPacketSize = 8192
+	// statsdErrorLogInterval is the shortest time between two logged flush
+	// errors, so that an unreachable server doesn't flood the logs.
+	statsdErrorLogInterval = time.Minute
+)
+
+// StatsDConfig configures a StatsD reporter.
+type StatsDConfig struct {
+	// Address is where metrics are sent to, either as "host:port" or
+	// "udp://host:port" for UDP, or as "unix:///path/to/socket" for a Unix
+	// domain datagram socket.
+	Address string
+
+	// Prefix is prepended to every metric name, separated by a dot.
+	Prefix string
+
+	// Tags are added to every metric. They are only sent when DogStatsD is
+	// true, as plain StatsD has no notion of tags.
+	Tags      map[string]string
+	DogStatsD bool
+
+	// Percentiles are reported for every timer and histogram, e.g. 0.95 is
+	// reported as "<name>.p95".
+	Percentiles []float64
+
+	// Logger is used to log flush errors, at most once every minute. The
+	// standard logger is used if it's nil.
+	Logger *logrus.Entry
+}
+
+// StatsD flushes all metrics in the given registry to a StatsD or DogStatsD
+// server at the given interval, and blocks forever. Meters and counters are
+// sent as the difference since the last flush, gauges as they are, and timers
+// as count, mean, max, and percentiles in milliseconds.
+func StatsD(registry metrics.Registry, interval time.Duration, cfg StatsDConfig) error {
+	reporter, err := NewStatsDReporter(registry, cfg)
+	if err != nil {
+		return err
+	}
+	defer reporter.Close()
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logrus.NewEntry(logrus.StandardLogger())
+	}
+	errLogger := &statsdErrorLogger{logger: logger, interval: statsdErrorLogInterval, now: time.Now}
+
+	for range time.Tick(interval) {
+		errLogger.log(reporter.Flush())
+	}
+
+	return nil
+}
+
+// statsdErrorLogger logs flush errors, skipping the errors that occur within
+// interval of the last logged one and counting them in the next one logged.
+type statsdErrorLogger struct {
+	logger   *logrus.Entry
+	interval time.Duration
+	now      func() time.Time
+
+	lastLogged time.Time
+	skipped    int
+}
+
+func (l *statsdErrorLogger) log(err error) {
+	if err == nil {
+		return
+	}
+
+	now := l.now()
+	if !l.lastLogged.IsZero() && now.Sub(l.lastLogged) < l.interval {
+		l.skipped++
+		return
+	}
+
+	l.logger.WithFields(logrus.Fields{
+		"err":     err,
+		"skipped": l.skipped,
+	}).Error("couldn't flush metrics to statsd")
+
+	l.lastLogged = now
+	l.skipped = 0
+}
+
+// StatsDReporter sends the metrics in a registry to a StatsD or DogStatsD
+// server every time Flush is called.
+type StatsDReporter struct {
+	registry   metrics.Registry
+	cfg        StatsDConfig
+	network    string
+	address    string
+	conn       net.Conn
+	packetSize int
+	tags       string
+
+	lastCounts map[string]int64
+}
+
+// NewStatsDReporter creates a StatsDReporter for the given registry and
+// connects to the configured address.
+func NewStatsDReporter(registry metrics.Registry, cfg StatsDConfig) (*StatsDReporter, error) {
+	network, address, err := parseStatsDAddress(cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't connect to statsd")
+	}
+
+	packetSize := statsdUDPPacketSize
+	if network == "unixgram" {
+		packetSize = statsdUDSPacketSize
+	}
+
+	if cfg.Percentiles == nil {
+		cfg.Percentiles = []float64{0.5, 0.95, 0.99}
+	}
+
+	return &StatsDReporter{
+		registry:   registry,
+		cfg:        cfg,
+		network:    network,
+		address:    address,
+		conn:       conn,
+		packetSize: packetSize,
+		tags:       statsdTags(cfg),
+		lastCounts: map[string]int64{},
+	}, nil
+}
+
+func parseStatsDAddress(address string) (string, string, error) {
+	if !strings.Contains(address, "://") {
+		return "udp", address, nil
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", errors.Wrap(err, "couldn't parse statsd address")
+	}
+
+	switch u.Scheme {
+	case "udp":
+		return "udp", u.Host, nil
+	case "unix":
+		return "unixgram", u.Path, nil
+	default:
+		return "", "", fmt.Errorf("unknown statsd address scheme %q", u.Scheme)
+	}
+}
+
+func statsdTags(cfg StatsDConfig) string {
+	if !cfg.DogStatsD || len(cfg.Tags) == 0 {
+		return ""
+	}
+
+	tags := []string{}
+	for key, value := range cfg.Tags {
+		if value == "" {
+			continue
+		}
+		tags = append(tags, key+":"+value)
+	}
+	if len(tags) == 0 {
+		return ""
+	}
+
+	sort.Strings(tags)
+	return "|#" + strings.Join(tags, ",")
+}
+
+// Close closes the connection to the StatsD server.
+func (r *StatsDReporter) Close() error {
+	return r.conn.Close()
+}
+
+// Flush sends the current value of every metric in the registry.
+func (r *StatsDReporter) Flush() error {
+	lines := []string{}
+	r.registry.Each(func(name string, metric interface{}) {
+		lines = append(lines, r.lines(name, metric)...)
+	})
+	sort.Strings(lines)
+
+	var firstErr error
+	packet := &bytes.Buffer{}
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > r.packetSize {
+			if err := r.send(packet); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		if err := r.send(packet); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func (r *StatsDReporter) send(packet *bytes.Buffer) error {
+	defer packet.Reset()
+
+	_, err := r.conn.Write(packet.Bytes())
+	if err != nil && r.network == "unixgram" {
+		// the socket is gone if the agent was restarted, so connect to the
+		// new one and try again
+		err = r.redial()
+		if err == nil {
+			_, err = r.conn.Write(packet.Bytes())
+		}
+	}
+
+	return errors.Wrap(err, "couldn't send metrics to statsd")
+}
+
+func (r *StatsDReporter) redial() error {
+	conn, err := net.Dial(r.network, r.address)
+	if err != nil {
+		return err
+	}
+
+	_ = r.conn.Close()
+	r.conn = conn
+	return nil
+}
+
+func (r *StatsDReporter) lines(name string, metric interface{}) []string {
+	switch metric := metric.(type) {
+	case metrics.Meter:
+		return []string{r.line(name, r.delta(name, metric.Count()), "c")}
+	case metrics.Counter:
+		return []string{r.line(name, r.delta(name, metric.Count()), "c")}
+	case metrics.Gauge:
+		return []string{r.line(name, metric.Value(), "g")}
+	case metrics.GaugeFloat64:
+		return []string{r.line(name, metric.Value(), "g")}
+	case metrics.Timer:
+		snapshot := metric.Snapshot()
+		ms := float64(time.Millisecond)
+		lines := []string{
+			r.line(name+".count", r.delta(name, snapshot.Count()), "c"),
+			r.line(name+".mean", snapshot.Mean()/ms, "g"),
+			r.line(name+".max", float64(snapshot.Max())/ms, "g"),
+		}
+		for i, value := range snapshot.Percentiles(r.cfg.Percentiles) {
+			lines = append(lines, r.line(name+statsdPercentileSuffix(r.cfg.Percentiles[i]), value/ms, "g"))
+		}
+		return lines
+	case metrics.Histogram:
+		snapshot := metric.Snapshot()
+		lines := []string{
+			r.line(name+".count", r.delta(name, snapshot.Count()), "c"),
+			r.line(name+".mean", snapshot.Mean(), "g"),
+			r.line(name+".max", snapshot.Max(), "g"),
+		}
+		for i, value := range snapshot.Percentiles(r.cfg.Percentiles) {
+			lines = append(lines, r.line(name+statsdPercentileSuffix(r.cfg.Percentiles[i]), value, "g"))
+		}
+		return lines
+	}
+
+	return nil
+}
+
+// delta returns the difference between the given count and the count at the
+// last flush, as StatsD counters are summed up by the server.
+func (r *StatsDReporter) delta(name string, count int64) int64 {
+	delta := count - r.lastCounts[name]
+	r.lastCounts[name] = count
+	return delta
+}
+
+func (r *StatsDReporter) line(name string, value interface{}, kind string) string {
+	if r.cfg.Prefix != "" {
+		name = r.cfg.Prefix + "." + name
+	}
+
+	switch value := value.(type) {
+	case float64:
+		return fmt.Sprintf("%s:%s|%s%s", statsdName(name), strconv.FormatFloat(value, 'f', -1, 64), kind, r.tags)
+	default:
+		return fmt.Sprintf("%s:%v|%s%s", statsdName(name), value, kind, r.tags)
+	}
+}
+
+func statsdName(name string) string {
+	return strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", "\n", "_").Replace(name)
+}
+
+func statsdPercentileSuffix(p float64) string {
+	percentile := strings.TrimRight(strings.TrimRight(fmt.Sprintf("%f", p*100), "0"), ".")
+	return ".p" + strings.Replace(percentile, ".", "_", -1)
+}
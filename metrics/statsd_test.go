@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func readStatsDPacket(t *testing.T, conn net.PacketConn) []string {
+	buf := make([]byte, statsdUDSPacketSize)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(string(buf[:n]), "\n")
+}
+
+func TestStatsDReporter_Flush(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	registry := metrics.NewRegistry()
+	metrics.GetOrRegisterMeter("travis.worker.job.requeue", registry).Mark(3)
+	metrics.GetOrRegisterGauge("travis.worker.goroutines", registry).Update(42)
+	metrics.GetOrRegisterTimer("travis.worker.job.start_time", registry).Update(1500 * time.Millisecond)
+
+	reporter, err := NewStatsDReporter(registry, StatsDConfig{
+		Address:   "udp://" + conn.LocalAddr().String(),
+		Prefix:    "dev",
+		DogStatsD: true,
+		Tags: map[string]string{
+			"site":  "org",
+			"queue": "builds.test",
+			"infra": "",
+		},
+	})
+	assert.Nil(t, err)
+	defer reporter.Close()
+
+	assert.Nil(t, reporter.Flush())
+	lines := readStatsDPacket(t, conn)
+
+	assert.Contains(t, lines, "dev.travis.worker.job.requeue:3|c|#queue:builds.test,site:org")
+	assert.Contains(t, lines, "dev.travis.worker.goroutines:42|g|#queue:builds.test,site:org")
+	assert.Contains(t, lines, "dev.travis.worker.job.start_time.count:1|c|#queue:builds.test,site:org")
+	assert.Contains(t, lines, "dev.travis.worker.job.start_time.p95:1500|g|#queue:builds.test,site:org")
+
+	metrics.GetOrRegisterMeter("travis.worker.job.requeue", registry).Mark(1)
+	assert.Nil(t, reporter.Flush())
+	lines = readStatsDPacket(t, conn)
+
+	assert.Contains(t, lines, "dev.travis.worker.job.requeue:1|c|#queue:builds.test,site:org")
+	assert.Contains(t, lines, "dev.travis.worker.job.start_time.count:0|c|#queue:builds.test,site:org")
+}
+
+func TestStatsDReporter_PlainStatsDHasNoTags(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	registry := metrics.NewRegistry()
+	metrics.GetOrRegisterGauge("travis.worker.goroutines", registry).Update(42)
+
+	reporter, err := NewStatsDReporter(registry, StatsDConfig{
+		Address: conn.LocalAddr().String(),
+		Tags:    map[string]string{"site": "org"},
+	})
+	assert.Nil(t, err)
+	defer reporter.Close()
+
+	assert.Nil(t, reporter.Flush())
+	assert.Equal(t, []string{"travis.worker.goroutines:42|g"}, readStatsDPacket(t, conn))
+}
+
+func TestStatsDReporter_FlushRedialsUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "travis-worker-statsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "dsd.socket")
+	conn, err := net.ListenPacket("unixgram", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := metrics.NewRegistry()
+	metrics.GetOrRegisterGauge("travis.worker.goroutines", registry).Update(42)
+
+	reporter, err := NewStatsDReporter(registry, StatsDConfig{Address: "unix://" + socket})
+	assert.Nil(t, err)
+	defer reporter.Close()
+
+	assert.Nil(t, reporter.Flush())
+	assert.Equal(t, []string{"travis.worker.goroutines:42|g"}, readStatsDPacket(t, conn))
+
+	// the agent is restarted, which replaces its socket
+	conn.Close()
+	os.Remove(socket)
+	conn, err = net.ListenPacket("unixgram", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	assert.Nil(t, reporter.Flush())
+	assert.Equal(t, []string{"travis.worker.goroutines:42|g"}, readStatsDPacket(t, conn))
+}
+
+func TestStatsDErrorLogger(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	now := time.Now()
+	l := &statsdErrorLogger{
+		logger:   logrus.NewEntry(logger),
+		interval: time.Minute,
+		now:      func() time.Time { return now },
+	}
+
+	l.log(nil)
+	assert.Len(t, hook.AllEntries(), 0)
+
+	l.log(errors.New("connection refused"))
+	assert.Len(t, hook.AllEntries(), 1)
+
+	now = now.Add(30 * time.Second)
+	l.log(errors.New("connection refused"))
+	l.log(errors.New("connection refused"))
+	assert.Len(t, hook.AllEntries(), 1)
+
+	now = now.Add(time.Minute)
+	l.log(errors.New("connection refused"))
+	assert.Len(t, hook.AllEntries(), 2)
+	assert.Equal(t, 2, hook.LastEntry().Data["skipped"])
+}
+
+func TestParseStatsDAddress(t *testing.T) {
+	network, address, err := parseStatsDAddress("unix:///var/run/datadog/dsd.socket")
+	assert.Nil(t, err)
+	assert.Equal(t, "unixgram", network)
+	assert.Equal(t, "/var/run/datadog/dsd.socket", address)
+
+	_, _, err = parseStatsDAddress("tcp://localhost:8125")
+	assert.NotNil(t, err)
+
+	assert.Equal(t, ".p99_9", statsdPercentileSuffix(0.999))
+}
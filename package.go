@@ -35,6 +35,20 @@ func writeFoldEnd(w io.Writer, name string, b []byte) (int, error) {
 	return w.Write(folded)
 }
 
+// foldEnder returns a func that writes the end of the named fold the first
+// time it's called, so that a fold can be ended early, such as before the log
+// is closed, and again by a deferred call.
+func foldEnder(w io.Writer, name string) func() {
+	ended := false
+	return func() {
+		if ended {
+			return
+		}
+		ended = true
+		writeFoldEnd(w, name, []byte(""))
+	}
+}
+
 func stringSplitSpace(s string) []string {
 	parts := []string{}
 	for _, part := range strings.Split(s, " ") {
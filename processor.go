@@ -1,7 +1,11 @@
 package worker
 
 import (
+	"reflect"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	gocontext "context"
 
@@ -10,15 +14,18 @@ import (
 	"github.com/travis-ci/worker/backend"
 	"github.com/travis-ci/worker/config"
 	"github.com/travis-ci/worker/context"
+	"github.com/travis-ci/worker/metrics"
 	"go.opencensus.io/trace"
 )
 
 // A Processor gets jobs off the job queue and coordinates running it with other
 // components.
 type Processor struct {
-	ID       string
-	hostname string
-	config   *config.Config
+	ID        string
+	hostname  string
+	config    *config.Config
+	logTailer *LogTailer
+	jobEvents *JobEventEmitter
 
 	ctx                     gocontext.Context
 	buildJobsChan           <-chan Job
@@ -32,21 +39,51 @@ type Processor struct {
 	terminate  gocontext.CancelFunc
 	shutdownAt time.Time
 
+	// intakeResumed is non-nil while intake is paused, and is closed when
+	// intake is resumed. intakePaused is closed when intake is paused, and
+	// replaced when intake is resumed.
+	intakeLock    sync.Mutex
+	intakeResumed chan struct{}
+	intakePaused  chan struct{}
+
+	currentJobLock sync.Mutex
+	currentJob     *ProcessorJobStatus
+
+	// pendingConfig and pendingProvider are set by Reconfigure, and replace
+	// config and provider before the next job is processed. activeProvider is
+	// the provider used by the job being processed, if any.
+	reconfigureLock sync.Mutex
+	pendingConfig   *config.Config
+	pendingProvider backend.Provider
+	activeProvider  backend.Provider
+
 	// ProcessedCount contains the number of jobs that has been processed
 	// by this Processor. This value should not be modified outside of the
 	// Processor.
 	ProcessedCount int
 
 	// CurrentStatus contains the current status of the processor, and can
-	// be one of "new", "waiting", "processing" or "done".
+	// be one of "new", "waiting", "paused", "processing" or "done".
 	CurrentStatus string
 
 	// LastJobID contains the ID of the last job the processor processed.
 	LastJobID uint64
 }
 
+// ProcessorJobStatus describes the job a Processor is currently working on.
+type ProcessorJobStatus struct {
+	ID         uint64    `json:"id"`
+	Repository string    `json:"repo"`
+	Stage      string    `json:"stage"`
+	StartedAt  time.Time `json:"started_at"`
+	InstanceID string    `json:"instance_id,omitempty"`
+	ImageName  string    `json:"image,omitempty"`
+}
+
 type ProcessorConfig struct {
-	Config *config.Config
+	Config    *config.Config
+	LogTailer *LogTailer
+	JobEvents *JobEventEmitter
 }
 
 // NewProcessor creates a new processor that will run the build jobs on the
@@ -68,9 +105,11 @@ func NewProcessor(ctx gocontext.Context, hostname string, queue JobQueue,
 	}
 
 	return &Processor{
-		ID:       processorID,
-		hostname: hostname,
-		config:   config.Config,
+		ID:        processorID,
+		hostname:  hostname,
+		config:    config.Config,
+		logTailer: config.LogTailer,
+		jobEvents: config.JobEvents,
 
 		ctx:                     ctx,
 		buildJobsChan:           buildJobsChan,
@@ -108,6 +147,29 @@ func (p *Processor) Run() {
 		default:
 		}
 
+		if resumed := p.intakePausedChan(); resumed != nil {
+			logger.Debug("intake is paused, waiting for resume")
+			p.CurrentStatus = "paused"
+
+			select {
+			case <-p.ctx.Done():
+				logger.Info("processor is done, terminating")
+				return
+			case <-p.graceful:
+				logger.WithField("shutdown_duration_s", time.Since(p.shutdownAt).Seconds()).Info("processor is done, terminating")
+				p.terminate()
+				return
+			case <-resumed:
+				p.CurrentStatus = "waiting"
+				continue
+			}
+		}
+
+		paused := p.intakePausedNotifyChan()
+		if paused == nil {
+			continue
+		}
+
 		select {
 		case <-p.ctx.Done():
 			logger.Info("processor is done, terminating")
@@ -116,12 +178,22 @@ func (p *Processor) Run() {
 			logger.WithField("shutdown_duration_s", time.Since(p.shutdownAt).Seconds()).Info("processor is done, terminating")
 			p.terminate()
 			return
+		case <-paused:
+			continue
 		case buildJob, ok := <-p.buildJobsChan:
 			if !ok {
 				p.terminate()
 				return
 			}
 
+			if p.intakePausedChan() != nil {
+				// intake was paused while the job was being received
+				p.handBack(buildJob)
+				continue
+			}
+
+			p.applyPendingConfig()
+
 			buildJob.StartAttributes().ProgressType = p.config.ProgressType
 
 			jobID := buildJob.Payload().Job.ID
@@ -148,6 +220,7 @@ func (p *Processor) Run() {
 			p.CurrentStatus = "processing"
 
 			p.process(ctx, buildJob)
+			p.releaseProvider()
 
 			logger.WithFields(logrus.Fields{
 				"job_id": jobID,
@@ -182,11 +255,168 @@ func (p *Processor) Terminate() {
 	p.terminate()
 }
 
+// PauseIntake tells the processor to stop picking up new jobs once it is done
+// with the job it is currently processing, if any, until ResumeIntake is
+// called.
+func (p *Processor) PauseIntake() {
+	p.intakeLock.Lock()
+	defer p.intakeLock.Unlock()
+
+	if p.intakeResumed == nil {
+		p.intakeResumed = make(chan struct{})
+		if p.intakePaused != nil {
+			close(p.intakePaused)
+			p.intakePaused = nil
+		}
+	}
+}
+
+// ResumeIntake tells a paused processor to start picking up jobs again.
+func (p *Processor) ResumeIntake() {
+	p.intakeLock.Lock()
+	defer p.intakeLock.Unlock()
+
+	if p.intakeResumed != nil {
+		close(p.intakeResumed)
+		p.intakeResumed = nil
+	}
+}
+
+func (p *Processor) intakePausedChan() chan struct{} {
+	p.intakeLock.Lock()
+	defer p.intakeLock.Unlock()
+
+	return p.intakeResumed
+}
+
+// intakePausedNotifyChan returns a channel that is closed when intake is
+// paused, or nil if it is paused already.
+func (p *Processor) intakePausedNotifyChan() chan struct{} {
+	p.intakeLock.Lock()
+	defer p.intakeLock.Unlock()
+
+	if p.intakeResumed != nil {
+		return nil
+	}
+	if p.intakePaused == nil {
+		p.intakePaused = make(chan struct{})
+	}
+	return p.intakePaused
+}
+
+// jobNacker is implemented by jobs that can be handed back to their queue
+// without being processed.
+type jobNacker interface {
+	Nack(gocontext.Context) error
+}
+
+// handBack returns a job the processor received while intake was paused to
+// its queue, or requeues it if the queue doesn't support that.
+func (p *Processor) handBack(buildJob Job) {
+	ctx := context.FromJobID(p.ctx, buildJob.Payload().Job.ID)
+	logger := context.LoggerFromContext(ctx).WithField("self", "processor")
+
+	var err error
+	if nacker, ok := buildJob.(jobNacker); ok {
+		logger.Info("intake is paused, handing job back to the queue")
+		err = nacker.Nack(ctx)
+	} else {
+		logger.Info("intake is paused, requeueing job")
+		err = buildJob.Requeue(ctx)
+	}
+	if err != nil {
+		logger.WithField("err", err).Error("couldn't hand job back")
+	}
+}
+
+// Reconfigure tells the processor to use the given config and provider for
+// the jobs it picks up from now on. The job it is currently processing, if
+// any, is not affected.
+func (p *Processor) Reconfigure(cfg *config.Config, provider backend.Provider) {
+	p.reconfigureLock.Lock()
+	defer p.reconfigureLock.Unlock()
+
+	p.pendingConfig = cfg
+	p.pendingProvider = provider
+}
+
+func (p *Processor) applyPendingConfig() {
+	p.reconfigureLock.Lock()
+	defer p.reconfigureLock.Unlock()
+
+	if p.pendingConfig != nil {
+		p.config = p.pendingConfig
+		p.pendingConfig = nil
+	}
+	if p.pendingProvider != nil {
+		p.provider = p.pendingProvider
+		p.pendingProvider = nil
+	}
+	p.activeProvider = p.provider
+}
+
+func (p *Processor) releaseProvider() {
+	p.reconfigureLock.Lock()
+	defer p.reconfigureLock.Unlock()
+
+	p.activeProvider = nil
+}
+
+// usesProvider returns true if the processor is processing a job with the
+// given provider.
+func (p *Processor) usesProvider(provider backend.Provider) bool {
+	p.reconfigureLock.Lock()
+	defer p.reconfigureLock.Unlock()
+
+	return p.activeProvider == provider
+}
+
+// CurrentJob returns a snapshot of the job the processor is currently working
+// on, or nil if it isn't processing a job.
+func (p *Processor) CurrentJob() *ProcessorJobStatus {
+	p.currentJobLock.Lock()
+	defer p.currentJobLock.Unlock()
+
+	if p.currentJob == nil {
+		return nil
+	}
+
+	current := *p.currentJob
+	return &current
+}
+
+func (p *Processor) setCurrentJob(current *ProcessorJobStatus) {
+	p.currentJobLock.Lock()
+	defer p.currentJobLock.Unlock()
+
+	p.currentJob = current
+}
+
+func (p *Processor) updateCurrentJob(stage string, state multistep.StateBag) {
+	p.currentJobLock.Lock()
+	defer p.currentJobLock.Unlock()
+
+	if p.currentJob == nil {
+		return
+	}
+
+	if stage != "" {
+		p.currentJob.Stage = stage
+	}
+
+	if instance, ok := state.Get("instance").(backend.Instance); ok {
+		p.currentJob.InstanceID = instance.ID()
+		p.currentJob.ImageName = instance.ImageName()
+	}
+}
+
 func (p *Processor) process(ctx gocontext.Context, buildJob Job) {
 	ctx = buildJob.SetupContext(ctx)
+	buildJob = p.jobEvents.wrapJob(buildJob)
 	ctx = context.WithTimings(ctx)
+	ctx = context.FromBackend(ctx, p.config.ProviderName)
 
-	ctx, span := trace.StartSpan(ctx, "ProcessorRun")
+	ctx, span := startJobSpan(ctx, "ProcessorRun")
 	defer span.End()
 
 	span.AddAttributes(
@@ -210,6 +440,13 @@ func (p *Processor) process(ctx gocontext.Context, buildJob Job) {
 		"self":   "processor",
 	})
 
+	p.setCurrentJob(&ProcessorJobStatus{
+		ID:         buildJob.Payload().Job.ID,
+		Repository: buildJob.Payload().Repository.Slug,
+		StartedAt:  time.Now().UTC(),
+	})
+	defer p.setCurrentJob(nil)
+
 	logTimeout := p.config.LogTimeout
 	if buildJob.Payload().Timeouts.LogSilence != 0 {
 		logTimeout = time.Duration(buildJob.Payload().Timeouts.LogSilence) * time.Second
@@ -231,15 +468,18 @@ func (p *Processor) process(ctx gocontext.Context, buildJob Job) {
 		&stepOpenLogWriter{
 			maxLogLength:      p.config.MaxLogLength,
 			defaultLogTimeout: p.config.LogTimeout,
+			logTailer:         p.logTailer,
 		},
 		&stepCheckCancellation{},
 		&stepStartInstance{
 			provider:     p.provider,
 			startTimeout: p.config.StartupTimeout,
+			jobEvents:    p.jobEvents,
 		},
 		&stepCheckCancellation{},
 		&stepUploadScript{
 			uploadTimeout: p.config.ScriptUploadTimeout,
+			jobEvents:     p.jobEvents,
 		},
 		&stepCheckCancellation{},
 		&stepUpdateState{},
@@ -255,18 +495,73 @@ func (p *Processor) process(ctx gocontext.Context, buildJob Job) {
 		},
 	}
 
+	for i, step := range steps {
+		steps[i] = &processorStepTracker{Step: step, processor: p, stage: stepStage(step)}
+	}
+
 	runner := &multistep.BasicRunner{Steps: steps}
 
 	logger.Info("starting job")
 	runner.Run(state)
 
+	if timings, ok := context.TimingsFromContext(ctx); ok {
+		for name, duration := range timings {
+			metrics.TimeJobStep(name, duration)
+		}
+	}
+
 	fields := context.LoggerTimingsFromContext(ctx)
 	instance, ok := state.Get("instance").(backend.Instance)
 	if ok {
 		fields["instance_id"] = instance.ID()
 		fields["image_name"] = instance.ImageName()
+		span.AddAttributes(
+			trace.StringAttribute("instance_id", instance.ID()),
+			trace.StringAttribute("image_name", instance.ImageName()),
+		)
 	}
 	logger.WithFields(fields).Info("finished job")
 
 	p.ProcessedCount++
 }
+
+// processorStepTracker wraps a step to keep track of the stage of the job the
+// processor is currently working on.
+type processorStepTracker struct {
+	multistep.Step
+
+	processor *Processor
+	stage     string
+}
+
+func (s *processorStepTracker) Run(state multistep.StateBag) multistep.StepAction {
+	s.processor.updateCurrentJob(s.stage, state)
+	defer s.processor.updateCurrentJob("", state)
+
+	return s.Step.Run(state)
+}
+
+// stepStage turns a step type name like "stepRunScript" into "run_script".
+func stepStage(step multistep.Step) string {
+	t := reflect.TypeOf(step)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	name := []rune(strings.TrimPrefix(t.Name(), "step"))
+	stage := []rune{}
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			// Only start a new word at the end of an acronym, so that
+			// "BuildJSON" becomes "build_json".
+			if i > 0 && (unicode.IsLower(name[i-1]) ||
+				(i+1 < len(name) && unicode.IsLower(name[i+1]))) {
+				stage = append(stage, '_')
+			}
+			r = unicode.ToLower(r)
+		}
+		stage = append(stage, r)
+	}
+
+	return string(stage)
+}
@@ -25,20 +25,28 @@ type ProcessorPool struct {
 	CancellationBroadcaster *CancellationBroadcaster
 	Hostname                string
 	Config                  *config.Config
+	LogTailer               *LogTailer
+	JobEvents               *JobEventEmitter
 
 	queue            JobQueue
 	logWriterFactory LogWriterFactory
 	poolErrors       []error
 	processorsLock   sync.Mutex
 	processors       []*Processor
+	draining         []*Processor
 	processorsWG     sync.WaitGroup
+	size             int
+	pendingDecrs     int
 	pauseCount       int
+	intakePaused     bool
 }
 
 type ProcessorPoolConfig struct {
-	Hostname string
-	Context  gocontext.Context
-	Config   *config.Config
+	Hostname  string
+	Context   gocontext.Context
+	Config    *config.Config
+	LogTailer *LogTailer
+	JobEvents *JobEventEmitter
 }
 
 // NewProcessorPool creates a new processor pool using the given arguments.
@@ -47,9 +55,11 @@ func NewProcessorPool(ppc *ProcessorPoolConfig,
 	cancellationBroadcaster *CancellationBroadcaster) *ProcessorPool {
 
 	return &ProcessorPool{
-		Hostname: ppc.Hostname,
-		Context:  ppc.Context,
-		Config:   ppc.Config,
+		Hostname:  ppc.Hostname,
+		Context:   ppc.Context,
+		Config:    ppc.Config,
+		LogTailer: ppc.LogTailer,
+		JobEvents: ppc.JobEvents,
 
 		Provider:                provider,
 		Generator:               generator,
@@ -65,10 +75,12 @@ func (p *ProcessorPool) Each(f func(int, *Processor)) {
 	procIDs := []string{}
 	procsByID := map[string]*Processor{}
 
+	p.processorsLock.Lock()
 	for _, proc := range p.processors {
 		procIDs = append(procIDs, proc.ID)
 		procsByID[proc.ID] = proc
 	}
+	p.processorsLock.Unlock()
 
 	sort.Strings(procIDs)
 
@@ -77,9 +89,13 @@ func (p *ProcessorPool) Each(f func(int, *Processor)) {
 	}
 }
 
-// Size returns the number of processors in the pool
+// Size returns the number of processors in the pool, including those that
+// have been added with Incr but haven't started yet.
 func (p *ProcessorPool) Size() int {
-	return len(p.processors)
+	p.processorsLock.Lock()
+	defer p.processorsLock.Unlock()
+
+	return p.size
 }
 
 // TotalProcessed returns the sum of all processor ProcessedCount values.
@@ -94,18 +110,24 @@ func (p *ProcessorPool) TotalProcessed() int {
 // Run starts up a number of processors and connects them to the given queue.
 // This method stalls until all processors have finished.
 func (p *ProcessorPool) Run(poolSize int, queue JobQueue, logWriterFactory LogWriterFactory) error {
+	p.processorsLock.Lock()
 	p.queue = queue
 	p.logWriterFactory = logWriterFactory
 	p.poolErrors = []error{}
+	p.processorsLock.Unlock()
 
 	for i := 0; i < poolSize; i++ {
 		p.Incr()
 	}
 
-	if len(p.poolErrors) > 0 {
+	p.processorsLock.Lock()
+	poolErrors := p.poolErrors
+	p.processorsLock.Unlock()
+
+	if len(poolErrors) > 0 {
 		context.LoggerFromContext(p.Context).WithFields(logrus.Fields{
 			"self":        "processor_pool",
-			"pool_errors": p.poolErrors,
+			"pool_errors": poolErrors,
 		}).Panic("failed to populate pool")
 	}
 
@@ -143,12 +165,29 @@ func (p *ProcessorPool) GracefulShutdown(togglePause bool) {
 
 // Incr adds a single running processor to the pool
 func (p *ProcessorPool) Incr() {
+	p.processorsLock.Lock()
+	defer p.processorsLock.Unlock()
+
+	p.incr()
+}
+
+// incr adds a processor to the pool. The processors lock must be held.
+func (p *ProcessorPool) incr() {
+	p.size++
 	p.processorsWG.Add(1)
+	queue, logWriterFactory := p.queue, p.logWriterFactory
 	go func() {
 		defer p.processorsWG.Done()
-		err := p.runProcessor(p.queue, p.logWriterFactory)
+		err := p.runProcessor(queue, logWriterFactory)
 		if err != nil {
+			p.processorsLock.Lock()
 			p.poolErrors = append(p.poolErrors, err)
+			if p.pendingDecrs > 0 {
+				p.pendingDecrs--
+			} else {
+				p.size--
+			}
+			p.processorsLock.Unlock()
 			return
 		}
 	}()
@@ -156,24 +195,154 @@ func (p *ProcessorPool) Incr() {
 
 // Decr pops a processor out of the pool and issues a graceful shutdown
 func (p *ProcessorPool) Decr() {
+	p.processorsLock.Lock()
+	defer p.processorsLock.Unlock()
+
+	p.decr()
+}
+
+// decr removes a processor from the pool. If all processors that have
+// started are removed already, one that is still starting is removed once it
+// has started. The processors lock must be held.
+func (p *ProcessorPool) decr() {
+	if p.size == 0 {
+		return
+	}
+	p.size--
+
 	if len(p.processors) == 0 {
+		p.pendingDecrs++
 		return
 	}
 
 	var proc *Processor
 	proc, p.processors = p.processors[len(p.processors)-1], p.processors[:len(p.processors)-1]
+	p.draining = append(p.draining, proc)
 	proc.GracefulShutdown()
 }
 
+// SetSize adds or removes processors until the pool has the given size, and
+// returns the size of the pool before resizing. The size must be at least 1,
+// since the pool stops once it has no processors left.
+func (p *ProcessorPool) SetSize(size int) (int, error) {
+	if size < 1 {
+		return 0, fmt.Errorf("pool size must be at least 1, got %d", size)
+	}
+
+	p.processorsLock.Lock()
+	defer p.processorsLock.Unlock()
+
+	prev := p.size
+
+	for n := prev; n < size; n++ {
+		p.incr()
+	}
+
+	for n := prev; n > size; n-- {
+		p.decr()
+	}
+
+	return prev, nil
+}
+
+// Reconfigure replaces the config and provider used by the processors in the
+// pool, and by processors added later. Each processor switches over before
+// picking up its next job, so running jobs keep the config and instance they
+// started with.
+func (p *ProcessorPool) Reconfigure(cfg *config.Config, provider backend.Provider) {
+	p.processorsLock.Lock()
+	defer p.processorsLock.Unlock()
+
+	p.Config = cfg
+	p.Provider = provider
+
+	// processors that are shutting down may still pick up a job
+	for _, processor := range p.allProcessors() {
+		processor.Reconfigure(cfg, provider)
+	}
+}
+
+// providerInUse returns true if any processor, including those that are
+// shutting down, is processing a job with the given provider.
+func (p *ProcessorPool) providerInUse(provider backend.Provider) bool {
+	p.processorsLock.Lock()
+	defer p.processorsLock.Unlock()
+
+	for _, processor := range p.allProcessors() {
+		if processor.usesProvider(provider) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allProcessors returns the processors in the pool and those that are still
+// shutting down after being removed from it. The processors lock must be held.
+func (p *ProcessorPool) allProcessors() []*Processor {
+	all := make([]*Processor, 0, len(p.processors)+len(p.draining))
+	all = append(all, p.processors...)
+	return append(all, p.draining...)
+}
+
+// PauseIntake stops all processors in the pool from picking up new jobs. Jobs
+// that are already running are not affected.
+func (p *ProcessorPool) PauseIntake() {
+	p.processorsLock.Lock()
+	defer p.processorsLock.Unlock()
+
+	p.intakePaused = true
+	for _, processor := range p.processors {
+		processor.PauseIntake()
+	}
+}
+
+// ResumeIntake lets all processors in the pool pick up new jobs again.
+func (p *ProcessorPool) ResumeIntake() {
+	p.processorsLock.Lock()
+	defer p.processorsLock.Unlock()
+
+	p.intakePaused = false
+	for _, processor := range p.processors {
+		processor.ResumeIntake()
+	}
+}
+
+// IntakePaused returns whether intake has been paused with PauseIntake.
+func (p *ProcessorPool) IntakePaused() bool {
+	p.processorsLock.Lock()
+	defer p.processorsLock.Unlock()
+
+	return p.intakePaused
+}
+
+// FindJob returns the processor that is currently working on the job with the
+// given ID, if any.
+func (p *ProcessorPool) FindJob(jobID uint64) (*Processor, bool) {
+	var found *Processor
+	p.Each(func(_ int, proc *Processor) {
+		if current := proc.CurrentJob(); current != nil && current.ID == jobID {
+			found = proc
+		}
+	})
+	return found, found != nil
+}
+
 func (p *ProcessorPool) runProcessor(queue JobQueue, logWriterFactory LogWriterFactory) error {
 	processorUUID := uuid.NewRandom()
 	processorID := fmt.Sprintf("%s@%d.%s", processorUUID.String(), os.Getpid(), p.Hostname)
 	ctx := context.FromProcessor(p.Context, processorID)
 
+	p.processorsLock.Lock()
+	cfg, provider := p.Config, p.Provider
+	p.processorsLock.Unlock()
+
 	proc, err := NewProcessor(ctx, p.Hostname,
-		queue, logWriterFactory, p.Provider, p.Generator, p.Persister, p.CancellationBroadcaster,
+		queue, logWriterFactory, provider, p.Generator, p.Persister, p.CancellationBroadcaster,
 		ProcessorConfig{
-			Config: p.Config,
+			Config:    cfg,
+			LogTailer: p.LogTailer,
+			JobEvents: p.JobEvents,
 		})
 
 	if err != nil {
@@ -185,9 +354,31 @@ func (p *ProcessorPool) runProcessor(queue JobQueue, logWriterFactory LogWriterF
 	}
 
 	p.processorsLock.Lock()
+	if p.pendingDecrs > 0 {
+		// the pool was shrunk while this processor was starting
+		p.pendingDecrs--
+		p.processorsLock.Unlock()
+		return nil
+	}
+	if p.intakePaused {
+		proc.PauseIntake()
+	}
+	if p.Config != cfg || p.Provider != provider {
+		proc.Reconfigure(p.Config, p.Provider)
+	}
 	p.processors = append(p.processors, proc)
 	p.processorsLock.Unlock()
 
 	proc.Run()
+
+	p.processorsLock.Lock()
+	for n, draining := range p.draining {
+		if draining == proc {
+			p.draining = append(p.draining[:n], p.draining[n+1:]...)
+			break
+		}
+	}
+	p.processorsLock.Unlock()
+
 	return nil
 }
@@ -0,0 +1,68 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	gocontext "context"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/travis-ci/worker/config"
+)
+
+func TestProcessorPool_SetSize(t *testing.T) {
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	defer cancel()
+
+	pool := NewProcessorPool(&ProcessorPoolConfig{
+		Context: ctx,
+		Config:  &config.Config{},
+	}, nil, nil, nil, NewCancellationBroadcaster())
+
+	doneChan := make(chan error)
+	go func() {
+		doneChan <- pool.Run(1, &fakeJobQueue{c: make(chan Job)}, nil)
+	}()
+
+	running := func() int {
+		n := 0
+		pool.Each(func(int, *Processor) { n++ })
+		return n
+	}
+	waitForRunning := func(n int) {
+		for i := 0; i < 100; i++ {
+			if running() == n {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("%d processors running, expected %d", running(), n)
+	}
+
+	waitForRunning(1)
+
+	// back to back resizes count processors that are still starting
+	prev, err := pool.SetSize(4)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, prev)
+
+	prev, err = pool.SetSize(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, prev)
+	assert.Equal(t, 2, pool.Size())
+
+	waitForRunning(2)
+
+	_, err = pool.SetSize(0)
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, pool.Size())
+
+	pool.GracefulShutdown(false)
+
+	select {
+	case err := <-doneChan:
+		assert.Nil(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("pool didn't stop")
+	}
+}
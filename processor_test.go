@@ -3,11 +3,13 @@ package worker
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"reflect"
 	"testing"
 	"time"
 
 	simplejson "github.com/bitly/go-simplejson"
+	"github.com/mitchellh/multistep"
 	"github.com/pborman/uuid"
 	"github.com/travis-ci/worker/backend"
 	"github.com/travis-ci/worker/config"
@@ -139,3 +141,152 @@ func TestProcessor(t *testing.T) {
 		}
 	}
 }
+
+func TestStepStage(t *testing.T) {
+	for step, expected := range map[multistep.Step]string{
+		&stepRunScript{}:             "run_script",
+		&stepCheckCancellation{}:     "check_cancellation",
+		&stepSubscribeCancellation{}: "subscribe_cancellation",
+		&stepTransformBuildJSON{}:    "transform_build_json",
+	} {
+		if actual := stepStage(step); actual != expected {
+			t.Errorf("stepStage(%T) = %q, expected %q", step, actual, expected)
+		}
+	}
+}
+
+func TestProcessor_PauseIntake(t *testing.T) {
+	ctx := workerctx.FromProcessor(context.TODO(), uuid.NewRandom().String())
+	jobQueue := &fakeJobQueue{c: make(chan Job)}
+
+	processor, err := NewProcessor(ctx, "test-hostname", jobQueue, nil, nil, nil, nil, nil, ProcessorConfig{
+		Config: &config.Config{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	processor.PauseIntake()
+
+	doneChan := make(chan struct{})
+	go func() {
+		processor.Run()
+		doneChan <- struct{}{}
+	}()
+
+	waitForStatus := func(status string) {
+		for i := 0; i < 100; i++ {
+			if processor.CurrentStatus == status {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("processor.CurrentStatus = %q, expected %q", processor.CurrentStatus, status)
+	}
+
+	waitForStatus("paused")
+
+	processor.ResumeIntake()
+	waitForStatus("waiting")
+
+	processor.GracefulShutdown()
+	<-doneChan
+}
+
+func TestProcessor_PauseIntakeWhileWaiting(t *testing.T) {
+	ctx := workerctx.FromProcessor(context.TODO(), uuid.NewRandom().String())
+	jobChan := make(chan Job, 1)
+
+	processor, err := NewProcessor(ctx, "test-hostname", &fakeJobQueue{c: jobChan}, nil, nil, nil, nil, nil, ProcessorConfig{
+		Config: &config.Config{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doneChan := make(chan struct{})
+	go func() {
+		processor.Run()
+		doneChan <- struct{}{}
+	}()
+
+	// give the processor time to start waiting for a job
+	time.Sleep(50 * time.Millisecond)
+
+	processor.PauseIntake()
+	time.Sleep(50 * time.Millisecond)
+
+	jobChan <- &fakeJob{payload: &JobPayload{Job: JobJobPayload{ID: 3}}}
+	time.Sleep(50 * time.Millisecond)
+
+	if len(jobChan) != 1 {
+		t.Fatal("paused processor picked up a job")
+	}
+
+	processor.GracefulShutdown()
+	<-doneChan
+}
+
+func TestProcessor_handBack(t *testing.T) {
+	ctx := workerctx.FromProcessor(context.TODO(), uuid.NewRandom().String())
+	processor := &Processor{ctx: ctx}
+
+	job := &fakeJob{payload: &JobPayload{Job: JobJobPayload{ID: 3}}}
+	processor.handBack(job)
+
+	if len(job.events) != 1 || job.events[0] != "requeued" {
+		t.Fatalf("job events = %v, expected a requeue", job.events)
+	}
+}
+
+func TestProcessor_FakeFaults(t *testing.T) {
+	for _, tc := range []struct {
+		faults   string
+		exitCode int
+	}{
+		{faults: `{}`, exitCode: 0},
+		{faults: `{"run": "exit:1"}`, exitCode: 1},
+		{faults: `{"run": "error"}`, exitCode: 2},
+		{faults: `{"run": "endless"}`, exitCode: 2},
+		{faults: `{"run": "hang"}`, exitCode: 2},
+		{faults: `{"run": "incomplete"}`, exitCode: RunJobExitRequeued},
+		{faults: `{"upload": "stale_vm"}`, exitCode: RunJobExitRequeued},
+		{faults: `{"start": "hang"}`, exitCode: RunJobExitRequeued},
+	} {
+		cfg := &config.Config{
+			HardTimeout:         time.Minute,
+			LogTimeout:          100 * time.Millisecond,
+			MaxLogLength:        1000,
+			StartupTimeout:      100 * time.Millisecond,
+			ScriptUploadTimeout: time.Minute,
+		}
+
+		job, err := newLocalJob([]byte(`{"job": {"id": 3}, "config": {"fake_faults": `+tc.faults+`}}`), cfg, ioutil.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		provider, err := backend.NewBackendProvider("fake", config.ProviderConfigFromMap(map[string]string{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		generator := buildScriptGeneratorFunction(func(ctx context.Context, job Job) ([]byte, error) {
+			return []byte("hello, world"), nil
+		})
+
+		ctx := workerctx.FromProcessor(context.TODO(), uuid.NewRandom().String())
+		processor, err := NewProcessor(ctx, "test-hostname", newLocalJobQueue(job), nil, provider, generator, nil, NewCancellationBroadcaster(), ProcessorConfig{
+			Config: cfg,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		processor.Run()
+
+		if job.exitCode() != tc.exitCode {
+			t.Errorf("faults %s: exit code %d, expected %d", tc.faults, job.exitCode(), tc.exitCode)
+		}
+	}
+}
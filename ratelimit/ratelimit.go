@@ -12,42 +12,77 @@ import (
 )
 
 const (
-	redisRateLimiterPoolMaxActive   = 1
-	redisRateLimiterPoolMaxIdle     = 1
+	redisRateLimiterPoolMaxActive   = 20
+	redisRateLimiterPoolMaxIdle     = 5
 	redisRateLimiterPoolIdleTimeout = 3 * time.Minute
 )
 
+// gcraScript implements the generic cell rate algorithm. The key holds the
+// theoretical arrival time (TAT) of the next call in microseconds, taken from
+// the Redis server clock so that clients with skewed clocks agree.
+//
+// KEYS[1] is the rate limit key, ARGV[1] the emission interval (per divided
+// by maxCalls) and ARGV[2] the burst tolerance (per minus the emission
+// interval), both in microseconds. It returns {1, 0} if the call is allowed,
+// and {0, µs to wait} otherwise.
+var gcraScript = redis.NewScript(1, `
+if redis.replicate_commands then
+  redis.replicate_commands()
+end
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local interval = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+
+local tat = tonumber(redis.call("GET", KEYS[1]) or now)
+if tat < now then
+  tat = now
+end
+
+local allowAt = tat - tolerance
+if now < allowAt then
+  return {0, math.ceil(allowAt - now)}
+end
+
+local newTat = tat + interval
+redis.call("SET", KEYS[1], string.format("%.0f", newTat), "PX", math.ceil((newTat - now) / 1000) + 1)
+return {1, 0}
+`)
+
 // RateLimiter checks if a call can be let through and returns true if it can.
 //
 // The name should be the same for all calls that should be affected by the
 // same rate limit. The maxCalls and per arguments must be the same for all
 // calls that use the same name, otherwise the behaviour is undefined.
 //
-// The rate limiter lets through maxCalls calls in a window of time specified
-// by the "per" argument. Note that the window is not sliding, so if you say 10
-// calls per minute, and 10 calls happen in the first second, no further calls
-// will be let through for another 59 seconds.
+// The rate limiter lets through maxCalls calls in any window of time of the
+// length specified by the "per" argument, spacing them out evenly once the
+// initial burst of maxCalls calls is used up.
 //
 // The actual call should only be made if (true, nil) is returned. If (false,
 // nil) is returned, it means that the number of requests in the time window is
-// met, and you should sleep for a bit and try again.
+// met. Wait can be used instead to block until the call is let through.
 //
 // In case an error happens, (false, err) is returned.
 type RateLimiter interface {
 	RateLimit(ctx gocontext.Context, name string, maxCalls uint64, per time.Duration) (bool, error)
+
+	// Wait blocks until a call is let through, returning nil, or until the
+	// context is done or an error happens, returning the error.
+	Wait(ctx gocontext.Context, name string, maxCalls uint64, per time.Duration) error
 }
 
 type redisRateLimiter struct {
-	ctx    gocontext.Context
 	pool   *redis.Pool
 	prefix string
 }
 
 type nullRateLimiter struct{}
 
-// NewRateLimiter creates a RateLimiter that's backed by Redis. The prefix can
-// be used to allow multiple rate limiters with the same name on the same Redis
-// server.
+// NewRateLimiter creates a RateLimiter that's backed by Redis, suitable for
+// sharing a rate limit between many workers. The prefix can be used to allow
+// multiple rate limiters with the same name on the same Redis server.
 func NewRateLimiter(redisURL string, prefix string) RateLimiter {
 	return &redisRateLimiter{
 		pool: &redis.Pool{
@@ -73,13 +108,23 @@ func NewNullRateLimiter() RateLimiter {
 	return nullRateLimiter{}
 }
 
-// BUG(sarahhodne): The Redis rate limiter is known to let through too many
-// requests when there are many clients talking to the same Redis. The reason
-// for this is unknown, but it's probably wise to limit the number of clients
-// to 5 or 6 for the time being.
 func (rl *redisRateLimiter) RateLimit(ctx gocontext.Context, name string, maxCalls uint64, per time.Duration) (bool, error) {
-	conn := rl.pool.Get()
-	defer conn.Close()
+	ok, _, err := rl.allow(ctx, name, maxCalls, per)
+	return ok, err
+}
+
+func (rl *redisRateLimiter) Wait(ctx gocontext.Context, name string, maxCalls uint64, per time.Duration) error {
+	return wait(ctx, func() (bool, time.Duration, error) {
+		return rl.allow(ctx, name, maxCalls, per)
+	})
+}
+
+// allow runs the GCRA script, returning whether the call is let through and
+// otherwise how long to wait before trying again.
+func (rl *redisRateLimiter) allow(ctx gocontext.Context, name string, maxCalls uint64, per time.Duration) (bool, time.Duration, error) {
+	if maxCalls == 0 {
+		return false, per, nil
+	}
 
 	if trace.FromContext(ctx) != nil {
 		var span *trace.Span
@@ -87,49 +132,54 @@ func (rl *redisRateLimiter) RateLimit(ctx gocontext.Context, name string, maxCal
 		defer span.End()
 	}
 
-	now := time.Now()
-	timestamp := now.Unix() - (now.Unix() % int64(per.Seconds()))
-
-	key := fmt.Sprintf("%s:%s:%d", rl.prefix, name, timestamp)
-
-	cur, err := redis.Int64(conn.Do("GET", key))
-	if err != nil && err != redis.ErrNil {
-		return false, err
-	}
-
-	if err != redis.ErrNil && uint64(cur) >= maxCalls {
-		return false, nil
-	}
-
-	_, err = conn.Do("WATCH", key)
-	if err != nil {
-		return false, err
-	}
+	conn := rl.pool.Get()
+	defer conn.Close()
 
-	connSend := func(commandName string, args ...interface{}) {
-		if err != nil && err != redis.ErrNil {
-			return
-		}
-		err = conn.Send(commandName, args...)
-	}
-	connSend("MULTI")
-	connSend("INCR", key)
-	connSend("EXPIRE", key, int64(per.Seconds()))
-	if err != nil {
-		return false, err
-	}
+	interval := per / time.Duration(maxCalls)
+	tolerance := per - interval
+	key := fmt.Sprintf("%s:%s", rl.prefix, name)
 
-	reply, err := conn.Do("EXEC")
+	reply, err := redis.Int64s(gcraScript.Do(conn, key, int64(interval/time.Microsecond), int64(tolerance/time.Microsecond)))
 	if err != nil {
-		return false, err
+		return false, 0, err
 	}
-	if reply == nil {
-		return false, nil
+	if len(reply) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit script reply %v", reply)
 	}
 
-	return true, nil
+	return reply[0] == 1, time.Duration(reply[1]) * time.Microsecond, nil
 }
 
 func (rl nullRateLimiter) RateLimit(ctx gocontext.Context, name string, maxCalls uint64, per time.Duration) (bool, error) {
 	return true, nil
 }
+
+func (rl nullRateLimiter) Wait(ctx gocontext.Context, name string, maxCalls uint64, per time.Duration) error {
+	return nil
+}
+
+// wait calls allow until it lets the call through, sleeping for as long as it
+// asks in between.
+func wait(ctx gocontext.Context, allow func() (bool, time.Duration, error)) error {
+	for {
+		ok, retryAfter, err := allow()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		if retryAfter <= 0 {
+			retryAfter = time.Millisecond
+		}
+
+		timer := time.NewTimer(retryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -13,10 +13,6 @@ func TestRateLimit(t *testing.T) {
 		t.Skip("skipping redis test since there is no REDIS_URL")
 	}
 
-	if time.Now().Minute() > 58 {
-		t.Log("Note: The TestRateLimit test is known to have a bug if run near the top of the hour. Since the rate limiter isn't a moving window, it could end up checking against two different buckets on either side of the top of the hour, so if you see that just re-run it after you've passed the top of the hour.")
-	}
-
 	rateLimiter := NewRateLimiter(os.Getenv("REDIS_URL"), fmt.Sprintf("worker-test-rl-%d", os.Getpid()))
 
 	ok, err := rateLimiter.RateLimit(context.TODO(), "slow", 2, time.Hour)
@@ -43,3 +39,66 @@ func TestRateLimit(t *testing.T) {
 		t.Fatal("expected to get rate limited, but was not limited")
 	}
 }
+
+func TestWait(t *testing.T) {
+	if os.Getenv("REDIS_URL") == "" {
+		t.Skip("skipping redis test since there is no REDIS_URL")
+	}
+
+	rateLimiter := NewRateLimiter(os.Getenv("REDIS_URL"), fmt.Sprintf("worker-test-rl-wait-%d", os.Getpid()))
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		err := rateLimiter.Wait(context.TODO(), "fast", 2, 200*time.Millisecond)
+		if err != nil {
+			t.Fatalf("rate limiter error: %v", err)
+		}
+	}
+	if time.Since(start) < 150*time.Millisecond {
+		t.Fatalf("expected to wait for the rate limit, but took %v", time.Since(start))
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+
+	err := rateLimiter.Wait(ctx, "slow", 1, time.Hour)
+	if err != nil {
+		t.Fatalf("rate limiter error: %v", err)
+	}
+	err = rateLimiter.Wait(ctx, "slow", 1, time.Hour)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, but got %v", err)
+	}
+}
+
+func TestRateLimit_ManyClients(t *testing.T) {
+	if os.Getenv("REDIS_URL") == "" {
+		t.Skip("skipping redis test since there is no REDIS_URL")
+	}
+
+	prefix := fmt.Sprintf("worker-test-rl-many-%d", os.Getpid())
+	allowed := make(chan bool, 200)
+
+	for c := 0; c < 20; c++ {
+		go func() {
+			rateLimiter := NewRateLimiter(os.Getenv("REDIS_URL"), prefix)
+			for i := 0; i < 10; i++ {
+				ok, err := rateLimiter.RateLimit(context.TODO(), "shared", 50, time.Hour)
+				if err != nil {
+					t.Errorf("rate limiter error: %v", err)
+				}
+				allowed <- ok
+			}
+		}()
+	}
+
+	count := 0
+	for i := 0; i < 200; i++ {
+		if <-allowed {
+			count++
+		}
+	}
+	if count != 50 {
+		t.Fatalf("expected 50 calls to be let through, but got %d", count)
+	}
+}
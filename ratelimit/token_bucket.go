@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	gocontext "context"
+)
+
+type tokenBucketRateLimiter struct {
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// NewTokenBucketRateLimiter creates a RateLimiter that keeps its state in
+// memory, for setups where a single worker talks to an API. Each name gets a
+// bucket holding up to maxCalls tokens, refilled at maxCalls per "per".
+func NewTokenBucketRateLimiter() RateLimiter {
+	return &tokenBucketRateLimiter{
+		buckets: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+}
+
+func (rl *tokenBucketRateLimiter) RateLimit(ctx gocontext.Context, name string, maxCalls uint64, per time.Duration) (bool, error) {
+	ok, _ := rl.allow(name, maxCalls, per)
+	return ok, nil
+}
+
+func (rl *tokenBucketRateLimiter) Wait(ctx gocontext.Context, name string, maxCalls uint64, per time.Duration) error {
+	return wait(ctx, func() (bool, time.Duration, error) {
+		ok, retryAfter := rl.allow(name, maxCalls, per)
+		return ok, retryAfter, nil
+	})
+}
+
+// allow takes a token from the bucket if there is one, and otherwise returns
+// how long it takes until there is.
+func (rl *tokenBucketRateLimiter) allow(name string, maxCalls uint64, per time.Duration) (bool, time.Duration) {
+	if maxCalls == 0 {
+		return false, per
+	}
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	now := rl.now()
+	capacity := float64(maxCalls)
+	perToken := per / time.Duration(maxCalls)
+
+	bucket, ok := rl.buckets[name]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, updatedAt: now}
+		rl.buckets[name] = bucket
+	}
+
+	if elapsed := now.Sub(bucket.updatedAt); elapsed > 0 {
+		bucket.tokens += float64(elapsed) / float64(perToken)
+		if bucket.tokens > capacity {
+			bucket.tokens = capacity
+		}
+	}
+	bucket.updatedAt = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - bucket.tokens) * float64(perToken))
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucketRateLimit(t *testing.T) {
+	now := time.Now()
+	rl := NewTokenBucketRateLimiter().(*tokenBucketRateLimiter)
+	rl.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		ok, err := rl.RateLimit(context.TODO(), "slow", 3, time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, ok, "call %d", i)
+	}
+
+	ok, _ := rl.RateLimit(context.TODO(), "slow", 3, time.Minute)
+	assert.False(t, ok)
+
+	ok, _ = rl.RateLimit(context.TODO(), "other", 3, time.Minute)
+	assert.True(t, ok)
+
+	_, retryAfter := rl.allow("slow", 3, time.Minute)
+	assert.Equal(t, 20*time.Second, retryAfter)
+
+	now = now.Add(19 * time.Second)
+	ok, _ = rl.RateLimit(context.TODO(), "slow", 3, time.Minute)
+	assert.False(t, ok)
+
+	now = now.Add(time.Second)
+	ok, _ = rl.RateLimit(context.TODO(), "slow", 3, time.Minute)
+	assert.True(t, ok)
+
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		ok, _ = rl.RateLimit(context.TODO(), "slow", 3, time.Minute)
+		assert.True(t, ok, "call %d after refill", i)
+	}
+	ok, _ = rl.RateLimit(context.TODO(), "slow", 3, time.Minute)
+	assert.False(t, ok)
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	rl := NewTokenBucketRateLimiter()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, rl.Wait(context.TODO(), "fast", 2, 100*time.Millisecond))
+	}
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+
+	assert.Nil(t, rl.Wait(ctx, "slow", 1, time.Hour))
+	assert.Equal(t, context.DeadlineExceeded, rl.Wait(ctx, "slow", 1, time.Hour))
+}
+
+func TestNullRateLimiterWait(t *testing.T) {
+	assert.Nil(t, NewNullRateLimiter().Wait(context.TODO(), "any", 0, time.Hour))
+}
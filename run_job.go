@@ -0,0 +1,197 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	gocontext "context"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/travis-ci/worker/backend"
+	"github.com/travis-ci/worker/config"
+	"github.com/travis-ci/worker/context"
+)
+
+// Exit codes used by RunJob for jobs that didn't finish with a FinishState.
+const (
+	RunJobExitRequeued   = 4
+	RunJobExitUnfinished = 5
+)
+
+var runJobExitCodes = map[FinishState]int{
+	FinishStatePassed:    0,
+	FinishStateFailed:    1,
+	FinishStateErrored:   2,
+	FinishStateCancelled: 3,
+}
+
+// RunJobOptions holds the overrides for a job run with RunJob.
+type RunJobOptions struct {
+	// ImageName, if set, is used instead of selecting an image.
+	ImageName string
+
+	// StartAttributes are key=value overrides of the start attributes, with
+	// keys named as in the job config (language, dist, group, os,
+	// osx_image), or queue and vm_type.
+	StartAttributes []string
+
+	// ScriptPath, if set, is where the generated build script is saved.
+	ScriptPath string
+}
+
+// RunJob reads a job payload and runs it through the normal processor steps
+// against the configured provider and build script generator, writing the
+// job log to out. It returns the exit code for the state the job finished
+// with: 0 for passed, 1 for failed, 2 for errored, 3 for cancelled,
+// RunJobExitRequeued if the job was requeued, and RunJobExitUnfinished if it
+// never finished. An interrupt cancels the job.
+func (i *CLI) RunJob(in io.Reader, out io.Writer, opts *RunJobOptions) (int, error) {
+	if i.c.Bool("debug") {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	ctx, cancel := gocontext.WithCancel(context.FromProcessor(gocontext.Background(), "run-job"))
+	defer cancel()
+
+	i.ctx = ctx
+	i.cancel = cancel
+	i.logger = context.LoggerFromContext(ctx).WithField("self", "cli")
+
+	cfg, err := config.Load(i.c)
+	if err != nil {
+		return RunJobExitUnfinished, err
+	}
+	i.Config = cfg
+
+	body, err := ioutil.ReadAll(in)
+	if err != nil {
+		return RunJobExitUnfinished, errors.Wrap(err, "couldn't read job payload")
+	}
+
+	job, err := newLocalJob(body, cfg, out)
+	if err != nil {
+		return RunJobExitUnfinished, err
+	}
+
+	err = applyRunJobOptions(job.startAttributes, opts)
+	if err != nil {
+		return RunJobExitUnfinished, err
+	}
+
+	if cfg.TravisSite != "" {
+		cfg.ProviderConfig.Set("TRAVIS_SITE", cfg.TravisSite)
+	}
+
+	i.setupImageSelectorCache()
+
+	err = i.checkProviderConfig()
+	if err != nil {
+		return RunJobExitUnfinished, err
+	}
+
+	provider, err := backend.NewBackendProvider(cfg.ProviderName, cfg.ProviderConfig)
+	if err != nil {
+		return RunJobExitUnfinished, errors.Wrap(err, "couldn't create backend provider")
+	}
+
+	err = provider.Setup(ctx)
+	if err != nil {
+		return RunJobExitUnfinished, errors.Wrap(err, "couldn't setup backend provider")
+	}
+
+	var generator BuildScriptGenerator = NewBuildScriptGenerator(cfg)
+	if opts.ScriptPath != "" {
+		generator = &scriptSavingGenerator{BuildScriptGenerator: generator, path: opts.ScriptPath}
+	}
+
+	cancellationBroadcaster := NewCancellationBroadcaster()
+
+	processor, err := NewProcessor(ctx, cfg.Hostname, newLocalJobQueue(job),
+		nil, provider, generator, NewBuildTracePersister(cfg), cancellationBroadcaster,
+		ProcessorConfig{Config: cfg})
+	if err != nil {
+		return RunJobExitUnfinished, err
+	}
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signalChan)
+
+	go func() {
+		select {
+		case <-signalChan:
+			i.logger.Warn("signal received, cancelling job")
+			cancellationBroadcaster.Broadcast(job.payload.Job.ID)
+		case <-ctx.Done():
+		}
+	}()
+
+	processor.Run()
+
+	return job.exitCode(), nil
+}
+
+// applyRunJobOptions applies the overrides in opts to the start attributes.
+func applyRunJobOptions(startAttributes *backend.StartAttributes, opts *RunJobOptions) error {
+	if opts.ImageName != "" {
+		startAttributes.ImageName = opts.ImageName
+	}
+
+	for _, attr := range opts.StartAttributes {
+		parts := strings.SplitN(attr, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid start attribute %q, expected key=value", attr)
+		}
+
+		key, value := parts[0], parts[1]
+		switch key {
+		case "language":
+			startAttributes.Language = value
+		case "dist":
+			startAttributes.Dist = value
+		case "group":
+			startAttributes.Group = value
+		case "os":
+			startAttributes.OS = value
+		case "osx_image":
+			startAttributes.OsxImage = value
+		case "image_name":
+			startAttributes.ImageName = value
+		case "queue":
+			startAttributes.Queue = value
+		case "vm_type":
+			startAttributes.VMType = value
+		default:
+			return fmt.Errorf("unknown start attribute %q", key)
+		}
+	}
+
+	return nil
+}
+
+// scriptSavingGenerator saves each script it generates to a file.
+type scriptSavingGenerator struct {
+	BuildScriptGenerator
+
+	path string
+}
+
+func (g *scriptSavingGenerator) Generate(ctx gocontext.Context, job Job) ([]byte, error) {
+	script, err := g.BuildScriptGenerator.Generate(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(g.path, script, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't save build script")
+	}
+
+	return script, nil
+}
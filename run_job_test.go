@@ -0,0 +1,86 @@
+package worker
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gocontext "context"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travis-ci/worker/backend"
+	"github.com/travis-ci/worker/config"
+	workerctx "github.com/travis-ci/worker/context"
+)
+
+func TestApplyRunJobOptions(t *testing.T) {
+	startAttributes := &backend.StartAttributes{Language: "ruby", Dist: "trusty"}
+
+	err := applyRunJobOptions(startAttributes, &RunJobOptions{
+		ImageName:       "travis-ci-garnet",
+		StartAttributes: []string{"language=go", "queue=builds.gce", "vm_type=premium"},
+	})
+	require.Nil(t, err)
+
+	assert.Equal(t, "go", startAttributes.Language)
+	assert.Equal(t, "trusty", startAttributes.Dist)
+	assert.Equal(t, "builds.gce", startAttributes.Queue)
+	assert.Equal(t, "premium", startAttributes.VMType)
+	assert.Equal(t, "travis-ci-garnet", startAttributes.ImageName)
+
+	err = applyRunJobOptions(startAttributes, &RunJobOptions{StartAttributes: []string{"language"}})
+	assert.NotNil(t, err)
+
+	err = applyRunJobOptions(startAttributes, &RunJobOptions{StartAttributes: []string{"flavor=spicy"}})
+	assert.NotNil(t, err)
+}
+
+func TestRunJobProcessor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "travis-worker-run-job")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := &config.Config{
+		ProviderName:        "fake",
+		HardTimeout:         time.Minute,
+		LogTimeout:          time.Minute,
+		MaxLogLength:        1000,
+		StartupTimeout:      time.Minute,
+		ScriptUploadTimeout: time.Minute,
+	}
+
+	out := &bytes.Buffer{}
+	job, err := newLocalJob([]byte(`{"job": {"id": 3}, "repository": {"slug": "travis-ci/worker"}, "config": {"language": "go"}}`), cfg, out)
+	require.Nil(t, err)
+
+	provider, err := backend.NewBackendProvider("fake", config.ProviderConfigFromMap(map[string]string{
+		"LOG_OUTPUT": "hello, world",
+	}))
+	require.Nil(t, err)
+
+	scriptPath := filepath.Join(dir, "build.sh")
+	generator := &scriptSavingGenerator{
+		BuildScriptGenerator: buildScriptGeneratorFunction(func(ctx gocontext.Context, job Job) ([]byte, error) {
+			return []byte("echo hello"), nil
+		}),
+		path: scriptPath,
+	}
+
+	ctx := workerctx.FromProcessor(gocontext.TODO(), "run-job")
+	processor, err := NewProcessor(ctx, "test-hostname", newLocalJobQueue(job),
+		nil, provider, generator, nil, NewCancellationBroadcaster(), ProcessorConfig{Config: cfg})
+	require.Nil(t, err)
+
+	processor.Run()
+
+	assert.Equal(t, 0, job.exitCode())
+	assert.Contains(t, out.String(), "hello, world")
+
+	script, err := ioutil.ReadFile(scriptPath)
+	require.Nil(t, err)
+	assert.Equal(t, "echo hello", string(script))
+}
@@ -0,0 +1,123 @@
+package worker
+
+import (
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/travis-ci/worker/config"
+)
+
+// redactingFormatter wraps a logrus.Formatter to replace secrets in the
+// formatted log entries.
+type redactingFormatter struct {
+	logrus.Formatter
+}
+
+func (f *redactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	formatted, err := f.Formatter.Format(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(config.Redact(string(formatted))), nil
+}
+
+// refreshSecrets resolves the secret references in the config again every
+// interval, and reloads the config when any of the secrets that can be
+// changed without a restart has changed. Changes to other secrets are logged
+// once, as they only apply after a restart.
+func (i *CLI) refreshSecrets(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	warned := map[string]bool{}
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-i.ctx.Done():
+			return
+		}
+
+		cfg, err := config.Load(i.c)
+		if err != nil {
+			i.logger.WithField("err", err).Error("couldn't refresh secrets")
+			continue
+		}
+
+		next, restart := refreshedConfig(i.currentConfig(), cfg)
+
+		for _, name := range restart {
+			if warned[name] {
+				continue
+			}
+			warned[name] = true
+			i.logger.WithField("setting", name).Warn("secret changed, restart the worker to apply it")
+		}
+
+		if next == nil {
+			continue
+		}
+
+		i.logger.Info("secrets changed, reloading config")
+
+		_, err = i.applyConfig(next)
+		if err != nil {
+			i.logger.WithField("err", err).Error("couldn't reload config with refreshed secrets")
+		}
+	}
+}
+
+// refreshedConfig compares the settings resolved from secret references
+// between prev and cfg. It returns a copy of prev with the changed secrets
+// that can be reloaded applied, or nil if none of them changed, and the
+// names of the changed secrets that need a restart.
+func refreshedConfig(prev, cfg *config.Config) (*config.Config, []string) {
+	next := *prev
+	next.SecretRefs = cfg.SecretRefs
+	next.ProviderSecretRefs = cfg.ProviderSecretRefs
+	next.ProviderConfig = config.ProviderConfigFromMap(providerConfigMap(prev.ProviderConfig))
+
+	changed := false
+	restart := []string{}
+
+	prevVal := reflect.ValueOf(prev).Elem()
+	cfgVal := reflect.ValueOf(cfg).Elem()
+	nextVal := reflect.ValueOf(&next).Elem()
+
+	for n := 0; n < prevVal.NumField(); n++ {
+		name := prevVal.Type().Field(n).Tag.Get("config")
+		if _, ok := cfg.SecretRefs[name]; !ok {
+			continue
+		}
+
+		if prevVal.Field(n).String() == cfgVal.Field(n).String() {
+			continue
+		}
+
+		if !reloadableSettings[name] {
+			restart = append(restart, name)
+			continue
+		}
+
+		nextVal.Field(n).SetString(cfgVal.Field(n).String())
+		changed = true
+	}
+
+	for key := range cfg.ProviderSecretRefs {
+		if prev.ProviderConfig.Get(key) != cfg.ProviderConfig.Get(key) {
+			next.ProviderConfig.Set(key, cfg.ProviderConfig.Get(key))
+			changed = true
+		}
+	}
+
+	sort.Strings(restart)
+
+	if !changed {
+		return nil, restart
+	}
+
+	return &next, restart
+}
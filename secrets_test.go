@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/travis-ci/worker/config"
+)
+
+func TestRedactingFormatter(t *testing.T) {
+	config.RegisterSecret("sup3rs3cret")
+
+	formatter := &redactingFormatter{Formatter: &logrus.TextFormatter{DisableColors: true}}
+	entry := logrus.NewEntry(logrus.New()).WithField("token", "sup3rs3cret")
+	entry.Message = "using sup3rs3cret"
+
+	formatted, err := formatter.Format(entry)
+	assert.Nil(t, err)
+	assert.Contains(t, string(formatted), `msg="using [REDACTED]"`)
+	assert.Contains(t, string(formatted), "token=[REDACTED]")
+	assert.NotContains(t, string(formatted), "sup3rs3cret")
+}
+
+func TestRefreshedConfig(t *testing.T) {
+	prev := &config.Config{
+		AmqpURI:        "amqp://old",
+		JobBoardURL:    "https://old@job-board.example.com",
+		QueueName:      "builds.old",
+		ProviderConfig: config.ProviderConfigFromMap(map[string]string{"ACCOUNT_JSON": "{}", "ZONE": "a"}),
+		SecretRefs: map[string]string{
+			"amqp-uri":      "env://AMQP_URI",
+			"job-board-url": "env://JOB_BOARD_URL",
+		},
+		ProviderSecretRefs: map[string]string{"ACCOUNT_JSON": "file:///account.json"},
+	}
+
+	cfg := *prev
+	cfg.QueueName = "builds.new"
+	next, restart := refreshedConfig(prev, &cfg)
+	assert.Nil(t, next)
+	assert.Empty(t, restart)
+
+	cfg.AmqpURI = "amqp://new"
+	next, restart = refreshedConfig(prev, &cfg)
+	assert.Nil(t, next)
+	assert.Equal(t, []string{"amqp-uri"}, restart)
+
+	cfg.JobBoardURL = "https://new@job-board.example.com"
+	next, restart = refreshedConfig(prev, &cfg)
+	assert.Equal(t, []string{"amqp-uri"}, restart)
+	if assert.NotNil(t, next) {
+		assert.Equal(t, "https://new@job-board.example.com", next.JobBoardURL)
+		assert.Equal(t, "amqp://old", next.AmqpURI)
+		assert.Equal(t, "builds.old", next.QueueName)
+	}
+
+	cfg = *prev
+	cfg.ProviderConfig = config.ProviderConfigFromMap(map[string]string{"ACCOUNT_JSON": `{"new": true}`, "ZONE": "b"})
+	next, restart = refreshedConfig(prev, &cfg)
+	assert.Empty(t, restart)
+	if assert.NotNil(t, next) {
+		assert.Equal(t, `{"new": true}`, next.ProviderConfig.Get("ACCOUNT_JSON"))
+		assert.Equal(t, "a", next.ProviderConfig.Get("ZONE"))
+		assert.Equal(t, "{}", prev.ProviderConfig.Get("ACCOUNT_JSON"))
+	}
+}
@@ -5,7 +5,6 @@ import (
 
 	"github.com/mitchellh/multistep"
 	"github.com/travis-ci/worker/context"
-	"go.opencensus.io/trace"
 )
 
 type stepCheckCancellation struct{}
@@ -15,7 +14,7 @@ func (s *stepCheckCancellation) Run(state multistep.StateBag) multistep.StepActi
 
 	ctx := state.Get("ctx").(gocontext.Context)
 
-	ctx, span := trace.StartSpan(ctx, "CheckCancellation.Run")
+	ctx, span := startJobSpan(ctx, "CheckCancellation.Run")
 	defer span.End()
 
 	select {
@@ -41,7 +40,7 @@ func (s *stepCheckCancellation) Run(state multistep.StateBag) multistep.StepActi
 func (s *stepCheckCancellation) Cleanup(state multistep.StateBag) {}
 
 func (s *stepCheckCancellation) writeLogAndFinishWithState(ctx gocontext.Context, logWriter LogWriter, buildJob Job, state FinishState, logMessage string) {
-	ctx, span := trace.StartSpan(ctx, "WriteLogAndFinishWithState.CheckCancellation")
+	ctx, span := startJobSpan(ctx, "WriteLogAndFinishWithState.CheckCancellation")
 	defer span.End()
 
 	_, err := logWriter.WriteAndClose([]byte(logMessage))
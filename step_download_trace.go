@@ -12,7 +12,6 @@ import (
 	"github.com/travis-ci/worker/backend"
 	"github.com/travis-ci/worker/context"
 	"github.com/travis-ci/worker/metrics"
-	"go.opencensus.io/trace"
 )
 
 type stepDownloadTrace struct {
@@ -28,7 +27,7 @@ func (s *stepDownloadTrace) Run(state multistep.StateBag) multistep.StepAction {
 
 	defer context.TimeSince(ctx, "step_download_trace_run", time.Now())
 
-	ctx, span := trace.StartSpan(ctx, "DownloadTrace.Run")
+	ctx, span := startJobSpan(ctx, "DownloadTrace.Run")
 	defer span.End()
 
 	buildJob := state.Get("buildJob").(Job)
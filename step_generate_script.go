@@ -8,7 +8,6 @@ import (
 	"github.com/cenk/backoff"
 	"github.com/mitchellh/multistep"
 	"github.com/travis-ci/worker/context"
-	"go.opencensus.io/trace"
 )
 
 type stepGenerateScript struct {
@@ -21,7 +20,7 @@ func (s *stepGenerateScript) Run(state multistep.StateBag) multistep.StepAction
 
 	defer context.TimeSince(ctx, "step_generate_script_run", time.Now())
 
-	ctx, span := trace.StartSpan(ctx, "GenerateScript.Run")
+	ctx, span := startJobSpan(ctx, "GenerateScript.Run")
 	defer span.End()
 
 	logger := context.LoggerFromContext(ctx).WithField("self", "step_generate_script")
@@ -8,12 +8,13 @@ import (
 	"github.com/mitchellh/multistep"
 	"github.com/sirupsen/logrus"
 	"github.com/travis-ci/worker/context"
-	"go.opencensus.io/trace"
+	workererrors "github.com/travis-ci/worker/errors"
 )
 
 type stepOpenLogWriter struct {
 	maxLogLength      int
 	defaultLogTimeout time.Duration
+	logTailer         *LogTailer
 }
 
 func (s *stepOpenLogWriter) Run(state multistep.StateBag) multistep.StepAction {
@@ -22,7 +23,7 @@ func (s *stepOpenLogWriter) Run(state multistep.StateBag) multistep.StepAction {
 	logWriterFactory := state.Get("logWriterFactory")
 	logger := context.LoggerFromContext(ctx).WithField("self", "step_open_log_writer")
 
-	ctx, span := trace.StartSpan(ctx, "OpenLogWriter.Run")
+	ctx, span := startJobSpan(ctx, "OpenLogWriter.Run")
 	defer span.End()
 
 	var logWriter LogWriter
@@ -37,17 +38,18 @@ func (s *stepOpenLogWriter) Run(state multistep.StateBag) multistep.StepAction {
 		logger.WithFields(logrus.Fields{
 			"err":         err,
 			"log_timeout": s.defaultLogTimeout,
-		}).Error("couldn't open a log writer, attempting requeue")
+		}).Error("couldn't open a log writer")
 		context.CaptureError(ctx, err)
 
-		err := buildJob.Requeue(ctx)
-		if err != nil {
-			logger.WithField("err", err).Error("couldn't requeue job")
-		}
+		failJob(ctx, buildJob, nil, err, workererrors.ClassLogSinkFailed)
 		return multistep.ActionHalt
 	}
 	logWriter.SetMaxLogLength(s.maxLogLength)
 
+	if s.logTailer != nil {
+		logWriter = s.logTailer.LogWriter(buildJob.Payload().Job.ID, logWriter)
+	}
+
 	state.Put("logWriter", logWriter)
 
 	return multistep.ActionContinue
@@ -56,7 +58,7 @@ func (s *stepOpenLogWriter) Run(state multistep.StateBag) multistep.StepAction {
 func (s *stepOpenLogWriter) Cleanup(state multistep.StateBag) {
 	ctx := state.Get("ctx").(gocontext.Context)
 
-	ctx, span := trace.StartSpan(ctx, "OpenLogWriter.Cleanup")
+	ctx, span := startJobSpan(ctx, "OpenLogWriter.Cleanup")
 	defer span.End()
 
 	logWriter, ok := state.Get("logWriter").(LogWriter)
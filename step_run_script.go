@@ -11,7 +11,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/travis-ci/worker/backend"
 	"github.com/travis-ci/worker/context"
-	"go.opencensus.io/trace"
+	workererrors "github.com/travis-ci/worker/errors"
 )
 
 type runScriptReturn struct {
@@ -34,7 +34,7 @@ func (s *stepRunScript) Run(state multistep.StateBag) multistep.StepAction {
 
 	defer context.TimeSince(ctx, "step_run_script_run", time.Now())
 
-	ctx, span := trace.StartSpan(ctx, "RunScript.Run")
+	ctx, span := startJobSpan(ctx, "RunScript.Run")
 	defer span.End()
 
 	preTimeoutCtx := ctx
@@ -76,13 +76,10 @@ func (s *stepRunScript) Run(state multistep.StateBag) multistep.StepAction {
 				logger.WithFields(logrus.Fields{
 					"err":       r.err,
 					"completed": r.result.Completed,
-				}).Error("couldn't run script, attempting requeue")
+				}).Error("couldn't run script")
 				context.CaptureError(ctx, r.err)
 
-				err := buildJob.Requeue(preTimeoutCtx)
-				if err != nil {
-					logger.WithField("err", err).Error("couldn't requeue job")
-				}
+				failJob(preTimeoutCtx, buildJob, logWriter, r.err, workererrors.ClassConnectionLost)
 			} else {
 				logger.WithField("err", r.err).WithField("completed", r.result.Completed).Error("couldn't run script")
 				err := buildJob.Finish(preTimeoutCtx, FinishStateErrored)
@@ -126,7 +123,7 @@ func (s *stepRunScript) Run(state multistep.StateBag) multistep.StepAction {
 }
 
 func (s *stepRunScript) writeLogAndFinishWithState(preTimeoutCtx, ctx gocontext.Context, logWriter LogWriter, buildJob Job, state FinishState, logMessage string) {
-	ctx, span := trace.StartSpan(ctx, "WriteLogAndFinishWithState.RunScript")
+	ctx, span := startJobSpan(ctx, "WriteLogAndFinishWithState.RunScript")
 	defer span.End()
 
 	logger := context.LoggerFromContext(ctx).WithField("self", "step_run_script")
@@ -7,7 +7,6 @@ import (
 	"github.com/mitchellh/multistep"
 	"github.com/sirupsen/logrus"
 	"github.com/travis-ci/worker/context"
-	"go.opencensus.io/trace"
 )
 
 type stepSendReceived struct{}
@@ -18,7 +17,7 @@ func (s *stepSendReceived) Run(state multistep.StateBag) multistep.StepAction {
 
 	defer context.TimeSince(ctx, "step_send_received_run", time.Now())
 
-	ctx, span := trace.StartSpan(ctx, "SendReceived.Run")
+	ctx, span := startJobSpan(ctx, "SendReceived.Run")
 	defer span.End()
 
 	err := buildJob.Received(ctx)
@@ -6,7 +6,6 @@ import (
 
 	"github.com/mitchellh/multistep"
 	"github.com/travis-ci/worker/context"
-	"go.opencensus.io/trace"
 )
 
 type stepSleep struct {
@@ -18,7 +17,7 @@ func (s *stepSleep) Run(state multistep.StateBag) multistep.StepAction {
 
 	defer context.TimeSince(ctx, "step_sleep_run", time.Now())
 
-	ctx, span := trace.StartSpan(ctx, "Sleep.Run")
+	ctx, span := startJobSpan(ctx, "Sleep.Run")
 	defer span.End()
 
 	time.Sleep(s.duration)
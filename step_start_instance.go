@@ -17,6 +17,7 @@ import (
 type stepStartInstance struct {
 	provider     backend.Provider
 	startTimeout time.Duration
+	jobEvents    *JobEventEmitter
 }
 
 func (s *stepStartInstance) Run(state multistep.StateBag) multistep.StepAction {
@@ -28,7 +29,7 @@ func (s *stepStartInstance) Run(state multistep.StateBag) multistep.StepAction {
 
 	defer context.TimeSince(ctx, "step_start_instance_run", time.Now())
 
-	ctx, span := trace.StartSpan(ctx, "StartInstance.Run")
+	ctx, span := startJobSpan(ctx, "StartInstance.Run")
 	defer span.End()
 
 	logger.Info("starting instance")
@@ -45,13 +46,15 @@ func (s *stepStartInstance) Run(state multistep.StateBag) multistep.StepAction {
 		err      error
 	)
 
+	endFold := func() {}
 	if s.provider.SupportsProgress() && buildJob.StartAttributes().ProgressType != "" {
 		var progresser backend.Progresser
 		switch buildJob.StartAttributes().ProgressType {
 		case "text":
 			progresser = backend.NewTextProgresser(logWriter)
 			writeFoldStart(logWriter, "step_start_instance", []byte("\033[33;1mStarting instance\033[0m\r\n"))
-			defer writeFoldEnd(logWriter, "step_start_instance", []byte(""))
+			endFold = foldEnder(logWriter, "step_start_instance")
+			defer endFold()
 		default:
 			logger.WithField("progress_type", buildJob.StartAttributes().ProgressType).Warn("unknown progress type")
 			progresser = &backend.NullProgresser{}
@@ -63,29 +66,22 @@ func (s *stepStartInstance) Run(state multistep.StateBag) multistep.StepAction {
 	}
 
 	if err != nil {
-		jobAbortErr, ok := errors.Cause(err).(workererrors.JobAbortError)
-		if ok {
-			logWriter.WriteAndClose([]byte(jobAbortErr.UserFacingErrorMessage()))
-
-			err = buildJob.Finish(preTimeoutCtx, FinishStateErrored)
-			if err != nil {
-				logger.WithField("err", err).WithField("state", FinishStateErrored).Error("couldn't mark job as finished")
-			}
-
-			return multistep.ActionHalt
+		fallback := workererrors.ClassUnknown
+		if ctx.Err() == gocontext.DeadlineExceeded {
+			fallback = workererrors.ClassBootTimeout
 		}
 
 		logger.WithFields(logrus.Fields{
 			"err":           err,
 			"start_timeout": s.startTimeout,
-		}).Error("couldn't start instance, attempting requeue")
-		context.CaptureError(ctx, err)
-
-		err := buildJob.Requeue(preTimeoutCtx)
-		if err != nil {
-			logger.WithField("err", err).Error("couldn't requeue job")
+		}).Error("couldn't start instance")
+		if _, ok := errors.Cause(err).(workererrors.JobAbortError); !ok {
+			context.CaptureError(ctx, err)
 		}
 
+		// failJob may close the log, so the fold must end first
+		endFold()
+		failJob(preTimeoutCtx, buildJob, logWriter, err, fallback)
 		return multistep.ActionHalt
 	}
 
@@ -97,7 +93,20 @@ func (s *stepStartInstance) Run(state multistep.StateBag) multistep.StepAction {
 		"warmed":           instance.Warmed(),
 	}).Info("started instance")
 
+	span.AddAttributes(
+		trace.StringAttribute("instance_id", instance.ID()),
+		trace.StringAttribute("image_name", instance.ImageName()),
+	)
+
 	state.Put("instance", instance)
+	state.Put("ctx", context.FromInstanceID(state.Get("ctx").(gocontext.Context), instance.ID()))
+
+	s.jobEvents.Emit(ctx, &JobEvent{
+		Type:           JobEventInstanceStarted,
+		InstanceID:     instance.ID(),
+		ImageName:      instance.ImageName(),
+		BootDurationMs: time.Since(startTime).Seconds() * 1e3,
+	})
 
 	return multistep.ActionContinue
 }
@@ -107,7 +116,7 @@ func (s *stepStartInstance) Cleanup(state multistep.StateBag) {
 
 	defer context.TimeSince(ctx, "step_start_instance_cleanup", time.Now())
 
-	ctx, span := trace.StartSpan(ctx, "StartInstance.Cleanup")
+	ctx, span := startJobSpan(ctx, "StartInstance.Cleanup")
 	defer span.End()
 
 	instance, ok := state.Get("instance").(backend.Instance)
@@ -123,9 +132,18 @@ func (s *stepStartInstance) Cleanup(state multistep.StateBag) {
 		return
 	}
 
+	event := &JobEvent{
+		Type:       JobEventInstanceStopped,
+		InstanceID: instance.ID(),
+		ImageName:  instance.ImageName(),
+	}
+
 	if err := instance.Stop(ctx); err != nil {
 		logger.WithFields(logrus.Fields{"err": err, "instance": instance}).Warn("couldn't stop instance")
+		event.Error = err.Error()
 	} else {
 		logger.Info("stopped instance")
 	}
+
+	s.jobEvents.Emit(ctx, event)
 }
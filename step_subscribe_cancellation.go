@@ -4,7 +4,6 @@ import (
 	gocontext "context"
 
 	"github.com/mitchellh/multistep"
-	"go.opencensus.io/trace"
 )
 
 type stepSubscribeCancellation struct {
@@ -14,7 +13,7 @@ type stepSubscribeCancellation struct {
 func (s *stepSubscribeCancellation) Run(state multistep.StateBag) multistep.StepAction {
 	ctx := state.Get("ctx").(gocontext.Context)
 
-	ctx, span := trace.StartSpan(ctx, "SubscribeCancellation.Run")
+	ctx, span := startJobSpan(ctx, "SubscribeCancellation.Run")
 	defer span.End()
 
 	if s.cancellationBroadcaster == nil {
@@ -37,7 +36,7 @@ func (s *stepSubscribeCancellation) Cleanup(state multistep.StateBag) {
 
 	ctx := state.Get("ctx").(gocontext.Context)
 
-	ctx, span := trace.StartSpan(ctx, "SubscribeCancellation.Cleanup")
+	ctx, span := startJobSpan(ctx, "SubscribeCancellation.Cleanup")
 	defer span.End()
 
 	buildJob := state.Get("buildJob").(Job)
@@ -8,7 +8,6 @@ import (
 
 	"github.com/mitchellh/multistep"
 	"github.com/travis-ci/worker/context"
-	"go.opencensus.io/trace"
 	gocontext "golang.org/x/net/context"
 )
 
@@ -26,7 +25,7 @@ func (s *stepTransformBuildJSON) Run(state multistep.StateBag) multistep.StepAct
 	buildJob := state.Get("buildJob").(Job)
 	ctx := state.Get("ctx").(gocontext.Context)
 
-	ctx, span := trace.StartSpan(ctx, "TransformBuildJSON.Run")
+	ctx, span := startJobSpan(ctx, "TransformBuildJSON.Run")
 	defer span.End()
 
 	if s.payloadFilterExecutable == "" {
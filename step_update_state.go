@@ -8,7 +8,6 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/travis-ci/worker/backend"
 	"github.com/travis-ci/worker/context"
-	"go.opencensus.io/trace"
 )
 
 type stepUpdateState struct{}
@@ -30,7 +29,7 @@ func (s *stepUpdateState) Run(state multistep.StateBag) multistep.StepAction {
 
 	defer context.TimeSince(ctx, "step_update_state_run", time.Now())
 
-	ctx, span := trace.StartSpan(ctx, "UpdateState.Run")
+	ctx, span := startJobSpan(ctx, "UpdateState.Run")
 	defer span.End()
 
 	logWriter.SetJobStarted(&JobStartedMeta{
@@ -71,7 +70,7 @@ func (s *stepUpdateState) Cleanup(state multistep.StateBag) {
 
 	defer context.TimeSince(ctx, "step_update_state_cleanup", time.Now())
 
-	ctx, span := trace.StartSpan(ctx, "UpdateState.Cleanup")
+	ctx, span := startJobSpan(ctx, "UpdateState.Cleanup")
 	defer span.End()
 
 	logger := context.LoggerFromContext(ctx).WithField("self", "step_update_state")
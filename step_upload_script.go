@@ -10,12 +10,13 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/travis-ci/worker/backend"
 	"github.com/travis-ci/worker/context"
+	workererrors "github.com/travis-ci/worker/errors"
 	"github.com/travis-ci/worker/metrics"
-	"go.opencensus.io/trace"
 )
 
 type stepUploadScript struct {
 	uploadTimeout time.Duration
+	jobEvents     *JobEventEmitter
 }
 
 func (s *stepUploadScript) Run(state multistep.StateBag) multistep.StepAction {
@@ -31,7 +32,7 @@ func (s *stepUploadScript) Run(state multistep.StateBag) multistep.StepAction {
 
 	defer context.TimeSince(ctx, "step_upload_script_run", time.Now())
 
-	ctx, span := trace.StartSpan(ctx, "UploadScript.Run")
+	ctx, span := startJobSpan(ctx, "UploadScript.Run")
 	defer span.End()
 
 	preTimeoutCtx := ctx
@@ -39,9 +40,11 @@ func (s *stepUploadScript) Run(state multistep.StateBag) multistep.StepAction {
 	ctx, cancel := gocontext.WithTimeout(ctx, s.uploadTimeout)
 	defer cancel()
 
+	endFold := func() {}
 	if instance.SupportsProgress() && buildJob.StartAttributes().ProgressType == "text" {
 		writeFoldStart(logWriter, "step_upload_script", []byte("\033[33;1mUploading script\033[0m\r\n"))
-		defer writeFoldEnd(logWriter, "step_upload_script", []byte(""))
+		endFold = foldEnder(logWriter, "step_upload_script")
+		defer endFold()
 	}
 
 	err := instance.UploadScript(ctx, script)
@@ -55,14 +58,12 @@ func (s *stepUploadScript) Run(state multistep.StateBag) multistep.StepAction {
 		logger.WithFields(logrus.Fields{
 			"err":            err,
 			"upload_timeout": s.uploadTimeout,
-		}).Error("couldn't upload script, attempting requeue")
+		}).Error("couldn't upload script")
 		context.CaptureError(ctx, err)
 
-		err := buildJob.Requeue(preTimeoutCtx)
-		if err != nil {
-			logger.WithField("err", err).Error("couldn't requeue job")
-		}
-
+		// failJob may close the log, so the fold must end first
+		endFold()
+		failJob(preTimeoutCtx, buildJob, logWriter, err, workererrors.ClassScriptUploadFailed)
 		return multistep.ActionHalt
 	}
 
@@ -70,6 +71,8 @@ func (s *stepUploadScript) Run(state multistep.StateBag) multistep.StepAction {
 		"since_processed_ms": time.Since(processedAt).Seconds() * 1e3,
 	}).Info("uploaded script")
 
+	s.jobEvents.Emit(ctx, &JobEvent{Type: JobEventScriptUploaded})
+
 	return multistep.ActionContinue
 }
 
@@ -0,0 +1,81 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	gocontext "context"
+
+	"github.com/mitchellh/multistep"
+	"github.com/stretchr/testify/assert"
+	"github.com/travis-ci/worker/backend"
+	"github.com/travis-ci/worker/config"
+	workererrors "github.com/travis-ci/worker/errors"
+)
+
+// progressFailingInstance is an instance that reports progress and fails to
+// upload scripts with a non-retryable error.
+type progressFailingInstance struct {
+	backend.Instance
+}
+
+func (i *progressFailingInstance) SupportsProgress() bool { return true }
+
+func (i *progressFailingInstance) UploadScript(ctx gocontext.Context, script []byte) error {
+	return workererrors.Classify(fmt.Errorf("no image found"), workererrors.ClassImageNotFound)
+}
+
+// closeTrackingLogWriter records the writes made after the log was closed.
+type closeTrackingLogWriter struct {
+	LogWriter
+
+	closed             bool
+	writesAfterClosing int
+}
+
+func (w *closeTrackingLogWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		w.writesAfterClosing++
+	}
+	return w.LogWriter.Write(p)
+}
+
+func (w *closeTrackingLogWriter) WriteAndClose(p []byte) (int, error) {
+	w.closed = true
+	return w.LogWriter.WriteAndClose(p)
+}
+
+func TestStepUploadScript_RunFailureEndsFoldBeforeClosingLog(t *testing.T) {
+	bp, _ := backend.NewBackendProvider("fake", config.ProviderConfigFromMap(map[string]string{}))
+
+	ctx := gocontext.TODO()
+	instance, _ := bp.Start(ctx, nil)
+
+	out := &bytes.Buffer{}
+	job := &fakeJob{
+		payload:         &JobPayload{Job: JobJobPayload{ID: 4}},
+		startAttributes: &backend.StartAttributes{ProgressType: "text"},
+	}
+
+	state := &multistep.BasicStateBag{}
+	state.Put("ctx", ctx)
+	state.Put("buildJob", job)
+	logWriter := &closeTrackingLogWriter{LogWriter: newWriterLogWriter(ctx, out, time.Minute)}
+	logWriter.SetMaxLogLength(1000)
+	state.Put("logWriter", logWriter)
+	state.Put("processedAt", time.Now())
+	state.Put("instance", &progressFailingInstance{Instance: instance})
+	state.Put("script", []byte("echo hi"))
+
+	s := &stepUploadScript{uploadTimeout: time.Minute}
+	assert.Equal(t, multistep.ActionHalt, s.Run(state))
+	assert.Equal(t, []string{"errored"}, job.events)
+	assert.Equal(t, 0, logWriter.writesAfterClosing)
+
+	log := out.String()
+	assert.Equal(t, 1, strings.Count(log, "travis_fold:end:step_upload_script"))
+	assert.True(t, strings.Index(log, "travis_fold:end:step_upload_script") < strings.Index(log, "no image could be found"), log)
+}
@@ -7,7 +7,6 @@ import (
 
 	"github.com/mitchellh/multistep"
 	"github.com/travis-ci/worker/backend"
-	"go.opencensus.io/trace"
 )
 
 type stepWriteWorkerInfo struct {
@@ -19,17 +18,23 @@ func (s *stepWriteWorkerInfo) Run(state multistep.StateBag) multistep.StepAction
 	instance := state.Get("instance").(backend.Instance)
 	ctx := state.Get("ctx").(gocontext.Context)
 
-	ctx, span := trace.StartSpan(ctx, "WriteWorkerInfo.Run")
+	ctx, span := startJobSpan(ctx, "WriteWorkerInfo.Run")
 	defer span.End()
 
 	if hostname, ok := state.Get("hostname").(string); ok && hostname != "" {
-		_, _ = writeFold(logWriter, "worker_info", []byte(strings.Join([]string{
+		lines := []string{
 			"\033[33;1mWorker information\033[0m",
 			fmt.Sprintf("hostname: %s", hostname),
 			fmt.Sprintf("version: %s %s", VersionString, RevisionURLString),
 			fmt.Sprintf("instance: %s %s (via %s)", instance.ID(), instance.ImageName(), buildJob.Name()),
 			fmt.Sprintf("startup: %v", instance.StartupDuration()),
-		}, "\n")))
+		}
+
+		if sa := buildJob.StartAttributes(); sa != nil && sa.ImageSelection.Arm != "" {
+			lines = append(lines, fmt.Sprintf("image selection: %s", sa.ImageSelection.Arm))
+		}
+
+		_, _ = writeFold(logWriter, "worker_info", []byte(strings.Join(lines, "\n")))
 	}
 
 	return multistep.ActionContinue
@@ -11,6 +11,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/travis-ci/worker/backend"
 	"github.com/travis-ci/worker/config"
+	"github.com/travis-ci/worker/image"
 )
 
 type byteBufferLogWriter struct {
@@ -81,3 +82,18 @@ func TestStepWriteWorkerInfo_Run(t *testing.T) {
 	assert.Contains(t, out, "\nstartup: 42.17s\n")
 	assert.Contains(t, out, "\ntravis_fold:end:worker_info\r\033[0K")
 }
+
+func TestStepWriteWorkerInfo_RunWithImageSelection(t *testing.T) {
+	s, logWriter, state := setupStepWriteWorkerInfo()
+	state.Put("buildJob", &fakeJob{
+		payload: &JobPayload{Job: JobJobPayload{ID: 4}},
+		startAttributes: &backend.StartAttributes{
+			ImageSelection: image.Selection{Arm: "canary", Image: "travis-ci-canary"},
+		},
+	})
+
+	s.Run(state)
+
+	out := logWriter.String()
+	assert.Contains(t, out, "\nstartup: 42.17s\nimage selection: canary\n")
+}
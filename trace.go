@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"fmt"
+	"net/http"
+
+	gocontext "context"
+
+	"github.com/travis-ci/worker/context"
+	"go.opencensus.io/trace"
+)
+
+// startJobSpan starts a span as trace.StartSpan does, and attaches the job's
+// uuid, backend name, and instance ID to it as far as they are known.
+func startJobSpan(ctx gocontext.Context, name string) (gocontext.Context, *trace.Span) {
+	ctx, span := trace.StartSpan(ctx, name)
+	span.AddAttributes(jobSpanAttributes(ctx)...)
+	return ctx, span
+}
+
+func jobSpanAttributes(ctx gocontext.Context) []trace.Attribute {
+	attrs := []trace.Attribute{}
+
+	if uuid, ok := context.UUIDFromContext(ctx); ok {
+		attrs = append(attrs, trace.StringAttribute("uuid", uuid))
+	}
+	if backend, ok := context.BackendFromContext(ctx); ok {
+		attrs = append(attrs, trace.StringAttribute("backend", backend))
+	}
+	if instanceID, ok := context.InstanceIDFromContext(ctx); ok {
+		attrs = append(attrs, trace.StringAttribute("instance_id", instanceID))
+	}
+
+	return attrs
+}
+
+// setTraceParentHeader sets the W3C Trace Context traceparent header of req
+// to the span in ctx, so that the receiving service can continue the trace.
+// Nothing is set if ctx has no span.
+func setTraceParentHeader(req *http.Request, ctx gocontext.Context) {
+	span := trace.FromContext(ctx)
+	if span == nil {
+		return
+	}
+
+	req.Header.Set("traceparent", traceParent(span.SpanContext()))
+}
+
+func traceParent(sc trace.SpanContext) string {
+	flags := "00"
+	if sc.IsSampled() {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
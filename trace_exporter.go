@@ -0,0 +1,382 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gocontext "context"
+
+	"contrib.go.opencensus.io/exporter/stackdriver"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/travis-ci/worker/config"
+	"github.com/travis-ci/worker/context"
+	"go.opencensus.io/trace"
+	"google.golang.org/api/option"
+)
+
+const (
+	otlpExporterBatchSize     = 512
+	otlpExporterFlushInterval = 5 * time.Second
+)
+
+// traceExporter is a trace.Exporter that may buffer spans, and needs to be
+// flushed before the worker exits.
+type traceExporter interface {
+	trace.Exporter
+
+	Flush()
+}
+
+// newTraceExporters builds the trace exporters named in the comma-delimited
+// TraceExporters config option.
+func newTraceExporters(ctx gocontext.Context, cfg *config.Config) ([]traceExporter, error) {
+	exporters := []traceExporter{}
+
+	for _, name := range strings.Split(cfg.TraceExporters, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		exporter, err := newTraceExporter(ctx, name, cfg)
+		if err != nil {
+			stopTraceExporters(exporters)
+			return nil, errors.Wrapf(err, "couldn't create %s trace exporter", name)
+		}
+
+		exporters = append(exporters, exporter)
+	}
+
+	if len(exporters) == 0 {
+		return nil, errors.New("no trace exporters configured")
+	}
+
+	return exporters, nil
+}
+
+// stopTraceExporters stops the background work of exporters that won't be
+// registered, as building a later exporter failed.
+func stopTraceExporters(exporters []traceExporter) {
+	for _, exporter := range exporters {
+		if e, ok := exporter.(*otlpTraceExporter); ok {
+			e.stop()
+		}
+	}
+}
+
+func newTraceExporter(ctx gocontext.Context, name string, cfg *config.Config) (traceExporter, error) {
+	switch name {
+	case "stackdriver":
+		creds, err := loadStackdriverTraceJSON(ctx, cfg.StackdriverTraceAccountJSON)
+		if err != nil {
+			return nil, err
+		}
+
+		return stackdriver.NewExporter(stackdriver.Options{
+			ProjectID: cfg.StackdriverProjectID,
+			TraceClientOptions: []option.ClientOption{
+				option.WithCredentials(creds),
+			},
+			MonitoringClientOptions: []option.ClientOption{
+				option.WithCredentials(creds),
+			},
+		})
+	case "otlp":
+		return newOTLPTraceExporter(ctx, cfg.OTLPEndpoint, cfg.TraceServiceName), nil
+	case "file":
+		return newFileTraceExporter(cfg.TraceFile)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", name)
+	}
+}
+
+// fileTraceExporter appends every span as a single line of JSON to a file,
+// for analysing traces without any tracing infrastructure.
+type fileTraceExporter struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+type fileTraceSpan struct {
+	TraceID      string                 `json:"trace_id"`
+	SpanID       string                 `json:"span_id"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"`
+	Name         string                 `json:"name"`
+	StartTime    time.Time              `json:"start_time"`
+	EndTime      time.Time              `json:"end_time"`
+	DurationMs   float64                `json:"duration_ms"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Annotations  []fileTraceAnnotation  `json:"annotations,omitempty"`
+	StatusCode   int32                  `json:"status_code"`
+	Status       string                 `json:"status,omitempty"`
+}
+
+type fileTraceAnnotation struct {
+	Time       time.Time              `json:"time"`
+	Message    string                 `json:"message"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+func newFileTraceExporter(path string) (*fileTraceExporter, error) {
+	if path == "" {
+		return nil, errors.New("no trace file given")
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't open trace file")
+	}
+
+	return &fileTraceExporter{file: file}, nil
+}
+
+func (e *fileTraceExporter) ExportSpan(sd *trace.SpanData) {
+	span := &fileTraceSpan{
+		TraceID:    sd.TraceID.String(),
+		SpanID:     sd.SpanID.String(),
+		Name:       sd.Name,
+		StartTime:  sd.StartTime.UTC(),
+		EndTime:    sd.EndTime.UTC(),
+		DurationMs: sd.EndTime.Sub(sd.StartTime).Seconds() * 1e3,
+		Attributes: sd.Attributes,
+		StatusCode: sd.Code,
+		Status:     sd.Message,
+	}
+	if sd.ParentSpanID != (trace.SpanID{}) {
+		span.ParentSpanID = sd.ParentSpanID.String()
+	}
+	for _, annotation := range sd.Annotations {
+		span.Annotations = append(span.Annotations, fileTraceAnnotation{
+			Time:       annotation.Time.UTC(),
+			Message:    annotation.Message,
+			Attributes: annotation.Attributes,
+		})
+	}
+
+	line, err := json.Marshal(span)
+	if err != nil {
+		return
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	_, _ = e.file.Write(append(line, '\n'))
+}
+
+func (e *fileTraceExporter) Flush() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	_ = e.file.Sync()
+}
+
+// otlpTraceExporter sends spans in batches to an OpenTelemetry collector
+// using OTLP over HTTP with the JSON encoding.
+type otlpTraceExporter struct {
+	endpoint    string
+	serviceName string
+	httpClient  *http.Client
+	ctx         gocontext.Context
+	cancel      gocontext.CancelFunc
+
+	spansMutex sync.Mutex
+	spans      []*trace.SpanData
+	flushChan  chan struct{}
+}
+
+func newOTLPTraceExporter(ctx gocontext.Context, endpoint, serviceName string) *otlpTraceExporter {
+	e := &otlpTraceExporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		flushChan:   make(chan struct{}, 1),
+	}
+	e.ctx, e.cancel = gocontext.WithCancel(ctx)
+
+	go e.flushRegularly(e.ctx)
+
+	return e
+}
+
+func (e *otlpTraceExporter) ExportSpan(sd *trace.SpanData) {
+	e.spansMutex.Lock()
+	e.spans = append(e.spans, sd)
+	full := len(e.spans) >= otlpExporterBatchSize
+	e.spansMutex.Unlock()
+
+	if full {
+		select {
+		case e.flushChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *otlpTraceExporter) flushRegularly(ctx gocontext.Context) {
+	ticker := time.NewTicker(otlpExporterFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			e.Flush()
+		case <-e.flushChan:
+			e.Flush()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stop ends the regular flushing of spans without flushing them.
+func (e *otlpTraceExporter) stop() {
+	e.cancel()
+}
+
+func (e *otlpTraceExporter) Flush() {
+	e.spansMutex.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.spansMutex.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	err := e.send(spans)
+	if err != nil {
+		context.LoggerFromContext(e.ctx).WithFields(logrus.Fields{
+			"self":  "trace_exporter",
+			"err":   err,
+			"spans": len(spans),
+		}).Error("couldn't export spans")
+	}
+}
+
+func (e *otlpTraceExporter) send(spans []*trace.SpanData) error {
+	body, err := json.Marshal(otlpTracesRequest(e.serviceName, spans))
+	if err != nil {
+		return errors.Wrap(err, "couldn't marshal spans")
+	}
+
+	req, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "couldn't create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "couldn't send spans")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("expected 2xx from collector but got %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// otlpTracesRequest converts spans to the JSON encoding of an OTLP
+// ExportTraceServiceRequest.
+func otlpTracesRequest(serviceName string, spans []*trace.SpanData) map[string]interface{} {
+	otlpSpans := []map[string]interface{}{}
+	for _, sd := range spans {
+		span := map[string]interface{}{
+			"traceId":           hex.EncodeToString(sd.TraceID[:]),
+			"spanId":            hex.EncodeToString(sd.SpanID[:]),
+			"name":              sd.Name,
+			"kind":              otlpSpanKind(sd.SpanKind),
+			"startTimeUnixNano": strconv.FormatInt(sd.StartTime.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(sd.EndTime.UnixNano(), 10),
+			"attributes":        otlpAttributes(sd.Attributes),
+			"status":            otlpStatus(sd.Status),
+		}
+		if sd.ParentSpanID != (trace.SpanID{}) {
+			span["parentSpanId"] = hex.EncodeToString(sd.ParentSpanID[:])
+		}
+
+		events := []map[string]interface{}{}
+		for _, annotation := range sd.Annotations {
+			events = append(events, map[string]interface{}{
+				"timeUnixNano": strconv.FormatInt(annotation.Time.UnixNano(), 10),
+				"name":         annotation.Message,
+				"attributes":   otlpAttributes(annotation.Attributes),
+			})
+		}
+		if len(events) > 0 {
+			span["events"] = events
+		}
+
+		otlpSpans = append(otlpSpans, span)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []map[string]interface{}{
+			{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]interface{}{
+						"service.name":    serviceName,
+						"service.version": VersionString,
+					}),
+				},
+				"scopeSpans": []map[string]interface{}{
+					{
+						"scope": map[string]interface{}{"name": "github.com/travis-ci/worker"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+func otlpSpanKind(kind int) int {
+	switch kind {
+	case trace.SpanKindServer:
+		return 2
+	case trace.SpanKindClient:
+		return 3
+	default:
+		return 1
+	}
+}
+
+func otlpStatus(status trace.Status) map[string]interface{} {
+	if status.Code == trace.StatusCodeOK {
+		return map[string]interface{}{}
+	}
+
+	return map[string]interface{}{
+		"code":    2,
+		"message": status.Message,
+	}
+}
+
+func otlpAttributes(attributes map[string]interface{}) []map[string]interface{} {
+	attrs := []map[string]interface{}{}
+	for key, value := range attributes {
+		var v map[string]interface{}
+		switch value := value.(type) {
+		case bool:
+			v = map[string]interface{}{"boolValue": value}
+		case int64:
+			v = map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+		case float64:
+			v = map[string]interface{}{"doubleValue": value}
+		default:
+			v = map[string]interface{}{"stringValue": fmt.Sprintf("%v", value)}
+		}
+		attrs = append(attrs, map[string]interface{}{"key": key, "value": v})
+	}
+	return attrs
+}
@@ -0,0 +1,156 @@
+package worker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gocontext "context"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/travis-ci/worker/config"
+	"github.com/travis-ci/worker/context"
+	"go.opencensus.io/trace"
+)
+
+func buildTestSpanData() *trace.SpanData {
+	start := time.Date(2018, 11, 5, 12, 0, 0, 0, time.UTC)
+	return &trace.SpanData{
+		SpanContext: trace.SpanContext{
+			TraceID: trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+			SpanID:  trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		},
+		ParentSpanID: trace.SpanID{8, 7, 6, 5, 4, 3, 2, 1},
+		Name:         "StartInstance.Run",
+		StartTime:    start,
+		EndTime:      start.Add(1500 * time.Millisecond),
+		Attributes: map[string]interface{}{
+			"instance_id": "i-abc",
+			"job_id":      int64(4),
+		},
+		Status: trace.Status{Code: 2, Message: "unknown"},
+	}
+}
+
+func TestNewTraceExporters(t *testing.T) {
+	_, err := newTraceExporters(gocontext.TODO(), &config.Config{TraceExporters: "otlp,carrier-pigeon"})
+	assert.NotNil(t, err)
+
+	_, err = newTraceExporters(gocontext.TODO(), &config.Config{TraceExporters: " , "})
+	assert.NotNil(t, err)
+
+	dir, err := ioutil.TempDir("", "travis-worker-trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := gocontext.WithCancel(gocontext.TODO())
+	defer cancel()
+
+	exporters, err := newTraceExporters(ctx, &config.Config{
+		TraceExporters: "otlp, file",
+		OTLPEndpoint:   "http://localhost:4318/v1/traces",
+		TraceFile:      filepath.Join(dir, "trace.jsonl"),
+	})
+	assert.Nil(t, err)
+	assert.Len(t, exporters, 2)
+}
+
+func TestOTLPTraceExporter_stop(t *testing.T) {
+	exporter := newOTLPTraceExporter(gocontext.TODO(), "http://localhost:4318/v1/traces", "worker")
+	assert.Nil(t, exporter.ctx.Err())
+
+	exporter.stop()
+	assert.NotNil(t, exporter.ctx.Err())
+}
+
+func TestFileTraceExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "travis-worker-trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	exporter, err := newFileTraceExporter(filepath.Join(dir, "trace.jsonl"))
+	assert.Nil(t, err)
+
+	exporter.ExportSpan(buildTestSpanData())
+	exporter.ExportSpan(buildTestSpanData())
+	exporter.Flush()
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "trace.jsonl"))
+	assert.Nil(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Len(t, lines, 2)
+
+	span := &fileTraceSpan{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), span))
+	assert.Equal(t, "0102030405060708090a0b0c0d0e0f10", span.TraceID)
+	assert.Equal(t, "0807060504030201", span.ParentSpanID)
+	assert.Equal(t, "StartInstance.Run", span.Name)
+	assert.Equal(t, float64(1500), span.DurationMs)
+	assert.Equal(t, "i-abc", span.Attributes["instance_id"])
+}
+
+func TestOTLPTraceExporter(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+
+		body := map[string]interface{}{}
+		assert.Nil(t, json.NewDecoder(req.Body).Decode(&body))
+		received <- body
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := gocontext.WithCancel(gocontext.TODO())
+	defer cancel()
+
+	exporter := newOTLPTraceExporter(ctx, ts.URL, "travis-worker")
+	exporter.ExportSpan(buildTestSpanData())
+	exporter.Flush()
+
+	var body map[string]interface{}
+	select {
+	case body = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected spans to be sent to the collector")
+	}
+
+	resourceSpans := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	scopeSpans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})
+	span := scopeSpans["spans"].([]interface{})[0].(map[string]interface{})
+
+	assert.Equal(t, "0102030405060708090a0b0c0d0e0f10", span["traceId"])
+	assert.Equal(t, "0102030405060708", span["spanId"])
+	assert.Equal(t, "0807060504030201", span["parentSpanId"])
+	assert.Equal(t, "1541419200000000000", span["startTimeUnixNano"])
+	assert.Equal(t, float64(2), span["status"].(map[string]interface{})["code"])
+	assert.Contains(t, span["attributes"], map[string]interface{}{
+		"key":   "job_id",
+		"value": map[string]interface{}{"intValue": "4"},
+	})
+}
+
+func TestJobSpanAttributes(t *testing.T) {
+	assert.Len(t, jobSpanAttributes(gocontext.TODO()), 0)
+
+	ctx := context.FromUUID(gocontext.TODO(), "4e1f6b1c-2d3a-4b9c-a1d2-6f3e2a1b0c9d")
+	ctx = context.FromBackend(ctx, "fake")
+	ctx = context.FromInstanceID(ctx, "i-abc")
+
+	assert.Equal(t, []trace.Attribute{
+		trace.StringAttribute("uuid", "4e1f6b1c-2d3a-4b9c-a1d2-6f3e2a1b0c9d"),
+		trace.StringAttribute("backend", "fake"),
+		trace.StringAttribute("instance_id", "i-abc"),
+	}, jobSpanAttributes(ctx))
+}
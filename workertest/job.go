@@ -0,0 +1,217 @@
+package workertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	gocontext "context"
+
+	simplejson "github.com/bitly/go-simplejson"
+	"github.com/travis-ci/worker"
+	"github.com/travis-ci/worker/backend"
+)
+
+// Job is a worker.Job that records every state transition the processor
+// makes it go through, and keeps its logs in memory.
+type Job struct {
+	// NewLogWriter, if set, is called to create the log writer each time the
+	// job's log is opened, with the log silence timeout the processor asked
+	// for. By default, a LogWriter with that timeout is created.
+	NewLogWriter func(silence time.Duration) *LogWriter
+
+	payload         *worker.JobPayload
+	startAttributes *backend.StartAttributes
+
+	mutex      sync.Mutex
+	events     []*Event
+	logWriters []*LogWriter
+}
+
+// NewJob returns a Job with the given ID and a minimal payload. The payload
+// and start attributes can be changed through Payload and StartAttributes
+// before the job is run.
+func NewJob(id uint64) *Job {
+	return &Job{
+		payload: &worker.JobPayload{
+			Type: "job:test",
+			Job: worker.JobJobPayload{
+				ID:     id,
+				Number: fmt.Sprintf("%d.1", id),
+			},
+			Build: worker.BuildPayload{
+				ID:     id,
+				Number: fmt.Sprintf("%d", id),
+			},
+			Repository: worker.RepositoryPayload{
+				ID:   1,
+				Slug: "travis-ci/workertest",
+			},
+			UUID:   fmt.Sprintf("workertest-%d", id),
+			Config: map[string]interface{}{},
+		},
+		startAttributes: &backend.StartAttributes{
+			Language: "ruby",
+			Dist:     "trusty",
+			Group:    "stable",
+			OS:       "linux",
+		},
+	}
+}
+
+// Payload returns the job's payload.
+func (j *Job) Payload() *worker.JobPayload {
+	return j.payload
+}
+
+// RawPayload returns the job's payload encoded as JSON.
+func (j *Job) RawPayload() *simplejson.Json {
+	body, err := json.Marshal(j.payload)
+	if err != nil {
+		return simplejson.New()
+	}
+
+	rawPayload, err := simplejson.NewJson(body)
+	if err != nil {
+		return simplejson.New()
+	}
+	return rawPayload
+}
+
+// StartAttributes returns the job's start attributes.
+func (j *Job) StartAttributes() *backend.StartAttributes {
+	return j.startAttributes
+}
+
+// Received records a received transition.
+func (j *Job) Received(ctx gocontext.Context) error {
+	j.transition(EventReceived, "", "")
+	return nil
+}
+
+// Started records a started transition.
+func (j *Job) Started(ctx gocontext.Context) error {
+	j.transition(EventStarted, "", "")
+	return nil
+}
+
+// Error records an errored transition, writes the message to a new log, and
+// finishes the job as errored, like the AMQP and HTTP jobs do.
+func (j *Job) Error(ctx gocontext.Context, message string) error {
+	j.transition(EventErrored, "", message)
+
+	log, err := j.LogWriter(ctx, time.Minute)
+	if err != nil {
+		return err
+	}
+
+	_, err = log.WriteAndClose([]byte(message))
+	if err != nil {
+		return err
+	}
+
+	return j.Finish(ctx, worker.FinishStateErrored)
+}
+
+// Requeue records a requeued transition.
+func (j *Job) Requeue(ctx gocontext.Context) error {
+	j.transition(EventRequeued, "", "")
+	return nil
+}
+
+// Finish records a finished transition with the given state.
+func (j *Job) Finish(ctx gocontext.Context, state worker.FinishState) error {
+	j.transition(EventFinished, string(state), "")
+	return nil
+}
+
+// LogWriter opens a new log for the job.
+func (j *Job) LogWriter(ctx gocontext.Context, defaultLogTimeout time.Duration) (worker.LogWriter, error) {
+	logTimeout := time.Duration(j.payload.Timeouts.LogSilence) * time.Second
+	if logTimeout == 0 {
+		logTimeout = defaultLogTimeout
+	}
+
+	var w *LogWriter
+	if j.NewLogWriter != nil {
+		w = j.NewLogWriter(logTimeout)
+	} else {
+		w = NewLogWriter(logTimeout)
+	}
+
+	j.mutex.Lock()
+	j.logWriters = append(j.logWriters, w)
+	j.mutex.Unlock()
+
+	return w, nil
+}
+
+// SetupContext returns ctx unchanged.
+func (j *Job) SetupContext(ctx gocontext.Context) gocontext.Context { return ctx }
+
+// Name returns "workertest".
+func (j *Job) Name() string { return "workertest" }
+
+// LogWriters returns the log writers opened for the job, in order.
+func (j *Job) LogWriters() []*LogWriter {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return append([]*LogWriter{}, j.logWriters...)
+}
+
+// Log returns everything written to the job's logs.
+func (j *Job) Log() string {
+	logs := []string{}
+	for _, w := range j.LogWriters() {
+		logs = append(logs, w.String())
+	}
+	return strings.Join(logs, "")
+}
+
+// Events returns the job's state transitions and log events, in order.
+func (j *Job) Events() []*Event {
+	j.mutex.Lock()
+	events := append([]*Event{}, j.events...)
+	logWriters := j.logWriters
+	j.mutex.Unlock()
+
+	for _, w := range logWriters {
+		for _, event := range w.recordedEvents() {
+			event.JobID = j.payload.Job.ID
+			events = append(events, event)
+		}
+	}
+
+	sortEvents(events)
+	return events
+}
+
+// FinishState returns the state the job last finished with, or "" if it
+// didn't finish.
+func (j *Job) FinishState() worker.FinishState {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	for i := len(j.events) - 1; i >= 0; i-- {
+		if j.events[i].Type == EventFinished {
+			return worker.FinishState(j.events[i].State)
+		}
+	}
+	return ""
+}
+
+func (j *Job) transition(eventType, state, message string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.events = append(j.events, &Event{
+		Time:    time.Now(),
+		JobID:   j.payload.Job.ID,
+		Type:    eventType,
+		State:   state,
+		Message: message,
+	})
+}
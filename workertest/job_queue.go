@@ -0,0 +1,72 @@
+package workertest
+
+import (
+	"sync"
+
+	gocontext "context"
+
+	"github.com/travis-ci/worker"
+)
+
+// JobQueue is an in-memory worker.JobQueue. Every processor using the queue
+// reads from the same channel, so each job is handed out once.
+type JobQueue struct {
+	jobs chan worker.Job
+
+	mutex     sync.Mutex
+	closed    bool
+	cleanedUp bool
+}
+
+// NewJobQueue returns a JobQueue holding the given jobs. More jobs can be
+// pushed onto it until it is closed.
+func NewJobQueue(jobs ...worker.Job) *JobQueue {
+	q := &JobQueue{jobs: make(chan worker.Job, len(jobs))}
+	for _, job := range jobs {
+		q.jobs <- job
+	}
+	return q
+}
+
+// Push adds a job to the queue, blocking until a processor takes it if the
+// queue's buffer is full.
+func (q *JobQueue) Push(job worker.Job) {
+	q.jobs <- job
+}
+
+// Close closes the jobs channel once the jobs already in the queue are taken,
+// which makes the processors reading from it return.
+func (q *JobQueue) Close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+}
+
+// Jobs returns the queue's jobs channel.
+func (q *JobQueue) Jobs(ctx gocontext.Context) (<-chan worker.Job, error) {
+	return q.jobs, nil
+}
+
+// Name returns "workertest".
+func (q *JobQueue) Name() string { return "workertest" }
+
+// Cleanup marks the queue as cleaned up.
+func (q *JobQueue) Cleanup() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.cleanedUp = true
+	return nil
+}
+
+// CleanedUp returns true if Cleanup was called.
+func (q *JobQueue) CleanedUp() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.cleanedUp
+}
@@ -0,0 +1,217 @@
+package workertest
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	gocontext "context"
+
+	"github.com/travis-ci/worker"
+)
+
+// LogWriter is an in-memory worker.LogWriter. Its Timeout channel fires when
+// TriggerTimeout is called, or when nothing is written for the log silence
+// timeout it was created with.
+type LogWriter struct {
+	// Err, if set, is returned by every write and by Close, as if the log
+	// sink was unreachable.
+	Err error
+
+	mutex            sync.Mutex
+	buf              bytes.Buffer
+	closed           bool
+	maxLength        int
+	maxLengthReached bool
+	cancel           gocontext.CancelFunc
+	jobStarted       *worker.JobStartedMeta
+	events           []*Event
+
+	silence time.Duration
+	timer   *time.Timer
+	timeout chan time.Time
+}
+
+// NewLogWriter returns a LogWriter that times out after silence without
+// writes. A silence of 0 means it only times out when TriggerTimeout is
+// called.
+func NewLogWriter(silence time.Duration) *LogWriter {
+	w := &LogWriter{
+		silence: silence,
+		timeout: make(chan time.Time, 1),
+	}
+	if silence > 0 {
+		w.timer = time.AfterFunc(silence, w.TriggerTimeout)
+	}
+	return w
+}
+
+// TriggerTimeout makes the Timeout channel fire, as if the log had been
+// silent for too long.
+func (w *LogWriter) TriggerTimeout() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.timeout <- time.Now():
+		w.record(EventLogTimeout)
+	default:
+	}
+}
+
+// Write appends p to the log. Once the log is longer than the maximum log
+// length, the cancel function is called and nothing more is written.
+func (w *LogWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return 0, fmt.Errorf("attempted write to closed log")
+	}
+	if w.Err != nil {
+		return 0, w.Err
+	}
+
+	if w.timer != nil {
+		w.timer.Reset(w.silence)
+	}
+
+	if w.maxLength > 0 && w.buf.Len()+len(p) > w.maxLength {
+		if !w.maxLengthReached {
+			w.maxLengthReached = true
+			w.record(EventLogMaxLength)
+			if w.cancel != nil {
+				w.cancel()
+			}
+		}
+		return 0, nil
+	}
+
+	return w.buf.Write(p)
+}
+
+// WriteAndClose writes p, regardless of the maximum log length, and closes
+// the log.
+func (w *LogWriter) WriteAndClose(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return 0, fmt.Errorf("log already closed")
+	}
+	if w.Err != nil {
+		return 0, w.Err
+	}
+
+	n, _ := w.buf.Write(p)
+	w.close()
+	return n, nil
+}
+
+// Close closes the log.
+func (w *LogWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	if w.Err != nil {
+		return w.Err
+	}
+
+	w.close()
+	return nil
+}
+
+func (w *LogWriter) close() {
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.record(EventLogClosed)
+}
+
+// Timeout returns a channel that fires when the log times out.
+func (w *LogWriter) Timeout() <-chan time.Time {
+	return w.timeout
+}
+
+// SetMaxLogLength sets the maximum log length in bytes. 0 means unlimited.
+func (w *LogWriter) SetMaxLogLength(bytes int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.maxLength = bytes
+}
+
+// SetJobStarted remembers the job started metadata.
+func (w *LogWriter) SetJobStarted(meta *worker.JobStartedMeta) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.jobStarted = meta
+}
+
+// SetCancelFunc sets the function called when the maximum log length is
+// reached.
+func (w *LogWriter) SetCancelFunc(cancel gocontext.CancelFunc) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.cancel = cancel
+}
+
+// MaxLengthReached returns true if a write went past the maximum log length.
+func (w *LogWriter) MaxLengthReached() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.maxLengthReached
+}
+
+// String returns everything written to the log.
+func (w *LogWriter) String() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.buf.String()
+}
+
+// Closed returns true if the log was closed.
+func (w *LogWriter) Closed() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.closed
+}
+
+// JobStarted returns the metadata passed to SetJobStarted, if any.
+func (w *LogWriter) JobStarted() *worker.JobStartedMeta {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.jobStarted
+}
+
+// record adds an event to the log's events. The mutex must be held.
+func (w *LogWriter) record(eventType string) {
+	w.events = append(w.events, &Event{Time: time.Now(), Type: eventType})
+}
+
+func (w *LogWriter) recordedEvents() []*Event {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	events := []*Event{}
+	for _, event := range w.events {
+		e := *event
+		events = append(events, &e)
+	}
+	return events
+}
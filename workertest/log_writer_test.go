@@ -0,0 +1,78 @@
+package workertest
+
+import (
+	"testing"
+	"time"
+
+	gocontext "context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogWriter(t *testing.T) {
+	cancelled := false
+
+	w := NewLogWriter(0)
+	w.SetMaxLogLength(10)
+	w.SetCancelFunc(func() { cancelled = true })
+
+	_, err := w.Write([]byte("hello"))
+	assert.Nil(t, err)
+	_, err = w.Write([]byte(", world"))
+	assert.Nil(t, err)
+	assert.True(t, w.MaxLengthReached())
+	assert.True(t, cancelled)
+
+	_, err = w.WriteAndClose([]byte("!"))
+	assert.Nil(t, err)
+	assert.True(t, w.Closed())
+	assert.Equal(t, "hello!", w.String())
+
+	_, err = w.Write([]byte("more"))
+	assert.NotNil(t, err)
+
+	types := []string{}
+	for _, event := range w.recordedEvents() {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []string{EventLogMaxLength, EventLogClosed}, types)
+}
+
+func TestLogWriter_Timeout(t *testing.T) {
+	w := NewLogWriter(0)
+	w.TriggerTimeout()
+
+	select {
+	case <-w.Timeout():
+	default:
+		t.Fatal("TriggerTimeout didn't time out the log")
+	}
+
+	w = NewLogWriter(20 * time.Millisecond)
+	start := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	w.Write([]byte("still alive"))
+
+	<-w.Timeout()
+	assert.True(t, time.Since(start) >= 30*time.Millisecond)
+}
+
+func TestJobQueue(t *testing.T) {
+	q := NewJobQueue(NewJob(1))
+	jobs, err := q.Jobs(gocontext.TODO())
+	assert.Nil(t, err)
+
+	go func() {
+		q.Push(NewJob(2))
+		q.Close()
+	}()
+
+	ids := []uint64{}
+	for job := range jobs {
+		ids = append(ids, job.Payload().Job.ID)
+	}
+	assert.Equal(t, []uint64{1, 2}, ids)
+
+	assert.Nil(t, q.Cleanup())
+	assert.True(t, q.CleanedUp())
+}
@@ -0,0 +1,245 @@
+// Package workertest provides in-memory implementations of the worker's job
+// queue, job, and log writer, and a helper that runs a Processor against them,
+// for testing code that extends the worker, such as a custom JobQueue,
+// LogWriterFactory, or backend provider.
+//
+// Run processes the given jobs and returns a Trace of what happened to each
+// of them:
+//
+//	job := workertest.NewJob(1)
+//	trace, err := workertest.Run(&workertest.Config{}, job)
+//	...
+//	trace.Types(1) // received, instance_started, script_uploaded, started, ...
+package workertest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	gocontext "context"
+
+	"github.com/travis-ci/worker"
+	"github.com/travis-ci/worker/backend"
+	"github.com/travis-ci/worker/config"
+	"github.com/travis-ci/worker/context"
+)
+
+// Event types recorded by Job and LogWriter. The instance_started,
+// script_uploaded, and instance_stopped job events sent by the processor are
+// recorded as well.
+const (
+	EventReceived = "received"
+	EventStarted  = "started"
+	EventErrored  = "errored"
+	EventRequeued = "requeued"
+	EventFinished = "finished"
+
+	EventLogTimeout   = "log_timeout"
+	EventLogMaxLength = "log_max_length"
+	EventLogClosed    = "log_closed"
+)
+
+// DefaultScript is the build script Run generates when Config.Generator is
+// nil.
+const DefaultScript = "#!/bin/bash\necho workertest\n"
+
+// Event is something that happened to a job while it was processed.
+type Event struct {
+	Time  time.Time
+	JobID uint64
+	Type  string
+
+	// State is the finish state of finished events.
+	State string
+
+	// Message is the error message of errored events.
+	Message string
+
+	// InstanceID is the ID of the instance the job ran on, for the events
+	// sent by the processor.
+	InstanceID string
+}
+
+// Trace is the record of a Run.
+type Trace struct {
+	// Events are the events for all jobs, in order.
+	Events []*Event
+}
+
+// ForJob returns the events for the job with the given ID.
+func (t *Trace) ForJob(id uint64) []*Event {
+	events := []*Event{}
+	for _, event := range t.Events {
+		if event.JobID == id {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Types returns the types of the events for the job with the given ID.
+func (t *Trace) Types(id uint64) []string {
+	types := []string{}
+	for _, event := range t.ForJob(id) {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+// Config configures Run. All fields are optional.
+type Config struct {
+	// Provider runs the jobs. By default, the fake provider is used.
+	Provider backend.Provider
+
+	// Generator generates the build scripts. By default, DefaultScript is
+	// used for every job.
+	Generator worker.BuildScriptGenerator
+
+	// LogWriterFactory opens the job logs instead of the jobs themselves.
+	LogWriterFactory worker.LogWriterFactory
+
+	// CancellationBroadcaster can be used to cancel jobs while they run.
+	CancellationBroadcaster *worker.CancellationBroadcaster
+
+	// Config is the worker config. By default, all timeouts are a minute and
+	// the maximum log length is 4.5MB.
+	Config *config.Config
+
+	// Timeout is how long the processor may take to process all jobs, a
+	// minute by default.
+	Timeout time.Duration
+}
+
+type generatorFunc func(gocontext.Context, worker.Job) ([]byte, error)
+
+func (f generatorFunc) Generate(ctx gocontext.Context, job worker.Job) ([]byte, error) {
+	return f(ctx, job)
+}
+
+// Run processes the jobs one after another with a single Processor and
+// returns a Trace of what happened. It returns an error if the processor
+// can't be created or doesn't finish within the timeout.
+func Run(c *Config, jobs ...*Job) (*Trace, error) {
+	cfg := *c
+	if cfg.Provider == nil {
+		provider, err := backend.NewBackendProvider("fake", config.ProviderConfigFromMap(map[string]string{
+			"LOG_OUTPUT": "workertest",
+		}))
+		if err != nil {
+			return nil, err
+		}
+		cfg.Provider = provider
+	}
+	if cfg.Generator == nil {
+		cfg.Generator = generatorFunc(func(gocontext.Context, worker.Job) ([]byte, error) {
+			return []byte(DefaultScript), nil
+		})
+	}
+	if cfg.CancellationBroadcaster == nil {
+		cfg.CancellationBroadcaster = worker.NewCancellationBroadcaster()
+	}
+	if cfg.Config == nil {
+		cfg.Config = &config.Config{
+			HardTimeout:         time.Minute,
+			LogTimeout:          time.Minute,
+			StartupTimeout:      time.Minute,
+			ScriptUploadTimeout: time.Minute,
+			MaxLogLength:        4500000,
+		}
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Minute
+	}
+
+	ctx, cancel := gocontext.WithCancel(context.FromProcessor(gocontext.Background(), "workertest"))
+	defer cancel()
+
+	sink := &traceSink{}
+	jobEvents := worker.NewJobEventEmitter(ctx, "workertest", "workertest", "", sink)
+
+	queue := NewJobQueue()
+	go func() {
+		for _, job := range jobs {
+			queue.Push(job)
+		}
+		queue.Close()
+	}()
+
+	processor, err := worker.NewProcessor(ctx, "workertest", queue, cfg.LogWriterFactory,
+		cfg.Provider, cfg.Generator, nil, cfg.CancellationBroadcaster, worker.ProcessorConfig{
+			Config:    cfg.Config,
+			JobEvents: jobEvents,
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		processor.Run()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(cfg.Timeout):
+		processor.Terminate()
+		return nil, fmt.Errorf("processor didn't finish within %v", cfg.Timeout)
+	}
+
+	flushCtx, cancelFlush := gocontext.WithTimeout(ctx, cfg.Timeout)
+	defer cancelFlush()
+
+	err = jobEvents.Close(flushCtx)
+	if err != nil {
+		return nil, fmt.Errorf("job events weren't recorded within %v", cfg.Timeout)
+	}
+
+	trace := &Trace{Events: sink.recordedEvents()}
+	for _, job := range jobs {
+		trace.Events = append(trace.Events, job.Events()...)
+	}
+	sortEvents(trace.Events)
+
+	return trace, nil
+}
+
+// traceSink records the job events sent by the processor that the jobs
+// themselves don't know about.
+type traceSink struct {
+	mutex  sync.Mutex
+	events []*Event
+}
+
+func (s *traceSink) Name() string { return "workertest" }
+
+func (s *traceSink) Send(event *worker.JobEvent) error {
+	switch event.Type {
+	case worker.JobEventInstanceStarted, worker.JobEventScriptUploaded, worker.JobEventInstanceStopped:
+		s.mutex.Lock()
+		s.events = append(s.events, &Event{
+			Time:       event.Time,
+			JobID:      event.JobID,
+			Type:       event.Type,
+			Message:    event.Error,
+			InstanceID: event.InstanceID,
+		})
+		s.mutex.Unlock()
+	}
+	return nil
+}
+
+func (s *traceSink) recordedEvents() []*Event {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]*Event{}, s.events...)
+}
+
+func sortEvents(events []*Event) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+}
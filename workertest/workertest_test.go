@@ -0,0 +1,96 @@
+package workertest
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/travis-ci/worker"
+	"github.com/travis-ci/worker/backend"
+	"github.com/travis-ci/worker/config"
+)
+
+func init() {
+	logrus.SetLevel(logrus.FatalLevel)
+	if os.Getenv("TRAVIS_WORKER_TEST_DEBUG") == "1" {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+}
+
+func TestRun(t *testing.T) {
+	first, second := NewJob(1), NewJob(2)
+
+	trace, err := Run(&Config{}, first, second)
+	require.Nil(t, err)
+
+	for _, job := range []*Job{first, second} {
+		id := job.Payload().Job.ID
+		assert.Equal(t, []string{
+			EventReceived, worker.JobEventInstanceStarted, worker.JobEventScriptUploaded,
+			EventStarted, EventFinished, worker.JobEventInstanceStopped, EventLogClosed,
+		}, trace.Types(id))
+		assert.Equal(t, worker.FinishStatePassed, job.FinishState())
+		assert.Contains(t, job.Log(), "workertest")
+		assert.NotEqual(t, "", trace.ForJob(id)[1].InstanceID)
+	}
+
+	assert.True(t, trace.ForJob(1)[0].Time.Before(trace.ForJob(2)[0].Time))
+}
+
+func TestRun_LogFailures(t *testing.T) {
+	broken := NewJob(1)
+	broken.NewLogWriter = func(time.Duration) *LogWriter {
+		w := NewLogWriter(0)
+		w.Err = errors.New("log sink is down")
+		return w
+	}
+
+	silent := NewJob(2)
+	silent.StartAttributes().FakeFaults = map[string]interface{}{"run": "hang"}
+	silent.NewLogWriter = func(time.Duration) *LogWriter {
+		return NewLogWriter(50 * time.Millisecond)
+	}
+
+	trace, err := Run(&Config{}, broken, silent)
+	require.Nil(t, err)
+
+	assert.Equal(t, []string{
+		EventReceived, worker.JobEventInstanceStarted, worker.JobEventScriptUploaded,
+		EventStarted, EventRequeued, worker.JobEventInstanceStopped,
+	}, trace.Types(1))
+	assert.Equal(t, worker.FinishState(""), broken.FinishState())
+
+	assert.Equal(t, []string{
+		EventReceived, worker.JobEventInstanceStarted, worker.JobEventScriptUploaded,
+		EventStarted, EventLogTimeout, EventLogClosed, EventFinished, worker.JobEventInstanceStopped,
+	}, trace.Types(2))
+	assert.Equal(t, worker.FinishStateErrored, silent.FinishState())
+}
+
+func TestRun_MaxLogLength(t *testing.T) {
+	provider, err := backend.NewBackendProvider("fake", config.ProviderConfigFromMap(map[string]string{
+		"LOG_BYTES": "10000",
+	}))
+	require.Nil(t, err)
+
+	job := NewJob(1)
+	trace, err := Run(&Config{
+		Provider: provider,
+		Config: &config.Config{
+			HardTimeout:         time.Minute,
+			LogTimeout:          time.Minute,
+			StartupTimeout:      time.Minute,
+			ScriptUploadTimeout: time.Minute,
+			MaxLogLength:        1000,
+		},
+	}, job)
+	require.Nil(t, err)
+
+	assert.Contains(t, trace.Types(1), EventLogMaxLength)
+	assert.Equal(t, worker.FinishStateErrored, job.FinishState())
+	assert.True(t, len(job.Log()) < 2000, "log is %d bytes long", len(job.Log()))
+}
@@ -0,0 +1,106 @@
+package worker
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	gocontext "context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/travis-ci/worker/context"
+)
+
+// writerLogWriter is a LogWriter writing to an io.Writer, which is left open
+// when the log is closed.
+type writerLogWriter struct {
+	logWriterLimits
+
+	ctx gocontext.Context
+	w   io.Writer
+
+	// mutex is held while writing and closing, so that concurrent closes
+	// don't both close closeChan and nothing is written after a close.
+	mutex     sync.Mutex
+	closeChan chan struct{}
+}
+
+func newWriterLogWriter(ctx gocontext.Context, w io.Writer, timeout time.Duration) LogWriter {
+	return &writerLogWriter{
+		logWriterLimits: newLogWriterLimits(timeout),
+
+		ctx: context.FromComponent(ctx, "log_writer"),
+		w:   w,
+
+		closeChan: make(chan struct{}),
+	}
+}
+
+func (w *writerLogWriter) Write(b []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed() {
+		return 0, fmt.Errorf("attempted write to closed log")
+	}
+
+	logger := context.LoggerFromContext(w.ctx).WithFields(logrus.Fields{
+		"self": "writer_log_writer",
+		"inst": fmt.Sprintf("%p", w),
+	})
+
+	if !w.accept(logger, b) {
+		return 0, nil
+	}
+
+	return w.w.Write(b)
+}
+
+func (w *writerLogWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed() {
+		return nil
+	}
+
+	w.stopTimer()
+
+	close(w.closeChan)
+	return nil
+}
+
+func (w *writerLogWriter) SetJobStarted(meta *JobStartedMeta) {}
+
+// WriteAndClose works like a Write followed by a Close, but isn't subject to
+// the maximum log length. Any folds that are still open are closed before b
+// is written.
+func (w *writerLogWriter) WriteAndClose(b []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed() {
+		return 0, fmt.Errorf("log already closed")
+	}
+
+	w.stopTimer()
+
+	close(w.closeChan)
+
+	_, err := w.w.Write(w.closeOpenFolds(b))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (w *writerLogWriter) closed() bool {
+	select {
+	case <-w.closeChan:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	gocontext "context"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriterLogWriter(t *testing.T) {
+	out := &bytes.Buffer{}
+	lw := newWriterLogWriter(gocontext.TODO(), out, time.Hour)
+	lw.SetMaxLogLength(100)
+	lw.SetCancelFunc(noCancel)
+
+	_, err := lw.Write([]byte("travis_fold:start:install\r\ninstalling"))
+	assert.Nil(t, err)
+
+	_, err = lw.WriteAndClose([]byte("bye"))
+	assert.Nil(t, err)
+
+	_, err = lw.Write([]byte("more"))
+	assert.NotNil(t, err)
+
+	assert.Equal(t, "travis_fold:start:install\r\ninstalling\ntravis_fold:end:install\r\033[0Kbye", out.String())
+}
+
+func TestWriterLogWriter_ConcurrentClose(t *testing.T) {
+	out := &bytes.Buffer{}
+	lw := newWriterLogWriter(gocontext.TODO(), out, time.Hour)
+	lw.SetMaxLogLength(100)
+	lw.SetCancelFunc(noCancel)
+
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_ = lw.Close()
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = lw.WriteAndClose([]byte("bye"))
+		}()
+	}
+	wg.Wait()
+
+	assert.True(t, out.Len() == 0 || out.String() == "bye")
+}